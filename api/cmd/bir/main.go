@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"time"
 
 	"github.com/rakunlabs/ada"
 	"github.com/rakunlabs/chu"
@@ -12,9 +16,28 @@ import (
 
 	mcors "github.com/rakunlabs/ada/middleware/cors"
 
+	"github.com/rytsh/bir/api/tools/apikey"
+	"github.com/rytsh/bir/api/tools/asn"
+	"github.com/rytsh/bir/api/tools/audit"
+	"github.com/rytsh/bir/api/tools/bulk"
+	"github.com/rytsh/bir/api/tools/compress"
+	"github.com/rytsh/bir/api/tools/cors"
 	"github.com/rytsh/bir/api/tools/dns"
+	"github.com/rytsh/bir/api/tools/domain"
 	"github.com/rytsh/bir/api/tools/feedback"
+	"github.com/rytsh/bir/api/tools/geo"
 	"github.com/rytsh/bir/api/tools/ip"
+	"github.com/rytsh/bir/api/tools/jobs"
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/openapi"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/proxy"
+	"github.com/rytsh/bir/api/tools/ratelimit"
+	"github.com/rytsh/bir/api/tools/recovery"
+	"github.com/rytsh/bir/api/tools/report"
+	"github.com/rytsh/bir/api/tools/requestlog"
+	"github.com/rytsh/bir/api/tools/retry"
 	"github.com/rytsh/bir/api/tools/ssl"
 	"github.com/rytsh/bir/api/tools/webrtc"
 	"github.com/rytsh/bir/api/tools/whois"
@@ -33,14 +56,38 @@ func main() {
 }
 
 type config struct {
-	Address    string          `cfg:"address" default:":8080"`
-	Middleware Middleware      `cfg:"middleware"`
-	Feedback   feedback.Config `cfg:"feedback"`
+	Address             string          `cfg:"address" default:":8080"`
+	LogLevel            string          `cfg:"log_level"`
+	BlockPrivateTargets bool            `cfg:"block_private_targets" default:"true"`
+	TrustedProxies      []string        `cfg:"trusted_proxies"`
+	Outbound            outbound.Config `cfg:"outbound"`
+	Retry               retry.Config    `cfg:"retry"`
+	Proxy               proxy.Config    `cfg:"proxy"`
+	ShutdownTimeout     time.Duration   `cfg:"shutdown_timeout" default:"15s"`
+	TLS                 TLS             `cfg:"tls"`
+	Middleware          Middleware      `cfg:"middleware"`
+	APIKey              apikey.Config   `cfg:"api_key"`
+	Audit               audit.Config    `cfg:"audit"`
+	Feedback            feedback.Config `cfg:"feedback"`
+	DNS                 dns.Config      `cfg:"dns"`
+	SSL                 ssl.Config      `cfg:"ssl"`
+	Geo                 geo.Config      `cfg:"geo"`
+	ASN                 asn.Config      `cfg:"asn"`
+	Bulk                bulk.Config     `cfg:"bulk"`
+	Jobs                jobs.Config     `cfg:"jobs"`
+	Report              report.Config   `cfg:"report"`
+	Whois               whois.Config    `cfg:"whois"`
+	DomainReport        domain.Config   `cfg:"domain_report"`
+	WebRTC              webrtc.Config   `cfg:"webrtc"`
+	Metrics             metrics.Config  `cfg:"metrics"`
 }
 
 type Middleware struct {
-	Enabled bool       `cfg:"enabled" default:"true"`
-	Cors    mcors.Cors `cfg:"cors"`
+	Enabled    bool             `cfg:"enabled" default:"true"`
+	RequestLog bool             `cfg:"request_log" default:"true"`
+	Compress   compress.Config  `cfg:"compress"`
+	Cors       cors.Config      `cfg:"cors"`
+	RateLimit  ratelimit.Config `cfg:"rate_limit"`
 }
 
 func run(ctx context.Context) error {
@@ -49,41 +96,211 @@ func run(ctx context.Context) error {
 		return err
 	}
 
+	// overrides LOG_LEVEL
+	if cfg.LogLevel != "" {
+		if err := logi.SetLogLevel(cfg.LogLevel); err != nil {
+			return fmt.Errorf("invalid log level %q: %w", cfg.LogLevel, err)
+		}
+	}
+
+	// client IPs in forwarding headers are only believed from these proxies
+	// when holding clients to limits
+	if err := ip.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+
 	server := ada.New()
 
-	setMiddleware(server, cfg.Middleware)
+	setMiddleware(ctx, server, cfg.Middleware)
+
+	// per-tool rate limits, on top of the global one
+	rl := cfg.Middleware.RateLimit
+
+	// shareable report snapshots (share=true on lookups)
+	reports := report.New(cfg.Report, nil)
+	reports.Start(ctx)
+
+	// outbound connections of the tools skip internal addresses
+	guard := netguard.New(cfg.BlockPrivateTargets)
+
+	// cap on the lookups running at once across all tools
+	outbound.SetDefault(outbound.New(cfg.Outbound))
+
+	// DNS and WHOIS queries failing transiently are retried with backoff
+	retry.SetDefault(retry.New(cfg.Retry))
+
+	// TLS connections and HTTP lookups go through the upstream proxy, if any
+	upstream, err := proxy.New(cfg.Proxy)
+	if err != nil {
+		return err
+	}
+	proxy.SetDefault(upstream)
+
+	// IP geolocation (MaxMind databases, opened once)
+	geoProvider, err := geo.New(cfg.Geo)
+	if err != nil {
+		return err
+	}
+	if closer, ok := geoProvider.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// origin AS and prefix of IPs, from the geo ASN database or Team Cymru
+	asns, err := asn.New(cfg.ASN, geoProvider)
+	if err != nil {
+		return err
+	}
+
+	// batches run in the background with async=true or a callback URL
+	jobManager := jobs.New(cfg.Jobs, guard)
+	jobManager.Start(ctx)
+
+	// who asked the IP lookup, DNS, SSL and WHOIS tools to contact which hosts
+	auditLog, err := audit.New(cfg.Audit, ip.ClientIP)
+	if err != nil {
+		return err
+	}
+
+	ih := ip.New(geoProvider, asns)
+	dh := dns.New(cfg.DNS, guard)
+	sh := ssl.New(cfg.SSL, guard)
+	wh := whois.New(cfg.Whois, guard)
+
+	// API keys on the protected tools, checked before rate limiting so
+	// rejected callers don't use up the limits
+	auth := cfg.APIKey
 
 	// tools endpoints
-	server.GET("/ip", server.Wrap(ip.IP))
-	server.GET("/dns", server.Wrap(dns.DNS))
-	server.GET("/ssl", server.Wrap(ssl.SSL))
-	server.GET("/whois", server.Wrap(whois.Whois))
+	ipAuth := auth.Middleware("ip")
+	server.GET("/ip", server.Wrap(ih.IP), metrics.Middleware("ip"), ipAuth)
+	// reverse DNS and ASN queries, limited like the DNS tool
+	server.GET("/ip/lookup", server.Wrap(ih.Lookup), metrics.Middleware("ip_lookup"), auditLog.Middleware("ip_lookup"), ipAuth, rl.Middleware(ctx, rl.DNS))
+	dnsAuth := auth.Middleware("dns")
+	server.GET("/dns", server.Wrap(dh.DNS), metrics.Middleware("dns"), auditLog.Middleware("dns"), dnsAuth, rl.Middleware(ctx, rl.DNS), reports.Middleware("dns"))
+	server.GET("/dns/verify-txt", server.Wrap(dh.VerifyTXT), metrics.Middleware("dns_verify_txt"), auditLog.Middleware("dns_verify_txt"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	server.GET("/dns/wildcard", server.Wrap(dh.Wildcard), metrics.Middleware("dns_wildcard"), auditLog.Middleware("dns_wildcard"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	server.GET("/dns/raw", server.Wrap(dh.Raw), metrics.Middleware("dns_raw"), auditLog.Middleware("dns_raw"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	server.GET("/dns/reverse", server.Wrap(dh.ReverseRange(cfg.Bulk.ReverseBatch)), metrics.Middleware("dns_reverse"), auditLog.Middleware("dns_reverse"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	server.GET("/dns/blacklist", server.Wrap(dh.Blacklist), metrics.Middleware("dns_blacklist"), auditLog.Middleware("dns_blacklist"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	server.POST("/dns/batch", server.Wrap(dh.Batch(cfg.Bulk.DNSBatch, jobManager)), metrics.Middleware("dns_batch"), auditLog.Middleware("dns_batch"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	server.GET("/dns/trace", server.Wrap(dh.Trace), metrics.Middleware("dns_trace"), auditLog.Middleware("dns_trace"), dnsAuth, rl.Middleware(ctx, rl.DNS))
+	sslAuth, sslLimit := auth.Middleware("ssl"), rl.Middleware(ctx, rl.SSL)
+	server.GET("/ssl", server.Wrap(sh.SSL), metrics.Middleware("ssl"), auditLog.Middleware("ssl"), sslAuth, sslLimit, reports.Middleware("ssl"))
+	server.POST("/ssl", server.Wrap(sh.SSLWithClientCert), metrics.Middleware("ssl"), auditLog.Middleware("ssl"), sslAuth, sslLimit)
+	// decoding makes no outbound connections, so only the global limit applies
+	server.POST("/ssl/decode", server.Wrap(sh.Decode), metrics.Middleware("ssl_decode"), sslAuth)
+	server.GET("/ssl/compare", server.Wrap(sh.Compare), metrics.Middleware("ssl_compare"), auditLog.Middleware("ssl_compare"), sslAuth, sslLimit)
+	server.GET("/ssl/ct", server.Wrap(sh.CT), metrics.Middleware("ssl_ct"), auditLog.Middleware("ssl_ct"), sslAuth, sslLimit)
+	server.POST("/ssl/batch", server.Wrap(sh.Batch(cfg.Bulk.SSLBatch, jobManager)), metrics.Middleware("ssl_batch"), auditLog.Middleware("ssl_batch"), sslAuth, sslLimit)
+	server.GET("/whois", server.Wrap(wh.Whois), metrics.Middleware("whois"), auditLog.Middleware("whois"), auth.Middleware("whois"), rl.Middleware(ctx, rl.Whois), reports.Middleware("whois"))
+	server.GET("/report/{id}", server.Wrap(reports.Get))
+	server.GET("/jobs/{id}", server.Wrap(jobManager.Get))
+
+	// combined DNS, SSL and WHOIS report of a domain
+	dr := domain.New(cfg.DomainReport, dh, sh, wh)
+	server.GET("/report", server.Wrap(dr.Report), metrics.Middleware("report"), auditLog.Middleware("report"), auth.Middleware("report"), rl.Middleware(ctx, rl.Whois), reports.Middleware("domain"))
 
 	// feedback endpoints (ALTCHA captcha + Discord webhook)
 	fb := feedback.New(cfg.Feedback)
 	server.GET("/feedback/challenge", server.Wrap(fb.Challenge))
 	server.POST("/feedback", server.Wrap(fb.Submit))
 
-	// WebRTC signaling endpoints (HTTP + SSE)
-	server.POST("/webrtc/room", webrtc.CreateRoomHandler)
-	server.POST("/webrtc/room/{code}/join", webrtc.JoinRoomHandler)
-	server.POST("/webrtc/room/{code}/signal", webrtc.SignalHandler)
-	server.GET("/webrtc/room/{code}/events", webrtc.EventsHandler)
+	// WebRTC signaling endpoints (HTTP + SSE, or WebSocket)
+	rooms := webrtc.New(cfg.WebRTC)
+	rooms.SetAllowOrigins(webSocketOrigins(cfg.Middleware))
+	rooms.Start(ctx)
+	server.POST("/webrtc/room", rooms.CreateRoomHandler)
+	server.POST("/webrtc/room/{code}/join", rooms.JoinRoomHandler)
+	server.POST("/webrtc/room/{code}/signal", rooms.SignalHandler)
+	server.GET("/webrtc/room/{code}/events", rooms.EventsHandler)
+	server.GET("/webrtc/room/{code}/ws", rooms.WebSocketHandler)
+	server.GET("/webrtc/turn", rooms.TURNHandler)
+	server.GET("/webrtc/rooms", rooms.RoomsHandler)
 
-	return server.StartWithContext(ctx, cfg.Address)
+	// API description (OpenAPI 3) and Swagger UI
+	server.GET("/openapi.json", apiSpec().Handler())
+	server.GET("/docs", openapi.DocsHandler("Bir API", "/openapi.json"))
+
+	// Prometheus metrics
+	if cfg.Metrics.Enabled {
+		server.GET(cfg.Metrics.Path, metrics.Handler().ServeHTTP)
+	}
+
+	return serve(ctx, server, cfg, rooms)
+}
+
+// serve runs the server until ctx is done, then shuts it down gracefully: it
+// stops accepting connections, closes the WebRTC rooms so event streams end,
+// and waits up to the shutdown timeout for in-flight requests before closing
+// the remaining connections.
+func serve(ctx context.Context, server *ada.Server, cfg *config, rooms *webrtc.RoomManager) error {
+	var httpServer *http.Server
+
+	started := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- start(server, cfg, func(s *http.Server) {
+			httpServer = s
+			close(started)
+		})
+	}()
+
+	select {
+	case err := <-errCh:
+		// Failed to listen
+		return err
+	case <-started:
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+
+	// Long-lived streams never finish on their own
+	rooms.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("shutdown timed out, closing connections", "error", err)
+		httpServer.Close()
+	}
+
+	return <-errCh
 }
 
 func getConfig(ctx context.Context) (*config, error) {
 	cfg := config{
 		Middleware: Middleware{
-			Cors: mcors.Cors{
-				AllowOrigins:     []string{"*"},
-				AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-				AllowHeaders:     []string{"Content-Type", "Authorization"},
-				AllowCredentials: false,
-				MaxAge:           3600,
+			Cors: cors.Config{
+				Cors: mcors.Cors{
+					AllowOrigins:     []string{"*"},
+					AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+					AllowHeaders:     []string{"Content-Type", "Authorization", "X-API-Key", "X-Room-Password", "X-Join-Token"},
+					AllowCredentials: false,
+					MaxAge:           3600,
+				},
+			},
+			RateLimit: ratelimit.Config{
+				Global: ratelimit.Limit{Rate: 10, Burst: 30},
+				DNS:    ratelimit.Limit{Rate: 5, Burst: 20},
+				SSL:    ratelimit.Limit{Rate: 1, Burst: 5},
+				Whois:  ratelimit.Limit{Rate: 0.5, Burst: 5},
 			},
 		},
+		APIKey: apikey.Config{
+			Tools: []string{"ssl", "whois", "report"},
+		},
+		Bulk: bulk.Config{
+			DNSBatch:     bulk.Limit{Concurrency: 10, MaxBatchSize: 100},
+			SSLBatch:     bulk.Limit{Concurrency: 10, MaxBatchSize: 50},
+			ReverseBatch: bulk.Limit{Concurrency: 16, MaxBatchSize: 256},
+		},
 	}
 
 	if err := chu.Load(
@@ -98,11 +315,32 @@ func getConfig(ctx context.Context) (*config, error) {
 	return &cfg, nil
 }
 
-func setMiddleware(s *ada.Server, mw Middleware) {
+// webSocketOrigins returns the origins the CORS policy of the WebRTC
+// WebSocket route allows, none without the middleware, which leaves only
+// pages of the API's own host.
+func webSocketOrigins(mw Middleware) []string {
+	if !mw.Enabled {
+		return nil
+	}
+
+	return mw.Cors.OriginsFor("/webrtc/room/{code}/ws")
+}
+
+func setMiddleware(ctx context.Context, s *ada.Server, mw Middleware) {
 	if mw.Enabled {
-		s.Use(
-			mcors.Middleware(mcors.WithConfig(mw.Cors)),
+		var middlewares []func(http.Handler) http.Handler
+		// outermost, so requests rejected by the others are logged too
+		if mw.RequestLog {
+			middlewares = append(middlewares, requestlog.Middleware)
+		}
+		middlewares = append(middlewares,
+			// inside the request log, so panics are logged with the request ID
+			recovery.Middleware,
+			mw.Compress.Middleware,
+			mw.Cors.Middleware,
+			mw.RateLimit.Middleware(ctx, mw.RateLimit.Global),
 		)
+		s.Use(middlewares...)
 
 		slog.Info("Middleware CORS configured",
 			"allow_origins", mw.Cors.AllowOrigins,
@@ -111,5 +349,28 @@ func setMiddleware(s *ada.Server, mw Middleware) {
 			"allow_credentials", mw.Cors.AllowCredentials,
 			"max_age", mw.Cors.MaxAge,
 		)
+
+		for _, profile := range mw.Cors.Profiles {
+			slog.Info("Middleware CORS profile configured",
+				"prefixes", profile.Prefixes,
+				"allow_origins", profile.AllowOrigins,
+				"allow_methods", profile.AllowMethods,
+				"allow_headers", profile.AllowHeaders,
+				"allow_credentials", profile.AllowCredentials,
+				"max_age", profile.MaxAge,
+			)
+		}
+
+		slog.Info("Middleware compression configured",
+			"enabled", mw.Compress.Enabled,
+			"min_size", mw.Compress.MinSize,
+		)
+
+		slog.Info("Middleware rate limit configured",
+			"enabled", mw.RateLimit.Enabled,
+			"global", mw.RateLimit.Global,
+		)
+	} else {
+		s.Use(recovery.Middleware)
 	}
 }
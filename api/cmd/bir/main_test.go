@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	mcors "github.com/rakunlabs/ada/middleware/cors"
+
+	"github.com/rytsh/bir/api/tools/cors"
+)
+
+func TestWebSocketOrigins(t *testing.T) {
+	app := []string{"https://app.example.com"}
+
+	tests := []struct {
+		name string
+		mw   Middleware
+		want []string
+	}{
+		{name: "disabled", mw: Middleware{Cors: cors.Config{Cors: mcors.Cors{AllowOrigins: app}}}},
+		{name: "default policy", mw: Middleware{Enabled: true}, want: []string{"*"}},
+		{
+			name: "webrtc profile",
+			mw: Middleware{Enabled: true, Cors: cors.Config{
+				Cors:     mcors.Cors{AllowOrigins: []string{"*"}},
+				Profiles: []cors.Profile{{Prefixes: []string{"/webrtc"}, Cors: mcors.Cors{AllowOrigins: app}}},
+			}},
+			want: app,
+		},
+		{
+			name: "profile of another route",
+			mw: Middleware{Enabled: true, Cors: cors.Config{
+				Cors:     mcors.Cors{AllowOrigins: []string{"https://docs.example.com"}},
+				Profiles: []cors.Profile{{Prefixes: []string{"/webrtc/rooms"}, Cors: mcors.Cors{AllowOrigins: app}}},
+			}},
+			want: []string{"https://docs.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := webSocketOrigins(tt.mw); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: webSocketOrigins() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+
+	altcha "github.com/altcha-org/altcha-lib-go/v2"
+
+	"github.com/rytsh/bir/api/tools/dns"
+	"github.com/rytsh/bir/api/tools/domain"
+	"github.com/rytsh/bir/api/tools/ip"
+	"github.com/rytsh/bir/api/tools/jobs"
+	"github.com/rytsh/bir/api/tools/openapi"
+	"github.com/rytsh/bir/api/tools/report"
+	"github.com/rytsh/bir/api/tools/ssl"
+	"github.com/rytsh/bir/api/tools/webrtc"
+	"github.com/rytsh/bir/api/tools/whois"
+)
+
+var timeoutParam = openapi.Param{
+	Name:        "timeout",
+	Description: "Override the timeout, e.g. 5s (at most 60s)",
+}
+
+var formatParam = openapi.Param{
+	Name:        "format",
+	Description: "Response format, else taken from the Accept header; default json",
+	Enum:        []string{"json", "yaml", "text"},
+}
+
+// jobParams run a batch in the background.
+var jobParams = []openapi.Param{
+	{Name: "async", Description: "Answer 202 with a job to poll at /jobs/{id}", Type: "boolean"},
+	{Name: "callback", Description: "Run as a job and POST it, signed, to this URL when done"},
+}
+
+// roomParams pick a signaling room and pass its password, which EventSource
+// and WebSocket clients can only send as a query param.
+var roomParams = []openapi.Param{
+	{Name: "code", In: "path"},
+	{Name: "X-Room-Password", In: "header", Description: "Password of a protected room"},
+	{Name: "password", Description: "Password of a protected room, instead of the header"},
+}
+
+// peerParam names the caller's peer in a room.
+var peerParam = openapi.Param{Name: "peer", Description: "Peer ID returned on create or join", Required: true}
+
+var shareParam = openapi.Param{
+	Name:        "share",
+	Description: "Save the result as a shareable report",
+	Type:        "boolean",
+}
+
+// apiSpec describes the tool, WebRTC signaling and feedback endpoints.
+// Response schemas are derived from the response structs.
+func apiSpec() openapi.Spec {
+	return openapi.Spec{
+		Title:       "Bir API",
+		Version:     version,
+		Description: "Network lookup tools, WebRTC signaling and feedback. The document itself (/openapi.json), the Swagger UI (/docs) and the Prometheus metrics endpoint are not described.",
+		Overrides: map[reflect.Type]openapi.Schema{
+			// Encoded as a plain string unless detailed=true adds a TTL
+			reflect.TypeFor[dns.Record](): {
+				"oneOf": []any{
+					openapi.Schema{"type": "string"},
+					openapi.Schema{
+						"type": "object",
+						"properties": map[string]any{
+							"value": openapi.Schema{"type": "string"},
+							"ttl":   openapi.Schema{"type": "integer"},
+						},
+						"required": []string{"value"},
+					},
+				},
+			},
+		},
+		Operations: []openapi.Operation{
+			{
+				Method:  "GET",
+				Path:    "/ip",
+				Tag:     "ip",
+				Summary: "Caller IP",
+				Params: []openapi.Param{
+					{Name: "geo", Description: "Add the approximate location, when a geo database is configured", Type: "boolean"},
+					{Name: "asn", Description: "Add the origin AS and announced prefix, when an ASN source is configured", Type: "boolean"},
+					{Name: "reverse", Description: "Add the PTR names of the IP", Type: "boolean"},
+					{Name: "anonymize", Description: "Zero the last octet of an IPv4 address or the last 80 bits of an IPv6 one", Type: "boolean"},
+					formatParam,
+				},
+				Response: ip.Response{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/ip/lookup",
+				Tag:     "ip",
+				Summary: "Version, scope, origin AS, location and PTR names of any IP",
+				Params: []openapi.Param{
+					{Name: "ip", Required: true},
+					{Name: "geo", Description: "Add the approximate location, when a geo database is configured", Type: "boolean"},
+					{Name: "asn", Description: "Set to false to leave out the origin AS and announced prefix", Type: "boolean"},
+					{Name: "reverse", Description: "Add the PTR names of the IP", Type: "boolean"},
+					formatParam,
+				},
+				Response: ip.Response{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns",
+				Tag:     "dns",
+				Summary: "DNS lookup",
+				Params: []openapi.Param{
+					{Name: "domain", Description: "Domain to look up; domain or ip is required"},
+					{Name: "ip", Description: "IP for a reverse lookup"},
+					{Name: "type", Description: "Comma separated record types, e.g. A,MX (default all)"},
+					{Name: "server", Description: "Nameserver to query, e.g. 1.1.1.1 or 8.8.8.8:53"},
+					{Name: "detailed", Description: "Include TTLs and negative caching info", Type: "boolean"},
+					{Name: "email", Description: "Check SPF, DMARC and DKIM", Type: "boolean"},
+					{Name: "selector", Description: "DKIM selector for email=true"},
+					{Name: "transport", Description: "Compare answers over IPv4 and IPv6", Enum: []string{"compare"}},
+					{Name: "doh", Description: "Resolve over DNS-over-HTTPS: true, false or an https endpoint URL"},
+					{Name: "dot", Description: "Resolve over DNS-over-TLS: true, false or a host[:port] resolver"},
+					{Name: "fallback", Description: "Use the system resolver when the DoT resolver can't be reached", Type: "boolean"},
+					{Name: "nocache", Description: "Skip the cached answer", Type: "boolean"},
+					{Name: "sort", Description: "Sort and deduplicate the addresses, and sort MX and NS records", Type: "boolean"},
+					{Name: "format", Description: "simple returns only the A and AAAA addresses as {domain, a, aaaa}; json, yaml or text the full format in that encoding", Enum: []string{"full", "simple", "json", "yaml", "text"}},
+					{Name: "ipv", Description: "Only look up the addresses of one IP family and reach the nameserver over it", Enum: []string{"4", "6"}},
+					timeoutParam,
+					shareParam,
+				},
+				Response: dns.DNSResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns/verify-txt",
+				Tag:     "dns",
+				Summary: "TXT domain ownership check",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					{Name: "name", Description: "Record name, relative to domain (e.g. _acme-challenge)"},
+					{Name: "value", Description: "Expected TXT value", Required: true},
+					{Name: "server", Description: "Nameserver to query"},
+					{Name: "doh", Description: "Resolve over DNS-over-HTTPS: true, false or an https endpoint URL"},
+					{Name: "dot", Description: "Resolve over DNS-over-TLS: true, false or a host[:port] resolver"},
+					timeoutParam,
+					formatParam,
+				},
+				Response: dns.VerifyTXTResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns/wildcard",
+				Tag:     "dns",
+				Summary: "Wildcard record detection",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					{Name: "server", Description: "Nameserver to query"},
+					{Name: "doh", Description: "Resolve over DNS-over-HTTPS: true, false or an https endpoint URL"},
+					{Name: "dot", Description: "Resolve over DNS-over-TLS: true, false or a host[:port] resolver"},
+					timeoutParam,
+					formatParam,
+				},
+				Response: dns.WildcardResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns/raw",
+				Tag:     "dns",
+				Summary: "Lookup of any record type, as raw RDATA",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					{Name: "type", Description: "Record type name or code, e.g. SVCB, TYPE64 or 64", Required: true},
+					{Name: "server", Description: "Nameserver to query"},
+					{Name: "doh", Description: "Resolve over DNS-over-HTTPS: true, false or an https endpoint URL"},
+					{Name: "dot", Description: "Resolve over DNS-over-TLS: true, false or a host[:port] resolver"},
+					timeoutParam,
+					formatParam,
+				},
+				Response: dns.RawResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns/blacklist",
+				Tag:     "dns",
+				Summary: "DNSBL (RBL) status of an IP",
+				Params: []openapi.Param{
+					{Name: "ip", Description: "IPv4 or IPv6 address to check", Required: true},
+					{Name: "server", Description: "Nameserver to query"},
+					{Name: "doh", Description: "Resolve over DNS-over-HTTPS: true, false or an https endpoint URL"},
+					{Name: "dot", Description: "Resolve over DNS-over-TLS: true, false or a host[:port] resolver"},
+					timeoutParam,
+					formatParam,
+				},
+				Response: dns.BlacklistResponse{},
+			},
+			{
+				Method:   "POST",
+				Path:     "/dns/batch",
+				Tag:      "dns",
+				Summary:  "Lookups of several domains",
+				Params:   jobParams,
+				Request:  dns.BatchRequest{},
+				Response: dns.BatchResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns/trace",
+				Tag:     "dns",
+				Summary: "Iterative resolution from the root servers",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					{Name: "type", Description: "Record type, default A", Enum: []string{"A", "AAAA", "MX", "TXT", "CNAME", "NS", "SOA", "CAA", "SRV"}},
+					formatParam,
+				},
+				Response: dns.TraceResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/dns/reverse",
+				Tag:     "dns",
+				Summary: "PTR names of the hosts of a CIDR range",
+				Params: []openapi.Param{
+					{Name: "cidr", Required: true, Description: "Range to look up, e.g. 192.0.2.0/28 (at most 256 hosts by default)"},
+					{Name: "timeout", Description: "Override the timeout of each host's lookup, e.g. 1s (at most 60s)"},
+					formatParam,
+				},
+				Response: dns.ReverseRangeResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/ssl",
+				Tag:     "ssl",
+				Summary: "SSL certificate info",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					{Name: "port", Type: "integer", Description: "Default 443, or the STARTTLS protocol port"},
+					{Name: "starttls", Description: "Upgrade a plaintext protocol", Enum: []string{"smtp", "imap", "pop3", "ftp"}},
+					{Name: "sni", Description: "Server name to send instead of the domain; empty or none sends no SNI"},
+					{Name: "alpn", Description: "Comma separated ALPN protocols to offer instead of h2,http/1.1; empty offers none"},
+					{Name: "ipv", Description: "Connect over IPv4 or IPv6 only", Enum: []string{"4", "6"}},
+					{Name: "browser", Description: "Check browser (OneCRL) revocation", Type: "boolean"},
+					{Name: "scan", Description: "Probe supported protocols and cipher suites", Type: "boolean"},
+					{Name: "headers", Description: "Fetch HTTP security headers", Type: "boolean"},
+					{Name: "preload", Description: "Check the domain against the Chromium HSTS preload list", Type: "boolean"},
+					{Name: "resumption", Description: "Handshake twice to check session resumption and 0-RTT", Type: "boolean"},
+					{Name: "nocache", Description: "Skip the cached result, when caching is configured", Type: "boolean"},
+					{Name: "warnDays", Type: "integer", Description: "Days before expiry reported as warning (default 30)"},
+					{Name: "critDays", Type: "integer", Description: "Days before expiry reported as critical (default 7)"},
+					timeoutParam,
+					shareParam,
+					formatParam,
+				},
+				Response: ssl.SSLResponse{},
+			},
+			{
+				Method:   "POST",
+				Path:     "/ssl",
+				Tag:      "ssl",
+				Summary:  "SSL certificate info with a client certificate (mTLS)",
+				Request:  ssl.InspectRequest{},
+				Response: ssl.SSLResponse{},
+			},
+			{
+				Method:   "POST",
+				Path:     "/ssl/decode",
+				Tag:      "ssl",
+				Summary:  "Certificate info of a PEM bundle or base64 DER certificate, also accepted as a raw body",
+				Request:  ssl.DecodeRequest{},
+				Response: ssl.DecodeResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/ssl/compare",
+				Tag:     "ssl",
+				Summary: "Compare the certificates of two servers",
+				Params: []openapi.Param{
+					{Name: "a", Description: "First server, host[:port]", Required: true},
+					{Name: "b", Description: "Second server, host[:port]", Required: true},
+					timeoutParam,
+					formatParam,
+				},
+				Response: ssl.CompareResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/ssl/ct",
+				Tag:     "ssl",
+				Summary: "Certificates logged in Certificate Transparency",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					{Name: "subdomains", Description: "Include certificates of subdomains", Type: "boolean"},
+					formatParam,
+				},
+				Response: ssl.CTResponse{},
+			},
+			{
+				Method:   "POST",
+				Path:     "/ssl/batch",
+				Tag:      "ssl",
+				Summary:  "Certificate expiry of several servers",
+				Params:   jobParams,
+				Request:  ssl.BatchRequest{},
+				Response: ssl.BatchResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/whois",
+				Tag:     "whois",
+				Summary: "WHOIS lookup",
+				Params: []openapi.Param{
+					{Name: "domain", Description: "One of domain, ip or asn is required"},
+					{Name: "ip"},
+					{Name: "asn", Description: "AS number, e.g. AS13335"},
+					{Name: "raw", Description: "Include the raw server responses", Type: "boolean"},
+					{Name: "deep", Description: "Follow the registry's referral to the registrar WHOIS server and merge its details", Type: "boolean"},
+					timeoutParam,
+					shareParam,
+					formatParam,
+				},
+				Response: whois.WhoisResponse{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/report",
+				Tag:     "report",
+				Summary: "Combined DNS, SSL and WHOIS report",
+				Params: []openapi.Param{
+					{Name: "domain", Required: true},
+					shareParam,
+					formatParam,
+				},
+				Response: domain.Response{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/report/{id}",
+				Tag:     "report",
+				Summary: "Shared lookup snapshot",
+				Params: []openapi.Param{
+					{Name: "id", In: "path"},
+				},
+				Response: report.Report{},
+			},
+			{
+				Method:  "GET",
+				Path:    "/jobs/{id}",
+				Tag:     "jobs",
+				Summary: "Status and result of a background batch",
+				Params: []openapi.Param{
+					{Name: "id", In: "path"},
+				},
+				Response: jobs.Job{},
+			},
+			{
+				Method:  "POST",
+				Path:    "/webrtc/room",
+				Tag:     "webrtc",
+				Summary: "Create a signaling room, joined by the caller; the body is optional",
+				Request: webrtc.CreateRoomRequest{},
+				Response: struct {
+					Room      string `json:"room"`
+					PeerID    string `json:"peerId"`
+					JoinToken string `json:"joinToken,omitempty"`
+				}{},
+			},
+			{
+				Method:  "POST",
+				Path:    "/webrtc/room/{code}/join",
+				Tag:     "webrtc",
+				Summary: "Join a room, getting the IDs of the peers already in it",
+				Params: append(slices.Clone(roomParams),
+					openapi.Param{Name: "X-Join-Token", In: "header", Description: "One-time join token of the room"},
+					openapi.Param{Name: "token", Description: "One-time join token, instead of the header"},
+				),
+				Response: struct {
+					Status string   `json:"status"`
+					PeerID string   `json:"peerId"`
+					Peers  []string `json:"peers"`
+				}{},
+			},
+			{
+				Method:  "POST",
+				Path:    "/webrtc/room/{code}/signal",
+				Tag:     "webrtc",
+				Summary: "Send a signaling message to a peer of the room",
+				Params: []openapi.Param{
+					{Name: "code", In: "path"},
+					peerParam,
+					{Name: "to", Description: "Receiving peer, instead of the message's to; optional with a single other peer"},
+				},
+				Request: webrtc.SignalMessage{},
+				Response: struct {
+					Status string `json:"status"`
+				}{},
+			},
+			{
+				Method:      "GET",
+				Path:        "/webrtc/room/{code}/events",
+				Tag:         "webrtc",
+				Summary:     "Server-sent events stream of the signaling messages for a peer",
+				Params:      append(slices.Clone(roomParams), peerParam),
+				Response:    webrtc.SignalMessage{},
+				ContentType: "text/event-stream",
+			},
+			{
+				Method:  "GET",
+				Path:    "/webrtc/room/{code}/ws",
+				Tag:     "webrtc",
+				Summary: "WebSocket carrying signaling messages both ways, instead of signal and events",
+				Params:  append(slices.Clone(roomParams), peerParam),
+				Status:  http.StatusSwitchingProtocols,
+			},
+			{
+				Method:   "GET",
+				Path:     "/webrtc/turn",
+				Tag:      "webrtc",
+				Summary:  "Short-lived TURN credentials",
+				Response: webrtc.TURNCredentials{},
+			},
+			{
+				Method:   "GET",
+				Path:     "/webrtc/rooms",
+				Tag:      "webrtc",
+				Summary:  "Active rooms (admin, requires the API key as a Bearer token)",
+				Response: webrtc.RoomsResponse{},
+			},
+			{
+				Method:   "GET",
+				Path:     "/feedback/challenge",
+				Tag:      "feedback",
+				Summary:  "ALTCHA captcha challenge for a feedback message",
+				Response: altcha.Challenge{},
+			},
+			{
+				Method:  "POST",
+				Path:    "/feedback",
+				Tag:     "feedback",
+				Summary: "Send a feedback message with the solved captcha",
+				Request: struct {
+					Name    string `json:"name"`
+					Message string `json:"message"`
+					Page    string `json:"page"`
+					Altcha  string `json:"altcha"`
+				}{},
+				Response: struct {
+					OK bool `json:"ok"`
+				}{},
+			},
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAPISpec(t *testing.T) {
+	doc := apiSpec().Build()
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatalf("marshal spec: %v", err)
+	}
+
+	paths := doc["paths"].(map[string]map[string]any)
+
+	// the routes registered in run, besides the document, docs and metrics
+	routes := []string{
+		"GET /ip", "GET /ip/lookup",
+		"GET /dns", "GET /dns/verify-txt", "GET /dns/wildcard", "GET /dns/raw", "GET /dns/reverse",
+		"GET /dns/blacklist", "POST /dns/batch", "GET /dns/trace",
+		"GET /ssl", "POST /ssl", "POST /ssl/decode", "GET /ssl/compare", "GET /ssl/ct", "POST /ssl/batch",
+		"GET /whois", "GET /report", "GET /report/{id}", "GET /jobs/{id}",
+		"GET /feedback/challenge", "POST /feedback",
+		"POST /webrtc/room", "POST /webrtc/room/{code}/join", "POST /webrtc/room/{code}/signal",
+		"GET /webrtc/room/{code}/events", "GET /webrtc/room/{code}/ws", "GET /webrtc/turn", "GET /webrtc/rooms",
+	}
+
+	documented := 0
+	for _, ops := range paths {
+		documented += len(ops)
+	}
+	if documented != len(routes) {
+		t.Errorf("spec has %d operations, want %d", documented, len(routes))
+	}
+
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		op, ok := paths[path][strings.ToLower(method)].(map[string]any)
+		if !ok {
+			t.Errorf("%s missing from the spec", route)
+			continue
+		}
+		if op["summary"] == "" || op["tags"] == nil {
+			t.Errorf("%s has no summary or tag: %v", route, op)
+		}
+
+		// path params are declared
+		for _, segment := range strings.Split(path, "/") {
+			name, ok := strings.CutPrefix(segment, "{")
+			if !ok {
+				continue
+			}
+			name = strings.TrimSuffix(name, "}")
+
+			found := false
+			params, _ := op["parameters"].([]any)
+			for _, param := range params {
+				p := param.(map[string]any)
+				found = found || p["name"] == name && p["in"] == "path"
+			}
+			if !found {
+				t.Errorf("%s doesn't declare the path param %s", route, name)
+			}
+		}
+	}
+
+	ws := paths["/webrtc/room/{code}/ws"]["get"].(map[string]any)["responses"].(map[string]any)
+	if _, ok := ws["101"]; !ok {
+		t.Errorf("ws responses = %v, want a 101", ws)
+	}
+}
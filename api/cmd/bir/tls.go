@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/rakunlabs/ada"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS configures serving HTTPS (and HTTP/2) directly, with a certificate from
+// files or from Let's Encrypt. It is off by default, for deployments behind a
+// TLS terminating proxy.
+type TLS struct {
+	Enabled bool `cfg:"enabled"`
+	// CertFile and KeyFile are the PEM certificate chain and private key.
+	CertFile string `cfg:"cert_file"`
+	KeyFile  string `cfg:"key_file"`
+	// ACMEDomains get certificates from Let's Encrypt instead of the files.
+	// The TLS-ALPN challenge needs the server reachable on port 443.
+	ACMEDomains []string `cfg:"acme_domains"`
+	// ACMEEmail is the optional contact of the Let's Encrypt account.
+	ACMEEmail string `cfg:"acme_email"`
+	// ACMECacheDir keeps the account key and certificates across restarts.
+	ACMECacheDir string `cfg:"acme_cache_dir" default:"acme-cache"`
+}
+
+// config returns the server TLS config, loading the certificate files or
+// setting up the ACME manager.
+func (t TLS) config() (*tls.Config, error) {
+	hasFiles := t.CertFile != "" || t.KeyFile != ""
+
+	switch {
+	case hasFiles && len(t.ACMEDomains) > 0:
+		return nil, errors.New("tls: set either cert_file and key_file or acme_domains, not both")
+	case hasFiles:
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load certificate: %w", err)
+		}
+
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}, nil
+	case len(t.ACMEDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.ACMEDomains...),
+			Cache:      autocert.DirCache(t.ACMECacheDir),
+			Email:      t.ACMEEmail,
+		}
+
+		// Offers h2 and http/1.1, plus acme-tls/1 for the challenge
+		cfg := manager.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+
+		return cfg, nil
+	default:
+		return nil, errors.New("tls: cert_file and key_file or acme_domains are required")
+	}
+}
+
+// start serves server on cfg.Address until it is shut down, over TLS when
+// enabled. onServer receives the http.Server once the address is listened
+// on.
+func start(server *ada.Server, cfg *config, onServer func(*http.Server)) error {
+	if !cfg.TLS.Enabled {
+		return server.Start(cfg.Address, ada.WithHTTPServerFunc(func(s *http.Server) *http.Server {
+			onServer(s)
+			return s
+		}))
+	}
+
+	tlsConfig, err := cfg.TLS.config()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return fmt.Errorf("address cannot listen %s: %w", cfg.Address, err)
+	}
+
+	// HTTP/2 is negotiated over TLS by default
+	httpServer := &http.Server{
+		Handler:           server,
+		ReadHeaderTimeout: ada.DefaultReadHeaderTimeout,
+		TLSConfig:         tlsConfig,
+	}
+	onServer(httpServer)
+
+	slog.Info("server started", "addr", listener.Addr().String(), "tls", true, "acme", len(cfg.TLS.ACMEDomains) > 0)
+
+	if err := httpServer.ServeTLS(listener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+
+	return nil
+}
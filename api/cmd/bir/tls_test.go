@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	t.Run("files", func(t *testing.T) {
+		cfg, err := TLS{CertFile: certFile, KeyFile: keyFile}.config()
+		if err != nil {
+			t.Fatalf("config() error = %v", err)
+		}
+		if len(cfg.Certificates) != 1 || cfg.GetCertificate != nil || cfg.MinVersion != tls.VersionTLS12 {
+			t.Fatalf("config() = %+v, want the file certificate and TLS 1.2 at least", cfg)
+		}
+	})
+
+	t.Run("acme", func(t *testing.T) {
+		cfg, err := TLS{ACMEDomains: []string{"example.com"}, ACMECacheDir: t.TempDir()}.config()
+		if err != nil {
+			t.Fatalf("config() error = %v", err)
+		}
+		if cfg.GetCertificate == nil || !slices.Contains(cfg.NextProtos, "acme-tls/1") || !slices.Contains(cfg.NextProtos, "h2") {
+			t.Fatalf("config() = %+v, want the ACME manager offering h2 and acme-tls/1", cfg)
+		}
+		if cfg.MinVersion != tls.VersionTLS12 {
+			t.Fatalf("MinVersion = %x, want TLS 1.2", cfg.MinVersion)
+		}
+	})
+
+	for name, cfg := range map[string]TLS{
+		"none":          {},
+		"both":          {CertFile: certFile, KeyFile: keyFile, ACMEDomains: []string{"example.com"}},
+		"key missing":   {CertFile: certFile},
+		"missing files": {CertFile: filepath.Join(t.TempDir(), "cert.pem"), KeyFile: keyFile},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := cfg.config(); err == nil {
+				t.Fatal("config() error = nil")
+			}
+		})
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM
+// files.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
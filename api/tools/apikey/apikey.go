@@ -0,0 +1,81 @@
+// Package apikey restricts tools to callers with an API key, sent as
+// "Authorization: Bearer <key>" or in the X-API-Key header.
+package apikey
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Config holds the API keys, loaded from env via chu. Authentication is off
+// while no key is set.
+type Config struct {
+	// Keys are the accepted API keys.
+	Keys []string `cfg:"keys"`
+	// Tools lists the tools that require a key, e.g. ssl, whois. The others
+	// stay open.
+	Tools []string `cfg:"tools"`
+}
+
+// Middleware returns a middleware requiring a valid key on the routes of tool.
+// When no key is configured or tool isn't protected, the middleware passes
+// requests through.
+func (c Config) Middleware(tool string) func(http.Handler) http.Handler {
+	keys := make([][]byte, 0, len(c.Keys))
+	for _, key := range c.Keys {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+
+	if len(keys) == 0 || !slices.Contains(c.Tools, tool) {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Valid(FromRequest(r), keys...) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "missing or invalid API key"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FromRequest returns the key sent with r: the X-API-Key header, or else a
+// Bearer token.
+func FromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return ""
+}
+
+// Valid reports whether given is one of keys. Every key is compared in
+// constant time, so the timing doesn't reveal which one (if any) is close.
+func Valid(given string, keys ...[]byte) bool {
+	if given == "" {
+		return false
+	}
+
+	match := 0
+	for _, key := range keys {
+		match |= subtle.ConstantTimeCompare([]byte(given), key)
+	}
+
+	return match == 1
+}
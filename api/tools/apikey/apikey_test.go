@@ -0,0 +1,49 @@
+package apikey
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	cfg := Config{Keys: []string{"key-1", "key-2"}, Tools: []string{"whois"}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		tool   string
+		header string
+		value  string
+		status int
+	}{
+		{name: "missing", tool: "whois", status: http.StatusUnauthorized},
+		{name: "invalid", tool: "whois", header: "X-API-Key", value: "key-3", status: http.StatusUnauthorized},
+		{name: "header", tool: "whois", header: "X-API-Key", value: "key-2", status: http.StatusOK},
+		{name: "bearer", tool: "whois", header: "Authorization", value: "Bearer key-1", status: http.StatusOK},
+		{name: "other scheme", tool: "whois", header: "Authorization", value: "Basic key-1", status: http.StatusUnauthorized},
+		{name: "open tool", tool: "ip", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			cfg.Middleware(tt.tool)(ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+
+	// Without keys every tool is open
+	rec := httptest.NewRecorder()
+	Config{Tools: []string{"whois"}}.Middleware("whois")(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status without keys = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,146 @@
+// Package asn resolves the origin AS and announced prefix of IP addresses,
+// from the MaxMind ASN database of the geo provider or the IP to ASN mapping
+// service of Team Cymru over DNS. Results are cached by prefix, so the
+// addresses of a network share one lookup.
+package asn
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/geo"
+)
+
+// Data sources of Config.Source.
+const (
+	SourceMMDB  = "mmdb"
+	SourceCymru = "cymru"
+	SourceNone  = "none"
+)
+
+const (
+	// cacheTTL keeps routing data, which changes rarely, for a few hours.
+	cacheTTL = 6 * time.Hour
+	// cacheSize bounds the cached prefixes.
+	cacheSize = 4096
+	// lookupTimeout bounds the Cymru queries of a lookup.
+	lookupTimeout = 3 * time.Second
+)
+
+// Config holds the ASN lookup configuration, loaded from env via chu.
+type Config struct {
+	// Source is mmdb, cymru or none. Empty uses the ASN database of the geo
+	// provider when one is configured.
+	Source    string        `cfg:"source"`
+	CacheTTL  time.Duration `cfg:"cache_ttl"`
+	CacheSize int           `cfg:"cache_size"`
+	Timeout   time.Duration `cfg:"timeout"`
+}
+
+// Info is the routing origin of an IP.
+type Info struct {
+	ASN  uint   `json:"asn,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Prefix is the announced network holding the IP, RIR the registry it
+	// was allocated by; the mmdb source doesn't know the RIR.
+	Prefix  string `json:"prefix,omitempty"`
+	RIR     string `json:"rir,omitempty"`
+	Country string `json:"country,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Resolver looks up the Info of IPs from one source. A nil Resolver means
+// none is configured.
+type Resolver struct {
+	source  string
+	geo     geo.GeoProvider
+	timeout time.Duration
+	cache   *cache
+	// lookupTXT queries the Cymru zones, stubbed in tests.
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+}
+
+// New builds a Resolver of cfg, backed by provider for the mmdb source. It
+// returns nil when no source is configured.
+func New(cfg Config, provider geo.GeoProvider) (*Resolver, error) {
+	source := strings.ToLower(strings.TrimSpace(cfg.Source))
+	if source == "" {
+		if _, ok := provider.(geo.Noop); ok || provider == nil {
+			return nil, nil
+		}
+		source = SourceMMDB
+	}
+
+	switch source {
+	case SourceNone:
+		return nil, nil
+	case SourceMMDB:
+		if _, ok := provider.(geo.Noop); ok || provider == nil {
+			return nil, fmt.Errorf("asn source %q needs a geo ASN database", source)
+		}
+	case SourceCymru:
+	default:
+		return nil, fmt.Errorf("unknown asn source %q, expected mmdb, cymru or none", cfg.Source)
+	}
+
+	return &Resolver{
+		source:    source,
+		geo:       provider,
+		timeout:   cmp.Or(cfg.Timeout, lookupTimeout),
+		cache:     newCache(cmp.Or(cfg.CacheTTL, cacheTTL), cmp.Or(cfg.CacheSize, cacheSize)),
+		lookupTXT: net.DefaultResolver.LookupTXT,
+	}, nil
+}
+
+// Lookup returns the Info of addr, or nil when it isn't announced. Failures
+// are reported in Info.Error.
+func (r *Resolver) Lookup(ctx context.Context, addr netip.Addr) *Info {
+	if r == nil {
+		return nil
+	}
+
+	addr = addr.Unmap()
+	if info, ok := r.cache.get(addr); ok {
+		return &info
+	}
+
+	var (
+		info Info
+		err  error
+	)
+	switch r.source {
+	case SourceMMDB:
+		info, err = r.lookupMMDB(addr)
+	case SourceCymru:
+		info, err = r.lookupCymru(ctx, addr)
+	}
+	if err != nil {
+		return &Info{Source: r.source, Error: err.Error()}
+	}
+	if info.ASN == 0 {
+		return nil
+	}
+
+	info.Source = r.source
+	if prefix, err := netip.ParsePrefix(info.Prefix); err == nil {
+		r.cache.set(prefix, info)
+	}
+
+	return &info
+}
+
+// lookupMMDB reads the ASN database of the geo provider.
+func (r *Resolver) lookupMMDB(addr netip.Addr) (Info, error) {
+	record, err := r.geo.Lookup(net.IP(addr.AsSlice()))
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{ASN: record.ASN, Name: record.ASOrg, Prefix: record.Network}, nil
+}
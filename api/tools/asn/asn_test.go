@@ -0,0 +1,78 @@
+package asn
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestCymruLookup(t *testing.T) {
+	var queries []string
+	r := &Resolver{
+		source:  SourceCymru,
+		timeout: time.Second,
+		cache:   newCache(time.Hour, 16),
+		lookupTXT: func(_ context.Context, name string) ([]string, error) {
+			queries = append(queries, name)
+			switch name {
+			case "1.1.1.1.origin.asn.cymru.com":
+				return []string{
+					"13335 | 1.1.1.0/16 | AU | apnic | 2011-08-11",
+					"13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11",
+				}, nil
+			case "AS13335.asn.cymru.com":
+				return []string{"13335 | US | arin | 2010-07-14 | CLOUDFLARENET, US"}, nil
+			}
+			return nil, nil
+		},
+	}
+
+	want := Info{ASN: 13335, Name: "CLOUDFLARENET, US", Prefix: "1.1.1.0/24", RIR: "APNIC", Country: "AU", Source: SourceCymru}
+
+	info := r.Lookup(context.Background(), netip.MustParseAddr("1.1.1.1"))
+	if info == nil || *info != want {
+		t.Fatalf("Lookup = %+v, want %+v", info, want)
+	}
+
+	// another address of the prefix is answered from the cache
+	info = r.Lookup(context.Background(), netip.MustParseAddr("1.1.1.200"))
+	if info == nil || *info != want {
+		t.Fatalf("cached Lookup = %+v, want %+v", info, want)
+	}
+	if len(queries) != 2 {
+		t.Errorf("queries = %v, want the origin and AS name only", queries)
+	}
+
+	if info := r.Lookup(context.Background(), netip.MustParseAddr("1.1.2.1")); info != nil {
+		t.Errorf("Lookup of an unannounced IP = %+v, want nil", info)
+	}
+}
+
+func TestCymruOriginName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.10":  "10.2.0.192.origin.asn.cymru.com",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com",
+	}
+
+	for ip, want := range tests {
+		if got := cymruOriginName(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("cymruOriginName(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestNewWithoutSource(t *testing.T) {
+	r, err := New(Config{}, nil)
+	if err != nil || r != nil {
+		t.Fatalf("New = %v, %v, want nil", r, err)
+	}
+
+	if info := r.Lookup(context.Background(), netip.MustParseAddr("1.1.1.1")); info != nil {
+		t.Errorf("Lookup = %+v, want nil", info)
+	}
+
+	if _, err := New(Config{Source: "bgp"}, nil); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+}
@@ -0,0 +1,86 @@
+package asn
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// cache is a size-bounded LRU cache of Info by announced prefix, with a fixed
+// TTL.
+type cache struct {
+	ttl     time.Duration
+	maxSize int
+	// order holds *cacheEntry values, most recently used first.
+	order   *list.List
+	entries map[netip.Prefix]*list.Element
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	prefix   netip.Prefix
+	info     Info
+	storedAt time.Time
+}
+
+func newCache(ttl time.Duration, maxSize int) *cache {
+	return &cache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[netip.Prefix]*list.Element),
+	}
+}
+
+// get returns the Info of the most specific cached prefix holding addr.
+func (c *cache) get(addr netip.Addr) (Info, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for bits := addr.BitLen(); bits >= 0; bits-- {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+
+		elem, ok := c.entries[prefix]
+		if !ok {
+			continue
+		}
+
+		entry := elem.Value.(*cacheEntry)
+		if time.Since(entry.storedAt) > c.ttl {
+			c.order.Remove(elem)
+			delete(c.entries, prefix)
+			continue
+		}
+
+		c.order.MoveToFront(elem)
+		return entry.info, true
+	}
+
+	return Info{}, false
+}
+
+// set stores info under prefix, evicting the least recently used entry when
+// the cache is full.
+func (c *cache) set(prefix netip.Prefix, info Info) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix = prefix.Masked()
+	if elem, ok := c.entries[prefix]; ok {
+		elem.Value = &cacheEntry{prefix: prefix, info: info, storedAt: time.Now()}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[prefix] = c.order.PushFront(&cacheEntry{prefix: prefix, info: info, storedAt: time.Now()})
+
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).prefix)
+	}
+}
@@ -0,0 +1,141 @@
+package asn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/rytsh/bir/api/tools/outbound"
+)
+
+// The DNS zones of the Team Cymru IP to ASN mapping service.
+const (
+	cymruOrigin  = "origin.asn.cymru.com"
+	cymruOrigin6 = "origin6.asn.cymru.com"
+	cymruASN     = "asn.cymru.com"
+)
+
+// lookupCymru queries the origin of addr, then the name of its AS.
+func (r *Resolver) lookupCymru(ctx context.Context, addr netip.Addr) (Info, error) {
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return Info{}, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	records, err := r.lookupTXT(ctx, cymruOriginName(addr))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return Info{}, nil
+		}
+		return Info{}, fmt.Errorf("origin lookup: %w", err)
+	}
+
+	info, ok := parseOrigin(records)
+	if !ok {
+		return Info{}, nil
+	}
+
+	// the name is a nice to have, the origin stands without it
+	if records, err := r.lookupTXT(ctx, fmt.Sprintf("AS%d.%s", info.ASN, cymruASN)); err == nil {
+		info.Name = parseName(records)
+	}
+
+	return info, nil
+}
+
+// cymruOriginName is the origin query name of addr: its reversed octets, or
+// nibbles for IPv6.
+func cymruOriginName(addr netip.Addr) string {
+	var labels []string
+	if addr.Is4() {
+		for _, b := range addr.As4() {
+			labels = append(labels, strconv.Itoa(int(b)))
+		}
+		labels = append(reverse(labels), cymruOrigin)
+	} else {
+		for _, b := range addr.As16() {
+			labels = append(labels, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0xf), 16))
+		}
+		labels = append(reverse(labels), cymruOrigin6)
+	}
+
+	return strings.Join(labels, ".")
+}
+
+func reverse(labels []string) []string {
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	return labels
+}
+
+// parseOrigin parses origin records, "13335 | 1.1.1.0/24 | AU | apnic |
+// 2011-08-11", keeping the most specific prefix. A prefix announced by
+// several ASes lists them all, the first is kept.
+func parseOrigin(records []string) (Info, bool) {
+	var (
+		best Info
+		bits = -1
+	)
+	for _, record := range records {
+		fields := splitFields(record)
+		if len(fields) < 4 {
+			continue
+		}
+
+		asns := strings.Fields(fields[0])
+		if len(asns) == 0 {
+			continue
+		}
+		number, err := strconv.ParseUint(asns[0], 10, 32)
+		if err != nil || number == 0 {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(fields[1])
+		if err != nil || prefix.Bits() <= bits {
+			continue
+		}
+
+		bits = prefix.Bits()
+		best = Info{
+			ASN:     uint(number),
+			Prefix:  prefix.Masked().String(),
+			Country: fields[2],
+			RIR:     strings.ToUpper(fields[3]),
+		}
+	}
+
+	return best, bits >= 0
+}
+
+// parseName returns the AS name of an AS record, "13335 | US | arin |
+// 2010-07-14 | CLOUDFLARENET, US".
+func parseName(records []string) string {
+	for _, record := range records {
+		if fields := splitFields(record); len(fields) >= 5 && fields[4] != "" {
+			return fields[4]
+		}
+	}
+
+	return ""
+}
+
+func splitFields(record string) []string {
+	fields := strings.Split(record, "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	return fields
+}
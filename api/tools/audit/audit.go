@@ -0,0 +1,233 @@
+// Package audit logs the outbound lookups the tools are asked for: who asked,
+// for which targets, and how it went, so abuse such as scanning through the
+// server can be traced afterwards. Entries go to a logger of their own,
+// apart from the request log, and hold no payloads or credentials.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/requestlog"
+	"github.com/rytsh/bir/api/tools/response"
+)
+
+const (
+	// maxTargets caps the targets of an entry, e.g. of a batch.
+	maxTargets = 256
+	// maxTargetLength cuts targets longer than a domain name can be.
+	maxTargetLength = 255
+	// maxBody caps the start of a response kept to find its error fields.
+	maxBody = 64 << 10
+)
+
+// targetParams are the query params naming a host the tools contact: the
+// domain or IP looked up, and a custom nameserver or DoT resolver.
+var targetParams = []string{"domain", "ip", "cidr", "server", "dot"}
+
+// urlParams are the query params holding a URL the tools contact, a custom
+// DoH endpoint or a job callback.
+var urlParams = []string{"doh", "callback"}
+
+// Config holds the audit log configuration, loaded from env via chu.
+type Config struct {
+	// Enabled turns the audit log on.
+	Enabled bool `cfg:"enabled"`
+	// Output is stdout, stderr or a file the entries are appended to,
+	// stdout when empty.
+	Output string `cfg:"output"`
+}
+
+// Logger writes the audit entries. A nil Logger logs nothing.
+type Logger struct {
+	logger   *slog.Logger
+	clientIP func(*http.Request) string
+}
+
+// New builds the Logger of cfg, nil when disabled. clientIP extracts the
+// caller's address of a request.
+func New(cfg Config, clientIP func(*http.Request) string) (*Logger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	w, err := output(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Logger{
+		logger:   slog.New(slog.NewJSONHandler(w, nil)),
+		clientIP: clientIP,
+	}, nil
+}
+
+// output opens the destination of the entries.
+func output(value string) (io.Writer, error) {
+	switch value {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+
+	f, err := os.OpenFile(value, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+
+	return f, nil
+}
+
+// entryKey holds the *entry of a request in its context.
+type entryKey struct{}
+
+// entry collects the targets of a request, those of the body being added by
+// the handler.
+type entry struct {
+	mu      sync.Mutex
+	targets []string
+}
+
+func (e *entry) add(targets ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target == "" || len(e.targets) >= maxTargets {
+			continue
+		}
+		if len(target) > maxTargetLength {
+			target = target[:maxTargetLength]
+		}
+		e.targets = append(e.targets, target)
+	}
+}
+
+// AddTargets records targets taken from a request body, e.g. the domains of
+// a batch, on the audit entry of ctx. Without one it does nothing.
+func AddTargets(ctx context.Context, targets ...string) {
+	if e, ok := ctx.Value(entryKey{}).(*entry); ok {
+		e.add(targets...)
+	}
+}
+
+// Middleware logs an entry for every request of tool once served, with the
+// targets of its query and those added by the handler. Rejected requests are
+// logged too, with their outcome.
+func (l *Logger) Middleware(tool string) func(http.Handler) http.Handler {
+	if l == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e := &entry{targets: []string{}}
+			query := r.URL.Query()
+			for _, param := range targetParams {
+				// dot=true or false only switches the configured resolver
+				if value := query.Get(param); value != "true" && value != "false" {
+					e.add(value)
+				}
+			}
+			for _, param := range urlParams {
+				// only the host, the URL may carry a token
+				if u, err := url.Parse(query.Get(param)); err == nil {
+					e.add(u.Host)
+				}
+			}
+
+			sw := response.NewWriter(w)
+			sw.Capture(maxBody)
+			start := time.Now()
+
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), entryKey{}, e)))
+
+			e.mu.Lock()
+			targets := e.targets
+			e.mu.Unlock()
+
+			l.logger.Info("audit",
+				"tool", tool,
+				"client_ip", l.clientIP(r),
+				"remote_addr", r.RemoteAddr,
+				"targets", targets,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", sw.Status(),
+				"outcome", outcome(sw.Status(), sw.Header().Get("Content-Type"), sw.Body()),
+				"duration_ms", float64(time.Since(start).Microseconds())/1000,
+				"request_id", w.Header().Get(requestlog.Header),
+			)
+		})
+	}
+}
+
+// outcome sums up a response from its status and, as the tools answer a
+// failed lookup with a 200 and an error field, its JSON body.
+func outcome(status int, contentType string, body []byte) string {
+	switch {
+	case status < http.StatusBadRequest:
+		if strings.HasPrefix(contentType, "application/json") {
+			return bodyOutcome(body)
+		}
+		return "ok"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "unauthorized"
+	case status == http.StatusTooManyRequests:
+		return "rate_limited"
+	case status < http.StatusInternalServerError:
+		return "rejected"
+	default:
+		return "failed"
+	}
+}
+
+// bodyOutcome reads the top-level fields of a JSON object: a non-empty error
+// means the lookup failed, non-empty errors that some of its queries did. A
+// body cut by maxBody is read up to the cut.
+func bodyOutcome(body []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return "ok"
+	}
+
+	result := "ok"
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			break
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			break
+		}
+
+		switch key {
+		case "error":
+			var msg string
+			if json.Unmarshal(value, &msg) == nil && msg != "" {
+				return "failed"
+			}
+		case "errors":
+			var errs map[string]string
+			if json.Unmarshal(value, &errs) == nil && len(errs) > 0 {
+				result = "partial"
+			}
+		}
+	}
+
+	return result
+}
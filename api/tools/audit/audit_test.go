@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{
+		logger:   slog.New(slog.NewJSONHandler(&buf, nil)),
+		clientIP: func(r *http.Request) string { return "203.0.113.7" },
+	}
+
+	handler := l.Middleware("dns_batch")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddTargets(r.Context(), "example.com", " ", "example.org")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/dns/batch?server=1.1.1.1&callback=https://hooks.example.net/in?token=s3cret", strings.NewReader(`{"domains":["example.com"]}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var got struct {
+		Msg      string   `json:"msg"`
+		Tool     string   `json:"tool"`
+		ClientIP string   `json:"client_ip"`
+		Targets  []string `json:"targets"`
+		Status   int      `json:"status"`
+		Outcome  string   `json:"outcome"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("entry %q: %v", buf.String(), err)
+	}
+
+	want := []string{"1.1.1.1", "hooks.example.net", "example.com", "example.org"}
+	if got.Msg != "audit" || got.Tool != "dns_batch" || got.ClientIP != "203.0.113.7" || !slices.Equal(got.Targets, want) ||
+		got.Status != http.StatusTooManyRequests || got.Outcome != "rate_limited" {
+		t.Fatalf("entry = %+v, want targets %q", got, want)
+	}
+	if strings.Contains(buf.String(), "s3cret") || strings.Contains(buf.String(), "domains") {
+		t.Fatalf("entry logs the request payload: %s", buf.String())
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{
+		logger:   slog.New(slog.NewJSONHandler(&buf, nil)),
+		clientIP: func(r *http.Request) string { return "203.0.113.7" },
+	}
+
+	tests := []struct {
+		query       string
+		body        string
+		wantTargets []string
+		wantOutcome string
+	}{
+		{query: "domain=example.com&dot=true", body: `{"domain":"example.com","records":{"A":["192.0.2.1"]}}`, wantTargets: []string{"example.com"}, wantOutcome: "ok"},
+		{query: "domain=example.com&doh=https://dns.example.net/dns-query/s3cret", body: `{"domain":"example.com","errors":{"MX":"timeout"}}`, wantTargets: []string{"example.com", "dns.example.net"}, wantOutcome: "partial"},
+		{query: "domain=example.com&dot=dns.example.net:853", body: `{"domain":"example.com","error":"THROTTLED"}`, wantTargets: []string{"example.com", "dns.example.net:853"}, wantOutcome: "failed"},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		handler := l.Middleware("dns")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(tt.body))
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/dns?"+tt.query, nil)
+		req.RemoteAddr = "198.51.100.4:41234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		var got struct {
+			RemoteAddr string   `json:"remote_addr"`
+			Targets    []string `json:"targets"`
+			Outcome    string   `json:"outcome"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("entry %q: %v", buf.String(), err)
+		}
+		if got.RemoteAddr != req.RemoteAddr || !slices.Equal(got.Targets, tt.wantTargets) || got.Outcome != tt.wantOutcome {
+			t.Errorf("%s: entry = %+v, want targets %q and outcome %s", tt.query, got, tt.wantTargets, tt.wantOutcome)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	l, err := New(Config{}, nil)
+	if err != nil || l != nil {
+		t.Fatalf("New(disabled) = %v, %v", l, err)
+	}
+
+	called := false
+	l.Middleware("dns")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no entry to add to
+		AddTargets(r.Context(), "example.com")
+		called = true
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/dns", nil))
+
+	if !called {
+		t.Fatal("disabled middleware didn't call the handler")
+	}
+}
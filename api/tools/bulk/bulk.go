@@ -0,0 +1,68 @@
+// Package bulk holds the limits shared by the batch/bulk endpoints, so the
+// concurrency and batch size of every bulk operation are tuned in one config
+// section instead of being hard-coded per endpoint.
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Limit caps a single bulk operation type.
+type Limit struct {
+	// Concurrency is the maximum number of items processed at once.
+	Concurrency int `cfg:"concurrency"`
+	// MaxBatchSize is the maximum number of items accepted in one call.
+	MaxBatchSize int `cfg:"max_batch_size"`
+}
+
+// Config holds the limits per bulk operation type, loaded once at startup.
+type Config struct {
+	DNSBatch     Limit `cfg:"dns_batch"`
+	SSLBatch     Limit `cfg:"ssl_batch"`
+	ReverseBatch Limit `cfg:"reverse_batch"`
+}
+
+// CheckSize returns an error when n items exceed the limit's batch size.
+func (l Limit) CheckSize(n int) error {
+	if l.MaxBatchSize > 0 && n > l.MaxBatchSize {
+		return fmt.Errorf("batch too large: %d items, maximum is %d", n, l.MaxBatchSize)
+	}
+
+	return nil
+}
+
+// ForEach calls fn for every index in [0, n) with at most l.Concurrency calls
+// running at once, and waits for all of them. Items not yet started are
+// skipped once ctx is done.
+func (l Limit) ForEach(ctx context.Context, n int, fn func(ctx context.Context, i int)) {
+	concurrency := l.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := range n {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			fn(ctx, i)
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,58 @@
+package bulk
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckSize(t *testing.T) {
+	l := Limit{MaxBatchSize: 2}
+	if err := l.CheckSize(2); err != nil {
+		t.Fatalf("CheckSize(2) = %v", err)
+	}
+	if err := l.CheckSize(3); err == nil {
+		t.Fatal("CheckSize(3) over the limit = nil")
+	}
+	if err := (Limit{}).CheckSize(1000); err != nil {
+		t.Fatalf("CheckSize() without a limit = %v", err)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+
+	Limit{Concurrency: 3}.ForEach(context.Background(), 20, func(ctx context.Context, i int) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		seen[i] = true
+		mu.Unlock()
+	})
+
+	if len(seen) != 20 {
+		t.Fatalf("ForEach() ran %d items, want 20", len(seen))
+	}
+	if p := peak.Load(); p > 3 {
+		t.Fatalf("ForEach() ran %d items at once, want at most 3", p)
+	}
+
+	// items not started when ctx is done are skipped
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran atomic.Int32
+	Limit{Concurrency: 1}.ForEach(ctx, 10, func(ctx context.Context, i int) {
+		ran.Add(1)
+		cancel()
+	})
+	if n := ran.Load(); n >= 10 {
+		t.Fatalf("ForEach() ran %d items after cancel", n)
+	}
+}
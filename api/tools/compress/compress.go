@@ -0,0 +1,276 @@
+// Package compress gzip or deflate encodes responses for clients that accept
+// it. Small bodies and streams are sent as is: a body is buffered up to the
+// minimum size before deciding, and a handler that flushes early is streaming.
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Config holds the compression configuration, loaded from env via chu.
+type Config struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+	// MinSize is the smallest body, in bytes, that is compressed.
+	MinSize int `cfg:"min_size" default:"1024"`
+}
+
+// compressedKey marks a request context as already handled by the middleware.
+type compressedKey struct{}
+
+var (
+	gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibPool = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// Middleware returns a middleware compressing the responses. When compression
+// is disabled, the middleware passes requests through.
+func (c Config) Middleware(next http.Handler) http.Handler {
+	if !c.Enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unmatched routes pass the middlewares twice; compress them once
+		if r.Context().Value(compressedKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), compressedKey{}, true))
+
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &writer{ResponseWriter: w, encoding: encoding, minSize: c.MinSize, status: http.StatusOK}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks gzip or deflate from an Accept-Encoding header, preferring
+// gzip, or returns "" when neither is accepted.
+func negotiate(header string) string {
+	q := map[string]float64{}
+	for part := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = v
+			}
+		}
+		q[coding] = weight
+	}
+
+	for _, coding := range []string{"gzip", "deflate"} {
+		weight, ok := q[coding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > 0 {
+			return coding
+		}
+	}
+
+	return ""
+}
+
+// compressible reports whether a response of contentType is worth
+// compressing. Event streams are excluded, as they must reach the client
+// unbuffered.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/javascript",
+		mediaType == "application/xml",
+		mediaType == "application/yaml":
+		return true
+	}
+
+	return false
+}
+
+// writer buffers the start of the body until it knows whether to compress.
+type writer struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	// enc is the encoder once compression started, nil when sending as is.
+	enc io.WriteCloser
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	// Informational responses go out right away
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// decide sends the headers and the buffered body, compressed when allowed and
+// the response qualifies.
+func (w *writer) decide(allow bool) error {
+	w.decided = true
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// As net/http would, before the encoding hides the body
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if compressible(h.Get("Content-Type")) {
+		h.Add("Vary", "Accept-Encoding")
+		allow = allow && len(w.buf) >= w.minSize && h.Get("Content-Encoding") == ""
+	} else {
+		allow = false
+	}
+
+	if allow {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		w.enc = w.newEncoder()
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *writer) newEncoder() io.WriteCloser {
+	if w.encoding == "gzip" {
+		gz := gzipPool.Get().(*gzip.Writer)
+		gz.Reset(w.ResponseWriter)
+		return gz
+	}
+
+	zw := zlibPool.Get().(*zlib.Writer)
+	zw.Reset(w.ResponseWriter)
+	return zw
+}
+
+// close sends what is still buffered and finishes the encoding.
+func (w *writer) close() {
+	if !w.decided {
+		if len(w.buf) == 0 && w.status == http.StatusOK {
+			// Nothing written; leave the response to net/http
+			return
+		}
+		_ = w.decide(true)
+	}
+
+	if w.enc == nil {
+		return
+	}
+
+	_ = w.enc.Close()
+	switch enc := w.enc.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(enc)
+	case *zlib.Writer:
+		zlibPool.Put(enc)
+	}
+	w.enc = nil
+}
+
+// Flush sends the response so far. Flushing before the minimum size is
+// reached marks the response as a stream, which is then sent as is.
+func (w *writer) Flush() {
+	if !w.decided {
+		_ = w.decide(false)
+	}
+
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", w.ResponseWriter)
+	}
+
+	// The connection is taken over; nothing is sent through the writer
+	w.decided = true
+	return hj.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,107 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	large := `{"raw":"` + strings.Repeat("a", 2048) + `"}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		flush          bool
+		want           string
+	}{
+		{name: "gzip", acceptEncoding: "gzip, deflate", contentType: "application/json", body: large, want: "gzip"},
+		{name: "deflate", acceptEncoding: "gzip;q=0, deflate", contentType: "application/json", body: large, want: "deflate"},
+		{name: "not accepted", acceptEncoding: "br", contentType: "application/json", body: large},
+		{name: "below threshold", acceptEncoding: "gzip", contentType: "application/json", body: `{"ip":"192.0.2.1"}`},
+		{name: "event stream", acceptEncoding: "gzip", contentType: "text/event-stream", body: large},
+		{name: "flushed early", acceptEncoding: "gzip", contentType: "application/json", body: large, flush: true},
+	}
+
+	handler := Config{Enabled: true, MinSize: 1024}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.Header.Get("X-Content-Type"))
+		if r.Header.Get("X-Flush") != "" {
+			_, _ = io.WriteString(w, r.Header.Get("X-Body")[:10])
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, r.Header.Get("X-Body")[10:])
+			return
+		}
+		_, _ = io.WriteString(w, r.Header.Get("X-Body"))
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whois", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			req.Header.Set("X-Content-Type", tt.contentType)
+			req.Header.Set("X-Body", tt.body)
+			if tt.flush {
+				req.Header.Set("X-Flush", "1")
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+
+			var body io.Reader = rec.Body
+			switch tt.want {
+			case "gzip":
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			case "deflate":
+				zr, err := zlib.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			if tt.want != "" && rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("Vary = %q", rec.Header().Get("Vary"))
+			}
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Fatalf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"gzip":                  "gzip",
+		"deflate, gzip":         "gzip",
+		"gzip;q=0, deflate":     "deflate",
+		"*":                     "gzip",
+		"*;q=0":                 "",
+		"br, identity":          "",
+		"GZIP ; q=0.5, deflate": "gzip",
+	}
+
+	for header, want := range tests {
+		if got := negotiate(header); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
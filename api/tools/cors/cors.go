@@ -0,0 +1,85 @@
+// Package cors applies a CORS policy chosen by route prefix, so some routes,
+// such as the WebRTC signaling ones, can allow specific origins with
+// credentials while the rest of the API stays open.
+package cors
+
+import (
+	"net/http"
+	"strings"
+
+	mcors "github.com/rakunlabs/ada/middleware/cors"
+)
+
+// Config holds the CORS configuration, loaded from env via chu. The embedded
+// policy applies to the routes no profile matches.
+type Config struct {
+	mcors.Cors
+	// Profiles replace the default policy under their prefixes.
+	Profiles []Profile `cfg:"profiles"`
+}
+
+// Profile is the CORS policy of the routes under its prefixes. Its fields are
+// not merged with the default policy.
+type Profile struct {
+	// Prefixes are path prefixes matched on segment boundaries: /webrtc
+	// matches /webrtc and /webrtc/room but not /webrtcx. The longest match
+	// among the profiles wins.
+	Prefixes []string `cfg:"prefixes"`
+	mcors.Cors
+}
+
+// Middleware returns a middleware answering preflights and setting the CORS
+// headers with the policy of the request path.
+func (c Config) Middleware(next http.Handler) http.Handler {
+	defaultHandler := c.Cors.Middleware()(next)
+
+	handlers := make([]http.Handler, len(c.Profiles))
+	for i, profile := range c.Profiles {
+		handlers[i] = profile.Cors.Middleware()(next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if i := c.profile(r.URL.Path); i >= 0 {
+			handlers[i].ServeHTTP(w, r)
+			return
+		}
+
+		defaultHandler.ServeHTTP(w, r)
+	})
+}
+
+// OriginsFor returns the origins allowed by the policy of path, "*" when
+// that policy leaves them unset.
+func (c Config) OriginsFor(path string) []string {
+	origins := c.Cors.AllowOrigins
+	if i := c.profile(path); i >= 0 {
+		origins = c.Profiles[i].AllowOrigins
+	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+
+	return origins
+}
+
+// profile returns the index of the profile with the longest prefix holding
+// path, -1 when none does.
+func (c Config) profile(path string) int {
+	found, matched := -1, -1
+	for i, profile := range c.Profiles {
+		for _, prefix := range profile.Prefixes {
+			prefix = strings.TrimSuffix(prefix, "/")
+			if len(prefix) > matched && hasPathPrefix(path, prefix) {
+				found, matched = i, len(prefix)
+			}
+		}
+	}
+
+	return found
+}
+
+// hasPathPrefix reports whether path is prefix or lies under it.
+func hasPathPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || rest[0] == '/' || prefix == "")
+}
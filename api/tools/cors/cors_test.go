@@ -0,0 +1,74 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mcors "github.com/rakunlabs/ada/middleware/cors"
+)
+
+func TestMiddleware(t *testing.T) {
+	cfg := Config{
+		Cors: mcors.Cors{AllowOrigins: []string{"*"}},
+		Profiles: []Profile{{
+			Prefixes: []string{"/webrtc/"},
+			Cors:     mcors.Cors{AllowOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+		}},
+	}
+	handler := cfg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path, origin    string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{path: "/dns", origin: "https://other.example", wantOrigin: "*"},
+		{path: "/webrtc/room", origin: "https://app.example.com", wantOrigin: "https://app.example.com", wantCredentials: "true"},
+		{path: "/webrtc", origin: "https://app.example.com", wantOrigin: "https://app.example.com", wantCredentials: "true"},
+		{path: "/webrtc/room", origin: "https://other.example"},
+		{path: "/webrtcx", origin: "https://other.example", wantOrigin: "*"},
+	}
+
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodGet, http.MethodOptions} {
+			req := httptest.NewRequest(method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("%s %s from %s: Access-Control-Allow-Origin = %q, want %q", method, tt.path, tt.origin, got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("%s %s from %s: Access-Control-Allow-Credentials = %q, want %q", method, tt.path, tt.origin, got, tt.wantCredentials)
+			}
+		}
+	}
+}
+
+func TestOriginsFor(t *testing.T) {
+	cfg := Config{
+		Profiles: []Profile{
+			{Prefixes: []string{"/webrtc"}, Cors: mcors.Cors{AllowOrigins: []string{"https://app.example.com"}}},
+			{Prefixes: []string{"/webrtc/rooms"}},
+		},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/dns", want: "*"},
+		{path: "/webrtc/room/{code}/ws", want: "https://app.example.com"},
+		{path: "/webrtc/rooms", want: "*"},
+		{path: "/webrtc/rooms/extra", want: "*"},
+		{path: "/webrtcx", want: "*"},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.OriginsFor(tt.path); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("OriginsFor(%q) = %q, want [%q]", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Package deflate compresses the large text payloads the caches keep, such
+// as raw WHOIS answers and PEM certificates, when they are configured to
+// trade CPU for memory.
+package deflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// Compress deflates s, for speed rather than size.
+func Compress(s string) []byte {
+	var buf bytes.Buffer
+	// Only an invalid level fails
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	_, _ = io.WriteString(w, s)
+	_ = w.Close()
+
+	return buf.Bytes()
+}
+
+// Decompress inflates what Compress deflated.
+func Decompress(b []byte) string {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+
+	// Read from memory, written by Compress: it can't fail
+	raw, _ := io.ReadAll(r)
+
+	return string(raw)
+}
@@ -0,0 +1,18 @@
+package deflate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "-----BEGIN CERTIFICATE-----\n" + strings.Repeat("MIIB", 500) + "\n-----END CERTIFICATE-----\n"} {
+		compressed := Compress(s)
+		if got := Decompress(compressed); got != s {
+			t.Fatalf("Decompress(Compress(%.20q)) = %.20q", s, got)
+		}
+		if len(s) > 1000 && len(compressed) >= len(s) {
+			t.Fatalf("Compress() of %d bytes gave %d", len(s), len(compressed))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/audit"
+	"github.com/rytsh/bir/api/tools/bulk"
+	"github.com/rytsh/bir/api/tools/jobs"
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+// maxBatchRequestSize caps the POST /dns/batch body.
+const maxBatchRequestSize = 64 << 10
+
+// BatchRequest is the body of POST /dns/batch.
+type BatchRequest struct {
+	Domains []string `json:"domains"`
+	// Type is a comma separated list of record types, every supported type
+	// when empty.
+	Type string `json:"type,omitempty"`
+}
+
+// BatchResponse is the response of POST /dns/batch. Results are in the order
+// of the domains.
+type BatchResponse struct {
+	Results []DNSResponse `json:"results,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Batch returns the handler of POST /dns/batch, looking up several domains at
+// once within limit, in the background when the request asks for a job.
+func (h *Handler) Batch(limit bulk.Limit, manager *jobs.Manager) func(c *ada.Context) error {
+	return func(c *ada.Context) error {
+		c.Request.Body = http.MaxBytesReader(c.Response, c.Request.Body, maxBatchRequestSize)
+
+		var req BatchRequest
+		if err := c.Bind(&req); err != nil {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: "invalid request body"})
+		}
+
+		audit.AddTargets(c.Request.Context(), req.Domains...)
+
+		if len(req.Domains) == 0 {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: "domains are required"})
+		}
+		if err := limit.CheckSize(len(req.Domains)); err != nil {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: err.Error()})
+		}
+
+		types, err := parseRecordTypes(req.Type)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: err.Error()})
+		}
+
+		return manager.Run(c, "dns_batch", func(ctx context.Context) any {
+			return BatchResponse{Results: h.LookupBatch(ctx, limit, req.Domains, types)}
+		})
+	}
+}
+
+// LookupBatch looks up the types of domains, at most limit.Concurrency at a
+// time. Invalid domains and failures are reported per result.
+func (h *Handler) LookupBatch(ctx context.Context, limit bulk.Limit, domains []string, types map[string]bool) []DNSResponse {
+	results := make([]DNSResponse, len(domains))
+	for i, domain := range domains {
+		// Kept for the domains skipped once the request is canceled
+		results[i] = DNSResponse{Domain: strings.TrimSpace(domain), Error: "not looked up"}
+	}
+
+	limit.ForEach(ctx, len(domains), func(ctx context.Context, i int) {
+		response, err := h.lookupTypes(ctx, domains[i], types)
+		if err != nil {
+			response = DNSResponse{Domain: strings.TrimSpace(domains[i]), Error: err.Error()}
+		}
+		results[i] = response
+	})
+
+	return results
+}
@@ -0,0 +1,223 @@
+package dns
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	mdns "github.com/miekg/dns"
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/retry"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+// Zones checked when Config.Blacklists or Config.Blacklists6 isn't set. Few
+// lists cover IPv6 yet.
+var (
+	defaultBlacklists  = []string{"zen.spamhaus.org", "bl.spamcop.net", "b.barracudacentral.org"}
+	defaultBlacklists6 = []string{"zen.spamhaus.org"}
+)
+
+// refusedPrefix holds the answers with which some lists, Spamhaus among
+// them, refuse a query, e.g. 127.255.255.254 for one relayed by a public
+// resolver; they don't mean the IP is listed.
+var refusedPrefix = netip.MustParsePrefix("127.255.255.0/24")
+
+// listedPrefix holds the answers meaning the IP is listed.
+var listedPrefix = netip.MustParsePrefix("127.0.0.0/8")
+
+var errIPRequired = errors.New("ip parameter is required")
+
+// BlacklistResponse holds the status of an IP on the DNSBL zones.
+type BlacklistResponse struct {
+	IP string `json:"ip,omitempty"`
+	// Listed is true when at least one zone lists the IP.
+	Listed bool            `json:"listed"`
+	Zones  []BlacklistZone `json:"zones,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// BlacklistZone is the answer of one DNSBL zone.
+type BlacklistZone struct {
+	Zone   string `json:"zone"`
+	Listed bool   `json:"listed"`
+	// Codes are the 127.0.0.x answers, whose meaning is up to the zone;
+	// Reasons are its TXT records, usually a link explaining the listing.
+	Codes   []string `json:"codes,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Blacklist handles GET /dns/blacklist?ip= - checks an IP against the
+// configured DNSBL zones, all at once.
+func (h *Handler) Blacklist(c *ada.Context) error {
+	query := c.Request.URL.Query()
+	serverParam := strings.TrimSpace(query.Get("server"))
+
+	addr, err := parseBlacklistIP(strings.TrimSpace(query.Get("ip")))
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, BlacklistResponse{Error: err.Error()})
+	}
+
+	var opts lookupOptions
+	opts.timeout, err = timeout.FromQuery(query)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, BlacklistResponse{Error: err.Error()})
+	}
+	opts.timeout = cmp.Or(opts.timeout, h.cfg.Timeout)
+
+	if serverParam != "" {
+		opts.server, err = parseNameserver(serverParam)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, BlacklistResponse{Error: err.Error()})
+		}
+	}
+
+	if err := h.parseResolver(&opts, query.Get("dot"), query.Get("doh")); err != nil {
+		return render.Send(c, http.StatusBadRequest, BlacklistResponse{Error: err.Error()})
+	}
+
+	if err := h.checkTargets(c.Request.Context(), &opts); err != nil {
+		return render.Send(c, http.StatusBadRequest, BlacklistResponse{Error: err.Error()})
+	}
+
+	response, err := checkBlacklists(c.Request.Context(), addr, h.blacklists(addr), opts)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), BlacklistResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// blacklists returns the zones addr is checked against.
+func (h *Handler) blacklists(addr netip.Addr) []string {
+	zones, defaults := h.cfg.Blacklists, defaultBlacklists
+	if addr.Is6() {
+		zones, defaults = h.cfg.Blacklists6, defaultBlacklists6
+	}
+	if len(zones) == 0 {
+		return defaults
+	}
+
+	return zones
+}
+
+// parseBlacklistIP parses the IP to check, an IPv4-mapped IPv6 address being
+// checked as IPv4.
+func parseBlacklistIP(value string) (netip.Addr, error) {
+	if value == "" {
+		return netip.Addr{}, errIPRequired
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, errInvalidIP
+	}
+
+	return addr.Unmap(), nil
+}
+
+// blacklistName returns the name queried for addr in zone: its octets, or
+// for IPv6 its nibbles, in reverse order under the zone.
+func blacklistName(addr netip.Addr, zone string) string {
+	// addr is valid, it can't fail
+	reversed, _ := mdns.ReverseAddr(addr.String())
+	reversed = strings.TrimSuffix(strings.TrimSuffix(reversed, "in-addr.arpa."), "ip6.arpa.")
+
+	return reversed + strings.Trim(zone, ".")
+}
+
+// checkBlacklists queries every zone for addr concurrently.
+func checkBlacklists(ctx context.Context, addr netip.Addr, zones []string, opts lookupOptions) (BlacklistResponse, error) {
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return BlacklistResponse{}, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(opts.timeout, lookupTimeout))
+	defer cancel()
+
+	server := cmp.Or(opts.dot, opts.doh, opts.server, systemNameserver())
+
+	response := BlacklistResponse{
+		IP:    addr.String(),
+		Zones: make([]BlacklistZone, len(zones)),
+	}
+
+	var wg sync.WaitGroup
+	for i, zone := range zones {
+		wg.Go(func() {
+			response.Zones[i] = checkBlacklist(ctx, opts.guard, server, blacklistName(addr, zone), zone)
+		})
+	}
+	wg.Wait()
+
+	for _, zone := range response.Zones {
+		response.Listed = response.Listed || zone.Listed
+	}
+
+	return response, nil
+}
+
+// checkBlacklist looks name up in zone. An A answer lists the IP, and its TXT
+// records then give the reason; a name that doesn't exist means it isn't
+// listed.
+func checkBlacklist(ctx context.Context, guard *netguard.Guard, server, name, zone string) BlacklistZone {
+	result := BlacklistZone{Zone: zone}
+
+	var rrs []mdns.RR
+	_, err := retry.Do(ctx, "dns", func() (err error) {
+		rrs, err = query(ctx, guard, server, name, mdns.TypeA)
+		return err
+	})
+	if err != nil {
+		if !isNotFoundError(err) {
+			metrics.UpstreamFailure("dns")
+			result.Error = simplifyError(err)
+		}
+		return result
+	}
+
+	for _, rr := range rrs {
+		a, ok := rr.(*mdns.A)
+		if !ok {
+			continue
+		}
+		code, _ := netip.AddrFromSlice(a.A.To4())
+
+		switch {
+		case refusedPrefix.Contains(code):
+			result.Error = fmt.Sprintf("query refused by the zone (%s)", code)
+			return result
+		case !listedPrefix.Contains(code):
+			result.Error = fmt.Sprintf("unexpected answer %s, the zone may be defunct", code)
+			return result
+		}
+		result.Codes = append(result.Codes, code.String())
+	}
+	result.Listed = len(result.Codes) > 0
+
+	if result.Listed {
+		// the listing stands without a reason
+		if rrs, err := query(ctx, guard, server, name, mdns.TypeTXT); err == nil {
+			for _, rr := range rrs {
+				if txt, ok := rr.(*mdns.TXT); ok {
+					result.Reasons = append(result.Reasons, strings.Join(txt.Txt, ""))
+				}
+			}
+		}
+	}
+
+	return result
+}
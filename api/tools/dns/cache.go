@@ -0,0 +1,210 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/outbound"
+)
+
+// cache is a size-bounded LRU cache of forward lookup responses. Each entry
+// expires on its own, after the TTL of its records.
+type cache struct {
+	maxSize int
+	// order holds *cacheEntry values, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	key       string
+	response  DNSResponse
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+func newCache(maxSize int) *cache {
+	return &cache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached response for key and when it was stored.
+func (c *cache) get(key string) (DNSResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return DNSResponse{}, time.Time{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return DNSResponse{}, time.Time{}, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return entry.response, entry.storedAt, true
+}
+
+// set stores response under key for ttl, evicting the least recently used
+// entry when the cache is full.
+func (c *cache) set(key string, response DNSResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry := &cacheEntry{key: key, response: response, storedAt: now, expiresAt: now.Add(ttl)}
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cachedLookup runs a forward lookup of domain through the cache. Concurrent
+// lookups of the same key share a single upstream lookup. With opts.noCache
+// the cache isn't read, but the fresh response still replaces the cached one.
+// The error is outbound.ErrBusy when no lookup slot is free.
+func (h *Handler) cachedLookup(ctx context.Context, domain string, opts lookupOptions) (DNSResponse, error) {
+	if h.cfg.CacheTTL <= 0 {
+		release, err := outbound.Acquire(ctx)
+		if err != nil {
+			return DNSResponse{}, err
+		}
+		defer release()
+
+		return lookup(ctx, domain, opts), nil
+	}
+
+	key := opts.cacheKey(domain)
+
+	if !opts.noCache {
+		response, storedAt, ok := h.cache.get(key)
+		metrics.DNSCache(ok)
+		if ok {
+			response.Cached = true
+			response.CacheAge = int64(time.Since(storedAt).Seconds())
+			return response, nil
+		}
+	}
+
+	value, err, _ := h.group.Do(key, func() (any, error) {
+		// Shared by every waiting request, so it mustn't end with the first
+		ctx := context.WithoutCancel(ctx)
+
+		release, err := outbound.Acquire(ctx)
+		if err != nil {
+			return DNSResponse{}, err
+		}
+		defer release()
+
+		response := lookup(ctx, domain, opts)
+
+		// Only cache complete answers; failures, and answers given while the
+		// DoT resolver was down, should be retried
+		if response.Error == "" && len(response.Errors) == 0 && response.Fallback == "" {
+			if ttl := cacheTTL(response, h.cfg.CacheTTL, h.cfg.NegativeCacheTTL); ttl > 0 {
+				h.cache.set(key, response, ttl)
+			}
+		}
+
+		return response, nil
+	})
+
+	if err != nil {
+		return DNSResponse{}, err
+	}
+
+	return value.(DNSResponse), nil
+}
+
+// cacheKey identifies the response of a forward lookup of domain: the record
+// types and every option that changes the answer.
+func (o lookupOptions) cacheKey(domain string) string {
+	types := make([]string, 0, len(recordTypes))
+	for _, t := range recordTypes {
+		if o.types[t] {
+			types = append(types, t)
+		}
+	}
+
+	return fmt.Sprintf("%s|%s|detailed=%t|server=%s|doh=%s|dot=%s|fallback=%t|email=%t|selector=%s|transport=%t|ipv=%d",
+		domain, strings.Join(types, ","), o.detailed, o.server, o.doh, o.dot, o.fallback, o.email, o.dkimSelector, o.compareTransport, o.ipv)
+}
+
+// cacheTTL returns how long response may be cached: for a negative answer
+// the lower of its SOA negative TTL and negative, otherwise the lowest TTL of
+// its records, or fallback when the TTLs aren't known (detailed=false).
+func cacheTTL(response DNSResponse, fallback, negative time.Duration) time.Duration {
+	if response.Negative {
+		if response.NegativeTTL != nil {
+			return min(time.Duration(*response.NegativeTTL)*time.Second, negative)
+		}
+		return negative
+	}
+
+	if ttl, ok := response.Records.minTTL(); ok {
+		return time.Duration(ttl) * time.Second
+	}
+
+	return fallback
+}
+
+// minTTL returns the lowest TTL of the records, false when none is known.
+func (r *DNSRecords) minTTL() (uint32, bool) {
+	if r == nil {
+		return 0, false
+	}
+
+	var (
+		lowest uint32
+		found  bool
+	)
+	add := func(ttl *uint32) {
+		if ttl != nil && (!found || *ttl < lowest) {
+			lowest, found = *ttl, true
+		}
+	}
+
+	for _, list := range [][]Record{r.A, r.AAAA, r.TXT, r.CNAME, r.NS} {
+		for _, record := range list {
+			add(record.TTL)
+		}
+	}
+	for _, mx := range r.MX {
+		add(mx.TTL)
+	}
+	for _, caa := range r.CAA {
+		add(caa.TTL)
+	}
+	for _, srv := range r.SRV {
+		add(srv.TTL)
+	}
+	if r.SOA != nil {
+		add(r.SOA.TTL)
+	}
+
+	return lowest, found
+}
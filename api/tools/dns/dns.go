@@ -1,75 +1,451 @@
 package dns
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rakunlabs/ada"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
 )
 
+// Record is a single record value. It is rendered as a plain string unless its
+// TTL is known (detailed=true), in which case it becomes {"value", "ttl"}.
+type Record struct {
+	Value string  `json:"value"`
+	TTL   *uint32 `json:"ttl,omitempty"`
+}
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	if r.TTL == nil {
+		return json.Marshal(r.Value)
+	}
+
+	type record Record
+	return json.Marshal(record(r))
+}
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*r = Record{}
+		return json.Unmarshal(data, &r.Value)
+	}
+
+	type record Record
+	return json.Unmarshal(data, (*record)(r))
+}
+
 type MXRecord struct {
-	Host     string `json:"host"`
-	Priority uint16 `json:"priority"`
+	Host     string  `json:"host"`
+	Priority uint16  `json:"priority"`
+	TTL      *uint32 `json:"ttl,omitempty"`
 }
 
 type SOARecord struct {
-	NS      string `json:"ns"`
-	Mbox    string `json:"mbox"`
-	Serial  uint32 `json:"serial"`
-	Refresh uint32 `json:"refresh"`
-	Retry   uint32 `json:"retry"`
-	Expire  uint32 `json:"expire"`
-	MinTTL  uint32 `json:"minTtl"`
+	NS      string  `json:"ns"`
+	Mbox    string  `json:"mbox"`
+	Serial  uint32  `json:"serial"`
+	Refresh uint32  `json:"refresh"`
+	Retry   uint32  `json:"retry"`
+	Expire  uint32  `json:"expire"`
+	MinTTL  uint32  `json:"minTtl"`
+	TTL     *uint32 `json:"ttl,omitempty"`
+}
+
+type CAARecord struct {
+	Flag  uint8   `json:"flag"`
+	Tag   string  `json:"tag"`
+	Value string  `json:"value"`
+	TTL   *uint32 `json:"ttl,omitempty"`
+}
+
+type SRVRecord struct {
+	Target   string  `json:"target"`
+	Port     uint16  `json:"port"`
+	Priority uint16  `json:"priority"`
+	Weight   uint16  `json:"weight"`
+	TTL      *uint32 `json:"ttl,omitempty"`
 }
 
 type DNSResponse struct {
-	Domain  string            `json:"domain,omitempty"`
-	IP      string            `json:"ip,omitempty"`
-	Records *DNSRecords       `json:"records,omitempty"`
-	Reverse []string          `json:"reverse,omitempty"`
-	Error   string            `json:"error,omitempty"`
-	Errors  map[string]string `json:"errors,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	// UnicodeDomain is the Unicode form of an internationalized Domain.
+	UnicodeDomain string            `json:"unicodeDomain,omitempty"`
+	IP            string            `json:"ip,omitempty"`
+	Records       *DNSRecords       `json:"records,omitempty"`
+	Resolver      string            `json:"resolver,omitempty"`
+	Fallback      string            `json:"fallback,omitempty"`
+	Reverse       []string          `json:"reverse,omitempty"`
+	NXDomain      bool              `json:"nxdomain,omitempty"`
+	NegativeTTL   *uint32           `json:"negativeTtl,omitempty"`
+	Negative      bool              `json:"negative,omitempty"`
+	Email         *EmailInfo        `json:"email,omitempty"`
+	Transport     *TransportCompare `json:"transport,omitempty"`
+	Cached        bool              `json:"cached,omitempty"`
+	// CacheAge is how long ago, in seconds, the cached response was fetched.
+	CacheAge int64             `json:"cacheAge,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"`
+	// Retries counts the queries retried after a transient failure.
+	Retries int `json:"retries,omitempty"`
 }
 
 type DNSRecords struct {
-	A     []string   `json:"A,omitempty"`
-	AAAA  []string   `json:"AAAA,omitempty"`
-	MX    []MXRecord `json:"MX,omitempty"`
-	TXT   []string   `json:"TXT,omitempty"`
-	CNAME []string   `json:"CNAME,omitempty"`
-	NS    []string   `json:"NS,omitempty"`
-	SOA   *SOARecord `json:"SOA,omitempty"`
+	A     []Record    `json:"A,omitempty"`
+	AAAA  []Record    `json:"AAAA,omitempty"`
+	MX    []MXRecord  `json:"MX,omitempty"`
+	TXT   []Record    `json:"TXT,omitempty"`
+	CNAME []Record    `json:"CNAME,omitempty"`
+	NS    []Record    `json:"NS,omitempty"`
+	SOA   *SOARecord  `json:"SOA,omitempty"`
+	CAA   []CAARecord `json:"CAA,omitempty"`
+	SRV   []SRVRecord `json:"SRV,omitempty"`
+}
+
+// recordTypes lists the record types supported by forward lookups, in the
+// order they are queried.
+var recordTypes = []string{"A", "AAAA", "MX", "TXT", "CNAME", "NS", "SOA", "CAA", "SRV"}
+
+// Config holds the DNS tool configuration, loaded from env via chu.
+type Config struct {
+	// DoHURL is the DNS-over-HTTPS (RFC 8484) endpoint used by doh=true.
+	DoHURL string `cfg:"doh_url" default:"https://cloudflare-dns.com/dns-query"`
+	// DoH resolves forward lookups over DoH unless a request sets doh=false.
+	DoH bool `cfg:"doh"`
+	// DoTServer is the DNS-over-TLS (RFC 7858) resolver used by dot=true, as
+	// host[:port]; its certificate is verified against host.
+	DoTServer string `cfg:"dot_server" default:"cloudflare-dns.com"`
+	// DoT resolves forward lookups over DoT unless a request sets dot=false.
+	DoT bool `cfg:"dot"`
+	// CacheTTL is how long a forward lookup is cached when the TTLs of its
+	// records aren't known; zero disables the cache.
+	CacheTTL time.Duration `cfg:"cache_ttl" default:"60s"`
+	// CacheSize caps the number of cached lookups (least recently used are
+	// evicted first).
+	CacheSize int `cfg:"cache_size" default:"1000"`
+	// NegativeCacheTTL caps how long a negative answer, NXDOMAIN or NODATA
+	// for every queried type, is cached: the SOA negative TTL when lower.
+	// Zero doesn't cache them.
+	NegativeCacheTTL time.Duration `cfg:"negative_cache_ttl" default:"30s"`
+	// Timeout bounds a lookup. When unset a forward lookup gets 15s, and a
+	// TXT check or reverse lookup 10s. Requests may override it with the
+	// timeout parameter.
+	Timeout time.Duration `cfg:"timeout"`
+	// Blacklists are the DNSBL zones /dns/blacklist checks IPv4 addresses
+	// against, Blacklists6 those it checks IPv6 ones against. Unset, a few
+	// well-known zones are used.
+	Blacklists  []string `cfg:"blacklists"`
+	Blacklists6 []string `cfg:"blacklists6"`
+}
+
+// Default timeouts, used when Config.Timeout isn't set.
+const (
+	lookupTimeout  = 15 * time.Second
+	verifyTimeout  = 10 * time.Second
+	reverseTimeout = 10 * time.Second
+)
+
+// Handler serves the DNS endpoints.
+type Handler struct {
+	cfg   Config
+	guard *netguard.Guard
+	cache *cache
+	group singleflight.Group
+}
+
+// New builds a DNS Handler from the given config. A non-nil guard refuses
+// custom nameservers and DoH endpoints on internal addresses.
+func New(cfg Config, guard *netguard.Guard) *Handler {
+	return &Handler{cfg: cfg, guard: guard, cache: newCache(cfg.CacheSize)}
 }
 
 // DNS handles DNS lookup requests
-func DNS(c *ada.Context) error {
+func (h *Handler) DNS(c *ada.Context) error {
 	domain := strings.TrimSpace(c.Request.URL.Query().Get("domain"))
 	ip := strings.TrimSpace(c.Request.URL.Query().Get("ip"))
+	typeParam := strings.TrimSpace(c.Request.URL.Query().Get("type"))
+	serverParam := strings.TrimSpace(c.Request.URL.Query().Get("server"))
+	selector := strings.ToLower(strings.TrimSpace(c.Request.URL.Query().Get("selector")))
+
+	requestTimeout, err := timeout.FromQuery(c.Request.URL.Query())
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+
+	format := c.Request.URL.Query().Get("format")
+	// json, yaml and text select the encoding of the full format
+	if format != "" && format != FormatFull && format != FormatSimple && !render.IsFormat(format) {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: errInvalidFormat.Error()})
+	}
 
 	// Reverse DNS lookup
 	if ip != "" {
-		return handleReverseLookup(c, ip)
+		if format == FormatSimple {
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: errSimpleReverse.Error()})
+		}
+		return h.handleReverseLookup(c, ip, requestTimeout)
 	}
 
 	// Forward DNS lookup
 	if domain == "" {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(DNSResponse{Error: "domain or ip parameter is required"})
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "domain or ip parameter is required"})
 	}
 
 	// Clean domain (remove protocol if present)
-	domain = cleanDomain(domain)
+	domain, err = cleanDomain(domain)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+
+	if !isValidDomain(domain) {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: errInvalidDomain.Error()})
+	}
+
+	types, err := parseRecordTypes(typeParam)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+	if format == FormatSimple {
+		if types, err = simpleTypesOf(types, typeParam == ""); err != nil {
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+		}
+	}
+
+	ipv, err := parseIPVersion(c.Request.URL.Query().Get("ipv"))
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+	if ipv != 0 {
+		if types, err = ipvTypes(types, typeParam == "", ipv); err != nil {
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+		}
+	}
+
+	opts := lookupOptions{
+		types:    types,
+		detailed: c.Request.URL.Query().Get("detailed") == "true",
+		email:    c.Request.URL.Query().Get("email") == "true",
+		noCache:  c.Request.URL.Query().Get("nocache") == "true",
+		timeout:  cmp.Or(requestTimeout, h.cfg.Timeout),
+		ipv:      ipv,
+	}
+
+	switch transport := c.Request.URL.Query().Get("transport"); transport {
+	case "":
+	case "compare":
+		if opts.ipv != 0 {
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: errIPVTransport.Error()})
+		}
+		opts.compareTransport = true
+	default:
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "invalid transport, expected compare"})
+	}
+
+	if selector != "" {
+		if !isValidLabel(selector) {
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "invalid DKIM selector"})
+		}
+		opts.dkimSelector = selector
+	}
+
+	if serverParam != "" {
+		opts.server, err = parseNameserver(serverParam)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+		}
+	}
+
+	dohParam := c.Request.URL.Query().Get("doh")
+	dotParam := c.Request.URL.Query().Get("dot")
+	if opts.ipv != 0 {
+		switch {
+		case opts.server != "" && !isFamily(opts.server, opts.ipv):
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: errIPVServer.Error()})
+		case opts.server == "" && nameserverOf(opts.ipv) == "":
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: errIPVNoNameserver.Error()})
+		case dohParam != "" && dohParam != "false":
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "doh can't be used with ipv"})
+		case dotParam != "" && dotParam != "false":
+			return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "dot can't be used with ipv"})
+		}
+		// The DoH and DoT clients can't be held to one family; use the
+		// nameservers
+		dohParam, dotParam = "false", "false"
+	}
+
+	// An explicit doh wins over the configured DoT default
+	explicitDoH := dohParam != "" && dohParam != "false"
+	opts.dot, err = h.parseDoT(dotParam, opts.server != "" || explicitDoH)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+	if opts.dot != "" && opts.compareTransport {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "transport=compare can't be used with dot"})
+	}
+	opts.fallback = c.Request.URL.Query().Get("fallback") == "true"
+
+	opts.doh, err = h.parseDoH(dohParam, opts.server != "" || opts.dot != "")
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+	if opts.doh != "" && opts.compareTransport {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: "transport=compare can't be used with doh"})
+	}
+
+	if err := h.checkTargets(c.Request.Context(), &opts); err != nil {
+		return render.Send(c, http.StatusBadRequest, DNSResponse{Error: err.Error()})
+	}
+
+	response, err := h.cachedLookup(c.Request.Context(), domain, opts)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), DNSResponse{Error: err.Error()})
+	}
+	// The resolver's order is kept unless asked otherwise, some clients
+	// balance load on it
+	if c.Request.URL.Query().Get("sort") == "true" && response.Records != nil {
+		response.Records = response.Records.sorted()
+	}
+	if format == FormatSimple {
+		return render.Send(c, http.StatusOK, simplify(response))
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// Errors returned by the lookups for malformed queries.
+var (
+	errInvalidDomain = errors.New("invalid domain format")
+	errInvalidIP     = errors.New("invalid IP address")
+)
+
+// Lookup looks up every supported record type of domain with the configured
+// resolver. The error reports an invalid domain; lookup failures are part of
+// the response.
+func (h *Handler) Lookup(ctx context.Context, domain string) (DNSResponse, error) {
+	types, err := parseRecordTypes("")
+	if err != nil {
+		return DNSResponse{}, err
+	}
+
+	return h.lookupTypes(ctx, domain, types)
+}
 
+// lookupTypes looks up types of domain with the configured resolver.
+func (h *Handler) lookupTypes(ctx context.Context, domain string, types map[string]bool) (DNSResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return DNSResponse{}, err
+	}
 	if !isValidDomain(domain) {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(DNSResponse{Error: "invalid domain format"})
+		return DNSResponse{}, errInvalidDomain
 	}
 
-	return handleForwardLookup(c, domain)
+	opts := lookupOptions{types: types, timeout: h.cfg.Timeout}
+	if err := h.parseResolver(&opts, "", ""); err != nil {
+		return DNSResponse{}, err
+	}
+
+	return h.cachedLookup(ctx, domain, opts)
 }
 
-func cleanDomain(domain string) string {
+// lookupOptions are the query parameters that shape a forward lookup.
+type lookupOptions struct {
+	types    map[string]bool
+	detailed bool
+	// server is a custom nameserver (ip:port); empty uses the system resolver.
+	server string
+	// doh is a DNS-over-HTTPS endpoint URL; it takes the place of server.
+	doh string
+	// dot is a DNS-over-TLS resolver, tls://host:port; it takes the place of
+	// server too. With fallback an unreachable one gives way to the system
+	// resolver instead of failing the lookup.
+	dot      string
+	fallback bool
+	// email parses SPF, DMARC and (with dkimSelector) DKIM records.
+	email        bool
+	dkimSelector string
+	// compareTransport repeats the lookup over tcp4 and tcp6 and reports
+	// differences between the answers.
+	compareTransport bool
+	// noCache skips the cached response, refreshing it.
+	noCache bool
+	// timeout bounds the lookup instead of the default.
+	timeout time.Duration
+	// guard is set by checkTargets when server, doh or dot is the caller's,
+	// to refuse connections to internal addresses.
+	guard *netguard.Guard
+	// ipv limits the lookup to the addresses of IPv4 (4) or IPv6 (6) and
+	// reaches the nameserver over that family.
+	ipv int
+}
+
+// parseNameserver validates an ip[:port] nameserver address, defaulting the
+// port to 53.
+func parseNameserver(value string) (string, error) {
+	if ip := net.ParseIP(strings.Trim(value, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53"), nil
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid server, expected ip[:port]")
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("invalid server, expected ip[:port]")
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid server port")
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// parseRecordTypes parses a comma-separated, case-insensitive list of record
+// types. An empty value selects every supported type.
+func parseRecordTypes(value string) (map[string]bool, error) {
+	types := make(map[string]bool, len(recordTypes))
+	if value == "" {
+		for _, t := range recordTypes {
+			types[t] = true
+		}
+		return types, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		t := strings.ToUpper(strings.TrimSpace(part))
+		if t == "" {
+			continue
+		}
+		if !containsString(recordTypes, t) {
+			return nil, fmt.Errorf("unsupported record type %q, supported types: %s", t, strings.Join(recordTypes, ", "))
+		}
+		types[t] = true
+	}
+
+	if len(types) == 0 {
+		return nil, fmt.Errorf("type parameter is empty, supported types: %s", strings.Join(recordTypes, ", "))
+	}
+
+	return types, nil
+}
+
+func cleanDomain(domain string) (string, error) {
 	// Remove protocol
 	domain = strings.TrimPrefix(domain, "https://")
 	domain = strings.TrimPrefix(domain, "http://")
@@ -81,7 +457,8 @@ func cleanDomain(domain string) string {
 	if idx := strings.Index(domain, ":"); idx != -1 {
 		domain = domain[:idx]
 	}
-	return strings.ToLower(strings.TrimSpace(domain))
+	// Internationalized domains are looked up by their punycode form
+	return idn.ToASCII(strings.ToLower(strings.TrimSpace(domain)))
 }
 
 func isValidDomain(domain string) bool {
@@ -94,31 +471,69 @@ func isValidDomain(domain string) bool {
 		return false
 	}
 	for _, part := range parts {
-		if len(part) == 0 || len(part) > 63 {
+		if !isValidLabel(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidLabel reports whether part is a hostname label or an underscore
+// label as used by SRV names (_service._proto.domain).
+func isValidLabel(part string) bool {
+	if len(part) == 0 || len(part) > 63 {
+		return false
+	}
+	if part[0] == '-' || part[len(part)-1] == '-' {
+		return false
+	}
+	for _, r := range part {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
 			return false
 		}
 	}
 	return true
 }
 
-func handleReverseLookup(c *ada.Context, ip string) error {
-	// Validate IP
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(DNSResponse{Error: "invalid IP address"})
+func (h *Handler) handleReverseLookup(c *ada.Context, ip string, requestTimeout time.Duration) error {
+	response, err := reverse(c.Request.Context(), ip, cmp.Or(requestTimeout, h.cfg.Timeout, reverseTimeout))
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), DNSResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// Reverse returns the PTR names of ip. The error reports an invalid IP; a
+// failed lookup is part of the response.
+func Reverse(ctx context.Context, ip string) (DNSResponse, error) {
+	return reverse(ctx, ip, reverseTimeout)
+}
+
+func reverse(ctx context.Context, ip string, timeout time.Duration) (DNSResponse, error) {
+	if net.ParseIP(ip) == nil {
+		return DNSResponse{}, errInvalidIP
+	}
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return DNSResponse{}, err
 	}
+	defer release()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	resolver := &net.Resolver{}
 	names, err := resolver.LookupAddr(ctx, ip)
 	if err != nil {
-		return c.SetStatus(http.StatusOK).SendJSON(DNSResponse{
+		return DNSResponse{
 			IP:      ip,
 			Reverse: []string{},
 			Error:   "no PTR records found",
-		})
+		}, nil
 	}
 
 	// Clean trailing dots from hostnames
@@ -127,90 +542,85 @@ func handleReverseLookup(c *ada.Context, ip string) error {
 		cleanNames[i] = strings.TrimSuffix(name, ".")
 	}
 
-	return c.SetStatus(http.StatusOK).SendJSON(DNSResponse{
+	return DNSResponse{
 		IP:      ip,
 		Reverse: cleanNames,
-	})
+	}, nil
 }
 
-func handleForwardLookup(c *ada.Context, domain string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+// lookup runs a forward lookup of domain.
+func lookup(ctx context.Context, domain string, opts lookupOptions) DNSResponse {
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(opts.timeout, lookupTimeout))
 	defer cancel()
 
-	resolver := &net.Resolver{}
-	records := &DNSRecords{}
-	errors := make(map[string]string)
-
-	// A records (IPv4)
-	if ips, err := resolver.LookupIP(ctx, "ip4", domain); err == nil {
-		records.A = make([]string, len(ips))
-		for i, ip := range ips {
-			records.A[i] = ip.String()
+	var fallback string
+	if opts.dot != "" {
+		// A resolver that can't be reached fails every query; find out once
+		if err := probeDoT(ctx, opts.guard, opts.dot); err != nil {
+			if !opts.fallback {
+				return DNSResponse{
+					Domain:        domain,
+					UnicodeDomain: idn.ToUnicode(domain),
+					Resolver:      opts.dot,
+					Error:         "DoT resolver unreachable: " + err.Error(),
+				}
+			}
+			fallback = "DoT resolver " + opts.dot + " unreachable: " + err.Error()
+			opts.dot = ""
 		}
-	} else if !isNotFoundError(err) {
-		errors["A"] = simplifyError(err)
 	}
 
-	// AAAA records (IPv6)
-	if ips, err := resolver.LookupIP(ctx, "ip6", domain); err == nil {
-		records.AAAA = make([]string, len(ips))
-		for i, ip := range ips {
-			records.AAAA[i] = ip.String()
-		}
-	} else if !isNotFoundError(err) {
-		errors["AAAA"] = simplifyError(err)
+	res := newResolver(opts)
+	records, errs, soaErr := res.lookupRecords(ctx, domain, opts.types)
+
+	response := DNSResponse{
+		Domain:        domain,
+		UnicodeDomain: idn.ToUnicode(domain),
+		Records:       records,
+		Resolver:      cmp.Or(opts.dot, opts.doh, opts.server),
+		Fallback:      fallback,
+		Retries:       int(res.retries.Load()),
 	}
 
-	// MX records
-	if mxs, err := resolver.LookupMX(ctx, domain); err == nil {
-		records.MX = make([]MXRecord, len(mxs))
-		for i, mx := range mxs {
-			records.MX[i] = MXRecord{
-				Host:     strings.TrimSuffix(mx.Host, "."),
-				Priority: mx.Pref,
-			}
+	// net.Resolver hides NXDOMAIN details, so when nothing was found ask the
+	// nameserver directly to report how long the answer is negatively cached.
+	if records.isEmpty() {
+		// Only not-found errors are left out of errs, so without any every
+		// query was answered NXDOMAIN or NODATA, not failed
+		response.Negative = len(errs) == 0
+
+		// The SOA answer was already fetched when it was asked for
+		if !opts.types["SOA"] {
+			_, soaErr = res.lookupSOA(ctx, domain)
+		}
+		if ttl, ok := negativeTTL(soaErr); ok {
+			response.NXDomain = true
+			response.NegativeTTL = ttl
 		}
-	} else if !isNotFoundError(err) {
-		errors["MX"] = simplifyError(err)
 	}
 
-	// TXT records
-	if txts, err := resolver.LookupTXT(ctx, domain); err == nil {
-		records.TXT = txts
-	} else if !isNotFoundError(err) {
-		errors["TXT"] = simplifyError(err)
+	if opts.email && !response.NXDomain {
+		response.Email = lookupEmail(ctx, res, domain, opts.dkimSelector)
 	}
 
-	// CNAME record
-	if cname, err := resolver.LookupCNAME(ctx, domain); err == nil {
-		cleanCname := strings.TrimSuffix(cname, ".")
-		if cleanCname != domain {
-			records.CNAME = []string{cleanCname}
-		}
-	} else if !isNotFoundError(err) {
-		errors["CNAME"] = simplifyError(err)
+	if opts.compareTransport {
+		response.Transport = compareTransports(ctx, domain, opts)
 	}
 
-	// NS records
-	if nss, err := resolver.LookupNS(ctx, domain); err == nil {
-		records.NS = make([]string, len(nss))
-		for i, ns := range nss {
-			records.NS[i] = strings.TrimSuffix(ns.Host, ".")
-		}
-	} else if !isNotFoundError(err) {
-		errors["NS"] = simplifyError(err)
+	if len(errs) > 0 {
+		response.Errors = errs
 	}
 
-	response := DNSResponse{
-		Domain:  domain,
-		Records: records,
+	if opts.ipv != 0 {
+		requireFamily(&response, opts.types, opts.ipv)
 	}
 
-	if len(errors) > 0 {
-		response.Errors = errors
-	}
+	return response
+}
 
-	return c.SetStatus(http.StatusOK).SendJSON(response)
+func (r *DNSRecords) isEmpty() bool {
+	return len(r.A) == 0 && len(r.AAAA) == 0 && len(r.MX) == 0 && len(r.TXT) == 0 &&
+		len(r.CNAME) == 0 && len(r.NS) == 0 && r.SOA == nil && len(r.CAA) == 0 && len(r.SRV) == 0
 }
 
 func isNotFoundError(err error) bool {
@@ -224,6 +634,15 @@ func isNotFoundError(err error) bool {
 		strings.Contains(errStr, "NODATA")
 }
 
+func containsString(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
 func simplifyError(err error) string {
 	if err == nil {
 		return ""
@@ -0,0 +1,954 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+func TestRecordMarshalJSON(t *testing.T) {
+	ttl := uint32(300)
+	records := DNSRecords{
+		A:  []Record{{Value: "1.2.3.4"}},
+		NS: []Record{{Value: "ns1.example.com", TTL: &ttl}},
+	}
+
+	got, err := json.Marshal(records)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	want := `{"A":["1.2.3.4"],"NS":[{"value":"ns1.example.com","ttl":300}]}`
+	if string(got) != want {
+		t.Fatalf("marshal = %s, want %s", got, want)
+	}
+
+	var decoded DNSRecords
+	if err := json.Unmarshal(got, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.A[0].Value != "1.2.3.4" || decoded.A[0].TTL != nil {
+		t.Fatalf("decoded A = %+v", decoded.A[0])
+	}
+	if decoded.NS[0].TTL == nil || *decoded.NS[0].TTL != 300 {
+		t.Fatalf("decoded NS = %+v", decoded.NS[0])
+	}
+}
+
+func TestParseRecordTypes(t *testing.T) {
+	types, err := parseRecordTypes("")
+	if err != nil || len(types) != len(recordTypes) {
+		t.Fatalf("empty value = %v, %v; want all types", types, err)
+	}
+
+	types, err = parseRecordTypes(" txt, mx ")
+	if err != nil {
+		t.Fatalf("parseRecordTypes: %v", err)
+	}
+	if len(types) != 2 || !types["TXT"] || !types["MX"] {
+		t.Fatalf("types = %v, want TXT and MX", types)
+	}
+
+	if _, err := parseRecordTypes("A,BOGUS"); err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+}
+
+func TestIsValidDomain(t *testing.T) {
+	valid := []string{"example.com", "sub.example-site.org", "_sip._tcp.example.com", "_dmarc.example.com"}
+	for _, domain := range valid {
+		if !isValidDomain(domain) {
+			t.Errorf("isValidDomain(%q) = false, want true", domain)
+		}
+	}
+
+	invalid := []string{"", "localhost", "example..com", "-bad.example.com", "bad space.com"}
+	for _, domain := range invalid {
+		if isValidDomain(domain) {
+			t.Errorf("isValidDomain(%q) = true, want false", domain)
+		}
+	}
+}
+
+func TestChallengeName(t *testing.T) {
+	tests := map[string]string{
+		"":                             "example.com",
+		"@":                            "example.com",
+		"_myapp-challenge":             "_myapp-challenge.example.com",
+		"_myapp-challenge.example.com": "_myapp-challenge.example.com",
+		"_MyApp.Example.com.":          "_myapp.example.com",
+	}
+
+	for name, want := range tests {
+		if got := challengeName(name, "example.com"); got != want {
+			t.Errorf("challengeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseSPF(t *testing.T) {
+	spf := parseSPF("v=spf1 ip4:192.0.2.0/24 ip4:bogus include:_spf.example.com mx a/24 ~all")
+	if spf.All != "~" {
+		t.Fatalf("all = %q, want ~", spf.All)
+	}
+	if spf.Lookups != 3 {
+		t.Fatalf("lookups = %d, want 3", spf.Lookups)
+	}
+	if len(spf.Mechanisms) != 6 || spf.Mechanisms[1].Error == "" || spf.Mechanisms[0].Error != "" {
+		t.Fatalf("mechanisms = %+v", spf.Mechanisms)
+	}
+
+	many := "v=spf1"
+	for range 11 {
+		many += " include:example.com"
+	}
+	if spf := parseSPF(many + " -all"); len(spf.Warnings) == 0 {
+		t.Fatal("expected a warning for more than 10 lookups")
+	}
+}
+
+func TestParseDMARC(t *testing.T) {
+	dmarc := parseDMARC("v=DMARC1; p=reject; pct=50; rua=mailto:a@example.com,mailto:b@example.com")
+	if dmarc.Policy != "reject" || dmarc.Pct != 50 || len(dmarc.RUA) != 2 || dmarc.Error != "" {
+		t.Fatalf("dmarc = %+v", dmarc)
+	}
+
+	if dmarc := parseDMARC("v=DMARC1; rua=mailto:a@example.com"); dmarc.Error == "" {
+		t.Fatal("expected error for missing policy")
+	}
+}
+
+func TestParseDoH(t *testing.T) {
+	const endpoint = "https://dns.example/dns-query"
+	h := New(Config{DoHURL: endpoint, DoH: true}, nil)
+
+	tests := []struct {
+		value     string
+		hasServer bool
+		want      string
+		wantErr   bool
+	}{
+		{value: "", want: endpoint},
+		{value: "", hasServer: true, want: ""},
+		{value: "false", want: ""},
+		{value: "true", want: endpoint},
+		{value: "https://other.example/dns-query", want: "https://other.example/dns-query"},
+		{value: "http://other.example/dns-query", wantErr: true},
+		{value: "true", hasServer: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := h.parseDoH(tt.value, tt.hasServer)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDoH(%q, %v) = %q, %v; want %q, error %v", tt.value, tt.hasServer, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLookupRejectsInvalidInput(t *testing.T) {
+	h := New(Config{}, nil)
+	ctx := context.Background()
+
+	if _, err := h.Lookup(ctx, "not a domain"); !errors.Is(err, errInvalidDomain) {
+		t.Errorf("Lookup() error = %v, want %v", err, errInvalidDomain)
+	}
+	if _, err := Reverse(ctx, "300.1.1.1"); !errors.Is(err, errInvalidIP) {
+		t.Errorf("Reverse() error = %v, want %v", err, errInvalidIP)
+	}
+	if _, err := h.Verify(ctx, "example.com", "bad..name", "token"); !errors.Is(err, errInvalidRecordName) {
+		t.Errorf("Verify() error = %v, want %v", err, errInvalidRecordName)
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	h := New(Config{DoHURL: "https://127.0.0.1/dns-query"}, netguard.New(true))
+	ctx := context.Background()
+
+	tests := []struct {
+		opts    lookupOptions
+		blocked bool
+	}{
+		{opts: lookupOptions{server: "1.1.1.1:53"}},
+		{opts: lookupOptions{server: "10.0.0.53:53"}, blocked: true},
+		{opts: lookupOptions{doh: "https://169.254.169.254/dns-query"}, blocked: true},
+		// The configured endpoint is trusted
+		{opts: lookupOptions{doh: "https://127.0.0.1/dns-query"}},
+	}
+
+	for _, tt := range tests {
+		opts := tt.opts
+		if err := h.checkTargets(ctx, &opts); errors.Is(err, netguard.ErrBlocked) != tt.blocked {
+			t.Errorf("checkTargets(%+v) = %v, want blocked %v", tt.opts, err, tt.blocked)
+		}
+	}
+
+	// Only the caller's targets are dialed through the guard
+	opts := lookupOptions{server: "1.1.1.1:53"}
+	if err := h.checkTargets(ctx, &opts); err != nil || opts.guard == nil {
+		t.Errorf("checkTargets() of a custom server = %v, guard %v", err, opts.guard)
+	}
+	opts = lookupOptions{doh: "https://127.0.0.1/dns-query"}
+	if err := h.checkTargets(ctx, &opts); err != nil || opts.guard != nil {
+		t.Errorf("checkTargets() of the configured endpoint = %v, guard %v", err, opts.guard)
+	}
+}
+
+func TestDoHGuard(t *testing.T) {
+	// A public endpoint could redirect to an internal one
+	srv := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/dns-query", http.StatusFound))
+	defer srv.Close()
+
+	msg := new(mdns.Msg)
+	msg.SetQuestion("example.com.", mdns.TypeA)
+	if _, err := exchangeDoH(context.Background(), nil, srv.URL, msg); err == nil || !strings.Contains(err.Error(), "302") {
+		t.Fatalf("exchangeDoH() of a redirect = %v, want the redirect refused", err)
+	}
+
+	// The dial is checked too, not only the name beforehand
+	guard := netguard.New(true)
+	if _, err := exchangeDoH(context.Background(), guard, srv.URL, msg); !errors.Is(err, netguard.ErrBlocked) {
+		t.Fatalf("exchangeDoH() through the guard = %v, want %v", err, netguard.ErrBlocked)
+	}
+	if _, err := exchange(context.Background(), guard, "127.0.0.1:53", "example.com", mdns.TypeA); !errors.Is(err, netguard.ErrBlocked) {
+		t.Fatalf("exchange() through the guard = %v, want %v", err, netguard.ErrBlocked)
+	}
+	if err := probeDoT(context.Background(), guard, dotScheme+"127.0.0.1:853"); !errors.Is(err, netguard.ErrBlocked) {
+		t.Fatalf("probeDoT() through the guard = %v, want %v", err, netguard.ErrBlocked)
+	}
+}
+
+func TestVerifyOverDoH(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		answer := new(mdns.Msg)
+		answer.SetReply(query)
+		if q := query.Question[0]; q.Qtype == mdns.TypeTXT && q.Name == "_verify.example.com." {
+			answer.Answer = append(answer.Answer, &mdns.TXT{
+				Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeTXT, Class: mdns.ClassINET, Ttl: 60},
+				Txt: []string{"token-123"},
+			})
+		}
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	h := New(Config{DoHURL: srv.URL, DoH: true}, nil)
+
+	response, err := h.Verify(context.Background(), "example.com", "_verify", "token-123")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !response.Verified || response.Name != "_verify.example.com" || response.Error != "" {
+		t.Fatalf("Verify() = %+v", response)
+	}
+
+	response, err = h.Verify(context.Background(), "example.com", "_verify", "other")
+	if err != nil || response.Verified {
+		t.Fatalf("Verify() with wrong value = %+v, %v", response, err)
+	}
+}
+
+func TestTraceLookup(t *testing.T) {
+	// The root, the TLD and the zone are served from three loopback addresses
+	// on the same port; ns0.example.test is a lame delegation to the TLD.
+	respond := map[string]func(q mdns.Question, m *mdns.Msg){
+		"127.0.0.1": func(q mdns.Question, m *mdns.Msg) {
+			m.Ns = append(m.Ns, &mdns.NS{Hdr: rrHeader("test.", mdns.TypeNS), Ns: "ns.test."})
+			m.Extra = append(m.Extra, &mdns.A{Hdr: rrHeader("ns.test.", mdns.TypeA), A: net.ParseIP("127.0.0.2")})
+		},
+		"127.0.0.2": func(q mdns.Question, m *mdns.Msg) {
+			m.Ns = append(m.Ns,
+				&mdns.NS{Hdr: rrHeader("example.test.", mdns.TypeNS), Ns: "ns0.example.test."},
+				&mdns.NS{Hdr: rrHeader("example.test.", mdns.TypeNS), Ns: "ns1.example.test."},
+			)
+			m.Extra = append(m.Extra,
+				&mdns.A{Hdr: rrHeader("ns0.example.test.", mdns.TypeA), A: net.ParseIP("127.0.0.2")},
+				&mdns.A{Hdr: rrHeader("ns1.example.test.", mdns.TypeA), A: net.ParseIP("127.0.0.3")},
+			)
+		},
+		"127.0.0.3": func(q mdns.Question, m *mdns.Msg) {
+			m.Authoritative = true
+			if q.Name != "www.example.test." {
+				m.Rcode = mdns.RcodeNameError
+				return
+			}
+			m.Answer = append(m.Answer, &mdns.A{Hdr: rrHeader(q.Name, mdns.TypeA), A: net.ParseIP("192.0.2.1")})
+		},
+	}
+
+	port := ""
+	for _, addr := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		pc, err := net.ListenPacket("udp", net.JoinHostPort(addr, port))
+		if err != nil {
+			t.Skipf("listen on %s: %v", addr, err)
+		}
+		_, port, _ = net.SplitHostPort(pc.LocalAddr().String())
+
+		srv := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, r *mdns.Msg) {
+			m := new(mdns.Msg)
+			m.SetReply(r)
+			respond[addr](r.Question[0], m)
+			_ = w.WriteMsg(m)
+		})}
+		go func() { _ = srv.ActivateAndServe() }()
+		t.Cleanup(func() { _ = srv.Shutdown() })
+	}
+
+	roots, tracePortOrig := rootServers, tracePort
+	rootServers, tracePort = []traceServer{{name: "root.test", addr: "127.0.0.1"}}, port
+	t.Cleanup(func() { rootServers, tracePort = roots, tracePortOrig })
+
+	h := New(Config{}, nil)
+
+	response, err := h.TraceLookup(context.Background(), "www.example.test", "")
+	if err != nil {
+		t.Fatalf("TraceLookup() error = %v", err)
+	}
+	if response.Error != "" || len(response.Answer) != 1 || response.Answer[0].Value != "192.0.2.1" {
+		t.Fatalf("TraceLookup() = %+v", response)
+	}
+
+	zones := make([]string, 0, len(response.Steps))
+	for _, step := range response.Steps {
+		zones = append(zones, step.Zone)
+	}
+	if want := []string{".", "test.", "example.test.", "example.test."}; !slices.Equal(zones, want) {
+		t.Fatalf("TraceLookup() zones = %v, want %v", zones, want)
+	}
+	if !response.Steps[2].Lame || response.Steps[3].Lame {
+		t.Fatalf("TraceLookup() lame delegation not reported: %+v", response.Steps)
+	}
+
+	response, err = h.TraceLookup(context.Background(), "missing.example.test", "A")
+	if err != nil || !response.NXDomain {
+		t.Fatalf("TraceLookup() of a missing name = %+v, %v", response, err)
+	}
+
+	if _, err := h.TraceLookup(context.Background(), "example.test", "PTR"); err == nil {
+		t.Fatal("TraceLookup() accepted an unsupported type")
+	}
+}
+
+func rrHeader(name string, rrtype uint16) mdns.RR_Header {
+	return mdns.RR_Header{Name: name, Rrtype: rrtype, Class: mdns.ClassINET, Ttl: 60}
+}
+
+func TestCachedLookup(t *testing.T) {
+	var (
+		queries atomic.Int32
+		release = make(chan struct{})
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		queries.Add(1)
+
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		answer := new(mdns.Msg)
+		answer.SetReply(query)
+		answer.Answer = append(answer.Answer, &mdns.A{Hdr: rrHeader(query.Question[0].Name, mdns.TypeA), A: net.ParseIP("192.0.2.1")})
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	h := New(Config{CacheTTL: time.Minute, CacheSize: 10}, nil)
+	opts := lookupOptions{types: map[string]bool{"A": true}, doh: srv.URL, detailed: true}
+
+	// Concurrent lookups of the same key share one upstream query
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			if response, _ := h.cachedLookup(context.Background(), "example.com", opts); len(response.Records.A) != 1 {
+				t.Errorf("cachedLookup() = %+v", response)
+			}
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("upstream queries = %d, want 1", n)
+	}
+
+	response, _ := h.cachedLookup(context.Background(), "example.com", opts)
+	if !response.Cached || queries.Load() != 1 {
+		t.Fatalf("cachedLookup() not served from cache: %+v", response)
+	}
+
+	opts.noCache = true
+	response, _ = h.cachedLookup(context.Background(), "example.com", opts)
+	if response.Cached || queries.Load() != 2 {
+		t.Fatalf("cachedLookup() with nocache = %+v, %d queries", response, queries.Load())
+	}
+}
+
+func TestCachedNegativeLookup(t *testing.T) {
+	var (
+		queries atomic.Int32
+		failing atomic.Bool
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		if failing.Load() {
+			http.Error(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		answer := new(mdns.Msg)
+		answer.SetRcode(query, mdns.RcodeNameError)
+		answer.Ns = append(answer.Ns, &mdns.SOA{Hdr: rrHeader("com.", mdns.TypeSOA), Ns: "ns.com.", Mbox: "admin.com.", Minttl: 300})
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	h := New(Config{CacheTTL: time.Minute, NegativeCacheTTL: 30 * time.Second, CacheSize: 10}, nil)
+	opts := lookupOptions{types: map[string]bool{"A": true}, doh: srv.URL, detailed: true}
+
+	response, _ := h.cachedLookup(context.Background(), "missing.com", opts)
+	if !response.Negative || !response.NXDomain || response.Cached {
+		t.Fatalf("cachedLookup() of a missing name = %+v", response)
+	}
+	n := queries.Load()
+
+	response, _ = h.cachedLookup(context.Background(), "missing.com", opts)
+	if !response.Negative || !response.Cached || queries.Load() != n {
+		t.Fatalf("cachedLookup() negative answer not served from cache: %+v", response)
+	}
+
+	// A failing upstream is no negative answer and isn't cached
+	failing.Store(true)
+	response, _ = h.cachedLookup(context.Background(), "down.com", opts)
+	if response.Negative || len(response.Errors) == 0 {
+		t.Fatalf("cachedLookup() with a failing upstream = %+v", response)
+	}
+	n = queries.Load()
+	if response, _ = h.cachedLookup(context.Background(), "down.com", opts); response.Cached || queries.Load() == n {
+		t.Fatalf("cachedLookup() cached a failure: %+v", response)
+	}
+}
+
+func TestLookupSOAOnce(t *testing.T) {
+	var soaQueries atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query.Question[0].Qtype == mdns.TypeSOA {
+			soaQueries.Add(1)
+		}
+
+		answer := new(mdns.Msg)
+		answer.SetRcode(query, mdns.RcodeNameError)
+		answer.Ns = append(answer.Ns, &mdns.SOA{Hdr: rrHeader("com.", mdns.TypeSOA), Ns: "ns.com.", Mbox: "admin.com.", Minttl: 300})
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	response := lookup(context.Background(), "missing.com", lookupOptions{types: map[string]bool{"A": true, "SOA": true}, doh: srv.URL})
+	if !response.NXDomain || response.NegativeTTL == nil {
+		t.Fatalf("lookup() of a missing name = %+v", response)
+	}
+	if n := soaQueries.Load(); n != 1 {
+		t.Fatalf("SOA queried %d times, want 1", n)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	ttl := func(v uint32) *uint32 { return &v }
+
+	tests := []struct {
+		name     string
+		response DNSResponse
+		want     time.Duration
+	}{
+		{name: "no TTLs", response: DNSResponse{Records: &DNSRecords{A: []Record{{Value: "192.0.2.1"}}}}, want: time.Minute},
+		{name: "lowest record TTL", response: DNSResponse{Records: &DNSRecords{
+			A:  []Record{{Value: "192.0.2.1", TTL: ttl(300)}},
+			MX: []MXRecord{{Host: "mx.example.com", TTL: ttl(30)}},
+		}}, want: 30 * time.Second},
+		{name: "NXDOMAIN", response: DNSResponse{Negative: true, NXDomain: true, NegativeTTL: ttl(900), Records: &DNSRecords{}}, want: 30 * time.Second},
+		{name: "NXDOMAIN short SOA", response: DNSResponse{Negative: true, NXDomain: true, NegativeTTL: ttl(10), Records: &DNSRecords{}}, want: 10 * time.Second},
+		{name: "NODATA", response: DNSResponse{Negative: true, Records: &DNSRecords{}}, want: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := cacheTTL(tt.response, time.Minute, 30*time.Second); got != tt.want {
+			t.Errorf("%s: cacheTTL() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	ttl := uint32(60)
+	simple := simplify(DNSResponse{
+		Domain: "example.com",
+		Records: &DNSRecords{
+			A:  []Record{{Value: "192.0.2.1", TTL: &ttl}, {Value: "192.0.2.2"}},
+			MX: []MXRecord{{Host: "mail.example.com", Priority: 10}},
+		},
+		Errors: map[string]string{"AAAA": "lookup failed"},
+	})
+
+	data, err := json.Marshal(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"domain":"example.com","a":["192.0.2.1","192.0.2.2"],"error":"lookup failed"}`
+	if string(data) != want {
+		t.Fatalf("simple response = %s, want %s", data, want)
+	}
+
+	var decoded SimpleResponse
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded.A, simple.A) || decoded.Domain != simple.Domain || decoded.Error != simple.Error {
+		t.Fatalf("round trip = %+v, want %+v", decoded, simple)
+	}
+
+	if _, err := simpleTypesOf(map[string]bool{"A": true, "MX": true}, false); !errors.Is(err, errSimpleTypes) {
+		t.Fatalf("simpleTypesOf(A,MX) error = %v, want %v", err, errSimpleTypes)
+	}
+}
+
+func TestDetectWildcard(t *testing.T) {
+	var wildcard atomic.Bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		answer := new(mdns.Msg)
+		answer.SetReply(query)
+		switch {
+		case !wildcard.Load():
+			answer.Rcode = mdns.RcodeNameError
+		case query.Question[0].Qtype == mdns.TypeA:
+			answer.Answer = append(answer.Answer, &mdns.A{Hdr: rrHeader(query.Question[0].Name, mdns.TypeA), A: net.ParseIP("192.0.2.1")})
+		}
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	opts := lookupOptions{doh: srv.URL}
+
+	response, err := detectWildcard(context.Background(), "example.com", opts)
+	if err != nil {
+		t.Fatalf("detectWildcard() error = %v", err)
+	}
+	if response.Wildcard || response.Error != "" || len(response.Probes) != wildcardProbes {
+		t.Fatalf("detectWildcard() without wildcard = %+v", response)
+	}
+
+	wildcard.Store(true)
+	response, err = detectWildcard(context.Background(), "example.com", opts)
+	if err != nil {
+		t.Fatalf("detectWildcard() error = %v", err)
+	}
+	if !response.Wildcard || !slices.Equal(response.Addresses, []string{"192.0.2.1"}) {
+		t.Fatalf("detectWildcard() with wildcard = %+v", response)
+	}
+	if response.Probes[0].Name == response.Probes[1].Name {
+		t.Fatalf("probes reuse the name %q", response.Probes[0].Name)
+	}
+
+	if _, err := detectWildcard(context.Background(), "not a domain", opts); !errors.Is(err, errInvalidDomain) {
+		t.Fatalf("detectWildcard() invalid domain error = %v", err)
+	}
+}
+
+func TestIPVTypes(t *testing.T) {
+	all, _ := parseRecordTypes("")
+	types, err := ipvTypes(all, true, 6)
+	if err != nil || types["A"] || !types["AAAA"] || !types["MX"] {
+		t.Fatalf("ipvTypes(all, 6) = %v, %v", types, err)
+	}
+
+	explicit, _ := parseRecordTypes("A,MX")
+	if _, err := ipvTypes(explicit, false, 6); !errors.Is(err, errIPVType) {
+		t.Fatalf("ipvTypes(A,MX, 6) error = %v, want %v", err, errIPVType)
+	}
+	if types, err := ipvTypes(explicit, false, 4); err != nil || !types["A"] {
+		t.Fatalf("ipvTypes(A,MX, 4) = %v, %v", types, err)
+	}
+
+	if !isFamily("[2001:db8::1]:53", 6) || isFamily("192.0.2.1:53", 6) || !isFamily("192.0.2.1:53", 4) {
+		t.Fatal("isFamily() mismatched the address family")
+	}
+}
+
+func TestRawLookup(t *testing.T) {
+	for value, want := range map[string]uint16{"svcb": 64, "TYPE65": 65, "35": mdns.TypeNAPTR, "TYPE65280": 65280} {
+		if qtype, err := parseRawType(value); err != nil || qtype != want {
+			t.Fatalf("parseRawType(%q) = %d, %v, want %d", value, qtype, err, want)
+		}
+	}
+	for _, value := range []string{"", "FOO", "0", "65536", "TYPE", "ANY", "AXFR", "OPT", "TYPE200"} {
+		if _, err := parseRawType(value); err == nil {
+			t.Fatalf("parseRawType(%q) accepted an invalid type", value)
+		}
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		q := query.Question[0]
+		answer := new(mdns.Msg)
+		answer.SetReply(query)
+		switch q.Qtype {
+		case mdns.TypeHTTPS:
+			answer.Answer = append(answer.Answer, &mdns.HTTPS{SVCB: mdns.SVCB{
+				Hdr:      rrHeader(q.Name, mdns.TypeHTTPS),
+				Priority: 1,
+				Target:   ".",
+				Value:    []mdns.SVCBKeyValue{&mdns.SVCBAlpn{Alpn: []string{"h2"}}},
+			}})
+		case 65280:
+			answer.Answer = append(answer.Answer, &mdns.RFC3597{Hdr: rrHeader(q.Name, 65280), Rdata: "c0000201"})
+		}
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	opts := lookupOptions{doh: srv.URL}
+
+	response, err := rawLookup(context.Background(), "example.com", mdns.TypeHTTPS, opts)
+	if err != nil || response.Error != "" || len(response.Records) != 1 {
+		t.Fatalf("rawLookup(HTTPS) = %+v, %v", response, err)
+	}
+	if got := response.Records[0]; got.Type != "HTTPS" || got.Data != `1 . alpn="h2"` || got.RDATA == "" {
+		t.Fatalf("rawLookup(HTTPS) record = %+v", got)
+	}
+
+	response, err = rawLookup(context.Background(), "example.com", 65280, opts)
+	if err != nil || len(response.Records) != 1 || response.Type != "TYPE65280" {
+		t.Fatalf("rawLookup(TYPE65280) = %+v, %v", response, err)
+	}
+	if got := response.Records[0]; got.Data != `\# 4 c0000201` || got.RDATA != "c0000201" {
+		t.Fatalf("rawLookup(TYPE65280) record = %+v", got)
+	}
+
+	response, err = rawLookup(context.Background(), "example.com", mdns.TypeNAPTR, opts)
+	if err != nil || response.Error != "" || len(response.Records) != 0 {
+		t.Fatalf("rawLookup() without records = %+v, %v", response, err)
+	}
+}
+
+func TestRangeHosts(t *testing.T) {
+	tests := []struct {
+		cidr        string
+		first, last string
+		count       int
+		wantErr     bool
+	}{
+		{cidr: "192.0.2.5/28", first: "192.0.2.1", last: "192.0.2.14", count: 14},
+		{cidr: "192.0.2.0/24", first: "192.0.2.1", last: "192.0.2.254", count: 254},
+		{cidr: "192.0.2.8/31", first: "192.0.2.8", last: "192.0.2.9", count: 2},
+		{cidr: "192.0.2.7/32", first: "192.0.2.7", last: "192.0.2.7", count: 1},
+		{cidr: "2001:db8::/120", first: "2001:db8::", last: "2001:db8::ff", count: 256},
+		{cidr: "192.0.2.0/23", wantErr: true},
+		{cidr: "2001:db8::/64", wantErr: true},
+		{cidr: "192.0.2.1", wantErr: true},
+		{cidr: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, hosts, err := rangeHosts(tt.cidr, 256)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("rangeHosts(%q): expected an error", tt.cidr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("rangeHosts(%q): %v", tt.cidr, err)
+			continue
+		}
+
+		if len(hosts) != tt.count || hosts[0].String() != tt.first || hosts[len(hosts)-1].String() != tt.last {
+			t.Errorf("rangeHosts(%q) = %d hosts %s-%s, want %d hosts %s-%s", tt.cidr, len(hosts), hosts[0], hosts[len(hosts)-1], tt.count, tt.first, tt.last)
+		}
+	}
+}
+
+func TestLookupOverDoT(t *testing.T) {
+	h := New(Config{DoTServer: "dns.example", DoT: true}, nil)
+	for _, tt := range []struct {
+		value, want string
+		hasServer   bool
+	}{
+		{value: "", want: "tls://dns.example:853"},
+		{value: "", hasServer: true, want: ""},
+		{value: "false", want: ""},
+		{value: "1.1.1.1", want: "tls://1.1.1.1:853"},
+		{value: "Other.Example:8853", want: "tls://other.example:8853"},
+		{value: "[2606:4700:4700::1111]:853", want: "tls://[2606:4700:4700::1111]:853"},
+		{value: "not a host", want: ""},
+	} {
+		got, err := h.parseDoT(tt.value, tt.hasServer)
+		if got != tt.want || (err != nil) != (tt.want == "" && tt.value != "" && tt.value != "false") {
+			t.Errorf("parseDoT(%q, %v) = %q, %v; want %q", tt.value, tt.hasServer, got, err, tt.want)
+		}
+	}
+	if _, err := h.parseDoT("true", true); err == nil {
+		t.Error("parseDoT() accepted dot with a custom server")
+	}
+
+	// httptest provides a certificate valid for 127.0.0.1
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	certSrv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certSrv.Certificate())
+	defer func(roots *x509.CertPool) { dotRoots = roots }(dotRoots)
+	dotRoots = roots
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", certSrv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{Listener: listener, Net: "tcp-tls", Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, query *mdns.Msg) {
+		answer := new(mdns.Msg)
+		answer.SetReply(query)
+		if q := query.Question[0]; q.Qtype == mdns.TypeA {
+			answer.Answer = append(answer.Answer, &mdns.A{Hdr: rrHeader(q.Name, mdns.TypeA), A: net.ParseIP("192.0.2.1")})
+		}
+		_ = w.WriteMsg(answer)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	resolver := dotScheme + listener.Addr().String()
+	response := lookup(context.Background(), "example.com", lookupOptions{types: map[string]bool{"A": true}, dot: resolver})
+	if response.Error != "" || response.Resolver != resolver || len(response.Records.A) != 1 || response.Records.A[0].Value != "192.0.2.1" {
+		t.Fatalf("lookup() over DoT = %+v", response)
+	}
+
+	// The DoT default holds for the other lookups too
+	dotHandler := New(Config{DoTServer: listener.Addr().String(), DoT: true}, nil)
+	raw, err := dotHandler.RawLookup(context.Background(), "example.com", "A")
+	if err != nil || raw.Error != "" || raw.Resolver != resolver || len(raw.Records) != 1 {
+		t.Fatalf("RawLookup() with the DoT default = %+v, %v", raw, err)
+	}
+	var opts lookupOptions
+	if err := dotHandler.parseResolver(&opts, "", "https://dns.example/dns-query"); err != nil || opts.dot != "" || opts.doh == "" {
+		t.Fatalf("parseResolver() with an explicit doh = %+v, %v, want DoH over the DoT default", opts, err)
+	}
+
+	// Nothing listens there any more
+	closed := dotScheme + certSrv.Listener.Addr().String()
+	response = lookup(context.Background(), "example.com", lookupOptions{types: map[string]bool{"A": true}, dot: closed})
+	if response.Error == "" || response.Records != nil {
+		t.Fatalf("lookup() with an unreachable DoT resolver = %+v, want an error", response)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	response = lookup(ctx, "example.com", lookupOptions{types: map[string]bool{"A": true}, dot: closed, fallback: true})
+	if response.Error != "" || response.Fallback == "" || response.Resolver != "" {
+		t.Fatalf("lookup() with fallback = %+v, want the system resolver", response)
+	}
+}
+
+func TestCheckBlacklists(t *testing.T) {
+	if addr, err := parseBlacklistIP("::ffff:192.0.2.1"); err != nil || blacklistName(addr, "zen.example.") != "1.2.0.192.zen.example" {
+		t.Fatalf("blacklistName(mapped IPv4) = %q, %v", blacklistName(addr, "zen.example."), err)
+	}
+	if addr, _ := parseBlacklistIP("2001:db8::1"); !strings.HasPrefix(blacklistName(addr, "zen.example"), "1.0.0.0.0.0.0.0.") ||
+		!strings.HasSuffix(blacklistName(addr, "zen.example"), ".8.b.d.0.1.0.0.2.zen.example") {
+		t.Fatalf("blacklistName(IPv6) = %q", blacklistName(addr, "zen.example"))
+	}
+	for _, value := range []string{"", "example.com", "fe80::1%eth0"} {
+		if _, err := parseBlacklistIP(value); err == nil {
+			t.Fatalf("parseBlacklistIP(%q) accepted an invalid IP", value)
+		}
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(mdns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		q := query.Question[0]
+		answer := new(mdns.Msg)
+		answer.SetReply(query)
+		switch {
+		case strings.HasSuffix(q.Name, ".listed.example.") && q.Qtype == mdns.TypeA:
+			answer.Answer = append(answer.Answer, &mdns.A{Hdr: rrHeader(q.Name, mdns.TypeA), A: net.ParseIP("127.0.0.2")})
+		case strings.HasSuffix(q.Name, ".listed.example."):
+			answer.Answer = append(answer.Answer, &mdns.TXT{Hdr: rrHeader(q.Name, mdns.TypeTXT), Txt: []string{"https://listed.example/", "lookup"}})
+		case strings.HasSuffix(q.Name, ".refused.example.") && q.Qtype == mdns.TypeA:
+			answer.Answer = append(answer.Answer, &mdns.A{Hdr: rrHeader(q.Name, mdns.TypeA), A: net.ParseIP("127.255.255.254")})
+		default:
+			answer.Rcode = mdns.RcodeNameError
+		}
+
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	client := dohClient
+	dohClient = srv.Client()
+	defer func() { dohClient = client }()
+
+	addr, _ := parseBlacklistIP("192.0.2.1")
+	response, err := checkBlacklists(context.Background(), addr, []string{"listed.example", "clean.example", "refused.example"}, lookupOptions{doh: srv.URL})
+	if err != nil || !response.Listed || len(response.Zones) != 3 {
+		t.Fatalf("checkBlacklists() = %+v, %v", response, err)
+	}
+
+	listed, clean, refused := response.Zones[0], response.Zones[1], response.Zones[2]
+	if !listed.Listed || !slices.Equal(listed.Codes, []string{"127.0.0.2"}) || !slices.Equal(listed.Reasons, []string{"https://listed.example/lookup"}) {
+		t.Fatalf("listed zone = %+v", listed)
+	}
+	if clean.Listed || clean.Error != "" {
+		t.Fatalf("clean zone = %+v", clean)
+	}
+	if refused.Listed || refused.Error == "" {
+		t.Fatalf("refused zone = %+v, want an error", refused)
+	}
+}
+
+func TestSortedRecords(t *testing.T) {
+	records := &DNSRecords{
+		A:    []Record{{Value: "192.0.2.10"}, {Value: "192.0.2.9"}, {Value: "192.0.2.10"}},
+		AAAA: []Record{{Value: "2001:db8::10"}, {Value: "2001:db8::9"}},
+		MX: []MXRecord{
+			{Host: "mx3.example.com", Priority: 20},
+			{Host: "MX2.example.com", Priority: 10},
+			{Host: "mx1.example.com", Priority: 10},
+		},
+		NS:  []Record{{Value: "ns2.example.com."}, {Value: "NS1.example.com."}},
+		TXT: []Record{{Value: "b"}, {Value: "a"}},
+	}
+
+	sorted := records.sorted()
+
+	values := func(records []Record) []string {
+		var out []string
+		for _, record := range records {
+			out = append(out, record.Value)
+		}
+		return out
+	}
+	if got := values(sorted.A); !slices.Equal(got, []string{"192.0.2.9", "192.0.2.10"}) {
+		t.Fatalf("sorted A = %q", got)
+	}
+	if got := values(sorted.AAAA); !slices.Equal(got, []string{"2001:db8::9", "2001:db8::10"}) {
+		t.Fatalf("sorted AAAA = %q", got)
+	}
+	var mx []string
+	for _, record := range sorted.MX {
+		mx = append(mx, record.Host)
+	}
+	if !slices.Equal(mx, []string{"mx1.example.com", "MX2.example.com", "mx3.example.com"}) {
+		t.Fatalf("sorted MX = %q", mx)
+	}
+	if got := values(sorted.NS); !slices.Equal(got, []string{"NS1.example.com.", "ns2.example.com."}) {
+		t.Fatalf("sorted NS = %q", got)
+	}
+	if got := values(sorted.TXT); !slices.Equal(got, []string{"b", "a"}) {
+		t.Fatalf("sorted TXT = %q, want the resolver's order", got)
+	}
+
+	// The records may be cached: they are left as they were
+	if got := values(records.A); !slices.Equal(got, []string{"192.0.2.10", "192.0.2.9", "192.0.2.10"}) {
+		t.Fatalf("sorted() changed the original A records to %q", got)
+	}
+	if records.MX[0].Host != "mx3.example.com" {
+		t.Fatalf("sorted() changed the original MX records to %+v", records.MX)
+	}
+}
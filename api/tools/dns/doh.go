@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/proxy"
+)
+
+// dohContentType is the DNS wireformat media type of RFC 8484.
+const dohContentType = "application/dns-message"
+
+// dohMaxResponseSize caps a DoH response body; a DNS message can't exceed
+// 64KiB.
+const dohMaxResponseSize = 64 << 10
+
+var dohClient = newDoHClient(nil)
+
+// guardedDoHClients holds the DoH client dialing through each guard, for the
+// endpoints given by callers.
+var guardedDoHClients sync.Map
+
+// newDoHClient returns a DoH client dialing through guard and the upstream
+// proxy. A redirect isn't followed: it could lead anywhere, internal
+// addresses included.
+func newDoHClient(guard *netguard.Guard) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Dialed through the proxy below, so the guard sees the target
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return proxy.DialContext(ctx, &net.Dialer{Timeout: 5 * time.Second}, guard, network, address)
+	}
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dohClientFor returns the DoH client dialing through guard, dohClient for a
+// nil one.
+func dohClientFor(guard *netguard.Guard) *http.Client {
+	if guard == nil {
+		return dohClient
+	}
+
+	client, ok := guardedDoHClients.Load(guard)
+	if !ok {
+		client, _ = guardedDoHClients.LoadOrStore(guard, newDoHClient(guard))
+	}
+
+	return client.(*http.Client)
+}
+
+// isDoH reports whether server is a DoH endpoint URL rather than ip:port.
+func isDoH(server string) bool {
+	return strings.HasPrefix(server, "https://")
+}
+
+// parseDoH resolves the doh param to an endpoint URL: "true" selects the
+// configured endpoint, "false" the regular resolver, and an https URL that
+// endpoint. Without the param the configured default applies, unless a custom
+// nameserver was given.
+func (h *Handler) parseDoH(value string, hasServer bool) (string, error) {
+	value = strings.TrimSpace(value)
+
+	var endpoint string
+	switch value {
+	case "":
+		if !h.cfg.DoH || hasServer {
+			return "", nil
+		}
+		endpoint = h.cfg.DoHURL
+	case "false":
+		return "", nil
+	case "true":
+		endpoint = h.cfg.DoHURL
+	default:
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return "", errors.New("invalid doh, expected true, false or an https URL")
+		}
+		endpoint = u.String()
+	}
+
+	if hasServer {
+		return "", errors.New("server and doh can't be used together")
+	}
+	if endpoint == "" {
+		return "", errors.New("no DoH endpoint configured")
+	}
+
+	return endpoint, nil
+}
+
+// checkTargets refuses a custom nameserver, DoH endpoint or DoT resolver on
+// an internal address, and sets the guard opts dials it through, so a
+// redirect or a name resolving differently later is refused too. The
+// configured DoH endpoint and DoT resolver are trusted; resolution errors are
+// left to the query.
+func (h *Handler) checkTargets(ctx context.Context, opts *lookupOptions) error {
+	var hosts []string
+	if opts.server != "" {
+		host, _, _ := net.SplitHostPort(opts.server)
+		hosts = append(hosts, host)
+	}
+	if opts.doh != "" && opts.doh != h.cfg.DoHURL {
+		if u, err := url.Parse(opts.doh); err == nil {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	if host := h.dotHost(opts.dot); host != "" {
+		hosts = append(hosts, host)
+	}
+
+	for _, host := range hosts {
+		if err := h.guard.Check(ctx, host); errors.Is(err, netguard.ErrBlocked) {
+			return err
+		}
+	}
+	if len(hosts) > 0 {
+		opts.guard = h.guard
+	}
+
+	return nil
+}
+
+// exchangeDoH sends msg to a DNS-over-HTTPS endpoint as a wireformat POST
+// (RFC 8484).
+func exchangeDoH(ctx context.Context, guard *netguard.Guard, endpoint string, msg *mdns.Msg) (*mdns.Msg, error) {
+	// RFC 8484 recommends ID 0 so answers are cache friendly
+	msg.Id = 0
+
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := dohClientFor(guard).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, dohContentType) {
+		return nil, fmt.Errorf("DoH server returned unexpected content type %q", ct)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	answer := new(mdns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("decode DoH response: %w", err)
+	}
+
+	return answer, nil
+}
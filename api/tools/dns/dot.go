@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+// dotScheme prefixes the DoT resolvers passed as server to exchange.
+const dotScheme = "tls://"
+
+// dotPort is the DNS-over-TLS port of RFC 7858.
+const dotPort = "853"
+
+// dotDialTimeout bounds the connection to a DoT resolver.
+const dotDialTimeout = 5 * time.Second
+
+// dotRoots verifies the DoT resolver certificates; nil uses the system roots.
+var dotRoots *x509.CertPool
+
+var errInvalidDoT = errors.New("invalid dot, expected true, false or a host[:port] resolver")
+
+// isDoT reports whether server is a DoT resolver rather than ip:port.
+func isDoT(server string) bool {
+	return strings.HasPrefix(server, dotScheme)
+}
+
+// parseDoT resolves the dot param to a tls://host:port resolver: "true"
+// selects the configured one, "false" the regular resolver, and host[:port]
+// that resolver. Without the param the configured default applies, unless a
+// custom nameserver or DoH endpoint was given.
+func (h *Handler) parseDoT(value string, hasServer bool) (string, error) {
+	value = strings.TrimSpace(value)
+
+	var resolver string
+	switch value {
+	case "":
+		if !h.cfg.DoT || hasServer {
+			return "", nil
+		}
+		resolver = h.cfg.DoTServer
+	case "false":
+		return "", nil
+	case "true":
+		resolver = h.cfg.DoTServer
+	default:
+		resolver = value
+	}
+
+	if hasServer {
+		return "", errors.New("dot can't be used with server or doh")
+	}
+	if resolver == "" {
+		return "", errors.New("no DoT resolver configured")
+	}
+
+	return parseDoTServer(resolver)
+}
+
+// parseResolver sets the DoT resolver and DoH endpoint of opts, after its
+// server, from the dot and doh params. An explicit doh wins over the
+// configured DoT default.
+func (h *Handler) parseResolver(opts *lookupOptions, dotParam, dohParam string) error {
+	explicitDoH := dohParam != "" && dohParam != "false"
+
+	var err error
+	opts.dot, err = h.parseDoT(dotParam, opts.server != "" || explicitDoH)
+	if err != nil {
+		return err
+	}
+
+	opts.doh, err = h.parseDoH(dohParam, opts.server != "" || opts.dot != "")
+
+	return err
+}
+
+// parseDoTServer validates a host[:port] DoT resolver, defaulting the port to
+// 853. The host is what the resolver certificate is verified against.
+func parseDoTServer(value string) (string, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = strings.Trim(value, "[]"), dotPort
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if ip := net.ParseIP(host); ip == nil && !isValidDomain(host) {
+		return "", errInvalidDoT
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", errInvalidDoT
+	}
+
+	return dotScheme + net.JoinHostPort(host, port), nil
+}
+
+// dotAddress splits a tls://host:port resolver into its address and the name
+// its certificate must match.
+func dotAddress(server string) (address, serverName string) {
+	address = strings.TrimPrefix(server, dotScheme)
+	serverName, _, _ = net.SplitHostPort(address)
+
+	return address, serverName
+}
+
+// dotClient returns a miekg/dns client for the DoT resolver server, dialing
+// through guard.
+func dotClient(guard *netguard.Guard, server string) (*mdns.Client, string) {
+	address, serverName := dotAddress(server)
+
+	return &mdns.Client{
+		Net:       "tcp-tls",
+		Dialer:    guard.Dialer(&net.Dialer{Timeout: dotDialTimeout}),
+		TLSConfig: &tls.Config{ServerName: serverName, RootCAs: dotRoots, MinVersion: tls.VersionTLS12},
+	}, address
+}
+
+// exchangeDoT sends msg to a DNS-over-TLS resolver (RFC 7858).
+func exchangeDoT(ctx context.Context, guard *netguard.Guard, server string, msg *mdns.Msg) (*mdns.Msg, error) {
+	client, address := dotClient(guard, server)
+
+	resp, _, err := client.ExchangeContext(ctx, msg, address)
+	return resp, err
+}
+
+// probeDoT connects to the DoT resolver server and closes the connection,
+// telling a resolver that can't be reached from one that answers with errors.
+func probeDoT(ctx context.Context, guard *netguard.Guard, server string) error {
+	client, address := dotClient(guard, server)
+
+	ctx, cancel := context.WithTimeout(ctx, dotDialTimeout)
+	defer cancel()
+
+	conn, err := client.DialContext(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// dotHost returns the host of a custom DoT resolver for checkTargets.
+func (h *Handler) dotHost(server string) string {
+	if server == "" {
+		return ""
+	}
+	if configured, err := parseDoTServer(h.cfg.DoTServer); err == nil && configured == server {
+		return ""
+	}
+
+	_, host := dotAddress(server)
+	return host
+}
@@ -0,0 +1,359 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// spfLookupLimit is the RFC 7208 limit on DNS-querying SPF terms.
+const spfLookupLimit = 10
+
+// EmailInfo is the email authentication setup of a domain (email=true).
+type EmailInfo struct {
+	SPF   *SPFInfo   `json:"spf,omitempty"`
+	DMARC *DMARCInfo `json:"dmarc,omitempty"`
+	DKIM  *DKIMInfo  `json:"dkim,omitempty"`
+}
+
+type SPFInfo struct {
+	Record     string         `json:"record,omitempty"`
+	Mechanisms []SPFMechanism `json:"mechanisms,omitempty"`
+	// Lookups counts the terms of this record that cost a DNS lookup;
+	// included records are not expanded.
+	Lookups  int      `json:"lookups"`
+	All      string   `json:"all,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type SPFMechanism struct {
+	Qualifier string `json:"qualifier"`
+	Type      string `json:"type"`
+	Value     string `json:"value,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type DMARCInfo struct {
+	Record          string   `json:"record,omitempty"`
+	Policy          string   `json:"policy,omitempty"`
+	SubdomainPolicy string   `json:"subdomainPolicy,omitempty"`
+	Pct             int      `json:"pct"`
+	RUA             []string `json:"rua,omitempty"`
+	RUF             []string `json:"ruf,omitempty"`
+	ADKIM           string   `json:"adkim,omitempty"`
+	ASPF            string   `json:"aspf,omitempty"`
+	Warnings        []string `json:"warnings,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+type DKIMInfo struct {
+	Selector  string `json:"selector"`
+	Record    string `json:"record,omitempty"`
+	KeyType   string `json:"keyType,omitempty"`
+	KeyBits   int    `json:"keyBits,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Revoked   bool   `json:"revoked,omitempty"`
+	Flags     string `json:"flags,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// lookupEmail fetches and parses the SPF and DMARC records of domain, and the
+// DKIM key of selector when one is given.
+func lookupEmail(ctx context.Context, res *resolver, domain, selector string) *EmailInfo {
+	info := &EmailInfo{}
+
+	spfRecords, err := findTXT(ctx, res, domain, "v=spf1")
+	switch {
+	case err != nil:
+		info.SPF = &SPFInfo{Error: err.Error()}
+	case len(spfRecords) == 0:
+		info.SPF = &SPFInfo{Error: "no SPF record found"}
+	case len(spfRecords) > 1:
+		info.SPF = &SPFInfo{Error: "multiple SPF records found, receivers treat this as a permanent error"}
+	default:
+		info.SPF = parseSPF(spfRecords[0])
+	}
+
+	dmarcRecords, err := findTXT(ctx, res, "_dmarc."+domain, "v=DMARC1")
+	switch {
+	case err != nil:
+		info.DMARC = &DMARCInfo{Error: err.Error()}
+	case len(dmarcRecords) == 0:
+		info.DMARC = &DMARCInfo{Error: "no DMARC record found"}
+	case len(dmarcRecords) > 1:
+		info.DMARC = &DMARCInfo{Error: "multiple DMARC records found, receivers ignore the policy"}
+	default:
+		info.DMARC = parseDMARC(dmarcRecords[0])
+	}
+
+	if selector != "" {
+		dkimRecords, err := findTXT(ctx, res, selector+"._domainkey."+domain, "")
+		switch {
+		case err != nil:
+			info.DKIM = &DKIMInfo{Selector: selector, Error: err.Error()}
+		case len(dkimRecords) == 0:
+			info.DKIM = &DKIMInfo{Selector: selector, Error: "no DKIM record found"}
+		default:
+			info.DKIM = parseDKIM(selector, dkimRecords[0])
+		}
+	}
+
+	return info
+}
+
+// findTXT returns the TXT records of name starting with prefix
+// (case-insensitive). A missing name is not an error.
+func findTXT(ctx context.Context, res *resolver, name, prefix string) ([]string, error) {
+	txts, err := res.lookupTXT(ctx, name)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s", simplifyError(err))
+	}
+
+	var found []string
+	for _, txt := range txts {
+		value := strings.TrimSpace(txt.Value)
+		if prefix == "" || hasVersionPrefix(value, prefix) {
+			found = append(found, value)
+		}
+	}
+	return found, nil
+}
+
+// hasVersionPrefix reports whether record starts with the version tag prefix
+// followed by the end of the record or a separator.
+func hasVersionPrefix(record, prefix string) bool {
+	if len(record) < len(prefix) || !strings.EqualFold(record[:len(prefix)], prefix) {
+		return false
+	}
+	rest := record[len(prefix):]
+	return rest == "" || rest[0] == ' ' || rest[0] == ';'
+}
+
+func parseSPF(record string) *SPFInfo {
+	info := &SPFInfo{Record: record}
+
+	terms := strings.Fields(record)[1:]
+	for i, term := range terms {
+		// Modifiers (name=value)
+		if name, value, ok := strings.Cut(term, "="); ok && !strings.ContainsAny(name, ":/") {
+			mechanism := SPFMechanism{Type: strings.ToLower(name), Value: value}
+			switch mechanism.Type {
+			case "redirect":
+				info.Lookups++
+				if value == "" {
+					mechanism.Error = "redirect requires a domain"
+				}
+			case "exp":
+			default:
+				mechanism.Error = "unknown modifier"
+			}
+			info.Mechanisms = append(info.Mechanisms, mechanism)
+			continue
+		}
+
+		mechanism := SPFMechanism{Qualifier: "+"}
+		if strings.ContainsAny(term[:1], "+-~?") {
+			mechanism.Qualifier = term[:1]
+			term = term[1:]
+		}
+
+		name, value, _ := strings.Cut(term, ":")
+		if name == term {
+			// a/24, mx/24 carry a prefix length without a domain
+			if n, cidr, ok := strings.Cut(term, "/"); ok {
+				name, value = n, "/"+cidr
+			}
+		}
+		mechanism.Type = strings.ToLower(name)
+		mechanism.Value = value
+
+		switch mechanism.Type {
+		case "all":
+			info.All = mechanism.Qualifier
+			if i != len(terms)-1 {
+				info.Warnings = append(info.Warnings, "terms after \"all\" are ignored")
+			}
+		case "include", "exists":
+			info.Lookups++
+			if value == "" {
+				mechanism.Error = mechanism.Type + " requires a domain"
+			}
+		case "a", "mx":
+			info.Lookups++
+		case "ptr":
+			info.Lookups++
+			info.Warnings = append(info.Warnings, "the ptr mechanism is deprecated")
+		case "ip4", "ip6":
+			if !isValidSPFNetwork(value, mechanism.Type == "ip6") {
+				mechanism.Error = "invalid " + mechanism.Type + " network"
+			}
+		default:
+			mechanism.Error = "unknown mechanism"
+		}
+
+		info.Mechanisms = append(info.Mechanisms, mechanism)
+	}
+
+	if info.Lookups > spfLookupLimit {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("%d DNS-lookup terms exceed the limit of %d", info.Lookups, spfLookupLimit))
+	}
+
+	switch info.All {
+	case "+":
+		info.Warnings = append(info.Warnings, "\"+all\" allows any server to send mail")
+	case "?":
+		info.Warnings = append(info.Warnings, "\"?all\" gives no protection")
+	case "":
+		if !strings.Contains(strings.ToLower(record), "redirect=") {
+			info.Warnings = append(info.Warnings, "no \"all\" mechanism, unmatched senders default to neutral")
+		}
+	}
+
+	return info
+}
+
+func isValidSPFNetwork(value string, ipv6 bool) bool {
+	var ip net.IP
+	if strings.Contains(value, "/") {
+		parsed, _, err := net.ParseCIDR(value)
+		if err != nil {
+			return false
+		}
+		ip = parsed
+	} else {
+		ip = net.ParseIP(value)
+	}
+
+	if ip == nil {
+		return false
+	}
+	return (ip.To4() == nil) == ipv6
+}
+
+// parseTags splits a tag=value; list as used by DMARC and DKIM records.
+func parseTags(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return tags
+}
+
+func parseDMARC(record string) *DMARCInfo {
+	info := &DMARCInfo{Record: record, Pct: 100}
+	tags := parseTags(record)
+
+	switch policy := strings.ToLower(tags["p"]); policy {
+	case "none", "quarantine", "reject":
+		info.Policy = policy
+		if policy == "none" {
+			info.Warnings = append(info.Warnings, "policy \"none\" only monitors and does not protect the domain")
+		}
+	case "":
+		info.Error = "missing required p= policy"
+	default:
+		info.Error = fmt.Sprintf("invalid policy %q", tags["p"])
+	}
+
+	if sp, ok := tags["sp"]; ok {
+		info.SubdomainPolicy = strings.ToLower(sp)
+	}
+
+	if pct, ok := tags["pct"]; ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 0 || n > 100 {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("invalid pct %q", pct))
+		} else {
+			info.Pct = n
+		}
+	}
+
+	info.RUA = splitURIs(tags["rua"])
+	info.RUF = splitURIs(tags["ruf"])
+	info.ADKIM = tags["adkim"]
+	info.ASPF = tags["aspf"]
+
+	if len(info.RUA) == 0 {
+		info.Warnings = append(info.Warnings, "no rua= address, aggregate reports are not collected")
+	}
+
+	return info
+}
+
+func splitURIs(value string) []string {
+	var uris []string
+	for _, uri := range strings.Split(value, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+func parseDKIM(selector, record string) *DKIMInfo {
+	info := &DKIMInfo{Selector: selector, Record: record, KeyType: "rsa"}
+	tags := parseTags(record)
+
+	if k, ok := tags["k"]; ok {
+		info.KeyType = strings.ToLower(k)
+	}
+	info.Flags = tags["t"]
+
+	key, ok := tags["p"]
+	if !ok {
+		info.Error = "missing required p= public key"
+		return info
+	}
+
+	// An empty key means the selector has been revoked
+	key = strings.Join(strings.Fields(key), "")
+	if key == "" {
+		info.Revoked = true
+		return info
+	}
+	info.PublicKey = key
+
+	der, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		info.Error = "public key is not valid base64"
+		return info
+	}
+
+	// Ed25519 keys (RFC 8463) are raw 32 bytes rather than SPKI
+	if info.KeyType == "ed25519" {
+		if len(der) != ed25519.PublicKeySize {
+			info.Error = "invalid ed25519 public key"
+		}
+		return info
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		info.Error = "invalid public key"
+		return info
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		info.KeyBits = pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.KeyBits = pub.Curve.Params().BitSize
+	}
+
+	return info
+}
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Errors of the ipv parameter, which forces lookups onto one IP family.
+var (
+	errInvalidIPV      = errors.New("invalid ipv, expected 4 or 6")
+	errIPVType         = errors.New("type conflicts with ipv, A is IPv4 and AAAA is IPv6")
+	errIPVServer       = errors.New("server address doesn't match ipv")
+	errIPVTransport    = errors.New("transport=compare can't be used with ipv")
+	errIPVNoNameserver = errors.New("no system nameserver of the ipv family, set server")
+)
+
+// parseIPVersion parses the ipv param: empty, 4 or 6.
+func parseIPVersion(value string) (int, error) {
+	switch strings.TrimSpace(value) {
+	case "":
+		return 0, nil
+	case "4":
+		return 4, nil
+	case "6":
+		return 6, nil
+	default:
+		return 0, errInvalidIPV
+	}
+}
+
+// addressType returns the address record type of an IP family.
+func addressType(ipv int) string {
+	if ipv == 6 {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// ipvTypes drops the address lookups of the other family from types. all
+// tells whether types are the default set; an explicit type of the other
+// family is an error.
+func ipvTypes(types map[string]bool, all bool, ipv int) (map[string]bool, error) {
+	other := addressType(10 - ipv)
+	if !types[other] {
+		return types, nil
+	}
+	if !all {
+		return nil, errIPVType
+	}
+
+	delete(types, other)
+
+	return types, nil
+}
+
+// isFamily reports whether the ip[:port] address is of the ipv family.
+func isFamily(address string, ipv int) bool {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return false
+	}
+
+	return addrPort.Addr().Unmap().Is4() == (ipv == 4)
+}
+
+// nameserverOf returns the first system nameserver of the ipv family, empty
+// when there is none.
+func nameserverOf(ipv int) string {
+	for _, server := range systemNameservers() {
+		if isFamily(server, ipv) {
+			return server
+		}
+	}
+
+	return ""
+}
+
+// newFamilyResolver returns a system resolver that only reaches the
+// nameservers of the ipv family, with a raw-mode server of that family.
+func newFamilyResolver(ipv int, detailed bool) *resolver {
+	dialer := &net.Dialer{}
+	suffix := strconv.Itoa(ipv)
+
+	return &resolver{
+		system: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network+suffix, address)
+			},
+		},
+		server:   nameserverOf(ipv),
+		raw:      detailed,
+		detailed: detailed,
+	}
+}
+
+// requireFamily reports a clear error when a successful lookup found no
+// address of the ipv family.
+func requireFamily(response *DNSResponse, types map[string]bool, ipv int) {
+	t := addressType(ipv)
+	if !types[t] || response.Error != "" || response.NXDomain || response.Errors[t] != "" {
+		return
+	}
+
+	if len(recordValues(response.Records)[t]) == 0 {
+		response.Error = fmt.Sprintf("%s has no IPv%d address", response.Domain, ipv)
+	}
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+// queryDialTimeout bounds the connection to a nameserver, as miekg/dns does
+// by default.
+const queryDialTimeout = 2 * time.Second
+
+// errNoData is returned by raw lookups when the name exists but has no
+// records of the queried type.
+var errNoData = errors.New("NODATA")
+
+// nxDomainError is returned by raw lookups when the queried name doesn't
+// exist. ttl is the negative-cache TTL taken from the authority SOA
+// (RFC 2308), nil when the server didn't include one.
+type nxDomainError struct {
+	ttl *uint32
+}
+
+func (e *nxDomainError) Error() string {
+	return "NXDOMAIN"
+}
+
+func newNXDomainError(resp *mdns.Msg) error {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			// Resolvers cache the NXDOMAIN for the lower of the SOA TTL and MINIMUM.
+			ttl := min(soa.Hdr.Ttl, soa.Minttl)
+			return &nxDomainError{ttl: &ttl}
+		}
+	}
+
+	return &nxDomainError{}
+}
+
+// negativeTTL returns the negative-cache TTL carried by an NXDOMAIN error.
+func negativeTTL(err error) (*uint32, bool) {
+	var nxErr *nxDomainError
+	if !errors.As(err, &nxErr) {
+		return nil, false
+	}
+
+	return nxErr.ttl, true
+}
+
+// systemNameservers returns the nameservers configured in /etc/resolv.conf,
+// falling back to the local resolver like the Go runtime.
+var systemNameservers = sync.OnceValue(func() []string {
+	conf, err := mdns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return []string{"127.0.0.1:53"}
+	}
+
+	servers := make([]string, len(conf.Servers))
+	for i, server := range conf.Servers {
+		servers[i] = net.JoinHostPort(server, conf.Port)
+	}
+
+	return servers
+})
+
+// systemNameserver returns the first system nameserver.
+func systemNameserver() string {
+	return systemNameservers()[0]
+}
+
+// exchange sends a single query for name and qtype to server over UDP,
+// retrying over TCP when the answer is truncated. A DoH endpoint URL as
+// server is queried over HTTPS instead, and a tls://host:port DoT resolver
+// over TLS. A non-nil guard refuses connecting to an internal address.
+func exchange(ctx context.Context, guard *netguard.Guard, server, name string, qtype uint16) (*mdns.Msg, error) {
+	msg := new(mdns.Msg)
+	msg.SetQuestion(mdns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, false)
+
+	if isDoH(server) {
+		return exchangeDoH(ctx, guard, server, msg)
+	}
+	if isDoT(server) {
+		return exchangeDoT(ctx, guard, server, msg)
+	}
+
+	client := &mdns.Client{Net: "udp", Dialer: guard.Dialer(&net.Dialer{Timeout: queryDialTimeout})}
+	resp, _, err := client.ExchangeContext(ctx, msg, server)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, msg, server)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// query sends a query for name and qtype to server and returns the answer
+// records of that type, skipping any CNAME chain in front of them so their
+// TTLs are those of the final records.
+func query(ctx context.Context, guard *netguard.Guard, server, name string, qtype uint16) ([]mdns.RR, error) {
+	resp, err := exchange(ctx, guard, server, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.Rcode {
+	case mdns.RcodeSuccess:
+	case mdns.RcodeNameError:
+		return nil, newNXDomainError(resp)
+	default:
+		return nil, fmt.Errorf("%s query failed: %s", mdns.TypeToString[qtype], mdns.RcodeToString[resp.Rcode])
+	}
+
+	rrs := make([]mdns.RR, 0, len(resp.Answer))
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	if len(rrs) == 0 {
+		return nil, errNoData
+	}
+
+	return rrs, nil
+}
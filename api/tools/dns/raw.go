@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+var errRawTypeRequired = errors.New("type parameter is required, e.g. SVCB, TYPE64 or 64")
+
+// RawResponse is the answer of a lookup of any record type, in presentation
+// format rather than modeled per type.
+type RawResponse struct {
+	Domain        string `json:"domain,omitempty"`
+	UnicodeDomain string `json:"unicodeDomain,omitempty"`
+	Type          string `json:"type,omitempty"`
+	TypeCode      uint16 `json:"typeCode,omitempty"`
+	Resolver      string `json:"resolver,omitempty"`
+	// Records is empty when the name exists without records of the type.
+	Records     []RawRecord `json:"records"`
+	NXDomain    bool        `json:"nxdomain,omitempty"`
+	NegativeTTL *uint32     `json:"negativeTtl,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// RawRecord is a single record. Data is its RDATA in presentation format, or
+// in the generic \# form of RFC 3597 for types the resolver doesn't know;
+// RDATA is the wire form in hex.
+type RawRecord struct {
+	Type  string `json:"type"`
+	Data  string `json:"data"`
+	RDATA string `json:"rdata"`
+	TTL   uint32 `json:"ttl"`
+}
+
+// Raw handles GET /dns/raw - looks up a record type by name or code, including
+// the ones /dns doesn't model, such as HTTPS, SVCB, NAPTR or DS.
+func (h *Handler) Raw(c *ada.Context) error {
+	query := c.Request.URL.Query()
+	domain := strings.TrimSpace(query.Get("domain"))
+	serverParam := strings.TrimSpace(query.Get("server"))
+
+	if domain == "" {
+		return render.Send(c, http.StatusBadRequest, RawResponse{Error: "domain parameter is required"})
+	}
+
+	qtype, err := parseRawType(query.Get("type"))
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, RawResponse{Error: err.Error()})
+	}
+
+	var opts lookupOptions
+	opts.timeout, err = timeout.FromQuery(query)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, RawResponse{Error: err.Error()})
+	}
+	opts.timeout = cmp.Or(opts.timeout, h.cfg.Timeout)
+
+	if serverParam != "" {
+		opts.server, err = parseNameserver(serverParam)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, RawResponse{Error: err.Error()})
+		}
+	}
+
+	if err := h.parseResolver(&opts, query.Get("dot"), query.Get("doh")); err != nil {
+		return render.Send(c, http.StatusBadRequest, RawResponse{Error: err.Error()})
+	}
+
+	if err := h.checkTargets(c.Request.Context(), &opts); err != nil {
+		return render.Send(c, http.StatusBadRequest, RawResponse{Error: err.Error()})
+	}
+
+	response, err := rawLookup(c.Request.Context(), domain, qtype, opts)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), RawResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// RawLookup looks up the records of recordType, a type name or code, of
+// domain with the configured resolver. The error reports invalid input;
+// lookup failures are part of the response.
+func (h *Handler) RawLookup(ctx context.Context, domain, recordType string) (RawResponse, error) {
+	qtype, err := parseRawType(recordType)
+	if err != nil {
+		return RawResponse{}, err
+	}
+
+	opts := lookupOptions{timeout: h.cfg.Timeout}
+	if err := h.parseResolver(&opts, "", ""); err != nil {
+		return RawResponse{}, err
+	}
+
+	return rawLookup(ctx, domain, qtype, opts)
+}
+
+// parseRawType parses a record type given by name (SVCB), in the generic
+// TYPE64 form or as a bare code (64). Meta types, such as OPT, AXFR or ANY,
+// don't hold data and are refused.
+func parseRawType(value string) (uint16, error) {
+	name := strings.ToUpper(strings.TrimSpace(value))
+	if name == "" {
+		return 0, errRawTypeRequired
+	}
+
+	if qtype, ok := mdns.StringToType[name]; ok {
+		return checkRawType(name, qtype)
+	}
+
+	code, isCode := strings.CutPrefix(name, "TYPE")
+	if !isCode {
+		code = name
+	}
+
+	n, err := strconv.ParseUint(code, 10, 16)
+	if err != nil {
+		if !isCode && strings.Trim(code, "0123456789") != "" {
+			return 0, fmt.Errorf("unknown record type %q, use its name, e.g. SVCB, or its code, e.g. TYPE64 or 64", name)
+		}
+		return 0, fmt.Errorf("invalid record type %q, codes range from 1 to 65535", name)
+	}
+
+	return checkRawType(name, uint16(n))
+}
+
+// checkRawType refuses the type codes that can't be looked up: 0, OPT and
+// the query and meta types of RFC 6895 (128-255).
+func checkRawType(name string, qtype uint16) (uint16, error) {
+	switch {
+	case qtype == 0:
+		return 0, fmt.Errorf("invalid record type %q, codes range from 1 to 65535", name)
+	case qtype == mdns.TypeOPT || (qtype >= 128 && qtype <= 255):
+		return 0, fmt.Errorf("record type %q is a meta type and holds no data", mdns.Type(qtype).String())
+	}
+
+	return qtype, nil
+}
+
+// rawLookup queries qtype of domain directly from the nameserver, the custom
+// server or the DoH endpoint of opts.
+func rawLookup(ctx context.Context, domain string, qtype uint16, opts lookupOptions) (RawResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return RawResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return RawResponse{}, errInvalidDomain
+	}
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return RawResponse{}, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(opts.timeout, lookupTimeout))
+	defer cancel()
+
+	response := RawResponse{
+		Domain:        domain,
+		UnicodeDomain: idn.ToUnicode(domain),
+		Type:          mdns.Type(qtype).String(),
+		TypeCode:      qtype,
+		Resolver:      cmp.Or(opts.dot, opts.doh, opts.server),
+		Records:       []RawRecord{},
+	}
+
+	rrs, err := query(ctx, opts.guard, newResolver(opts).server, domain, qtype)
+	if ttl, ok := negativeTTL(err); ok {
+		response.NXDomain = true
+		response.NegativeTTL = ttl
+		return response, nil
+	}
+	if err != nil && !errors.Is(err, errNoData) {
+		response.Error = simplifyError(err)
+	}
+
+	for _, rr := range rrs {
+		response.Records = append(response.Records, rawRecord(rr))
+	}
+
+	return response, nil
+}
+
+// rawRecord renders rr in both the presentation and the wire form of its
+// RDATA.
+func rawRecord(rr mdns.RR) RawRecord {
+	hdr := rr.Header()
+	record := RawRecord{
+		Type: mdns.Type(hdr.Rrtype).String(),
+		Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		TTL:  hdr.Ttl,
+	}
+
+	// RFC3597 renders its header differently, leaving it in Data
+	if generic, ok := rr.(*mdns.RFC3597); ok {
+		record.Data = fmt.Sprintf(`\# %d %s`, len(generic.Rdata)/2, generic.Rdata)
+	}
+
+	generic := new(mdns.RFC3597)
+	if err := generic.ToRFC3597(rr); err == nil {
+		record.RDATA = generic.Rdata
+	}
+
+	return record
+}
@@ -0,0 +1,444 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/retry"
+)
+
+// resolver performs the per-type lookups of a forward lookup. By default it
+// goes through the system resolver; in raw mode it queries server directly
+// with miekg/dns, which also makes record TTLs available.
+type resolver struct {
+	system *net.Resolver
+	server string
+	raw    bool
+	// guard dials a caller's server, refusing internal addresses.
+	guard *netguard.Guard
+	// detailed attaches TTLs to the returned records (raw mode only).
+	detailed bool
+	// retries counts the per-type lookups retried after a transient failure.
+	retries atomic.Int32
+}
+
+func newResolver(opts lookupOptions) *resolver {
+	r := &resolver{
+		system:   &net.Resolver{},
+		server:   systemNameserver(),
+		raw:      opts.detailed,
+		detailed: opts.detailed,
+		guard:    opts.guard,
+	}
+
+	// A custom nameserver, DoH endpoint or DoT resolver is always queried
+	// directly
+	switch {
+	case opts.dot != "":
+		r.server = opts.dot
+		r.raw = true
+	case opts.doh != "":
+		r.server = opts.doh
+		r.raw = true
+	case opts.server != "":
+		r.server = opts.server
+		r.raw = true
+	case opts.ipv != 0:
+		r = newFamilyResolver(opts.ipv, opts.detailed)
+	}
+
+	return r
+}
+
+// ttl returns the TTL of rr when detailed output is requested.
+func (r *resolver) ttl(rr mdns.RR) *uint32 {
+	if !r.detailed {
+		return nil
+	}
+
+	ttl := rr.Header().Ttl
+	return &ttl
+}
+
+// lookupRecords looks up every selected record type of domain concurrently.
+// Types that don't exist are left empty; other failures are reported per type
+// once transient ones have been retried. The error of the SOA lookup, which
+// carries the negative-cache TTL of an NXDOMAIN, is returned as is.
+func (r *resolver) lookupRecords(ctx context.Context, domain string, types map[string]bool) (*DNSRecords, map[string]string, error) {
+	var (
+		records = &DNSRecords{}
+		errors  = make(map[string]string)
+		soaErr  error
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+
+	// lookup runs fn in the background when t is selected. fn stores its
+	// result while holding mu and returns the lookup error.
+	lookup := func(t string, fn func() error) {
+		if !types[t] {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			attempts, err := retry.Do(ctx, "dns", fn)
+			r.retries.Add(int32(attempts - 1))
+
+			if err != nil && !isNotFoundError(err) {
+				metrics.UpstreamFailure("dns")
+
+				mu.Lock()
+				errors[t] = simplifyError(err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// A records (IPv4)
+	lookup("A", func() error {
+		addrs, err := r.lookupAddrs(ctx, domain, mdns.TypeA)
+		mu.Lock()
+		records.A = addrs
+		mu.Unlock()
+		return err
+	})
+
+	// AAAA records (IPv6)
+	lookup("AAAA", func() error {
+		addrs, err := r.lookupAddrs(ctx, domain, mdns.TypeAAAA)
+		mu.Lock()
+		records.AAAA = addrs
+		mu.Unlock()
+		return err
+	})
+
+	// MX records
+	lookup("MX", func() error {
+		mxs, err := r.lookupMX(ctx, domain)
+		mu.Lock()
+		records.MX = mxs
+		mu.Unlock()
+		return err
+	})
+
+	// TXT records
+	lookup("TXT", func() error {
+		txts, err := r.lookupTXT(ctx, domain)
+		mu.Lock()
+		records.TXT = txts
+		mu.Unlock()
+		return err
+	})
+
+	// CNAME record
+	lookup("CNAME", func() error {
+		cname, err := r.lookupCNAME(ctx, domain)
+		if cname != nil {
+			mu.Lock()
+			records.CNAME = []Record{*cname}
+			mu.Unlock()
+		}
+		return err
+	})
+
+	// NS records
+	lookup("NS", func() error {
+		nss, err := r.lookupNS(ctx, domain)
+		mu.Lock()
+		records.NS = nss
+		mu.Unlock()
+		return err
+	})
+
+	// SOA record (only present on the zone apex)
+	lookup("SOA", func() error {
+		soa, err := r.lookupSOA(ctx, domain)
+		mu.Lock()
+		records.SOA, soaErr = soa, err
+		mu.Unlock()
+		return err
+	})
+
+	// CAA records (CA authorization)
+	lookup("CAA", func() error {
+		caas, err := r.lookupCAA(ctx, domain)
+		mu.Lock()
+		records.CAA = caas
+		mu.Unlock()
+		return err
+	})
+
+	// SRV records (_service._proto.domain)
+	lookup("SRV", func() error {
+		srvs, err := r.lookupSRV(ctx, domain)
+		mu.Lock()
+		records.SRV = srvs
+		mu.Unlock()
+		return err
+	})
+
+	wg.Wait()
+
+	return records, errors, soaErr
+}
+
+// lookupAddrs returns the A (qtype TypeA) or AAAA (TypeAAAA) records of domain.
+func (r *resolver) lookupAddrs(ctx context.Context, domain string, qtype uint16) ([]Record, error) {
+	if !r.raw {
+		network := "ip4"
+		if qtype == mdns.TypeAAAA {
+			network = "ip6"
+		}
+
+		ips, err := r.system.LookupIP(ctx, network, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]Record, len(ips))
+		for i, ip := range ips {
+			records[i] = Record{Value: ip.String()}
+		}
+		return records, nil
+	}
+
+	rrs, err := query(ctx, r.guard, r.server, domain, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		switch v := rr.(type) {
+		case *mdns.A:
+			records = append(records, Record{Value: v.A.String(), TTL: r.ttl(rr)})
+		case *mdns.AAAA:
+			records = append(records, Record{Value: v.AAAA.String(), TTL: r.ttl(rr)})
+		}
+	}
+	return records, nil
+}
+
+func (r *resolver) lookupMX(ctx context.Context, domain string) ([]MXRecord, error) {
+	if !r.raw {
+		mxs, err := r.system.LookupMX(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]MXRecord, len(mxs))
+		for i, mx := range mxs {
+			records[i] = MXRecord{
+				Host:     strings.TrimSuffix(mx.Host, "."),
+				Priority: mx.Pref,
+			}
+		}
+		return records, nil
+	}
+
+	rrs, err := query(ctx, r.guard, r.server, domain, mdns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]MXRecord, 0, len(rrs))
+	for _, rr := range rrs {
+		if mx, ok := rr.(*mdns.MX); ok {
+			records = append(records, MXRecord{
+				Host:     strings.TrimSuffix(mx.Mx, "."),
+				Priority: mx.Preference,
+				TTL:      r.ttl(rr),
+			})
+		}
+	}
+	return records, nil
+}
+
+func (r *resolver) lookupTXT(ctx context.Context, domain string) ([]Record, error) {
+	if !r.raw {
+		txts, err := r.system.LookupTXT(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]Record, len(txts))
+		for i, txt := range txts {
+			records[i] = Record{Value: txt}
+		}
+		return records, nil
+	}
+
+	rrs, err := query(ctx, r.guard, r.server, domain, mdns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		if txt, ok := rr.(*mdns.TXT); ok {
+			// Join character-strings like net.Resolver does
+			records = append(records, Record{Value: strings.Join(txt.Txt, ""), TTL: r.ttl(rr)})
+		}
+	}
+	return records, nil
+}
+
+// lookupCNAME returns the canonical name of domain, or nil when domain is not
+// an alias.
+func (r *resolver) lookupCNAME(ctx context.Context, domain string) (*Record, error) {
+	if !r.raw {
+		cname, err := r.system.LookupCNAME(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		cleanCname := strings.TrimSuffix(cname, ".")
+		if cleanCname == domain {
+			return nil, nil
+		}
+		return &Record{Value: cleanCname}, nil
+	}
+
+	rrs, err := query(ctx, r.guard, r.server, domain, mdns.TypeCNAME)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rr := range rrs {
+		if cname, ok := rr.(*mdns.CNAME); ok {
+			return &Record{Value: strings.TrimSuffix(cname.Target, "."), TTL: r.ttl(rr)}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *resolver) lookupNS(ctx context.Context, domain string) ([]Record, error) {
+	if !r.raw {
+		nss, err := r.system.LookupNS(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]Record, len(nss))
+		for i, ns := range nss {
+			records[i] = Record{Value: strings.TrimSuffix(ns.Host, ".")}
+		}
+		return records, nil
+	}
+
+	rrs, err := query(ctx, r.guard, r.server, domain, mdns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		if ns, ok := rr.(*mdns.NS); ok {
+			records = append(records, Record{Value: strings.TrimSuffix(ns.Ns, "."), TTL: r.ttl(rr)})
+		}
+	}
+	return records, nil
+}
+
+// lookupSRV looks up the SRV records of a full _service._proto.domain name.
+func (r *resolver) lookupSRV(ctx context.Context, name string) ([]SRVRecord, error) {
+	if !r.raw {
+		_, srvs, err := r.system.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]SRVRecord, len(srvs))
+		for i, srv := range srvs {
+			records[i] = SRVRecord{
+				Target:   strings.TrimSuffix(srv.Target, "."),
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			}
+		}
+		return records, nil
+	}
+
+	rrs, err := query(ctx, r.guard, r.server, name, mdns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]SRVRecord, 0, len(rrs))
+	for _, rr := range rrs {
+		if srv, ok := rr.(*mdns.SRV); ok {
+			records = append(records, SRVRecord{
+				Target:   strings.TrimSuffix(srv.Target, "."),
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+				TTL:      r.ttl(rr),
+			})
+		}
+	}
+	return records, nil
+}
+
+// lookupCAA queries the CAA records of domain. Like SOA, net.Resolver has no
+// CAA lookup, so this always queries the nameserver directly.
+func (r *resolver) lookupCAA(ctx context.Context, domain string) ([]CAARecord, error) {
+	rrs, err := query(ctx, r.guard, r.server, domain, mdns.TypeCAA)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]CAARecord, 0, len(rrs))
+	for _, rr := range rrs {
+		if caa, ok := rr.(*mdns.CAA); ok {
+			records = append(records, CAARecord{
+				Flag:  caa.Flag,
+				Tag:   caa.Tag,
+				Value: caa.Value,
+				TTL:   r.ttl(rr),
+			})
+		}
+	}
+	return records, nil
+}
+
+// lookupSOA queries the SOA record of domain. net.Resolver has no SOA lookup,
+// so this always queries the nameserver directly. It returns nil without an
+// error when the answer belongs to another name (e.g. behind a CNAME), since
+// SOA only lives on the zone apex.
+func (r *resolver) lookupSOA(ctx context.Context, domain string) (*SOARecord, error) {
+	rrs, err := query(ctx, r.guard, r.server, domain, mdns.TypeSOA)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rr := range rrs {
+		soa, ok := rr.(*mdns.SOA)
+		if !ok || !strings.EqualFold(soa.Hdr.Name, mdns.Fqdn(domain)) {
+			continue
+		}
+
+		return &SOARecord{
+			NS:      strings.TrimSuffix(soa.Ns, "."),
+			Mbox:    strings.TrimSuffix(soa.Mbox, "."),
+			Serial:  soa.Serial,
+			Refresh: soa.Refresh,
+			Retry:   soa.Retry,
+			Expire:  soa.Expire,
+			MinTTL:  soa.Minttl,
+			TTL:     r.ttl(rr),
+		}, nil
+	}
+
+	return nil, nil
+}
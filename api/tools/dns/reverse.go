@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/bulk"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+// rangeLookupTimeout bounds the PTR lookup of each host of a range, so a
+// sparse range still answers promptly.
+const rangeLookupTimeout = 2 * time.Second
+
+var errCIDRRequired = errors.New("cidr parameter is required, e.g. 192.0.2.0/28")
+
+// ReverseRangeResponse holds the PTR names of the hosts of a CIDR range.
+type ReverseRangeResponse struct {
+	CIDR  string `json:"cidr,omitempty"`
+	Hosts int    `json:"hosts,omitempty"`
+	// Names maps the hosts with PTR records to their names; Failed lists the
+	// hosts whose lookup failed rather than found none.
+	Names  map[string][]string `json:"names"`
+	Failed []string            `json:"failed,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// ReverseRange returns the handler of GET /dns/reverse?cidr=, looking up the
+// PTR names of every host of a range at most limit.Concurrency at a time.
+// Ranges of more than limit.MaxBatchSize hosts are refused.
+func (h *Handler) ReverseRange(limit bulk.Limit) func(c *ada.Context) error {
+	return func(c *ada.Context) error {
+		query := c.Request.URL.Query()
+
+		lookupTimeout, err := timeout.FromQuery(query)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, ReverseRangeResponse{Error: err.Error()})
+		}
+
+		prefix, hosts, err := rangeHosts(strings.TrimSpace(query.Get("cidr")), limit.MaxBatchSize)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, ReverseRangeResponse{Error: err.Error()})
+		}
+
+		response := reverseRange(c.Request.Context(), limit, hosts, cmp.Or(lookupTimeout, rangeLookupTimeout))
+		response.CIDR = prefix.String()
+
+		return render.Send(c, http.StatusOK, response)
+	}
+}
+
+// rangeHosts returns the hosts of cidr: all its addresses, less the network
+// and broadcast ones of an IPv4 range larger than /31. It refuses ranges of
+// more than maxHosts, unless it is 0.
+func rangeHosts(cidr string, maxHosts int) (netip.Prefix, []netip.Addr, error) {
+	if cidr == "" {
+		return netip.Prefix{}, nil, errCIDRRequired
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil || prefix.Addr().Zone() != "" {
+		return netip.Prefix{}, nil, fmt.Errorf("invalid CIDR range %q, e.g. 192.0.2.0/28", cidr)
+	}
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	skipEnds := prefix.Addr().Is4() && hostBits > 1
+
+	// counted in bits first, an IPv6 range can overflow any int
+	if hostBits >= 31 {
+		return netip.Prefix{}, nil, fmt.Errorf("range too large: /%d, maximum is %d hosts", prefix.Bits(), maxHosts)
+	}
+	count := 1 << hostBits
+	if skipEnds {
+		count -= 2
+	}
+	if maxHosts > 0 && count > maxHosts {
+		return netip.Prefix{}, nil, fmt.Errorf("range too large: %d hosts, maximum is %d", count, maxHosts)
+	}
+
+	hosts := make([]netip.Addr, 0, count)
+	addr := prefix.Addr()
+	if skipEnds {
+		addr = addr.Next()
+	}
+	for range count {
+		hosts = append(hosts, addr)
+		addr = addr.Next()
+	}
+
+	return prefix, hosts, nil
+}
+
+// reverseRange looks up the PTR names of hosts, each within lookupTimeout.
+func reverseRange(ctx context.Context, limit bulk.Limit, hosts []netip.Addr, lookupTimeout time.Duration) ReverseRangeResponse {
+	response := ReverseRangeResponse{
+		Hosts: len(hosts),
+		Names: map[string][]string{},
+	}
+
+	names := make([][]string, len(hosts))
+	failed := make([]bool, len(hosts))
+	for i := range failed {
+		// Kept for the hosts skipped once the request is canceled
+		failed[i] = true
+	}
+
+	limit.ForEach(ctx, len(hosts), func(ctx context.Context, i int) {
+		found, err := reverseHost(ctx, hosts[i], lookupTimeout)
+		names[i], failed[i] = found, err != nil
+	})
+
+	for i, host := range hosts {
+		switch {
+		case failed[i]:
+			response.Failed = append(response.Failed, host.String())
+		case len(names[i]) > 0:
+			response.Names[host.String()] = names[i]
+		}
+	}
+
+	return response
+}
+
+// reverseHost returns the PTR names of addr, none when it has no PTR records.
+func reverseHost(ctx context.Context, addr netip.Addr, lookupTimeout time.Duration) ([]string, error) {
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	resolver := &net.Resolver{}
+	names, err := resolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+
+	return names, nil
+}
@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"slices"
+)
+
+// Response formats of GET /dns, selected with the format parameter.
+const (
+	FormatFull   = "full"
+	FormatSimple = "simple"
+)
+
+// simpleTypes are the record types of FormatSimple.
+var simpleTypes = []string{"A", "AAAA"}
+
+var (
+	errInvalidFormat = errors.New("invalid format, expected full, simple, json, yaml or text")
+	errSimpleTypes   = errors.New("format=simple only supports the A and AAAA types")
+	errSimpleReverse = errors.New("format=simple only supports domain lookups")
+)
+
+// SimpleResponse is the flat response of GET /dns?format=simple, for clients
+// that only want the addresses of a domain. Errors have the same
+// {"error": "..."} shape as the full format.
+type SimpleResponse struct {
+	Domain string `json:"domain,omitempty"`
+	// UnicodeDomain is the Unicode form of an internationalized Domain.
+	UnicodeDomain string   `json:"unicodeDomain,omitempty"`
+	A             []string `json:"a,omitempty"`
+	AAAA          []string `json:"aaaa,omitempty"`
+	NXDomain      bool     `json:"nxdomain,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// simpleTypesOf returns the record types to look up for FormatSimple: A and
+// AAAA, or the ones of types when it's limited to them.
+func simpleTypesOf(types map[string]bool, all bool) (map[string]bool, error) {
+	if all {
+		simple := make(map[string]bool, len(simpleTypes))
+		for _, t := range simpleTypes {
+			simple[t] = true
+		}
+		return simple, nil
+	}
+
+	for t := range maps.Keys(types) {
+		if !slices.Contains(simpleTypes, t) {
+			return nil, errSimpleTypes
+		}
+	}
+
+	return types, nil
+}
+
+// simplify flattens response to the addresses of its domain.
+func simplify(response DNSResponse) SimpleResponse {
+	simple := SimpleResponse{
+		Domain:        response.Domain,
+		UnicodeDomain: response.UnicodeDomain,
+		NXDomain:      response.NXDomain,
+		Error:         cmp.Or(response.Error, response.Errors["A"], response.Errors["AAAA"]),
+	}
+
+	values := recordValues(response.Records)
+	simple.A, simple.AAAA = values["A"], values["AAAA"]
+
+	return simple
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// sorted returns the records in a deterministic order (sort=true): A and AAAA
+// by address without duplicates, MX by priority then host, NS by name. The
+// records may be shared with the cache, so they are copied, not sorted in
+// place.
+func (r *DNSRecords) sorted() *DNSRecords {
+	sorted := *r
+	sorted.A = sortAddrs(r.A)
+	sorted.AAAA = sortAddrs(r.AAAA)
+
+	sorted.MX = slices.Clone(r.MX)
+	slices.SortStableFunc(sorted.MX, func(a, b MXRecord) int {
+		return cmp.Or(cmp.Compare(a.Priority, b.Priority), compareNames(a.Host, b.Host))
+	})
+
+	sorted.NS = slices.Clone(r.NS)
+	slices.SortStableFunc(sorted.NS, func(a, b Record) int {
+		return compareNames(a.Value, b.Value)
+	})
+
+	return &sorted
+}
+
+// sortAddrs returns the address records ordered by address, each address
+// once. Values that aren't addresses sort last, by text.
+func sortAddrs(records []Record) []Record {
+	if records == nil {
+		return nil
+	}
+
+	sorted := slices.Clone(records)
+	slices.SortStableFunc(sorted, func(a, b Record) int {
+		addrA, errA := netip.ParseAddr(a.Value)
+		addrB, errB := netip.ParseAddr(b.Value)
+		switch {
+		case errA == nil && errB == nil:
+			return addrA.Compare(addrB)
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			return strings.Compare(a.Value, b.Value)
+		}
+	})
+
+	return slices.CompactFunc(sorted, func(a, b Record) bool {
+		return a.Value == b.Value
+	})
+}
+
+// compareNames orders domain names case-insensitively, ignoring a trailing
+// dot.
+func compareNames(a, b string) int {
+	return strings.Compare(
+		strings.TrimSuffix(strings.ToLower(a), "."),
+		strings.TrimSuffix(strings.ToLower(b), "."),
+	)
+}
@@ -0,0 +1,414 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+const (
+	traceTimeout      = 30 * time.Second
+	traceQueryTimeout = 3 * time.Second
+	// traceMaxQueries bounds the queries of a trace, across CNAME restarts.
+	traceMaxQueries = 30
+	// traceMaxServers is how many nameservers of a zone are tried before the
+	// zone is given up on.
+	traceMaxServers = 3
+	traceMaxCNAMEs  = 8
+)
+
+// tracePort is the port nameservers are queried on; tests override it.
+var tracePort = "53"
+
+// rootServers are the IPv4 root hints.
+var rootServers = []traceServer{
+	{name: "a.root-servers.net", addr: "198.41.0.4"},
+	{name: "b.root-servers.net", addr: "170.247.170.2"},
+	{name: "c.root-servers.net", addr: "192.33.4.12"},
+	{name: "d.root-servers.net", addr: "199.7.91.13"},
+	{name: "e.root-servers.net", addr: "192.203.230.10"},
+	{name: "f.root-servers.net", addr: "192.5.5.241"},
+	{name: "g.root-servers.net", addr: "192.112.36.4"},
+	{name: "h.root-servers.net", addr: "198.97.190.53"},
+	{name: "i.root-servers.net", addr: "192.36.148.17"},
+	{name: "j.root-servers.net", addr: "192.58.128.30"},
+	{name: "k.root-servers.net", addr: "193.0.14.129"},
+	{name: "l.root-servers.net", addr: "199.7.83.42"},
+	{name: "m.root-servers.net", addr: "202.12.27.33"},
+}
+
+// TraceResponse is the result of an iterative resolution from the root.
+type TraceResponse struct {
+	Domain        string        `json:"domain"`
+	UnicodeDomain string        `json:"unicodeDomain,omitempty"`
+	Type          string        `json:"type"`
+	Steps         []TraceStep   `json:"steps"`
+	Answer        []TraceRecord `json:"answer,omitempty"`
+	NXDomain      bool          `json:"nxdomain,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// TraceStep is a single query of the trace.
+type TraceStep struct {
+	// Zone is the zone the server was queried as authoritative for.
+	Zone    string  `json:"zone"`
+	Name    string  `json:"name"`
+	Server  string  `json:"server"`
+	Address string  `json:"address,omitempty"`
+	RTTMs   float64 `json:"rttMs,omitempty"`
+	Rcode   string  `json:"rcode,omitempty"`
+	// Referral is the delegation to a child zone, when the server answered
+	// with one.
+	Referral *TraceReferral `json:"referral,omitempty"`
+	Answer   []TraceRecord  `json:"answer,omitempty"`
+	// Lame is set when the server isn't serving the zone it was delegated:
+	// it refused, failed or answered without an answer or a referral.
+	Lame  bool   `json:"lame,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type TraceReferral struct {
+	Zone        string   `json:"zone"`
+	Nameservers []string `json:"nameservers"`
+	// Glue maps nameservers to the addresses included with the referral.
+	Glue map[string]string `json:"glue,omitempty"`
+}
+
+type TraceRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+// traceServer is a nameserver of a zone; addr is empty when the referral
+// came without glue.
+type traceServer struct {
+	name string
+	addr string
+}
+
+// traceResult is the usable outcome of querying a zone.
+type traceResult struct {
+	answer   []mdns.RR
+	nxdomain bool
+	// zone and servers are set for a referral.
+	zone    string
+	servers []traceServer
+}
+
+// Trace handles GET /dns/trace - resolves a name iteratively from the root
+// servers and reports every delegation on the way.
+func (h *Handler) Trace(c *ada.Context) error {
+	domain := strings.TrimSpace(c.Request.URL.Query().Get("domain"))
+	if domain == "" {
+		return render.Send(c, http.StatusBadRequest, TraceResponse{Error: "domain parameter is required"})
+	}
+
+	response, err := h.TraceLookup(c.Request.Context(), domain, c.Request.URL.Query().Get("type"))
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), TraceResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// TraceLookup resolves recordType (default A) of domain iteratively, starting
+// at the root servers and following referrals and CNAMEs. The error reports
+// invalid input; resolution failures are part of the response.
+func (h *Handler) TraceLookup(ctx context.Context, domain, recordType string) (TraceResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return TraceResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return TraceResponse{}, errInvalidDomain
+	}
+
+	recordType = strings.ToUpper(strings.TrimSpace(recordType))
+	if recordType == "" {
+		recordType = "A"
+	}
+	if !containsString(recordTypes, recordType) {
+		return TraceResponse{}, fmt.Errorf("unsupported record type %q, supported types: %s", recordType, strings.Join(recordTypes, ", "))
+	}
+	qtype := mdns.StringToType[recordType]
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return TraceResponse{}, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, traceTimeout)
+	defer cancel()
+
+	t := &tracer{
+		client: &mdns.Client{
+			Net:    "udp",
+			Dialer: h.guard.Dialer(&net.Dialer{Timeout: traceQueryTimeout}),
+		},
+		response: TraceResponse{Domain: domain, UnicodeDomain: idn.ToUnicode(domain), Type: recordType, Steps: []TraceStep{}},
+	}
+
+	name := domain
+	for range traceMaxCNAMEs {
+		result, err := t.resolve(ctx, name, qtype)
+		if err != nil {
+			t.response.Error = err.Error()
+			return t.response, nil
+		}
+
+		if result.nxdomain {
+			t.response.NXDomain = true
+			return t.response, nil
+		}
+
+		t.response.Answer = append(t.response.Answer, traceRecords(result.answer)...)
+
+		// Restart from the root for a CNAME without the records behind it
+		target := cnameTarget(result.answer, name, qtype)
+		if target == "" {
+			return t.response, nil
+		}
+		name = target
+	}
+
+	t.response.Error = "too many CNAMEs"
+	return t.response, nil
+}
+
+// tracer holds the state of one trace.
+type tracer struct {
+	client   *mdns.Client
+	queries  int
+	response TraceResponse
+}
+
+// resolve follows the referrals for name from the root down to a server
+// that answers.
+func (t *tracer) resolve(ctx context.Context, name string, qtype uint16) (traceResult, error) {
+	zone := "."
+	servers := make([]traceServer, len(rootServers))
+	for i, j := range rand.Perm(len(rootServers)) {
+		servers[i] = rootServers[j]
+	}
+
+	for {
+		result, err := t.ask(ctx, zone, servers, name, qtype)
+		if err != nil {
+			return traceResult{}, err
+		}
+
+		if result.zone == "" {
+			return result, nil
+		}
+
+		zone, servers = result.zone, result.servers
+	}
+}
+
+// ask queries the servers of zone in turn until one gives a usable answer,
+// recording a step per query.
+func (t *tracer) ask(ctx context.Context, zone string, servers []traceServer, name string, qtype uint16) (traceResult, error) {
+	for _, server := range servers[:min(len(servers), traceMaxServers)] {
+		if t.queries >= traceMaxQueries {
+			return traceResult{}, errors.New("too many queries")
+		}
+		if ctx.Err() != nil {
+			return traceResult{}, errors.New("trace timed out")
+		}
+		t.queries++
+
+		step := TraceStep{Zone: zone, Name: name, Server: server.name}
+		result, ok := t.query(ctx, &step, server, name, qtype)
+		t.response.Steps = append(t.response.Steps, step)
+		if ok {
+			return result, nil
+		}
+	}
+
+	return traceResult{}, fmt.Errorf("no nameserver of %s answered", zone)
+}
+
+// query sends a non-recursive query to server and classifies the response,
+// filling in step. ok is false when the next server should be tried.
+func (t *tracer) query(ctx context.Context, step *TraceStep, server traceServer, name string, qtype uint16) (result traceResult, ok bool) {
+	addr := server.addr
+	if addr == "" {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", server.name)
+		if err != nil || len(addrs) == 0 {
+			step.Error = "could not resolve nameserver address"
+			return traceResult{}, false
+		}
+		addr = addrs[0].String()
+	}
+	step.Address = net.JoinHostPort(addr, tracePort)
+
+	msg := new(mdns.Msg)
+	msg.SetQuestion(mdns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, false)
+	msg.RecursionDesired = false
+
+	resp, rtt, err := t.exchange(ctx, msg, step.Address)
+	if err != nil {
+		if errors.Is(err, netguard.ErrBlocked) {
+			step.Error = err.Error()
+		} else {
+			step.Error = simplifyError(err)
+		}
+		return traceResult{}, false
+	}
+
+	step.RTTMs = float64(rtt.Microseconds()) / 1000
+	step.Rcode = mdns.RcodeToString[resp.Rcode]
+
+	switch resp.Rcode {
+	case mdns.RcodeSuccess:
+	case mdns.RcodeNameError:
+		return traceResult{nxdomain: true}, true
+	default:
+		step.Lame = true
+		step.Error = "server answered " + step.Rcode
+		return traceResult{}, false
+	}
+
+	if len(resp.Answer) > 0 {
+		step.Answer = traceRecords(resp.Answer)
+		return traceResult{answer: resp.Answer}, true
+	}
+
+	if referral, servers := parseReferral(resp, step.Zone, name); referral != nil {
+		step.Referral = referral
+		return traceResult{zone: referral.Zone, servers: servers}, true
+	}
+
+	// An authoritative empty answer: the name exists without such records
+	if resp.Authoritative {
+		return traceResult{}, true
+	}
+
+	step.Lame = true
+	step.Error = "no answer or referral (lame delegation)"
+	return traceResult{}, false
+}
+
+// exchange sends msg over UDP, retrying over TCP when the answer is truncated.
+func (t *tracer) exchange(ctx context.Context, msg *mdns.Msg, address string) (*mdns.Msg, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, traceQueryTimeout)
+	defer cancel()
+
+	resp, rtt, err := t.client.ExchangeContext(ctx, msg, address)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.Truncated {
+		client := *t.client
+		client.Net = "tcp"
+		return client.ExchangeContext(ctx, msg, address)
+	}
+
+	return resp, rtt, nil
+}
+
+// parseReferral returns the delegation in resp, if any: NS records for a zone
+// below parent that contains name. Nameservers with glue come first.
+func parseReferral(resp *mdns.Msg, parent, name string) (*TraceReferral, []traceServer) {
+	var (
+		referral TraceReferral
+		servers  []traceServer
+	)
+
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*mdns.NS)
+		if !ok {
+			continue
+		}
+
+		child := mdns.CanonicalName(ns.Hdr.Name)
+		if child == parent || !mdns.IsSubDomain(parent, child) || !mdns.IsSubDomain(child, mdns.CanonicalName(name)) {
+			continue
+		}
+		if referral.Zone == "" {
+			referral.Zone = child
+		}
+		if child != referral.Zone {
+			continue
+		}
+
+		host := mdns.CanonicalName(ns.Ns)
+		referral.Nameservers = append(referral.Nameservers, strings.TrimSuffix(host, "."))
+		servers = append(servers, traceServer{name: strings.TrimSuffix(host, ".")})
+	}
+
+	if referral.Zone == "" {
+		return nil, nil
+	}
+
+	var withGlue, withoutGlue []traceServer
+	for _, server := range servers {
+		for _, rr := range resp.Extra {
+			if a, ok := rr.(*mdns.A); ok && mdns.CanonicalName(a.Hdr.Name) == server.name+"." {
+				server.addr = a.A.String()
+				if referral.Glue == nil {
+					referral.Glue = make(map[string]string)
+				}
+				referral.Glue[server.name] = server.addr
+				break
+			}
+		}
+
+		if server.addr != "" {
+			withGlue = append(withGlue, server)
+		} else {
+			withoutGlue = append(withoutGlue, server)
+		}
+	}
+
+	return &referral, append(withGlue, withoutGlue...)
+}
+
+// cnameTarget returns where a CNAME for name points when answer doesn't
+// already hold records of qtype.
+func cnameTarget(answer []mdns.RR, name string, qtype uint16) string {
+	target := ""
+	for _, rr := range answer {
+		if rr.Header().Rrtype == qtype {
+			return ""
+		}
+		if cname, ok := rr.(*mdns.CNAME); ok && mdns.CanonicalName(cname.Hdr.Name) == mdns.CanonicalName(name) {
+			target = strings.TrimSuffix(cname.Target, ".")
+		}
+	}
+
+	return target
+}
+
+func traceRecords(rrs []mdns.RR) []TraceRecord {
+	records := make([]TraceRecord, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		records = append(records, TraceRecord{
+			Name:  strings.TrimSuffix(hdr.Name, "."),
+			Type:  mdns.TypeToString[hdr.Rrtype],
+			TTL:   hdr.Ttl,
+			Value: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+
+	return records
+}
@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+// TransportCompare is the result of repeating a lookup over IPv4 and IPv6
+// transport (transport=compare).
+type TransportCompare struct {
+	IPv4        TransportResult       `json:"ipv4"`
+	IPv6        TransportResult       `json:"ipv6"`
+	Match       bool                  `json:"match"`
+	Differences []TransportDifference `json:"differences,omitempty"`
+}
+
+type TransportResult struct {
+	Records *DNSRecords       `json:"records,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// TransportDifference lists the values of one record type that were only
+// returned over one of the transports.
+type TransportDifference struct {
+	Type     string   `json:"type"`
+	OnlyIPv4 []string `json:"onlyIpv4,omitempty"`
+	OnlyIPv6 []string `json:"onlyIpv6,omitempty"`
+}
+
+// transportTypes are the record types compared across transports. SOA and CAA
+// are queried directly over UDP and can't be forced onto a transport.
+var transportTypes = []string{"A", "AAAA", "MX", "TXT", "CNAME", "NS", "SRV"}
+
+// compareTransports repeats the selected lookups with the resolver connection
+// forced over tcp4 and then tcp6, and diffs the answers.
+func compareTransports(ctx context.Context, domain string, opts lookupOptions) *TransportCompare {
+	types := make(map[string]bool, len(transportTypes))
+	for _, t := range transportTypes {
+		types[t] = opts.types[t]
+	}
+
+	var (
+		compare TransportCompare
+		wg      sync.WaitGroup
+	)
+
+	for network, result := range map[string]*TransportResult{"tcp4": &compare.IPv4, "tcp6": &compare.IPv6} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res := newTransportResolver(opts.server, network, opts.guard)
+			records, errors, _ := res.lookupRecords(ctx, domain, types)
+
+			result.Records = records
+			if len(errors) > 0 {
+				result.Errors = errors
+			}
+		}()
+	}
+	wg.Wait()
+
+	ipv4 := recordValues(compare.IPv4.Records)
+	ipv6 := recordValues(compare.IPv6.Records)
+	for _, t := range transportTypes {
+		if !types[t] {
+			continue
+		}
+
+		diff := TransportDifference{
+			Type:     t,
+			OnlyIPv4: subtract(ipv4[t], ipv6[t]),
+			OnlyIPv6: subtract(ipv6[t], ipv4[t]),
+		}
+		if len(diff.OnlyIPv4) > 0 || len(diff.OnlyIPv6) > 0 {
+			compare.Differences = append(compare.Differences, diff)
+		}
+	}
+
+	compare.Match = len(compare.Differences) == 0 && len(compare.IPv4.Errors) == 0 && len(compare.IPv6.Errors) == 0
+
+	return &compare
+}
+
+// newTransportResolver returns a resolver whose connections to the nameserver
+// are forced over network (tcp4 or tcp6). Without a custom server, the system
+// nameservers of the other address family are skipped. A custom server is
+// dialed through guard.
+func newTransportResolver(server, network string, guard *netguard.Guard) *resolver {
+	dialer := &net.Dialer{}
+	if server != "" {
+		dialer = guard.Dialer(dialer)
+	}
+
+	return &resolver{
+		system: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
+				if server != "" {
+					address = server
+				}
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+		server: systemNameserver(),
+	}
+}
+
+// recordValues flattens records into comparable strings per type.
+func recordValues(records *DNSRecords) map[string][]string {
+	values := make(map[string][]string)
+	if records == nil {
+		return values
+	}
+
+	add := func(t string, list []Record) {
+		for _, r := range list {
+			values[t] = append(values[t], r.Value)
+		}
+	}
+
+	add("A", records.A)
+	add("AAAA", records.AAAA)
+	add("TXT", records.TXT)
+	add("CNAME", records.CNAME)
+	add("NS", records.NS)
+
+	for _, mx := range records.MX {
+		values["MX"] = append(values["MX"], fmt.Sprintf("%d %s", mx.Priority, mx.Host))
+	}
+	for _, srv := range records.SRV {
+		values["SRV"] = append(values["SRV"], fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+	}
+
+	return values
+}
+
+// subtract returns the values of a that are not in b.
+func subtract(a, b []string) []string {
+	var diff []string
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			diff = append(diff, v)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+type VerifyTXTResponse struct {
+	Domain        string   `json:"domain,omitempty"`
+	UnicodeDomain string   `json:"unicodeDomain,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Expected      string   `json:"expected,omitempty"`
+	Verified      bool     `json:"verified"`
+	Found         []string `json:"found,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// VerifyTXT handles domain ownership checks: it looks up the TXT records of
+// name under domain and reports whether one of them equals value exactly.
+func (h *Handler) VerifyTXT(c *ada.Context) error {
+	query := c.Request.URL.Query()
+	domain := strings.TrimSpace(query.Get("domain"))
+	name := strings.TrimSpace(query.Get("name"))
+	value := strings.TrimSpace(query.Get("value"))
+	serverParam := strings.TrimSpace(query.Get("server"))
+
+	if domain == "" || value == "" {
+		return render.Send(c, http.StatusBadRequest, VerifyTXTResponse{Error: "domain and value parameters are required"})
+	}
+
+	var (
+		opts lookupOptions
+		err  error
+	)
+	opts.timeout, err = timeout.FromQuery(query)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, VerifyTXTResponse{Error: err.Error()})
+	}
+	opts.timeout = cmp.Or(opts.timeout, h.cfg.Timeout)
+
+	if serverParam != "" {
+		opts.server, err = parseNameserver(serverParam)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, VerifyTXTResponse{Error: err.Error()})
+		}
+	}
+
+	if err := h.parseResolver(&opts, query.Get("dot"), query.Get("doh")); err != nil {
+		return render.Send(c, http.StatusBadRequest, VerifyTXTResponse{Error: err.Error()})
+	}
+
+	if err := h.checkTargets(c.Request.Context(), &opts); err != nil {
+		return render.Send(c, http.StatusBadRequest, VerifyTXTResponse{Error: err.Error()})
+	}
+
+	response, err := verifyTXT(c.Request.Context(), domain, name, value, opts)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), VerifyTXTResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// Verify reports whether a TXT record of name under domain equals value,
+// resolving with the configured resolver. The error reports an invalid domain
+// or name; lookup failures are part of the response.
+func (h *Handler) Verify(ctx context.Context, domain, name, value string) (VerifyTXTResponse, error) {
+	opts := lookupOptions{timeout: h.cfg.Timeout}
+	if err := h.parseResolver(&opts, "", ""); err != nil {
+		return VerifyTXTResponse{}, err
+	}
+
+	return verifyTXT(ctx, domain, name, value, opts)
+}
+
+var errInvalidRecordName = errors.New("invalid record name")
+
+// verifyTXT checks the TXT records of name under domain with the given
+// resolver options.
+func verifyTXT(ctx context.Context, domain, name, value string, opts lookupOptions) (VerifyTXTResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return VerifyTXTResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return VerifyTXTResponse{}, errInvalidDomain
+	}
+
+	fqdn := challengeName(name, domain)
+	if !isValidDomain(fqdn) {
+		return VerifyTXTResponse{}, errInvalidRecordName
+	}
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return VerifyTXTResponse{}, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(opts.timeout, verifyTimeout))
+	defer cancel()
+
+	response := VerifyTXTResponse{
+		Domain:        domain,
+		UnicodeDomain: idn.ToUnicode(domain),
+		Name:          fqdn,
+		Expected:      value,
+	}
+
+	txts, err := newResolver(opts).lookupTXT(ctx, fqdn)
+	if err != nil {
+		if !isNotFoundError(err) {
+			response.Error = simplifyError(err)
+		}
+		return response, nil
+	}
+
+	for _, txt := range txts {
+		response.Found = append(response.Found, txt.Value)
+		if txt.Value == value {
+			response.Verified = true
+		}
+	}
+
+	return response, nil
+}
+
+// challengeName returns the record name to check for a challenge. name may be
+// a label relative to domain (_myapp-challenge) or already include it; an
+// empty name checks domain itself.
+func challengeName(name, domain string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	switch {
+	case name == "" || name == "@" || name == domain:
+		return domain
+	case strings.HasSuffix(name, "."+domain):
+		return name
+	default:
+		return name + "." + domain
+	}
+}
@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+// wildcardProbes is the number of random names resolved. A single resolving
+// name could be a real record or a flaky resolver; all of them answering
+// alike is a wildcard.
+const wildcardProbes = 3
+
+type WildcardResponse struct {
+	Domain        string `json:"domain,omitempty"`
+	UnicodeDomain string `json:"unicodeDomain,omitempty"`
+	// Wildcard is true when every probe resolved to the same addresses.
+	Wildcard bool `json:"wildcard"`
+	// Addresses are the A and AAAA answers of the wildcard.
+	Addresses []string        `json:"addresses,omitempty"`
+	Probes    []WildcardProbe `json:"probes,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// WildcardProbe is the answer for one random name under the domain.
+type WildcardProbe struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Wildcard handles GET /dns/wildcard - checks whether random subdomains of a
+// domain resolve.
+func (h *Handler) Wildcard(c *ada.Context) error {
+	query := c.Request.URL.Query()
+	domain := strings.TrimSpace(query.Get("domain"))
+	serverParam := strings.TrimSpace(query.Get("server"))
+
+	if domain == "" {
+		return render.Send(c, http.StatusBadRequest, WildcardResponse{Error: "domain parameter is required"})
+	}
+
+	var (
+		opts lookupOptions
+		err  error
+	)
+	opts.timeout, err = timeout.FromQuery(query)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, WildcardResponse{Error: err.Error()})
+	}
+	opts.timeout = cmp.Or(opts.timeout, h.cfg.Timeout)
+
+	if serverParam != "" {
+		opts.server, err = parseNameserver(serverParam)
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, WildcardResponse{Error: err.Error()})
+		}
+	}
+
+	if err := h.parseResolver(&opts, query.Get("dot"), query.Get("doh")); err != nil {
+		return render.Send(c, http.StatusBadRequest, WildcardResponse{Error: err.Error()})
+	}
+
+	if err := h.checkTargets(c.Request.Context(), &opts); err != nil {
+		return render.Send(c, http.StatusBadRequest, WildcardResponse{Error: err.Error()})
+	}
+
+	response, err := detectWildcard(c.Request.Context(), domain, opts)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), WildcardResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// DetectWildcard reports whether domain has a wildcard A or AAAA record,
+// resolving with the configured resolver. The error reports an invalid
+// domain; lookup failures are part of the response.
+func (h *Handler) DetectWildcard(ctx context.Context, domain string) (WildcardResponse, error) {
+	opts := lookupOptions{timeout: h.cfg.Timeout}
+	if err := h.parseResolver(&opts, "", ""); err != nil {
+		return WildcardResponse{}, err
+	}
+
+	return detectWildcard(ctx, domain, opts)
+}
+
+// detectWildcard resolves a few random names under domain concurrently. It
+// only reports a wildcard when all of them resolve to the same addresses, so
+// a flaky resolver or a real record can't cause a false positive.
+func detectWildcard(ctx context.Context, domain string, opts lookupOptions) (WildcardResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return WildcardResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return WildcardResponse{}, errInvalidDomain
+	}
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return WildcardResponse{}, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(opts.timeout, lookupTimeout))
+	defer cancel()
+
+	response := WildcardResponse{
+		Domain:        domain,
+		UnicodeDomain: idn.ToUnicode(domain),
+		Probes:        make([]WildcardProbe, wildcardProbes),
+	}
+
+	res := newResolver(opts)
+	types := map[string]bool{"A": true, "AAAA": true}
+
+	var wg sync.WaitGroup
+	for i := range response.Probes {
+		wg.Go(func() {
+			name := randomLabel() + "." + domain
+			records, errs, _ := res.lookupRecords(ctx, name, types)
+
+			probe := WildcardProbe{Name: name, Addresses: []string{}}
+			for _, record := range slices.Concat(records.A, records.AAAA) {
+				probe.Addresses = append(probe.Addresses, record.Value)
+			}
+			slices.Sort(probe.Addresses)
+			if err := cmp.Or(errs["A"], errs["AAAA"]); err != "" {
+				probe.Error = err
+			}
+
+			response.Probes[i] = probe
+		})
+	}
+	wg.Wait()
+
+	first := response.Probes[0]
+	for _, probe := range response.Probes {
+		if probe.Error != "" {
+			response.Error = fmt.Sprintf("lookup of %s failed: %s", probe.Name, probe.Error)
+			return response, nil
+		}
+		if len(probe.Addresses) == 0 || !slices.Equal(probe.Addresses, first.Addresses) {
+			return response, nil
+		}
+	}
+
+	response.Wildcard = true
+	response.Addresses = first.Addresses
+
+	return response, nil
+}
+
+// randomLabel returns a label that is practically certain not to exist.
+func randomLabel() string {
+	return "bir-" + strings.ToLower(rand.Text()[:16])
+}
@@ -0,0 +1,162 @@
+// Package domain combines the DNS, SSL and WHOIS lookups of a domain into a
+// single report, running the tools concurrently.
+package domain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/dns"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/ssl"
+	"github.com/rytsh/bir/api/tools/whois"
+)
+
+// errTimedOut is reported for a tool that didn't finish within the timeout.
+var errTimedOut = errors.New("timed out")
+
+// Config holds the domain report configuration, loaded from env via chu.
+type Config struct {
+	// Timeout bounds the whole report; tools still running are reported as
+	// timed out.
+	Timeout time.Duration `cfg:"timeout" default:"20s"`
+}
+
+// Response is the combined report. Each tool reports its own error, so one
+// failing tool doesn't hide the others.
+type Response struct {
+	Domain string               `json:"domain"`
+	DNS    *dns.DNSResponse     `json:"dns,omitempty"`
+	SSL    *ssl.SSLResponse     `json:"ssl,omitempty"`
+	Whois  *whois.WhoisResponse `json:"whois,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// Handler serves domain reports using the tool handlers.
+type Handler struct {
+	cfg   Config
+	dns   *dns.Handler
+	ssl   *ssl.Handler
+	whois *whois.Handler
+}
+
+// New builds a domain report Handler on top of the tool handlers, so their
+// configuration and cache are shared with the tool endpoints.
+func New(cfg Config, dnsHandler *dns.Handler, sslHandler *ssl.Handler, whoisHandler *whois.Handler) *Handler {
+	return &Handler{
+		cfg:   cfg,
+		dns:   dnsHandler,
+		ssl:   sslHandler,
+		whois: whoisHandler,
+	}
+}
+
+// Report handles GET /report?domain=.
+func (h *Handler) Report(c *ada.Context) error {
+	domain := strings.TrimSpace(c.Request.URL.Query().Get("domain"))
+	if domain == "" {
+		return render.Send(c, http.StatusBadRequest, Response{Error: "domain parameter is required"})
+	}
+
+	response, err := h.Lookup(c.Request.Context(), domain)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), Response{Domain: domain, Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// Lookup runs the DNS, SSL and WHOIS lookups of domain concurrently. The error
+// reports an invalid domain, or outbound.ErrBusy when a tool got no lookup
+// slot; tool failures are part of the sub-responses.
+func (h *Handler) Lookup(ctx context.Context, domain string) (Response, error) {
+	if h.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Timeout)
+		defer cancel()
+	}
+
+	var (
+		response = Response{Domain: domain}
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		invalid  error
+	)
+
+	run := func(fn func(ctx context.Context) error) {
+		wg.Go(func() {
+			if err := fn(ctx); err != nil {
+				mu.Lock()
+				invalid = err
+				mu.Unlock()
+			}
+		})
+	}
+
+	run(func(ctx context.Context) error {
+		r, err := withTimeout(ctx, func() (dns.DNSResponse, error) {
+			return h.dns.Lookup(ctx, domain)
+		}, func(err error) dns.DNSResponse {
+			return dns.DNSResponse{Domain: domain, Error: err.Error()}
+		})
+		response.DNS = &r
+		return err
+	})
+
+	run(func(ctx context.Context) error {
+		r, err := withTimeout(ctx, func() (ssl.SSLResponse, error) {
+			return h.ssl.Inspect(ctx, domain, 0, ssl.Options{})
+		}, func(err error) ssl.SSLResponse {
+			return ssl.SSLResponse{Domain: domain, Error: err.Error()}
+		})
+		response.SSL = &r
+		return err
+	})
+
+	run(func(ctx context.Context) error {
+		r, err := withTimeout(ctx, func() (whois.WhoisResponse, error) {
+			return h.whois.Lookup(ctx, domain)
+		}, func(err error) whois.WhoisResponse {
+			return whois.WhoisResponse{Domain: domain, Error: err.Error()}
+		})
+		response.Whois = &r
+		return err
+	})
+
+	wg.Wait()
+
+	if invalid != nil {
+		return Response{}, invalid
+	}
+
+	return response, nil
+}
+
+// withTimeout runs fn, giving up when ctx is done. A tool that ignores its
+// context keeps running in the background, but the report doesn't wait for it.
+func withTimeout[T any](ctx context.Context, fn func() (T, error), failed func(error) T) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return failed(errTimedOut), nil
+	}
+}
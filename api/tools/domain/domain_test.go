@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	failed := func(err error) string { return err.Error() }
+
+	got, err := withTimeout(context.Background(), func() (string, error) {
+		return "ok", nil
+	}, failed)
+	if got != "ok" || err != nil {
+		t.Fatalf("withTimeout() = %q, %v; want ok, nil", got, err)
+	}
+
+	errInvalid := errors.New("invalid")
+	if _, err := withTimeout(context.Background(), func() (string, error) {
+		return "", errInvalid
+	}, failed); !errors.Is(err, errInvalid) {
+		t.Fatalf("withTimeout() error = %v; want %v", err, errInvalid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	got, err = withTimeout(ctx, func() (string, error) {
+		<-release
+		return "late", nil
+	}, failed)
+	if got != errTimedOut.Error() || err != nil {
+		t.Fatalf("withTimeout() = %q, %v; want %q, nil", got, err, errTimedOut)
+	}
+}
@@ -0,0 +1,167 @@
+// Package geo resolves approximate location and network ownership of IP
+// addresses behind a pluggable GeoProvider, so every geo-consuming tool
+// shares one backend.
+//
+// A MaxMind (GeoLite2/GeoIP2 mmdb) implementation is included; when nothing is
+// configured the Noop provider is used and lookups report ErrNotConfigured.
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ErrNotConfigured is returned by lookups when no geo data source is set up.
+var ErrNotConfigured = errors.New("geo provider is not configured")
+
+// Config holds the geo provider configuration, loaded from env via chu.
+type Config struct {
+	// CityDB is the path to a MaxMind City (or Country) mmdb file.
+	CityDB string `cfg:"city_db"`
+	// ASNDB is the path to a MaxMind ASN mmdb file.
+	ASNDB string `cfg:"asn_db"`
+}
+
+// GeoInfo is the approximate location and network details of an IP.
+type GeoInfo struct {
+	Country     string  `json:"country,omitempty"`
+	CountryCode string  `json:"countryCode,omitempty"`
+	Region      string  `json:"region,omitempty"`
+	City        string  `json:"city,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	ASN         uint    `json:"asn,omitempty"`
+	ASOrg       string  `json:"asOrg,omitempty"`
+	Network     string  `json:"network,omitempty"`
+}
+
+// GeoProvider looks up geo information for an IP address.
+type GeoProvider interface {
+	Lookup(ip net.IP) (GeoInfo, error)
+}
+
+// New builds a provider from the config, falling back to Noop when no
+// database is configured.
+func New(cfg Config) (GeoProvider, error) {
+	if cfg.CityDB == "" && cfg.ASNDB == "" {
+		return Noop{}, nil
+	}
+
+	return NewMaxMind(cfg.CityDB, cfg.ASNDB)
+}
+
+// Noop is the default provider used when no data source is configured.
+type Noop struct{}
+
+// Lookup always reports ErrNotConfigured.
+func (Noop) Lookup(net.IP) (GeoInfo, error) {
+	return GeoInfo{}, ErrNotConfigured
+}
+
+// MaxMind reads MaxMind mmdb databases. Either database may be omitted.
+type MaxMind struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// NewMaxMind opens the given City and ASN databases once; the readers are
+// safe for concurrent use and reused across lookups.
+func NewMaxMind(cityPath, asnPath string) (*MaxMind, error) {
+	m := &MaxMind{}
+
+	if cityPath != "" {
+		reader, err := maxminddb.Open(cityPath)
+		if err != nil {
+			return nil, fmt.Errorf("open city database: %w", err)
+		}
+		m.city = reader
+	}
+
+	if asnPath != "" {
+		reader, err := maxminddb.Open(asnPath)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("open ASN database: %w", err)
+		}
+		m.asn = reader
+	}
+
+	return m, nil
+}
+
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+type asnRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// Lookup returns the combined City and ASN data for ip.
+func (m *MaxMind) Lookup(ip net.IP) (GeoInfo, error) {
+	var info GeoInfo
+
+	if m.city != nil {
+		var record cityRecord
+		if err := m.city.Lookup(ip, &record); err != nil {
+			return GeoInfo{}, fmt.Errorf("city lookup: %w", err)
+		}
+
+		info.Country = record.Country.Names["en"]
+		info.CountryCode = record.Country.ISOCode
+		info.City = record.City.Names["en"]
+		info.Latitude = record.Location.Latitude
+		info.Longitude = record.Location.Longitude
+		if len(record.Subdivisions) > 0 {
+			info.Region = record.Subdivisions[0].Names["en"]
+		}
+	}
+
+	if m.asn != nil {
+		var record asnRecord
+		network, ok, err := m.asn.LookupNetwork(ip, &record)
+		if err != nil {
+			return GeoInfo{}, fmt.Errorf("ASN lookup: %w", err)
+		}
+
+		if ok {
+			info.ASN = record.Number
+			info.ASOrg = record.Org
+			info.Network = network.String()
+		}
+	}
+
+	return info, nil
+}
+
+// Close releases the underlying database readers.
+func (m *MaxMind) Close() error {
+	var errs []error
+
+	if m.city != nil {
+		errs = append(errs, m.city.Close())
+	}
+
+	if m.asn != nil {
+		errs = append(errs, m.asn.Close())
+	}
+
+	return errors.Join(errs...)
+}
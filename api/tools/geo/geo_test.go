@@ -0,0 +1,28 @@
+package geo
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestNewWithoutDatabasesIsNoop(t *testing.T) {
+	provider, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if _, ok := provider.(Noop); !ok {
+		t.Fatalf("provider = %T, want Noop", provider)
+	}
+
+	if _, err := provider.Lookup(net.ParseIP("8.8.8.8")); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("Lookup error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestNewWithMissingDatabaseFails(t *testing.T) {
+	if _, err := New(Config{CityDB: "testdata/does-not-exist.mmdb"}); err == nil {
+		t.Fatal("expected error for missing database file")
+	}
+}
@@ -0,0 +1,61 @@
+// Package idn converts internationalized domain names (IDNA 2008, UTS #46)
+// between their Unicode form and the ASCII (punycode) form used on the wire.
+package idn
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalid is returned for a Unicode domain that isn't a valid IDN.
+var ErrInvalid = errors.New("invalid internationalized domain name")
+
+// profile maps and validates like a lookup, but allows the underscore labels
+// of SRV and DKIM names (_dmarc.müller.de).
+var profile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(false),
+)
+
+// ToASCII returns domain with its Unicode labels converted to A-labels
+// (müller.de becomes xn--mller-kva.de). ASCII domains are returned as is.
+func ToASCII(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+
+	ascii, err := profile.ToASCII(domain)
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	return ascii, nil
+}
+
+// ToUnicode returns the Unicode form of an ASCII domain with A-labels, or ""
+// when domain has none, so responses only echo it for an IDN.
+func ToUnicode(domain string) string {
+	if !strings.HasPrefix(domain, "xn--") && !strings.Contains(domain, ".xn--") {
+		return ""
+	}
+
+	unicode, err := idna.Display.ToUnicode(domain)
+	if err != nil || unicode == domain {
+		return ""
+	}
+
+	return unicode
+}
+
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,43 @@
+package idn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+		err    error
+	}{
+		{domain: "example.com", want: "example.com"},
+		{domain: "_dmarc.example.com", want: "_dmarc.example.com"},
+		{domain: "müller.de", want: "xn--mller-kva.de"},
+		{domain: "MÜLLER.de", want: "xn--mller-kva.de"},
+		{domain: "例え.jp", want: "xn--r8jz45g.jp"},
+		{domain: "_dmarc.müller.de", want: "_dmarc.xn--mller-kva.de"},
+		{domain: "a‍b.de", err: ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		got, err := ToASCII(tt.domain)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ToASCII(%q) = %q, %v, want %q, %v", tt.domain, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := map[string]string{
+		"example.com":        "",
+		"xn--mller-kva.de":   "müller.de",
+		"www.xn--r8jz45g.jp": "www.例え.jp",
+	}
+
+	for domain, want := range tests {
+		if got := ToUnicode(domain); got != want {
+			t.Errorf("ToUnicode(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
@@ -1,19 +1,74 @@
 package ip
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/asn"
+	"github.com/rytsh/bir/api/tools/dns"
+	"github.com/rytsh/bir/api/tools/geo"
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+// reverseTimeout bounds the PTR lookup of reverse=true, so a missing PTR
+// doesn't slow the response down.
+const reverseTimeout = 2 * time.Second
+
+// Prefix lengths kept by anonymize=true: the last octet of an IPv4 address
+// and the last 80 bits of an IPv6 one are zeroed.
+const (
+	anonymizeBits4 = 24
+	anonymizeBits6 = 48
 )
 
 type Response struct {
-	IP string `json:"ip"`
+	IP string `json:"ip,omitempty"`
+	// Version is 4 or 6, and Scope where the address is routed: public,
+	// private, shared (carrier-grade NAT), loopback, link-local, multicast
+	// or unspecified.
+	Version int          `json:"version,omitempty"`
+	Scope   string       `json:"scope,omitempty"`
+	Geo     *geo.GeoInfo `json:"geo,omitempty"`
+	// ASN is the routing origin of a public IP, omitted when no ASN source
+	// is configured or the IP isn't announced.
+	ASN *asn.Info `json:"asn,omitempty"`
+	// Reverse holds the PTR names of IP with reverse=true, empty when it has
+	// none.
+	Reverse []string `json:"reverse,omitzero"`
+	// Anonymized is set when IP was masked with anonymize=true.
+	Anonymized bool   `json:"anonymized,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// sharedPrefix is the carrier-grade NAT range of RFC 6598.
+var sharedPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// Handler serves the IP endpoint.
+type Handler struct {
+	geo geo.GeoProvider
+	asn *asn.Resolver
 }
 
-// getClientIP extracts the client IP address from the request,
+// New builds an IP Handler. provider is used for geo=true lookups, asns, which
+// may be nil, for the ASN block.
+func New(provider geo.GeoProvider, asns *asn.Resolver) *Handler {
+	if provider == nil {
+		provider = geo.Noop{}
+	}
+
+	return &Handler{geo: provider, asn: asns}
+}
+
+// ClientIP extracts the client IP address from the request,
 // checking various headers that proxies might set.
-func getClientIP(r *http.Request) string {
+func ClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header (comma-separated list, first is client)
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		ips := strings.Split(xff, ",")
@@ -45,27 +100,141 @@ func getClientIP(r *http.Request) string {
 		return strings.TrimSpace(xcIP)
 	}
 
-	// Fall back to RemoteAddr (strip port if present)
-	remoteAddr := r.RemoteAddr
-	if idx := strings.LastIndex(remoteAddr, ":"); idx != -1 {
-		// Check if it's an IPv6 address with brackets
-		if strings.Contains(remoteAddr, "[") {
-			if bracketIdx := strings.LastIndex(remoteAddr, "]"); bracketIdx != -1 {
-				return remoteAddr[1:bracketIdx]
-			}
-		}
-		return remoteAddr[:idx]
-	}
+	// Fall back to RemoteAddr
+	return RemoteIP(r)
+}
+
+// IP returns the caller's IP. With geo=true, the approximate location is added
+// when a geo database is configured; with asn=true, its origin AS and prefix;
+// with reverse=true, its PTR names. anonymize=true masks it first.
+func (h *Handler) IP(c *ada.Context) error {
+	query := c.Request.URL.Query()
 
-	return remoteAddr
+	return render.Send(c, http.StatusOK, h.describe(c.Request.Context(), ClientIP(c.Request), query, query.Get("asn") == "true"))
 }
 
-func IP(c *ada.Context) error {
-	ip := getClientIP(c.Request)
+// Lookup handles GET /ip/lookup?ip= - describes any IP like IP does the
+// caller's, with the ASN block unless asn=false.
+func (h *Handler) Lookup(c *ada.Context) error {
+	value := strings.TrimSpace(c.Request.URL.Query().Get("ip"))
+	if value == "" {
+		return render.Send(c, http.StatusBadRequest, Response{Error: "ip parameter is required"})
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil || addr.Zone() != "" {
+		return render.Send(c, http.StatusBadRequest, Response{Error: "invalid IP address"})
+	}
+
+	query := c.Request.URL.Query()
+
+	return render.Send(c, http.StatusOK, h.describe(c.Request.Context(), addr.String(), query, query.Get("asn") != "false"))
+}
 
+// describe classifies ip and adds the geo info and PTR names the query asks
+// for, and the ASN block with withASN. With anonymize=true, ip is masked
+// before anything else, so the full address shows up nowhere.
+func (h *Handler) describe(ctx context.Context, ip string, query url.Values, withASN bool) Response {
 	resp := Response{
 		IP: ip,
 	}
 
-	return c.SendJSON(resp)
+	if query.Get("anonymize") == "true" {
+		resp.IP, resp.Anonymized = anonymize(ip), true
+		ip = resp.IP
+	}
+
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		resp.Version, resp.Scope = classify(addr)
+
+		// only public addresses are announced
+		if withASN && resp.Scope == "public" {
+			resp.ASN = h.asn.Lookup(ctx, addr)
+		}
+	}
+
+	if query.Get("geo") == "true" {
+		resp.Geo = h.lookupGeo(ip)
+	}
+
+	if query.Get("reverse") == "true" {
+		resp.Reverse = lookupReverse(ctx, ip)
+	}
+
+	return resp
+}
+
+// classify returns the IP version and the scope of addr.
+func classify(addr netip.Addr) (int, string) {
+	addr = addr.Unmap()
+
+	version := 6
+	if addr.Is4() {
+		version = 4
+	}
+
+	switch {
+	case addr.IsUnspecified():
+		return version, "unspecified"
+	case addr.IsLoopback():
+		return version, "loopback"
+	case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast():
+		return version, "link-local"
+	case addr.IsMulticast():
+		return version, "multicast"
+	case addr.IsPrivate():
+		return version, "private"
+	case sharedPrefix.Contains(addr):
+		return version, "shared"
+	default:
+		return version, "public"
+	}
+}
+
+// anonymize zeroes the host part of ip, GDPR-style: the last octet of an
+// IPv4 address, the last 80 bits of an IPv6 one. An address that can't be
+// parsed is dropped rather than shown.
+func anonymize(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+
+	bits := anonymizeBits6
+	if addr = addr.Unmap(); addr.Is4() {
+		bits = anonymizeBits4
+	}
+
+	prefix, _ := addr.WithZone("").Prefix(bits)
+	return prefix.Addr().String()
+}
+
+// lookupReverse returns the PTR names of ip, or an empty list when it has none
+// or the lookup fails.
+func lookupReverse(ctx context.Context, ip string) []string {
+	ctx, cancel := context.WithTimeout(ctx, reverseTimeout)
+	defer cancel()
+
+	response, err := dns.Reverse(ctx, ip)
+	if err != nil || response.Reverse == nil {
+		return []string{}
+	}
+
+	return response.Reverse
+}
+
+// lookupGeo returns the geo info of ip, or nil when it is unknown or no
+// database is configured.
+func (h *Handler) lookupGeo(ip string) *geo.GeoInfo {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+
+	info, err := h.geo.Lookup(parsed)
+	if err != nil || info == (geo.GeoInfo{}) {
+		return nil
+	}
+
+	return &info
 }
@@ -0,0 +1,181 @@
+package ip
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/geo"
+)
+
+type fakeGeo struct{}
+
+func (fakeGeo) Lookup(ip net.IP) (geo.GeoInfo, error) {
+	return geo.GeoInfo{CountryCode: "NL", City: "Amsterdam", ASN: 1136}, nil
+}
+
+func TestIPGeo(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider geo.GeoProvider
+		query    string
+		want     *geo.GeoInfo
+	}{
+		{name: "not requested", provider: fakeGeo{}},
+		{name: "requested", provider: fakeGeo{}, query: "?geo=true", want: &geo.GeoInfo{CountryCode: "NL", City: "Amsterdam", ASN: 1136}},
+		{name: "not configured", provider: nil, query: "?geo=true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ip"+tt.query, nil)
+			req.RemoteAddr = "203.0.113.7:4321"
+			rec := httptest.NewRecorder()
+
+			if err := New(tt.provider, nil).IP(ada.NewContext(rec, req)); err != nil {
+				t.Fatal(err)
+			}
+
+			var resp Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.IP != "203.0.113.7" {
+				t.Fatalf("ip = %q", resp.IP)
+			}
+			if (resp.Geo == nil) != (tt.want == nil) || resp.Geo != nil && *resp.Geo != *tt.want {
+				t.Fatalf("geo = %+v, want %+v", resp.Geo, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPReverse(t *testing.T) {
+	for _, query := range []string{"", "?reverse=true"} {
+		req := httptest.NewRequest(http.MethodGet, "/ip"+query, nil)
+		req.RemoteAddr = "127.0.0.1:4321"
+		rec := httptest.NewRecorder()
+
+		if err := New(nil, nil).IP(ada.NewContext(rec, req)); err != nil {
+			t.Fatal(err)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		// The list is present, possibly empty, only when requested
+		_, ok := body["reverse"]
+		if want := query != ""; ok != want {
+			t.Fatalf("query %q: reverse present = %v, want %v (%s)", query, ok, want, rec.Body)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		query   string
+		status  int
+		ip      string
+		version int
+		scope   string
+	}{
+		{query: "ip=8.8.8.8", status: http.StatusOK, ip: "8.8.8.8", version: 4, scope: "public"},
+		{query: "ip=10.1.2.3", status: http.StatusOK, ip: "10.1.2.3", version: 4, scope: "private"},
+		{query: "ip=100.64.0.1", status: http.StatusOK, ip: "100.64.0.1", version: 4, scope: "shared"},
+		{query: "ip=::ffff:127.0.0.1", status: http.StatusOK, ip: "::ffff:127.0.0.1", version: 4, scope: "loopback"},
+		{query: "ip=2001:4860:4860::8888", status: http.StatusOK, ip: "2001:4860:4860::8888", version: 6, scope: "public"},
+		{query: "ip=fe80::1", status: http.StatusOK, ip: "fe80::1", version: 6, scope: "link-local"},
+		{query: "ip=fd00::1", status: http.StatusOK, ip: "fd00::1", version: 6, scope: "private"},
+		{query: "", status: http.StatusBadRequest},
+		{query: "ip=example.com", status: http.StatusBadRequest},
+		{query: "ip=fe80::1%25eth0", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ip/lookup?"+tt.query, nil)
+		rec := httptest.NewRecorder()
+
+		if err := New(fakeGeo{}, nil).Lookup(ada.NewContext(rec, req)); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.status {
+			t.Fatalf("%q: status = %d, want %d (%s)", tt.query, rec.Code, tt.status, rec.Body)
+		}
+		if resp.IP != tt.ip || resp.Version != tt.version || resp.Scope != tt.scope || resp.Geo != nil {
+			t.Fatalf("%q: response = %+v", tt.query, resp)
+		}
+	}
+}
+
+func TestIPAnonymize(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		query      string
+		want       string
+	}{
+		{remoteAddr: "203.0.113.7:4321", want: "203.0.113.7"},
+		{remoteAddr: "203.0.113.7:4321", query: "?anonymize=true", want: "203.0.113.0"},
+		{remoteAddr: "[2001:db8:1234:5678:9abc::1]:4321", query: "?anonymize=true", want: "2001:db8:1234::"},
+		{remoteAddr: "[::ffff:198.51.100.23]:4321", query: "?anonymize=true", want: "198.51.100.0"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ip"+tt.query, nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+
+		if err := New(nil, nil).IP(ada.NewContext(rec, req)); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.IP != tt.want || resp.Anonymized != (tt.query != "") {
+			t.Errorf("%s%s: ip = %q, anonymized = %v, want %q", tt.remoteAddr, tt.query, resp.IP, resp.Anonymized, tt.want)
+		}
+	}
+}
+
+func TestTrustedClientIP(t *testing.T) {
+	t.Cleanup(func() { trustedProxies.Store(nil) })
+
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatalf("SetTrustedProxies() error = %v", err)
+	}
+	if err := SetTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Fatal("SetTrustedProxies() accepted a hostname")
+	}
+
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{remoteAddr: "10.1.2.3:4321", want: "203.0.113.7"},
+		{remoteAddr: "192.0.2.1:4321", want: "203.0.113.7"},
+		// Anyone else could forge the header
+		{remoteAddr: "192.0.2.2:4321", want: "192.0.2.2"},
+		{remoteAddr: "[2001:db8::1]:4321", want: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.1.2.3")
+
+		if got := TrustedClientIP(req); got != tt.want {
+			t.Errorf("TrustedClientIP() from %s = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
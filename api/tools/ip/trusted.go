@@ -0,0 +1,80 @@
+package ip
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// trustedProxies are the peers whose forwarding headers TrustedClientIP
+// believes.
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// SetTrustedProxies sets the reverse proxies, as IPs or CIDR ranges, in front
+// of the server. None are trusted until it is called.
+func SetTrustedProxies(values []string) error {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR range", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	trustedProxies.Store(&prefixes)
+
+	return nil
+}
+
+// RemoteIP returns the IP of the connection peer, which headers can't forge.
+func RemoteIP(r *http.Request) string {
+	// Strip the port if present
+	remoteAddr := r.RemoteAddr
+	if idx := strings.LastIndex(remoteAddr, ":"); idx != -1 {
+		// Check if it's an IPv6 address with brackets
+		if strings.Contains(remoteAddr, "[") {
+			if bracketIdx := strings.LastIndex(remoteAddr, "]"); bracketIdx != -1 {
+				return remoteAddr[1:bracketIdx]
+			}
+		}
+		return remoteAddr[:idx]
+	}
+
+	return remoteAddr
+}
+
+// TrustedClientIP returns the client IP to hold to limits: ClientIP when the
+// request comes through a trusted proxy, otherwise RemoteIP, as a client
+// could send any forwarding header to pass for many.
+func TrustedClientIP(r *http.Request) string {
+	remote := RemoteIP(r)
+
+	prefixes := trustedProxies.Load()
+	if prefixes == nil {
+		return remote
+	}
+
+	addr, err := netip.ParseAddr(remote)
+	if err != nil {
+		return remote
+	}
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr) {
+			return ClientIP(r)
+		}
+	}
+
+	return remote
+}
@@ -0,0 +1,392 @@
+// Package jobs runs batch requests in the background. A batch endpoint called
+// with async=true, or callback=<url>, answers 202 with a job ID right away;
+// the results are then polled with GET /jobs/{id}, or POSTed to the callback
+// URL when the job is done, signed with HMAC-SHA256.
+package jobs
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+// Job statuses.
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Callback request headers.
+const (
+	HeaderJobID     = "X-Bir-Job-Id"
+	HeaderSignature = "X-Bir-Signature"
+)
+
+const (
+	// maxRunning caps the jobs running at once when Config.MaxRunning is 0.
+	maxRunning = 4
+	// jobTTL keeps a finished job when Config.TTL is 0.
+	jobTTL = time.Hour
+	// callbackTimeout bounds a callback delivery when Config.CallbackTimeout
+	// is 0.
+	callbackTimeout = 10 * time.Second
+	// cleanupInterval is how often expired jobs are dropped.
+	cleanupInterval = time.Minute
+)
+
+var (
+	errTooManyJobs = errors.New("too many jobs running, try again later")
+	errNoSecret    = errors.New("callbacks need a signing secret, none is configured")
+	errNotEnabled  = errors.New("async jobs aren't enabled")
+	errCallbackURL = errors.New("invalid callback, expected an http or https URL")
+)
+
+// Config holds the background job configuration, loaded from env via chu.
+type Config struct {
+	// MaxRunning caps the jobs running at once, default 4; more are refused
+	// with 503.
+	MaxRunning int `cfg:"max_running"`
+	// TTL is how long a finished job can be polled, default 1h.
+	TTL time.Duration `cfg:"ttl"`
+	// Secret signs the callback payloads. Callbacks are refused without it.
+	Secret string `cfg:"secret"`
+	// CallbackTimeout bounds the delivery of a callback, default 10s.
+	CallbackTimeout time.Duration `cfg:"callback_timeout"`
+}
+
+// Job is a batch running in the background.
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind,omitempty"`
+	Status string `json:"status,omitempty"`
+	// Result is the response the batch endpoint would have returned, set
+	// once the job is done.
+	Result     json.RawMessage `json:"result,omitempty"`
+	Callback   *Callback       `json:"callback,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time      `json:"expiresAt,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Callback is the delivery of a job to its callback URL.
+type Callback struct {
+	URL        string `json:"url"`
+	Delivered  bool   `json:"delivered"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Manager runs and keeps the jobs. A nil Manager runs every batch inline.
+type Manager struct {
+	maxRunning int
+	ttl        time.Duration
+	secret     []byte
+	guard      *netguard.Guard
+	client     *http.Client
+
+	mu      sync.Mutex
+	ctx     context.Context
+	jobs    map[string]*Job
+	running int
+}
+
+// New builds a Manager of cfg. A non-nil guard refuses callbacks to internal
+// addresses. Call Start to tie the jobs to the server lifetime and drop the
+// expired ones.
+func New(cfg Config, guard *netguard.Guard) *Manager {
+	dialer := guard.Dialer(&net.Dialer{Timeout: 5 * time.Second})
+
+	return &Manager{
+		maxRunning: cmp.Or(cfg.MaxRunning, maxRunning),
+		ttl:        cmp.Or(cfg.TTL, jobTTL),
+		secret:     []byte(cfg.Secret),
+		guard:      guard,
+		client: &http.Client{
+			Timeout:   cmp.Or(cfg.CallbackTimeout, callbackTimeout),
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// a redirect could lead the payload anywhere
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		ctx:  context.Background(),
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Start runs the jobs submitted from now on under ctx, so they stop with the
+// server, and drops expired jobs until ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+
+	go m.cleanupLoop(ctx)
+}
+
+// Run answers a batch request: inline with the response of run, or, with
+// async=true or callback=<url>, with a 202 and the Job running run in the
+// background. kind names the batch, e.g. dns_batch.
+func (m *Manager) Run(c *ada.Context, kind string, run func(ctx context.Context) any) error {
+	query := c.Request.URL.Query()
+	callback := strings.TrimSpace(query.Get("callback"))
+	if callback == "" && query.Get("async") != "true" {
+		return render.Send(c, http.StatusOK, run(c.Request.Context()))
+	}
+
+	if m == nil {
+		return render.Send(c, http.StatusBadRequest, errorResponse{Error: errNotEnabled.Error()})
+	}
+
+	if callback != "" {
+		if err := m.checkCallback(c.Request.Context(), callback); err != nil {
+			return render.Send(c, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		}
+	}
+
+	job, err := m.submit(kind, callback, run)
+	if err != nil {
+		if errors.Is(err, errTooManyJobs) {
+			c.Response.Header().Set("Retry-After", "30")
+			return render.Send(c, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		}
+		return render.Send(c, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+	}
+
+	c.Response.Header().Set("Location", "/jobs/"+job.ID)
+	return render.Send(c, http.StatusAccepted, job)
+}
+
+// Get handles GET /jobs/{id}.
+func (m *Manager) Get(c *ada.Context) error {
+	job, ok := m.job(strings.ToLower(c.Request.PathValue("id")))
+	if !ok {
+		return render.Send(c, http.StatusNotFound, errorResponse{Error: "job not found or expired"})
+	}
+
+	return render.Send(c, http.StatusOK, job)
+}
+
+// checkCallback validates a callback URL. Signing is required, so receivers
+// can tell the payloads from forged ones.
+func (m *Manager) checkCallback(ctx context.Context, callback string) error {
+	if len(m.secret) == 0 {
+		return errNoSecret
+	}
+
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errCallbackURL
+	}
+
+	if err := m.guard.Check(ctx, u.Hostname()); errors.Is(err, netguard.ErrBlocked) {
+		return err
+	}
+
+	return nil
+}
+
+// submit starts run in the background and returns a copy of its Job.
+func (m *Manager) submit(kind, callback string, run func(ctx context.Context) any) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running >= m.maxRunning {
+		return Job{}, errTooManyJobs
+	}
+	m.running++
+
+	job := &Job{
+		ID:        id,
+		Kind:      kind,
+		Status:    StatusRunning,
+		CreatedAt: time.Now().UTC(),
+	}
+	if callback != "" {
+		job.Callback = &Callback{URL: callback}
+	}
+	m.jobs[id] = job
+
+	go m.process(m.ctx, job, run)
+
+	return job.snapshot(), nil
+}
+
+// process runs the job, then delivers it to its callback.
+func (m *Manager) process(ctx context.Context, job *Job, run func(ctx context.Context) any) {
+	result, err := runSafe(ctx, run)
+
+	m.mu.Lock()
+	now := time.Now().UTC()
+	expires := now.Add(m.ttl)
+	job.FinishedAt, job.ExpiresAt = &now, &expires
+	if err != nil {
+		job.Status, job.Error = StatusFailed, err.Error()
+	} else {
+		job.Status, job.Result = StatusDone, result
+	}
+	m.running--
+	finished := job.snapshot()
+	m.mu.Unlock()
+
+	if finished.Callback == nil {
+		return
+	}
+
+	delivery := m.deliver(ctx, finished)
+
+	m.mu.Lock()
+	job.Callback = &delivery
+	m.mu.Unlock()
+}
+
+// runSafe calls run and encodes its result, turning a panic into an error so
+// a failing batch doesn't take the server down.
+func runSafe(ctx context.Context, run func(ctx context.Context) any) (result json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("job panicked", "panic", r)
+			err = errors.New("job failed")
+		}
+	}()
+
+	return json.Marshal(run(ctx))
+}
+
+// deliver POSTs job to its callback URL, signing the body with the secret.
+func (m *Manager) deliver(ctx context.Context, job Job) Callback {
+	delivery := *job.Callback
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderJobID, job.ID)
+	req.Header.Set(HeaderSignature, Sign(m.secret, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		slog.Warn("job callback failed", "job", job.ID, "error", err)
+		delivery.Error = err.Error()
+		return delivery
+	}
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Delivered {
+		delivery.Error = fmt.Sprintf("callback returned status %d", resp.StatusCode)
+	}
+
+	return delivery
+}
+
+// Sign returns the X-Bir-Signature value of body: "sha256=" and the hex
+// HMAC-SHA256 of body under secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// job returns a copy of the job with id, unless it is unknown or expired.
+func (m *Manager) job(id string) (Job, bool) {
+	if m == nil {
+		return Job{}, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok || (job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)) {
+		return Job{}, false
+	}
+
+	return job.snapshot(), true
+}
+
+// snapshot copies j, which must be guarded by the Manager lock.
+func (j *Job) snapshot() Job {
+	job := *j
+	if j.Callback != nil {
+		callback := *j.Callback
+		job.Callback = &callback
+	}
+
+	return job
+}
+
+// cleanupLoop drops expired jobs until ctx is done.
+func (m *Manager) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		now := time.Now()
+		for id, job := range m.jobs {
+			if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+				delete(m.jobs, id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// newID returns a random job ID. It is all that guards the results of a job,
+// so it is longer than a report ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate job id: %w", err)
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
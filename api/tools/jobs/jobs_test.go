@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rakunlabs/ada"
+)
+
+func serve(t *testing.T, m *Manager, query string, run func(ctx context.Context) any) (*httptest.ResponseRecorder, Job) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/dns/batch?"+query, nil)
+	if err := m.Run(ada.NewContext(rec, req), "test", run); err != nil {
+		t.Fatal(err)
+	}
+
+	var job Job
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+
+	return rec, job
+}
+
+func TestRun(t *testing.T) {
+	result := func(context.Context) any { return map[string]int{"answer": 42} }
+
+	m := New(Config{MaxRunning: 1, Secret: "s3cret"}, nil)
+
+	// Without async or callback the batch answers inline
+	if rec, _ := serve(t, m, "", result); rec.Code != http.StatusOK || rec.Body.String() != "{\"answer\":42}\n" {
+		t.Fatalf("inline run = %d %q", rec.Code, rec.Body)
+	}
+
+	release := make(chan struct{})
+	rec, job := serve(t, m, "async=true", func(ctx context.Context) any {
+		<-release
+		return result(ctx)
+	})
+	if rec.Code != http.StatusAccepted || job.ID == "" || job.Status != StatusRunning || rec.Header().Get("Location") != "/jobs/"+job.ID {
+		t.Fatalf("async run = %d %+v", rec.Code, job)
+	}
+
+	if rec, _ := serve(t, m, "async=true", result); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("run over MaxRunning = %d, want 503", rec.Code)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, ok := m.job(job.ID)
+		if !ok {
+			t.Fatal("job not found")
+		}
+		if got.Status == StatusDone {
+			if string(got.Result) != `{"answer":42}` || got.ExpiresAt == nil {
+				t.Fatalf("finished job = %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCallback(t *testing.T) {
+	delivered := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		delivered <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	run := func(context.Context) any { return []string{"ok"} }
+
+	if rec, _ := serve(t, New(Config{}, nil), "callback="+url.QueryEscape(srv.URL), run); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback without a secret = %d, want 400", rec.Code)
+	}
+	if rec, _ := serve(t, New(Config{Secret: "s3cret"}, nil), "callback=ftp://example.com", run); rec.Code != http.StatusBadRequest {
+		t.Fatalf("ftp callback = %d, want 400", rec.Code)
+	}
+
+	m := New(Config{Secret: "s3cret"}, nil)
+	rec, job := serve(t, m, "callback="+url.QueryEscape(srv.URL), run)
+	if rec.Code != http.StatusAccepted || job.Callback == nil || job.Callback.URL != srv.URL {
+		t.Fatalf("callback run = %d %+v", rec.Code, job)
+	}
+
+	var (
+		req  *http.Request
+		body []byte
+	)
+	select {
+	case req = <-delivered:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not delivered")
+	}
+
+	if got := req.Header.Get(HeaderSignature); got != Sign([]byte("s3cret"), body) {
+		t.Fatalf("signature = %q, want %q", got, Sign([]byte("s3cret"), body))
+	}
+
+	var payload Job
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ID != job.ID || payload.Status != StatusDone || string(payload.Result) != `["ok"]` {
+		t.Fatalf("callback payload = %s, %v", body, err)
+	}
+}
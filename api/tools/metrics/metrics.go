@@ -0,0 +1,210 @@
+// Package metrics exposes Prometheus metrics for the tool endpoints. Tools
+// record their own events (cache hits, upstream failures, active rooms)
+// through the helpers here; request counts and latency come from Middleware.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/rytsh/bir/api/tools/response"
+)
+
+// Config holds the metrics configuration, loaded from env via chu.
+type Config struct {
+	// Enabled serves the metrics at Path.
+	Enabled bool `cfg:"enabled" default:"false"`
+	// Path is the route of the metrics endpoint.
+	Path string `cfg:"path" default:"/metrics"`
+}
+
+const namespace = "bir"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Tool requests by tool and HTTP status.",
+	}, []string{"tool", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Tool request duration in seconds.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"tool"})
+
+	whoisCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "whois_cache_total",
+		Help:      "WHOIS cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	dnsCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_cache_total",
+		Help:      "DNS cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	sslCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssl_cache_total",
+		Help:      "SSL cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	upstreamFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_failures_total",
+		Help:      "Failed upstream lookups by type.",
+	}, []string{"type"})
+
+	upstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Upstream lookups retried after a transient failure, by type.",
+	}, []string{"type"})
+
+	webrtcRooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webrtc_rooms_active",
+		Help:      "Active WebRTC signaling rooms.",
+	})
+
+	webrtcRoomsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webrtc_rooms_created_total",
+		Help:      "WebRTC signaling rooms created.",
+	})
+
+	webrtcRoomsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webrtc_rooms_deleted_total",
+		Help:      "WebRTC signaling rooms deleted by reason.",
+	}, []string{"reason"})
+
+	webrtcStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webrtc_streams_active",
+		Help:      "Open WebRTC events and WebSocket streams.",
+	})
+
+	webrtcStreamsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webrtc_streams_rejected_total",
+		Help:      "WebRTC streams refused by the total or per-IP cap.",
+	}, []string{"limit"})
+
+	outboundInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbound_lookups_in_flight",
+		Help:      "Outbound lookups running, across all tools.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records the request count and duration of a tool endpoint.
+func Middleware(tool string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := response.NewWriter(w)
+
+			next.ServeHTTP(sw, r)
+
+			requestsTotal.WithLabelValues(tool, strconv.Itoa(sw.Status())).Inc()
+			requestDuration.WithLabelValues(tool).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// WhoisCache records a WHOIS cache hit or miss.
+func WhoisCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	whoisCache.WithLabelValues(result).Inc()
+}
+
+// DNSCache records a DNS cache hit or miss.
+func DNSCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	dnsCache.WithLabelValues(result).Inc()
+}
+
+// SSLCache records an SSL cache hit or miss.
+func SSLCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	sslCache.WithLabelValues(result).Inc()
+}
+
+// UpstreamFailure records a failed upstream lookup, e.g. "dns", "tls",
+// "rdap" or "whois".
+func UpstreamFailure(kind string) {
+	upstreamFailures.WithLabelValues(kind).Inc()
+}
+
+// UpstreamRetry records an upstream lookup retried after a transient failure,
+// "dns" or "whois". A high rate points at a flaky upstream.
+func UpstreamRetry(kind string) {
+	upstreamRetries.WithLabelValues(kind).Inc()
+}
+
+// SetWebRTCRooms sets the number of active WebRTC rooms.
+func SetWebRTCRooms(n int) {
+	webrtcRooms.Set(float64(n))
+}
+
+// WebRTCRoomCreated records a created WebRTC room. With the active rooms gauge
+// it shows rooms that aren't cleaned up.
+func WebRTCRoomCreated() {
+	webrtcRoomsCreated.Inc()
+}
+
+// WebRTCRoomDeleted records a deleted WebRTC room. Rooms reaped for having no
+// connections or outliving their lifetime point at abandoning clients.
+func WebRTCRoomDeleted(reason string) {
+	webrtcRoomsDeleted.WithLabelValues(reason).Inc()
+}
+
+// WebRTCStreamOpened records an opened WebRTC events or WebSocket stream.
+func WebRTCStreamOpened() {
+	webrtcStreams.Inc()
+}
+
+// WebRTCStreamClosed records a closed WebRTC events or WebSocket stream.
+func WebRTCStreamClosed() {
+	webrtcStreams.Dec()
+}
+
+// WebRTCStreamRejected records a WebRTC stream refused by a cap, "total" or
+// "per_ip".
+func WebRTCStreamRejected(limit string) {
+	webrtcStreamsRejected.WithLabelValues(limit).Inc()
+}
+
+// OutboundStarted records an outbound lookup taking a slot of the global
+// limit.
+func OutboundStarted() {
+	outboundInFlight.Inc()
+}
+
+// OutboundDone records an outbound lookup giving its slot back.
+func OutboundDone() {
+	outboundInFlight.Dec()
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware("metrics_test")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("missing") == "true" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	series := []string{
+		`bir_requests_total{status="200",tool="metrics_test"}`,
+		`bir_requests_total{status="404",tool="metrics_test"}`,
+		`bir_request_duration_seconds_count{tool="metrics_test"}`,
+	}
+	before := scrape(t)
+
+	for _, target := range []string{"/", "/", "/?missing=true"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	after := scrape(t)
+	for i, want := range []float64{2, 1, 3} {
+		if got := after[series[i]] - before[series[i]]; got != want {
+			t.Errorf("%s went up by %v, want %v", series[i], got, want)
+		}
+	}
+}
+
+// scrape returns the value of every series served by Handler.
+func scrape(t *testing.T) map[string]float64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ' ')
+		if value, err := strconv.ParseFloat(line[i+1:], 64); err == nil && i > 0 {
+			values[line[:i]] = value
+		}
+	}
+
+	return values
+}
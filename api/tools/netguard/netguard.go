@@ -0,0 +1,102 @@
+// Package netguard keeps the tools from connecting to internal addresses, so
+// they can't be used to probe the network the server runs in (SSRF).
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// ErrBlocked is returned for a target on a loopback, link-local, private or
+// unique-local address.
+var ErrBlocked = errors.New("target resolves to a private or internal address")
+
+// Guard checks outbound targets. A nil Guard allows every target.
+type Guard struct {
+	resolver *net.Resolver
+}
+
+// New returns a Guard when enabled, or nil, which allows every target.
+func New(enabled bool) *Guard {
+	if !enabled {
+		return nil
+	}
+
+	return &Guard{resolver: net.DefaultResolver}
+}
+
+// Blocked reports whether addr is an internal address.
+func Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsPrivate() ||
+		addr.IsUnspecified()
+}
+
+// Check resolves host, a hostname or IP literal, and returns ErrBlocked when
+// any of its addresses is internal, as the dial may use any of them.
+// Resolution errors are returned as is.
+func (g *Guard) Check(ctx context.Context, host string) error {
+	_, err := g.Resolve(ctx, host)
+
+	return err
+}
+
+// Resolve is Check returning the addresses of host, for a connection that
+// must go to one of them, e.g. through a proxy, where the dial can't be
+// checked. A nil Guard doesn't resolve and returns no addresses.
+func (g *Guard) Resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if g == nil {
+		return nil, nil
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if Blocked(addr) {
+			return nil, ErrBlocked
+		}
+		return []netip.Addr{addr}, nil
+	}
+
+	addrs, err := g.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if Blocked(addr) {
+			return nil, ErrBlocked
+		}
+	}
+
+	return addrs, nil
+}
+
+// Dialer sets a Control hook on d that refuses connections to internal
+// addresses. It runs on the resolved address right before connecting, so a
+// name that resolves differently than during Check is still caught. d is
+// returned unchanged for a nil Guard.
+func (g *Guard) Dialer(d *net.Dialer) *net.Dialer {
+	if g == nil {
+		return d
+	}
+
+	d.Control = func(_, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if Blocked(addrPort.Addr()) {
+			return ErrBlocked
+		}
+		return nil
+	}
+
+	return d
+}
@@ -0,0 +1,73 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestBlocked(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{addr: "127.0.0.1", blocked: true},
+		{addr: "::1", blocked: true},
+		{addr: "169.254.169.254", blocked: true},
+		{addr: "fe80::1", blocked: true},
+		{addr: "10.1.2.3", blocked: true},
+		{addr: "172.16.0.1", blocked: true},
+		{addr: "192.168.1.1", blocked: true},
+		{addr: "fd00::1", blocked: true},
+		{addr: "0.0.0.0", blocked: true},
+		{addr: "::ffff:127.0.0.1", blocked: true},
+		{addr: "1.1.1.1"},
+		{addr: "2606:4700:4700::1111"},
+		{addr: "172.32.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := Blocked(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+			t.Errorf("Blocked(%s) = %v, want %v", tt.addr, got, tt.blocked)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	g := New(true)
+	ctx := context.Background()
+
+	for _, host := range []string{"127.0.0.1", "169.254.169.254", "localhost"} {
+		if err := g.Check(ctx, host); !errors.Is(err, ErrBlocked) {
+			t.Errorf("Check(%q) = %v, want %v", host, err, ErrBlocked)
+		}
+	}
+	if err := g.Check(ctx, "1.1.1.1"); err != nil {
+		t.Errorf("Check(1.1.1.1) = %v", err)
+	}
+
+	// A disabled guard allows everything
+	if err := New(false).Check(ctx, "127.0.0.1"); err != nil {
+		t.Errorf("disabled Check(127.0.0.1) = %v", err)
+	}
+}
+
+func TestDialerRefusesInternalAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if _, err := New(true).Dialer(&net.Dialer{}).Dial("tcp", ln.Addr().String()); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("Dial() error = %v, want %v", err, ErrBlocked)
+	}
+
+	conn, err := New(false).Dialer(&net.Dialer{}).Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("disabled Dial() error = %v", err)
+	}
+	conn.Close()
+}
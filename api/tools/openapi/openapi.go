@@ -0,0 +1,302 @@
+// Package openapi builds an OpenAPI 3 document for the API. Response schemas
+// are derived from the Go response structs through their json tags, so they
+// follow the handlers without a hand-maintained spec.
+package openapi
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version of the generated document.
+const Version = "3.0.3"
+
+// Schema is a JSON schema object.
+type Schema = map[string]any
+
+// Param is a query, path or header parameter of an operation.
+type Param struct {
+	Name        string
+	In          string // "query" (default), "path" or "header"
+	Description string
+	Required    bool
+	// Type is the schema type; defaults to "string".
+	Type string
+	Enum []string
+}
+
+// Operation describes one endpoint.
+type Operation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	Params  []Param
+	// Request is a value of the JSON request body type, if any.
+	Request any
+	// Response is a value of the response type, e.g. dns.DNSResponse{}.
+	Response any
+	// ContentType is the media type of the response, application/json by
+	// default. For a stream such as text/event-stream, Response is the type
+	// of its messages.
+	ContentType string
+	// Status is the status of a successful response, 200 by default. A 101
+	// (protocol upgrade) response has no content.
+	Status int
+}
+
+// Spec describes the API.
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+	Operations  []Operation
+	// Overrides replaces the derived schema of types with a custom JSON
+	// encoding.
+	Overrides map[reflect.Type]Schema
+}
+
+// Build returns the OpenAPI document of s.
+func (s Spec) Build() map[string]any {
+	g := &generator{
+		overrides: s.Overrides,
+		schemas:   make(map[string]Schema),
+		names:     make(map[reflect.Type]string),
+	}
+
+	paths := make(map[string]map[string]any)
+	for _, op := range s.Operations {
+		params := make([]any, 0, len(op.Params))
+		for _, p := range op.Params {
+			params = append(params, p.object())
+		}
+
+		status := cmp.Or(op.Status, http.StatusOK)
+		response := map[string]any{"description": http.StatusText(status)}
+		if status != http.StatusSwitchingProtocols {
+			response["content"] = map[string]any{
+				cmp.Or(op.ContentType, "application/json"): map[string]any{"schema": g.schema(reflect.TypeOf(op.Response))},
+			}
+		}
+
+		operation := map[string]any{
+			"summary":   op.Summary,
+			"responses": map[string]any{strconv.Itoa(status): response},
+		}
+		if op.Tag != "" {
+			operation["tags"] = []string{op.Tag}
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = make(map[string]any)
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	info := map[string]any{
+		"title":   s.Title,
+		"version": s.Version,
+	}
+	if s.Description != "" {
+		info["description"] = s.Description
+	}
+
+	return map[string]any{
+		"openapi": Version,
+		"info":    info,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+		},
+	}
+}
+
+// Handler serves the document of s as JSON. The document is built once.
+func (s Spec) Handler() http.HandlerFunc {
+	doc, err := json.Marshal(s.Build())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, "failed to build OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(doc)
+	}
+}
+
+func (p Param) object() map[string]any {
+	in := p.In
+	if in == "" {
+		in = "query"
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+
+	schema := Schema{"type": typ}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+
+	obj := map[string]any{
+		"name":     p.Name,
+		"in":       in,
+		"required": p.Required || in == "path",
+		"schema":   schema,
+	}
+	if p.Description != "" {
+		obj["description"] = p.Description
+	}
+
+	return obj
+}
+
+var (
+	timeType    = reflect.TypeFor[time.Time]()
+	rawJSONType = reflect.TypeFor[json.RawMessage]()
+)
+
+// generator derives schemas from Go types, collecting named structs as
+// components.
+type generator struct {
+	overrides map[reflect.Type]Schema
+	schemas   map[string]Schema
+	names     map[reflect.Type]string
+}
+
+func (g *generator) schema(t reflect.Type) Schema {
+	if t == nil {
+		return Schema{}
+	}
+
+	if s, ok := g.overrides[t]; ok {
+		return s
+	}
+
+	switch t {
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case rawJSONType:
+		return Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64
+			return Schema{"type": "string", "format": "byte"}
+		}
+		return Schema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			// Reserve the name first so recursive types terminate
+			g.names[t] = name
+			g.schemas[name] = Schema{}
+			g.schemas[name] = g.object(t)
+		}
+		return Schema{"$ref": "#/components/schemas/" + name}
+	default:
+		return Schema{}
+	}
+}
+
+// object builds the schema of a struct from its exported fields' json tags.
+// Fields without omitempty are required.
+func (g *generator) object(t reflect.Type) Schema {
+	properties := make(map[string]any)
+	var required []string
+
+	for field := range t.Fields() {
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := g.object(derefType(field.Type))
+			for k, v := range embedded["properties"].(map[string]any) {
+				properties[k] = v
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			required = append(required, name)
+		}
+	}
+
+	s := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+
+	return s
+}
+
+// componentName returns the type name, qualified with its package (e.g.
+// ip.Response becomes ipResponse) when another tool already uses the name.
+func (g *generator) componentName(t reflect.Type) string {
+	if _, taken := g.schemas[t.Name()]; !taken {
+		return t.Name()
+	}
+
+	pkg := t.PkgPath()
+	return pkg[strings.LastIndex(pkg, "/")+1:] + t.Name()
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,114 @@
+package openapi
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testInner struct {
+	Value string `json:"value"`
+}
+
+type testResponse struct {
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	At       time.Time         `json:"at"`
+	Inner    *testInner        `json:"inner,omitempty"`
+	Items    []testInner       `json:"items"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Skipped  string            `json:"-"`
+	internal string
+}
+
+func TestBuildDerivesSchemas(t *testing.T) {
+	doc := Spec{
+		Title: "test",
+		Operations: []Operation{
+			{Method: "GET", Path: "/test", Response: testResponse{}},
+			{Method: "POST", Path: "/test", Request: testInner{}, Response: testResponse{}},
+		},
+	}.Build()
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]Schema)
+
+	response, ok := schemas["testResponse"]
+	if !ok {
+		t.Fatalf("testResponse schema missing, got %v", schemas)
+	}
+
+	properties := response["properties"].(map[string]any)
+	if _, ok := properties["-"]; ok || properties["Skipped"] != nil || properties["internal"] != nil {
+		t.Errorf("ignored fields are documented: %v", properties)
+	}
+
+	if got := properties["at"]; !reflect.DeepEqual(got, Schema{"type": "string", "format": "date-time"}) {
+		t.Errorf("at = %v, want date-time string", got)
+	}
+	if got := properties["inner"]; !reflect.DeepEqual(got, Schema{"$ref": "#/components/schemas/testInner"}) {
+		t.Errorf("inner = %v, want testInner ref", got)
+	}
+
+	required := response["required"].([]string)
+	slices.Sort(required)
+	if want := []string{"at", "items", "name"}; !slices.Equal(required, want) {
+		t.Errorf("required = %v, want %v", required, want)
+	}
+
+	if _, ok := doc["paths"].(map[string]map[string]any)["/test"]["get"]; !ok {
+		t.Error("GET /test operation missing")
+	}
+
+	post, ok := doc["paths"].(map[string]map[string]any)["/test"]["post"].(map[string]any)
+	if !ok {
+		t.Fatal("POST /test operation missing")
+	}
+	body := post["requestBody"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)
+	if got := body["schema"]; !reflect.DeepEqual(got, Schema{"$ref": "#/components/schemas/testInner"}) {
+		t.Errorf("request body = %v, want testInner ref", got)
+	}
+}
+
+func TestBuildOverrides(t *testing.T) {
+	override := Schema{"type": "string"}
+	doc := Spec{
+		Operations: []Operation{
+			{Method: "GET", Path: "/test", Response: testResponse{}},
+		},
+		Overrides: map[reflect.Type]Schema{reflect.TypeFor[testInner](): override},
+	}.Build()
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]Schema)
+	if _, ok := schemas["testInner"]; ok {
+		t.Error("overridden type was added as a component")
+	}
+
+	properties := schemas["testResponse"]["properties"].(map[string]any)
+	if got := properties["inner"]; !reflect.DeepEqual(got, override) {
+		t.Errorf("inner = %v, want %v", got, override)
+	}
+}
+
+func TestBuildStreams(t *testing.T) {
+	doc := Spec{
+		Operations: []Operation{
+			{Method: "GET", Path: "/events", Response: testInner{}, ContentType: "text/event-stream"},
+			{Method: "GET", Path: "/ws", Status: 101},
+		},
+	}.Build()
+	paths := doc["paths"].(map[string]map[string]any)
+
+	events := paths["/events"]["get"].(map[string]any)["responses"].(map[string]any)["200"].(map[string]any)
+	if _, ok := events["content"].(map[string]any)["text/event-stream"]; !ok {
+		t.Errorf("events response = %v, want text/event-stream content", events)
+	}
+
+	ws, ok := paths["/ws"]["get"].(map[string]any)["responses"].(map[string]any)["101"].(map[string]any)
+	if !ok {
+		t.Fatalf("ws responses = %v, want a 101", paths["/ws"]["get"])
+	}
+	if _, ok := ws["content"]; ok {
+		t.Errorf("101 response has content: %v", ws)
+	}
+}
@@ -0,0 +1,135 @@
+// Package outbound caps the lookups the tools run at once, across all of
+// them, so a load spike can't exhaust file descriptors and ephemeral ports.
+// Lookups wait briefly for a free slot and fail with ErrBusy when too many
+// are already waiting.
+package outbound
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+)
+
+// ErrBusy is returned by Acquire when no slot frees up in time, or the queue
+// of waiting lookups is full.
+var ErrBusy = errors.New("too many lookups in progress, try again later")
+
+// Config holds the outbound limits, loaded from env via chu.
+type Config struct {
+	// MaxConcurrent caps the lookups running at once; 0 disables the limit.
+	MaxConcurrent int `cfg:"max_concurrent" default:"256"`
+	// Queue lookups may wait up to MaxWait for a slot, the ones beyond it
+	// fail right away.
+	Queue   int           `cfg:"queue" default:"256"`
+	MaxWait time.Duration `cfg:"max_wait" default:"2s"`
+}
+
+// Limiter is a semaphore of outbound lookups. A nil Limiter allows every
+// lookup.
+type Limiter struct {
+	slots   chan struct{}
+	queue   int64
+	waiting atomic.Int64
+	maxWait time.Duration
+}
+
+// New returns a Limiter of cfg, or nil when cfg.MaxConcurrent isn't positive.
+func New(cfg Config) *Limiter {
+	if cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+		queue:   int64(max(cfg.Queue, 0)),
+		maxWait: cfg.MaxWait,
+	}
+}
+
+// Acquire takes a slot, waiting at most the configured time, and returns the
+// func that gives it back. It fails with ErrBusy, also when ctx ends first.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.acquired(), nil
+	default:
+	}
+
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		return nil, ErrBusy
+	}
+	defer l.waiting.Add(-1)
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.acquired(), nil
+	case <-timer.C:
+		return nil, ErrBusy
+	case <-ctx.Done():
+		return nil, ErrBusy
+	}
+}
+
+// acquired records a taken slot and returns its release func.
+func (l *Limiter) acquired() func() {
+	metrics.OutboundStarted()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.slots
+			metrics.OutboundDone()
+		})
+	}
+}
+
+// retryAfter is a hint of when a rejected lookup may succeed.
+func (l *Limiter) retryAfter() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	return l.maxWait
+}
+
+var global atomic.Pointer[Limiter]
+
+// SetDefault makes l the limiter of Acquire. Lookups aren't limited until it
+// is called.
+func SetDefault(l *Limiter) {
+	global.Store(l)
+}
+
+// Acquire takes a slot of the default limiter, see Limiter.Acquire.
+func Acquire(ctx context.Context) (func(), error) {
+	return global.Load().Acquire(ctx)
+}
+
+// Status returns the status of a lookup rejected with err: 503 with a
+// Retry-After header set on w for ErrBusy, else 400 as the lookups only
+// return errors for invalid input.
+func Status(w http.ResponseWriter, err error) int {
+	if !errors.Is(err, ErrBusy) {
+		return http.StatusBadRequest
+	}
+
+	retryAfter := max(global.Load().retryAfter(), time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	return http.StatusServiceUnavailable
+}
@@ -0,0 +1,67 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Config{MaxConcurrent: 1, Queue: 1, MaxWait: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// One lookup waits for the slot, the next finds the queue full
+	waited := make(chan error, 1)
+	go func() {
+		release, err := l.Acquire(ctx)
+		if err == nil {
+			release()
+		}
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrBusy) {
+		t.Fatalf("Acquire() with a full queue: err = %v, want %v", err, ErrBusy)
+	}
+
+	release()
+	release() // Released once however often it is called
+	if err := <-waited; err != nil {
+		t.Fatalf("queued Acquire() error = %v", err)
+	}
+
+	// A slot that isn't given back in time fails the wait
+	release, _ = l.Acquire(ctx)
+	defer release()
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrBusy) {
+		t.Fatalf("Acquire() past MaxWait: err = %v, want %v", err, ErrBusy)
+	}
+
+	if New(Config{}) != nil {
+		t.Fatal("New() without MaxConcurrent should disable the limit")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	SetDefault(New(Config{MaxConcurrent: 1, MaxWait: 1500 * time.Millisecond}))
+	defer SetDefault(nil)
+
+	rec := httptest.NewRecorder()
+	if status := Status(rec, ErrBusy); status != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("Status(ErrBusy) = %d, Retry-After %q", status, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	if status := Status(rec, errors.New("invalid domain format")); status != http.StatusBadRequest || rec.Header().Get("Retry-After") != "" {
+		t.Fatalf("Status(invalid input) = %d, Retry-After %q", status, rec.Header().Get("Retry-After"))
+	}
+}
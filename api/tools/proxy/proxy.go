@@ -0,0 +1,306 @@
+// Package proxy sends the outbound connections of the tools through an
+// upstream HTTP or SOCKS5 proxy, for deployments whose traffic must leave
+// through one. The TLS connections of the SSL tool are tunneled with CONNECT
+// (or SOCKS5) to the real host, so the handshake and certificates are still
+// its own, and so are the DoH queries; the other HTTP lookups, such as CT or
+// RDAP, use the proxy like any HTTP client.
+package proxy
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpproxy"
+	netproxy "golang.org/x/net/proxy"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+// ErrFamily is returned when a connection through the proxy asks for an
+// address family: the proxy resolves the host itself.
+var ErrFamily = errors.New("the IP version can't be chosen through the proxy")
+
+// Config holds the proxy configuration, loaded from env via chu.
+type Config struct {
+	// URL is the proxy, http://, https:// or socks5://host:port, with
+	// user:password@ when it requires them. Empty uses HTTPS_PROXY, if set.
+	URL string `cfg:"url"`
+	// NoProxy lists the hosts reached directly: domains, also matching their
+	// subdomains, IPs and CIDR ranges, as in NO_PROXY. Empty uses NO_PROXY.
+	NoProxy []string `cfg:"no_proxy"`
+}
+
+// Proxy picks the proxy of a target. A nil Proxy connects directly.
+type Proxy struct {
+	proxyFunc func(*url.URL) (*url.URL, error)
+}
+
+// New returns the Proxy of cfg, falling back to the environment, or nil when
+// neither sets one.
+func New(cfg Config) (*Proxy, error) {
+	env := httpproxy.FromEnvironment()
+
+	raw := cmp.Or(cfg.URL, env.HTTPSProxy)
+	if raw == "" {
+		return nil, nil
+	}
+
+	u, err := parseURL(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	noProxy := env.NoProxy
+	if len(cfg.NoProxy) > 0 {
+		noProxy = strings.Join(cfg.NoProxy, ",")
+	}
+
+	pc := httpproxy.Config{HTTPProxy: u.String(), HTTPSProxy: u.String(), NoProxy: noProxy}
+
+	return &Proxy{proxyFunc: pc.ProxyFunc()}, nil
+}
+
+// parseURL parses a proxy URL, http:// when it has no scheme.
+func parseURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", raw)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		port := "80"
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return u, nil
+}
+
+// For returns the proxy to reach target through, nil to connect directly.
+func (p *Proxy) For(target *url.URL) (*url.URL, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	return p.proxyFunc(target)
+}
+
+// DialContext connects to address through the proxy, or directly with d
+// when it is to be reached directly. A non-nil guard refuses internal
+// addresses: on the dial when direct, otherwise by resolving the host first
+// and tunneling to the checked address, as the proxy may well reach internal
+// hosts. The tunneled connection reports address as its remote address, the
+// proxy's being meaningless to the caller.
+func (p *Proxy) DialContext(ctx context.Context, d *net.Dialer, guard *netguard.Guard, network, address string) (net.Conn, error) {
+	proxyURL, err := p.For(&url.URL{Scheme: "https", Host: address})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return guard.Dialer(d).DialContext(ctx, network, address)
+	}
+	if network != "tcp" {
+		return nil, ErrFamily
+	}
+
+	target, err := checkedTarget(ctx, guard, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The proxy is trusted configuration: not held to the guard
+	forward := &net.Dialer{Timeout: d.Timeout}
+
+	var conn net.Conn
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		conn, err = dialSOCKS5(ctx, forward, proxyURL, target)
+	default:
+		conn, err = dialCONNECT(ctx, forward, proxyURL, target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Host, err)
+	}
+
+	return &tunnelConn{Conn: conn, remote: tunnelAddr(address)}, nil
+}
+
+// checkedTarget returns the address to tunnel to: address, or with a guard
+// the first address its host resolves to once they are all checked, so a
+// rebound name can't lead the proxy to an internal one.
+func checkedTarget(ctx context.Context, guard *netguard.Guard, address string) (string, error) {
+	if guard == nil {
+		return address, nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := guard.Resolve(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address found for %s", host)
+	}
+
+	return net.JoinHostPort(addrs[0].Unmap().String(), port), nil
+}
+
+func dialSOCKS5(ctx context.Context, forward *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	var auth *netproxy.Auth
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		auth = &netproxy.Auth{User: user.Username(), Password: password}
+	}
+
+	dialer, err := netproxy.SOCKS5("tcp", proxyURL.Host, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialer.(netproxy.ContextDialer).DialContext(ctx, "tcp", address)
+}
+
+// dialCONNECT opens a tunnel to address with an HTTP CONNECT request, over
+// TLS for an https proxy.
+func dialCONNECT(ctx context.Context, forward *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	conn, err := forward.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// The exchange doesn't take a context; unblock it when ctx ends
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, cmp.Or(ctx.Err(), err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, cmp.Or(ctx.Err(), err)
+	}
+	// The body of a successful CONNECT is the tunnel
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: %s", address, resp.Status)
+	}
+
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+
+	// A server speaking first, e.g. an SMTP greeting before STARTTLS, may
+	// already be buffered
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// tunnelConn is a connection through the proxy, addressed as its target.
+type tunnelConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *tunnelConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// tunnelAddr is the host:port target of a tunnel.
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "tcp" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+// bufferedConn reads what the CONNECT response reader buffered first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+var global atomic.Pointer[Proxy]
+
+// SetDefault makes p the proxy of DialContext and Transport. Connections are
+// direct until it is called.
+func SetDefault(p *Proxy) {
+	global.Store(p)
+}
+
+// DialContext connects to address with the default proxy, see
+// Proxy.DialContext.
+func DialContext(ctx context.Context, d *net.Dialer, guard *netguard.Guard, network, address string) (net.Conn, error) {
+	return global.Load().DialContext(ctx, d, guard, network, address)
+}
+
+// Transport returns the HTTP transport of the lookups, sending requests
+// through the default proxy as of when they are made.
+var Transport = sync.OnceValue(func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return global.Load().For(req.URL)
+	}
+
+	return transport
+})
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+func TestDialCONNECT(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+	)
+	// A CONNECT proxy resolving target.test to the test server
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+
+		if r.Method != http.MethodConnect || r.Host != "target.test:443" {
+			http.Error(w, "unexpected request", http.StatusBadGateway)
+			return
+		}
+
+		backend, err := net.Dial("tcp", target.Listener.Addr().String())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer backend.Close()
+
+		w.WriteHeader(http.StatusOK)
+		client, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+
+		go func() { _, _ = io.Copy(backend, client) }()
+		_, _ = io.Copy(client, backend)
+	}))
+	defer upstream.Close()
+
+	p, err := New(Config{URL: "http://user:secret@" + upstream.Listener.Addr().String()})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	conn, err := p.DialContext(context.Background(), &net.Dialer{}, nil, "tcp", "target.test:443")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.RemoteAddr().String(); got != "target.test:443" {
+		t.Fatalf("RemoteAddr() = %q, want the target", got)
+	}
+
+	// The handshake is the target's own, through the tunnel
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("Handshake() through the tunnel error = %v", err)
+	}
+	if got := tlsConn.ConnectionState().PeerCertificates[0]; !got.Equal(target.Certificate()) {
+		t.Fatalf("certificate through the tunnel = %s, want the target's", got.Subject)
+	}
+
+	mu.Lock()
+	authorized := len(requests) == 1 && requests[0].Header.Get("Proxy-Authorization") == "Basic dXNlcjpzZWNyZXQ="
+	mu.Unlock()
+	if !authorized {
+		t.Fatal("the proxy didn't get one authenticated CONNECT")
+	}
+
+	if _, err := p.DialContext(context.Background(), &net.Dialer{}, nil, "tcp4", "target.test:443"); !errors.Is(err, ErrFamily) {
+		t.Fatalf("DialContext(tcp4) error = %v, want %v", err, ErrFamily)
+	}
+	if _, err := p.DialContext(context.Background(), &net.Dialer{}, nil, "tcp", "other.test:443"); err == nil {
+		t.Fatal("DialContext() succeeded though the proxy refused the tunnel")
+	}
+
+	// With a guard the target is checked before the proxy is asked, the
+	// proxy reaching what the guard would refuse
+	guard := netguard.New(true)
+	for _, address := range []string{"127.0.0.1:443", "localhost:443"} {
+		if _, err := p.DialContext(context.Background(), &net.Dialer{}, guard, "tcp", address); !errors.Is(err, netguard.ErrBlocked) {
+			t.Fatalf("DialContext(%s) through the guard error = %v, want %v", address, err, netguard.ErrBlocked)
+		}
+	}
+	mu.Lock()
+	n := len(requests)
+	mu.Unlock()
+	if n != 2 {
+		t.Fatalf("the proxy got %d requests, want none for the blocked targets", n-2)
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	p, err := New(Config{URL: "socks5://proxy.internal", NoProxy: []string{"corp.example", "10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "example.com:443", want: "socks5://proxy.internal:1080"},
+		{host: "corp.example:443"},
+		{host: "api.corp.example:443"},
+		{host: "10.1.2.3:443"},
+	}
+	for _, tt := range tests {
+		got, err := p.For(&url.URL{Scheme: "https", Host: tt.host})
+		if err != nil {
+			t.Fatalf("For(%s) error = %v", tt.host, err)
+		}
+		if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("For(%s) = %v, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"ftp://proxy.example", "http://"} {
+		if _, err := New(Config{URL: raw}); err == nil {
+			t.Errorf("New(%q) accepted an invalid proxy", raw)
+		}
+	}
+
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+	if p, err := New(Config{}); p != nil || err != nil {
+		t.Fatalf("New() without a proxy = %v, %v", p, err)
+	}
+}
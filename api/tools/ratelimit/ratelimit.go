@@ -0,0 +1,164 @@
+// Package ratelimit throttles clients with a token bucket per client IP, so
+// the tools can't be used to hammer third parties through the server.
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/rytsh/bir/api/tools/ip"
+)
+
+// cleanupInterval is how often idle client buckets are dropped.
+const cleanupInterval = time.Minute
+
+// Limit is a token bucket: Rate requests per second with bursts of up to
+// Burst requests. A zero Rate disables the limit.
+type Limit struct {
+	Rate  float64 `cfg:"rate"`
+	Burst int     `cfg:"burst"`
+}
+
+// Config holds the rate limits, loaded from env via chu. Global applies to
+// every route; the others apply on top of it to the matching tool.
+type Config struct {
+	Enabled bool  `cfg:"enabled" default:"true"`
+	Global  Limit `cfg:"global"`
+	DNS     Limit `cfg:"dns"`
+	SSL     Limit `cfg:"ssl"`
+	Whois   Limit `cfg:"whois"`
+	// IdleTTL is how long a client's bucket is kept after its last request.
+	IdleTTL time.Duration `cfg:"idle_ttl" default:"10m"`
+	// MaxClients caps the tracked clients per limit; the least recently seen
+	// client is dropped first.
+	MaxClients int `cfg:"max_clients" default:"100000"`
+}
+
+// Middleware returns a middleware enforcing limit per client IP, the
+// connection's unless it comes through a trusted proxy (see
+// ip.TrustedClientIP). Idle client
+// buckets are cleaned up until ctx is done. When rate limiting is disabled or
+// limit has no rate, the middleware passes requests through.
+func (c Config) Middleware(ctx context.Context, limit Limit) func(http.Handler) http.Handler {
+	if !c.Enabled || limit.Rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	l := newLimiter(limit, c.IdleTTL, c.MaxClients)
+	go l.cleanupLoop(ctx)
+
+	return l.middleware
+}
+
+// limiter keeps a token bucket per client.
+type limiter struct {
+	limit      Limit
+	idleTTL    time.Duration
+	maxClients int
+	// order holds *client values, most recently seen first.
+	order   *list.List
+	clients map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type client struct {
+	key      string
+	bucket   *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLimiter(limit Limit, idleTTL time.Duration, maxClients int) *limiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	return &limiter{
+		limit:      limit,
+		idleTTL:    idleTTL,
+		maxClients: maxClients,
+		order:      list.New(),
+		clients:    make(map[string]*list.Element),
+	}
+}
+
+func (l *limiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.reserve(ip.TrustedClientIP(r), time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"error": "rate limit exceeded"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reserve takes a token for key, returning how long to wait before retrying
+// when none is available.
+func (l *limiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	var c *client
+	if elem, ok := l.clients[key]; ok {
+		c = elem.Value.(*client)
+		l.order.MoveToFront(elem)
+	} else {
+		// The least recently seen client is the one dropped, idle or not
+		if l.maxClients > 0 && l.order.Len() >= l.maxClients {
+			l.removeLocked(l.order.Back())
+		}
+
+		c = &client{key: key, bucket: rate.NewLimiter(rate.Limit(l.limit.Rate), l.limit.Burst)}
+		l.clients[key] = l.order.PushFront(c)
+	}
+	c.lastSeen = now
+	l.mu.Unlock()
+
+	res := c.bucket.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		// Don't consume the token of a rejected request
+		res.CancelAt(now)
+		return delay
+	}
+
+	return 0
+}
+
+// cleanupLoop drops idle clients until ctx is done.
+func (l *limiter) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			l.cleanupLocked(now)
+			l.mu.Unlock()
+		}
+	}
+}
+
+// cleanupLocked drops the clients idle for longer than idleTTL, the least
+// recently seen at the back.
+func (l *limiter) cleanupLocked(now time.Time) {
+	for elem := l.order.Back(); elem != nil && now.Sub(elem.Value.(*client).lastSeen) > l.idleTTL; elem = l.order.Back() {
+		l.removeLocked(elem)
+	}
+}
+
+func (l *limiter) removeLocked(elem *list.Element) {
+	l.order.Remove(elem)
+	delete(l.clients, elem.Value.(*client).key)
+}
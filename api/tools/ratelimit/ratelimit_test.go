@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRejectsOverLimit(t *testing.T) {
+	cfg := Config{Enabled: true, IdleTTL: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := cfg.Middleware(ctx, Limit{Rate: 1, Burst: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var forged int
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ssl", nil)
+		req.RemoteAddr = remoteAddr
+		// Not from a trusted proxy: no fresh bucket for a forged header
+		forged++
+		req.Header.Set("X-Forwarded-For", "203.0.113."+strconv.Itoa(forged))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 2 {
+		if rec := request("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := request("192.0.2.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	// Other clients have their own bucket
+	if rec := request("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestLimiterBoundsClients(t *testing.T) {
+	l := newLimiter(Limit{Rate: 1, Burst: 1}, time.Minute, 2)
+	now := time.Now()
+
+	l.reserve("a", now)
+	l.reserve("b", now.Add(time.Second))
+	l.reserve("c", now.Add(2*time.Second))
+
+	if len(l.clients) != 2 {
+		t.Fatalf("clients = %d, want 2", len(l.clients))
+	}
+	if _, ok := l.clients["a"]; ok {
+		t.Error("least recently seen client was not evicted")
+	}
+
+	l.cleanupLocked(now.Add(2 * time.Minute))
+	if len(l.clients) != 0 {
+		t.Errorf("clients after cleanup = %d, want 0", len(l.clients))
+	}
+}
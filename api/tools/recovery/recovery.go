@@ -0,0 +1,60 @@
+// Package recovery turns handler panics into JSON 500 responses instead of
+// dropped connections.
+package recovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/rakunlabs/logi"
+
+	"github.com/rytsh/bir/api/tools/response"
+)
+
+// CodeInternal is the code of the response sent for a panic.
+const CodeInternal = "INTERNAL"
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// Middleware recovers from a panic in next, logging it with its stack on the
+// request's logger. When nothing was written yet the client gets a JSON 500;
+// a response already under way, such as an event stream, can't be amended and
+// is aborted.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := response.NewWriter(w)
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Deliberate aborts, e.g. by httputil.ReverseProxy
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			logi.Ctx(r.Context()).Error("handler panic",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()),
+			)
+
+			if tw.Started() {
+				panic(http.ErrAbortHandler)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: "internal error", Code: CodeInternal})
+		}()
+
+		next.ServeHTTP(tw, r)
+	})
+}
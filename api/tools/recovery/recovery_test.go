@@ -0,0 +1,39 @@
+package recovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") == "true" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("event: connected\ndata: {}\n\n"))
+		}
+
+		var m map[string]int
+		m["boom"]++
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ssl", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got, want := rec.Body.String(), `{"error":"internal error","code":"INTERNAL"}`+"\n"; got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+
+	// A started stream is aborted rather than amended
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("panic = %v, want %v", v, http.ErrAbortHandler)
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/webrtc/room/X/events?stream=true", nil))
+	t.Fatal("started stream not aborted")
+}
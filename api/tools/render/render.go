@@ -0,0 +1,180 @@
+// Package render writes the tool responses in the format the caller asks for:
+// JSON by default, YAML or a grep-friendly key/value text layout. The format
+// comes from the format query parameter, or else the Accept header.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/rakunlabs/ada"
+)
+
+// Response formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatText = "text"
+)
+
+// mediaTypes maps the Accept media types to the formats they select.
+var mediaTypes = map[string]string{
+	"application/json":   FormatJSON,
+	"application/yaml":   FormatYAML,
+	"application/x-yaml": FormatYAML,
+	"text/yaml":          FormatYAML,
+	"text/x-yaml":        FormatYAML,
+	"text/plain":         FormatText,
+}
+
+// contentTypes are the Content-Type headers of the formats.
+var contentTypes = map[string]string{
+	FormatJSON: "application/json; charset=UTF-8",
+	FormatYAML: "application/yaml; charset=UTF-8",
+	FormatText: "text/plain; charset=UTF-8",
+}
+
+// IsFormat reports whether name is a response format, for the tools whose
+// format parameter also takes other values.
+func IsFormat(name string) bool {
+	_, ok := contentTypes[name]
+	return ok
+}
+
+// Send writes v with status in the format r asks for. Responses are encoded
+// to JSON first, so YAML and text follow the JSON field names and order.
+func Send(c *ada.Context, status int, v any) error {
+	format := Negotiate(c.Request)
+
+	c.Response.Header().Add("Vary", "Accept")
+	if format == FormatJSON {
+		return c.SetStatus(status).SendJSON(v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch format {
+	case FormatYAML:
+		out, err = yaml.JSONToYAML(data)
+	case FormatText:
+		out, err = toText(data)
+	}
+	if err != nil {
+		return fmt.Errorf("render %s: %w", format, err)
+	}
+
+	c.Response.Header().Set("Content-Type", contentTypes[format])
+	c.Response.WriteHeader(status)
+	_, err = c.Response.Write(out)
+
+	return err
+}
+
+// Negotiate returns the format of r: the format query parameter when it
+// names one, else the Accept media type of the highest quality, else JSON.
+func Negotiate(r *http.Request) string {
+	if format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); IsFormat(format) {
+		return format
+	}
+
+	format, best := FormatJSON, 0.0
+	for _, value := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+
+		accepted, ok := mediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		if quality > best {
+			format, best = accepted, quality
+		}
+	}
+
+	return format
+}
+
+// toText flattens the JSON data into one "key<TAB>value" line per value,
+// keys being the dotted path to it, e.g. records.0.value.
+func toText(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var out bytes.Buffer
+	if err := writeText(&out, decoder, ""); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// writeText writes the next value of decoder under key.
+func writeText(w io.Writer, decoder *json.Decoder, key string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		n := 0
+		for ; decoder.More(); n++ {
+			child := strconv.Itoa(n)
+			if token == '{' {
+				name, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				child = name.(string)
+			}
+
+			if key != "" {
+				child = key + "." + child
+			}
+			if err := writeText(w, decoder, child); err != nil {
+				return err
+			}
+		}
+
+		// closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+
+		// empty arrays and objects still show up
+		if n == 0 && key != "" {
+			_, err = fmt.Fprintf(w, "%s\t%s\n", key, map[json.Delim]string{'[': "[]", '{': "{}"}[token])
+		}
+		return err
+	case string:
+		// multi-line values, such as raw WHOIS text, stay on their line
+		if strings.ContainsFunc(token, func(r rune) bool { return r < ' ' }) {
+			token = strconv.Quote(token)
+		}
+		_, err = fmt.Fprintf(w, "%s\t%s\n", key, token)
+	case nil:
+		_, err = fmt.Fprintf(w, "%s\t\n", key)
+	default:
+		_, err = fmt.Fprintf(w, "%s\t%v\n", key, token)
+	}
+
+	return err
+}
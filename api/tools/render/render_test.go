@@ -0,0 +1,85 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rakunlabs/ada"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		target string
+		accept string
+		want   string
+	}{
+		{target: "/", want: FormatJSON},
+		{target: "/", accept: "*/*", want: FormatJSON},
+		{target: "/", accept: "application/yaml", want: FormatYAML},
+		{target: "/", accept: "text/plain, application/json;q=0.9", want: FormatText},
+		{target: "/", accept: "text/plain;q=0.5, application/x-yaml", want: FormatYAML},
+		{target: "/", accept: "text/html,application/xhtml+xml,*/*;q=0.8", want: FormatJSON},
+		{target: "/?format=text", accept: "application/yaml", want: FormatText},
+		{target: "/?format=simple", accept: "application/yaml", want: FormatYAML},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+
+		if got := Negotiate(req); got != tt.want {
+			t.Errorf("Negotiate(%s, Accept %q) = %s, want %s", tt.target, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	type record struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	v := struct {
+		Domain  string   `json:"domain"`
+		Records []record `json:"records"`
+		Names   []string `json:"names"`
+		Raw     string   `json:"raw,omitempty"`
+	}{
+		Domain:  "example.com",
+		Records: []record{{Type: "A", Value: "192.0.2.1"}},
+		Names:   []string{},
+		Raw:     "line 1\nline 2",
+	}
+
+	tests := map[string]struct {
+		contentType string
+		body        string
+	}{
+		FormatYAML: {
+			contentType: "application/yaml; charset=UTF-8",
+			body:        "domain: example.com\nrecords:\n- type: A\n  value: 192.0.2.1\nnames: []\nraw: |-\n  line 1\n  line 2\n",
+		},
+		FormatText: {
+			contentType: "text/plain; charset=UTF-8",
+			body:        "domain\texample.com\nrecords.0.type\tA\nrecords.0.value\t192.0.2.1\nnames\t[]\nraw\t\"line 1\\nline 2\"\n",
+		},
+	}
+
+	for format, want := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/?format="+format, nil)
+
+		if err := Send(ada.NewContext(rec, req), http.StatusTeapot, v); err != nil {
+			t.Fatalf("Send %s: %v", format, err)
+		}
+
+		if rec.Code != http.StatusTeapot || rec.Header().Get("Content-Type") != want.contentType {
+			t.Errorf("%s: status %d, Content-Type %q; want %d, %q", format, rec.Code, rec.Header().Get("Content-Type"), http.StatusTeapot, want.contentType)
+		}
+		if rec.Body.String() != want.body {
+			t.Errorf("%s body:\n%s\nwant:\n%s", format, rec.Body.String(), want.body)
+		}
+	}
+}
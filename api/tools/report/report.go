@@ -0,0 +1,202 @@
+// Package report stores snapshots of lookup results so they can be shared as
+// permalinks. Calling a tool endpoint with share=true saves its JSON result
+// under a short ID, served unchanged by GET /report/{id} until it expires.
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rakunlabs/ada"
+)
+
+// Config holds the report storage configuration, loaded from env via chu.
+type Config struct {
+	// TTL is how long a shared report stays available.
+	TTL time.Duration `cfg:"ttl" default:"168h"`
+	// MaxReports caps the in-memory store; the oldest report is evicted first.
+	MaxReports int `cfg:"max_reports" default:"10000"`
+}
+
+// Report is an immutable snapshot of a lookup result.
+type Report struct {
+	ID        string          `json:"id"`
+	Tool      string          `json:"tool"`
+	Query     string          `json:"query"`
+	CreatedAt time.Time       `json:"createdAt"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+	Result    json.RawMessage `json:"result"`
+}
+
+// Link is added to a shared lookup response to point at its report.
+type Link struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler serves stored reports and saves new ones.
+type Handler struct {
+	store Store
+	ttl   time.Duration
+}
+
+// New builds a report Handler. A nil store uses an in-memory store.
+func New(cfg Config, store Store) *Handler {
+	if store == nil {
+		store = NewMemoryStore(cfg.MaxReports)
+	}
+
+	return &Handler{
+		store: store,
+		ttl:   cfg.TTL,
+	}
+}
+
+// Start runs the cleanup loop of the store, if it has one, until ctx is done.
+func (h *Handler) Start(ctx context.Context) {
+	if s, ok := h.store.(interface{ Start(context.Context) }); ok {
+		s.Start(ctx)
+	}
+}
+
+// Get handles GET /report/{id}.
+func (h *Handler) Get(c *ada.Context) error {
+	id := strings.ToLower(c.Request.PathValue("id"))
+
+	r, err := h.store.Get(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return c.SetStatus(http.StatusNotFound).SendJSON(errorResponse{Error: "report not found or expired"})
+		}
+		return c.SetStatus(http.StatusInternalServerError).SendJSON(errorResponse{Error: "failed to load report"})
+	}
+
+	return c.SetStatus(http.StatusOK).SendJSON(r)
+}
+
+// Middleware saves the response of a tool endpoint as a report when the
+// request has share=true, and adds a "report" link to the returned JSON.
+// Only successful responses are saved.
+func (h *Handler) Middleware(tool string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("share") != "true" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &recorder{header: w.Header(), status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			body := rec.body.Bytes()
+			if rec.status == http.StatusOK && json.Valid(body) {
+				query.Del("share")
+				if link, err := h.save(r, tool, query.Encode(), body); err != nil {
+					slog.Error("failed to save report", "tool", tool, "error", err)
+				} else {
+					body = withLink(body, link)
+				}
+			}
+
+			w.Header().Del("Content-Length")
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(body)
+		})
+	}
+}
+
+func (h *Handler) save(r *http.Request, tool, query string, result []byte) (Link, error) {
+	id, err := newID()
+	if err != nil {
+		return Link{}, err
+	}
+
+	now := time.Now().UTC()
+	report := Report{
+		ID:        id,
+		Tool:      tool,
+		Query:     query,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.ttl),
+		Result:    bytes.Clone(result),
+	}
+
+	if err := h.store.Save(r.Context(), report); err != nil {
+		return Link{}, err
+	}
+
+	return Link{
+		ID:        id,
+		URL:       "/report/" + id,
+		ExpiresAt: report.ExpiresAt,
+	}, nil
+}
+
+// withLink adds a "report" field to a JSON object body. Other bodies are
+// returned unchanged.
+func withLink(body []byte, link Link) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' {
+		return body
+	}
+
+	encoded, err := json.Marshal(link)
+	if err != nil {
+		return body
+	}
+
+	var out bytes.Buffer
+	out.Write(trimmed[:len(trimmed)-1])
+	if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+		out.WriteByte(',')
+	}
+	out.WriteString(`"report":`)
+	out.Write(encoded)
+	out.WriteString("}\n")
+
+	return out.Bytes()
+}
+
+// newID returns a short random, URL-safe report ID.
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate report id: %w", err)
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
+
+// recorder buffers a response so it can be saved before being written.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
@@ -0,0 +1,87 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rakunlabs/ada"
+)
+
+func TestShareAndGet(t *testing.T) {
+	h := New(Config{TTL: time.Hour}, nil)
+
+	server := ada.New()
+	server.GET("/dns", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"domain":"example.com"}`))
+	}, h.Middleware("dns"))
+	server.GET("/report/{id}", server.Wrap(h.Get))
+
+	// Without share=true nothing is stored
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dns?domain=example.com", nil))
+	if rec.Body.String() != `{"domain":"example.com"}` {
+		t.Fatalf("body = %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dns?domain=example.com&share=true", nil))
+
+	var shared struct {
+		Domain string `json:"domain"`
+		Report Link   `json:"report"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &shared); err != nil {
+		t.Fatalf("decode shared response %s: %v", rec.Body, err)
+	}
+	if shared.Domain != "example.com" || shared.Report.ID == "" {
+		t.Fatalf("shared response = %+v", shared)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, shared.Report.URL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get report status = %d", rec.Code)
+	}
+
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if report.Tool != "dns" || report.Query != "domain=example.com" || string(report.Result) != `{"domain":"example.com"}` {
+		t.Fatalf("report = %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/report/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing report status = %d, want 404", rec.Code)
+	}
+}
+
+func TestMemoryStoreExpired(t *testing.T) {
+	s := NewMemoryStore(0)
+	ctx := context.Background()
+
+	now := time.Now()
+	_ = s.Save(ctx, Report{ID: "old", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)})
+	_ = s.Save(ctx, Report{ID: "new", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+
+	if _, err := s.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get expired report: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, "new"); err != nil {
+		t.Fatalf("get report: %v", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.reports["old"]; ok || len(s.reports) != 1 {
+		t.Fatalf("reports after get = %v, want only the unexpired one", s.reports)
+	}
+}
@@ -0,0 +1,125 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when a report doesn't exist or expired.
+var ErrNotFound = errors.New("report not found")
+
+// Store persists reports. The in-memory store is the default; other backends
+// only need to implement this interface.
+type Store interface {
+	Save(ctx context.Context, r Report) error
+	Get(ctx context.Context, id string) (Report, error)
+}
+
+// cleanupInterval is how often the memory store drops expired reports.
+const cleanupInterval = time.Minute
+
+// MemoryStore keeps reports in memory until they expire. Expired reports are
+// dropped on access, when the store is full, and by the loop Start runs.
+type MemoryStore struct {
+	reports    map[string]Report
+	maxReports int
+	mu         sync.RWMutex
+}
+
+// NewMemoryStore returns an in-memory store holding at most maxReports
+// reports; 0 means unlimited.
+func NewMemoryStore(maxReports int) *MemoryStore {
+	return &MemoryStore{
+		reports:    make(map[string]Report),
+		maxReports: maxReports,
+	}
+}
+
+func (s *MemoryStore) Save(_ context.Context, r Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxReports > 0 && len(s.reports) >= s.maxReports {
+		s.cleanupLocked(time.Now())
+		if len(s.reports) >= s.maxReports {
+			s.evictOldestLocked()
+		}
+	}
+
+	s.reports[r.ID] = r
+
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (Report, error) {
+	s.mu.RLock()
+	r, ok := s.reports[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return Report{}, ErrNotFound
+	}
+
+	if now := time.Now(); now.After(r.ExpiresAt) {
+		s.mu.Lock()
+		// it may have been saved again since
+		if r, ok := s.reports[id]; ok && now.After(r.ExpiresAt) {
+			delete(s.reports, id)
+		}
+		s.mu.Unlock()
+
+		return Report{}, ErrNotFound
+	}
+
+	return r, nil
+}
+
+// Start runs the loop removing expired reports until ctx is done.
+func (s *MemoryStore) Start(ctx context.Context) {
+	go s.cleanupLoop(ctx)
+}
+
+func (s *MemoryStore) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.Cleanup()
+	}
+}
+
+// Cleanup removes expired reports.
+func (s *MemoryStore) Cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanupLocked(time.Now())
+}
+
+func (s *MemoryStore) cleanupLocked(now time.Time) {
+	for id, r := range s.reports {
+		if now.After(r.ExpiresAt) {
+			delete(s.reports, id)
+		}
+	}
+}
+
+func (s *MemoryStore) evictOldestLocked() {
+	var oldestID string
+	var oldest time.Time
+	for id, r := range s.reports {
+		if oldestID == "" || r.CreatedAt.Before(oldest) {
+			oldestID, oldest = id, r.CreatedAt
+		}
+	}
+
+	delete(s.reports, oldestID)
+}
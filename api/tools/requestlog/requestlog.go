@@ -0,0 +1,87 @@
+// Package requestlog tags every request with an ID and logs it on completion.
+// The ID is attached to the request's logger (see logi.Ctx), so log lines
+// written while handling a request can be correlated with it.
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/rakunlabs/logi"
+
+	"github.com/rytsh/bir/api/tools/response"
+)
+
+// Header carries the request ID, inbound and outbound.
+const Header = "X-Request-ID"
+
+// maxIDLength caps an inbound request ID; longer ones are replaced.
+const maxIDLength = 128
+
+// loggedKey marks a request context as already handled by the middleware.
+type loggedKey struct{}
+
+// Middleware assigns the request an ID, honoring a well-formed inbound
+// X-Request-ID, echoes it in the response and logs the request once served.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unmatched routes pass the middlewares twice; log them once
+		if r.Context().Value(loggedKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := r.Header.Get(Header)
+		if !validID(id) {
+			id = newID()
+		}
+		w.Header().Set(Header, id)
+
+		logger := slog.Default().With("request_id", id)
+		ctx := context.WithValue(r.Context(), loggedKey{}, true)
+		r = r.WithContext(logi.WithContext(ctx, logger))
+
+		sw := response.NewWriter(w)
+		start := time.Now()
+
+		next.ServeHTTP(sw, r)
+
+		logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.Status(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
+
+// validID reports whether id is a usable inbound request ID: non-empty, not
+// too long and printable ASCII, so it can't forge log lines or headers.
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newID returns a random (version 4) UUID.
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,54 @@
+package requestlog
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/rakunlabs/logi"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestMiddlewareAssignsRequestID(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if logi.Ctx(r.Context()) == slog.Default() {
+			t.Error("no request logger in context")
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name    string
+		inbound string
+		keep    bool
+	}{
+		{name: "generated"},
+		{name: "inbound", inbound: "trace-abc-123", keep: true},
+		{name: "invalid inbound", inbound: "bad id\nforged"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			if tt.inbound != "" {
+				req.Header.Set(Header, tt.inbound)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(Header)
+			if tt.keep {
+				if id != tt.inbound {
+					t.Fatalf("request ID = %q, want %q", id, tt.inbound)
+				}
+				return
+			}
+			if !uuidPattern.MatchString(id) {
+				t.Fatalf("request ID = %q, want a UUID", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Package response wraps the http.ResponseWriter of a request for the
+// middlewares that look at how it was answered: the status sent, whether the
+// response is under way and, for those asking, the start of its body.
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Writer records the status and start of a response. Streaming (SSE) and
+// WebSocket handlers assert http.Flusher and http.Hijacker directly, so both
+// are passed through.
+type Writer struct {
+	http.ResponseWriter
+	status  int
+	started bool
+	// body keeps the first capture bytes written
+	body    []byte
+	capture int
+}
+
+// NewWriter wraps w.
+func NewWriter(w http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: w}
+}
+
+// Capture makes the writer keep the first limit bytes of the body, returned
+// by Body. Call it before the handler writes.
+func (w *Writer) Capture(limit int) {
+	w.capture = limit
+}
+
+// Body returns the start of the body kept by Capture.
+func (w *Writer) Body() []byte {
+	return w.body
+}
+
+// Status returns the status of the response: 200 until the handler sets
+// another, and 101 once the connection is hijacked.
+func (w *Writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// Started reports whether the final response has begun, after which its
+// status and headers can no longer change.
+func (w *Writer) Started() bool {
+	return w.started
+}
+
+func (w *Writer) WriteHeader(status int) {
+	// Informational responses don't start the final one
+	if !w.started && (status >= http.StatusOK || status == http.StatusSwitchingProtocols) {
+		w.status, w.started = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	w.started = true
+	if room := w.capture - len(w.body); room > 0 {
+		w.body = append(w.body, b[:min(room, len(b))]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *Writer) Flush() {
+	w.started = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", w.ResponseWriter)
+	}
+
+	// A hijacked connection is switching protocols
+	w.status, w.started = http.StatusSwitchingProtocols, true
+	return hj.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,42 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	w := NewWriter(httptest.NewRecorder())
+	if w.Status() != http.StatusOK || w.Started() {
+		t.Fatalf("new writer: status = %d, started = %v", w.Status(), w.Started())
+	}
+
+	w.WriteHeader(http.StatusEarlyHints)
+	if w.Status() != http.StatusOK || w.Started() {
+		t.Fatalf("after 103: status = %d, started = %v", w.Status(), w.Started())
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	w.WriteHeader(http.StatusInternalServerError)
+	if w.Status() != http.StatusNotFound || !w.Started() {
+		t.Fatalf("after 404: status = %d, started = %v", w.Status(), w.Started())
+	}
+
+	w = NewWriter(httptest.NewRecorder())
+	_, _ = w.Write([]byte("ok"))
+	if w.Status() != http.StatusOK || !w.Started() {
+		t.Fatalf("after write: status = %d, started = %v", w.Status(), w.Started())
+	}
+}
+
+func TestWriterCapture(t *testing.T) {
+	w := NewWriter(httptest.NewRecorder())
+	w.Capture(5)
+	_, _ = w.Write([]byte("abc"))
+	_, _ = w.Write([]byte("defgh"))
+
+	if got := string(w.Body()); got != "abcde" {
+		t.Fatalf("body = %q, want %q", got, "abcde")
+	}
+}
@@ -0,0 +1,119 @@
+// Package retry retries the outbound DNS and WHOIS queries that fail for a
+// transient reason, a timeout or a refused or reset connection, with jittered
+// exponential backoff. Definitive answers, such as NXDOMAIN, aren't errors
+// worth retrying and are returned right away.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+)
+
+// Config holds the retry policy, loaded from env via chu.
+type Config struct {
+	// Attempts caps the tries of a query, the first included; 1 disables
+	// retrying.
+	Attempts int `cfg:"attempts" default:"3"`
+	// BaseDelay is the longest wait before the first retry, doubled for
+	// each further one up to MaxDelay. The actual wait is random below it.
+	BaseDelay time.Duration `cfg:"base_delay" default:"100ms"`
+	MaxDelay  time.Duration `cfg:"max_delay" default:"1s"`
+}
+
+// Policy retries transient failures. A nil Policy tries once.
+type Policy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// New returns the Policy of cfg, or nil when cfg.Attempts is under 2.
+func New(cfg Config) *Policy {
+	if cfg.Attempts < 2 {
+		return nil
+	}
+
+	baseDelay := max(cfg.BaseDelay, time.Millisecond)
+
+	return &Policy{
+		attempts:  cfg.Attempts,
+		baseDelay: baseDelay,
+		maxDelay:  max(cfg.MaxDelay, baseDelay),
+	}
+}
+
+// Do calls fn until it succeeds, fails for a reason that isn't transient, or
+// the attempts run out, and returns the number of calls and the last error.
+// A retry that wouldn't end before the deadline of ctx, if it took as long as
+// the failed call, isn't made. kind labels the retries in the metrics, e.g.
+// "dns".
+func (p *Policy) Do(ctx context.Context, kind string, fn func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := fn()
+		if err == nil || p == nil || attempt >= p.attempts || !Transient(err) || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay+time.Since(start) {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+
+		metrics.UpstreamRetry(kind)
+	}
+}
+
+// backoff returns the wait before the retry following attempt: random, up to
+// the base delay doubled attempt-1 times and capped at the max delay.
+func (p *Policy) backoff(attempt int) time.Duration {
+	ceiling := p.maxDelay
+	if shift := attempt - 1; shift < 32 && p.baseDelay<<shift < p.maxDelay {
+		ceiling = p.baseDelay << shift
+	}
+
+	return rand.N(ceiling) + 1
+}
+
+// Transient reports whether err may go away on retry: a timeout, or a refused
+// or reset connection. The end of the request's own context isn't.
+func Transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+var global atomic.Pointer[Policy]
+
+// SetDefault makes p the policy of Do. Queries are tried once until it is
+// called.
+func SetDefault(p *Policy) {
+	global.Store(p)
+}
+
+// Do runs fn under the default policy, see Policy.Do.
+func Do(ctx context.Context, kind string, fn func() error) (int, error) {
+	return global.Load().Do(ctx, kind, fn)
+}
@@ -0,0 +1,65 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	p := New(Config{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+
+	timeout := &net.OpError{Op: "read", Net: "udp", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}
+	refused := fmt.Errorf("whois: connect to whois server failed: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+	nxdomain := &net.DNSError{Err: "no such host", IsNotFound: true}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "success", errs: []error{nil}, attempts: 1},
+		{name: "timeout then success", errs: []error{timeout, nil}, attempts: 2},
+		{name: "refused every time", errs: []error{refused, refused, refused, nil}, attempts: 3, err: refused},
+		{name: "nxdomain", errs: []error{nxdomain, nil}, attempts: 1, err: nxdomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			attempts, err := p.Do(context.Background(), "test", func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if attempts != tt.attempts || calls != tt.attempts || !errors.Is(err, tt.err) {
+				t.Fatalf("Do() = %d, %v after %d calls, want %d, %v", attempts, err, calls, tt.attempts, tt.err)
+			}
+		})
+	}
+
+	// A nil policy tries once
+	if attempts, _ := (*Policy)(nil).Do(context.Background(), "test", func() error { return timeout }); attempts != 1 {
+		t.Fatalf("nil Policy made %d attempts", attempts)
+	}
+}
+
+func TestDoDeadline(t *testing.T) {
+	p := New(Config{Attempts: 5, BaseDelay: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// A retry taking as long as the failed call would end past the deadline
+	attempts, _ := p.Do(ctx, "test", func() error {
+		time.Sleep(30 * time.Millisecond)
+		return syscall.ECONNRESET
+	})
+	if attempts != 1 {
+		t.Fatalf("Do() made %d attempts, want 1", attempts)
+	}
+}
@@ -0,0 +1,130 @@
+package ssl
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/audit"
+	"github.com/rytsh/bir/api/tools/bulk"
+	"github.com/rytsh/bir/api/tools/jobs"
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+// BatchRequest is the body of POST /ssl/batch.
+type BatchRequest struct {
+	Targets []BatchTarget `json:"targets"`
+	// WarnDays and CritDays override the configured expiry thresholds for
+	// every target.
+	WarnDays int `json:"warnDays,omitempty"`
+	CritDays int `json:"critDays,omitempty"`
+}
+
+// BatchTarget is a server to check. A zero port defaults to 443.
+type BatchTarget struct {
+	Domain string `json:"domain"`
+	Port   int    `json:"port,omitempty"`
+}
+
+// BatchResult is the expiry of a target's certificate, without the
+// certificate details and chain of GET /ssl.
+type BatchResult struct {
+	Domain          string `json:"domain"`
+	Port            int    `json:"port"`
+	NotAfter        string `json:"notAfter,omitempty"`
+	DaysUntilExpiry int    `json:"daysUntilExpiry"`
+	Expired         bool   `json:"expired"`
+	NotYetValid     bool   `json:"notYetValid,omitempty"`
+	ExpiryStatus    string `json:"expiryStatus,omitempty"`
+	Valid           bool   `json:"valid"`
+	Error           string `json:"error,omitempty"`
+}
+
+// BatchResponse is the response of POST /ssl/batch. Results are in the order
+// of the targets.
+type BatchResponse struct {
+	Results []BatchResult `json:"results,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Batch returns the handler of POST /ssl/batch, checking the certificate
+// expiry of several targets at once within limit, in the background when the
+// request asks for a job.
+func (h *Handler) Batch(limit bulk.Limit, manager *jobs.Manager) func(c *ada.Context) error {
+	return func(c *ada.Context) error {
+		c.Request.Body = http.MaxBytesReader(c.Response, c.Request.Body, maxRequestSize)
+
+		var req BatchRequest
+		if err := c.Bind(&req); err != nil {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: "invalid request body"})
+		}
+
+		for _, target := range req.Targets {
+			audit.AddTargets(c.Request.Context(), target.Domain)
+		}
+
+		if len(req.Targets) == 0 {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: "targets are required"})
+		}
+		if err := limit.CheckSize(len(req.Targets)); err != nil {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: err.Error()})
+		}
+		if req.WarnDays < 0 || req.CritDays < 0 {
+			return render.Send(c, http.StatusBadRequest, BatchResponse{Error: "invalid warnDays or critDays"})
+		}
+
+		opts := Options{WarnDays: req.WarnDays, CritDays: req.CritDays}
+
+		return manager.Run(c, "ssl_batch", func(ctx context.Context) any {
+			return BatchResponse{Results: h.InspectBatch(ctx, limit, req.Targets, opts)}
+		})
+	}
+}
+
+// InspectBatch checks the certificate expiry of targets, at most
+// limit.Concurrency at a time and each within the configured batch timeout.
+// Invalid targets and connection failures are reported per result.
+func (h *Handler) InspectBatch(ctx context.Context, limit bulk.Limit, targets []BatchTarget, opts Options) []BatchResult {
+	results := make([]BatchResult, len(targets))
+	for i, target := range targets {
+		// Kept for the targets skipped once the request is canceled
+		results[i] = BatchResult{Domain: strings.TrimSpace(target.Domain), Port: target.Port, Error: "not checked"}
+	}
+
+	limit.ForEach(ctx, len(targets), func(ctx context.Context, i int) {
+		if h.cfg.BatchTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.cfg.BatchTimeout)
+			defer cancel()
+		}
+
+		results[i] = h.inspectExpiry(ctx, targets[i], opts)
+	})
+
+	return results
+}
+
+func (h *Handler) inspectExpiry(ctx context.Context, target BatchTarget, opts Options) BatchResult {
+	response, err := h.Inspect(ctx, target.Domain, target.Port, opts)
+	if err != nil {
+		return BatchResult{Domain: strings.TrimSpace(target.Domain), Port: target.Port, Error: err.Error()}
+	}
+
+	result := BatchResult{
+		Domain:          response.Domain,
+		Port:            response.Port,
+		DaysUntilExpiry: response.DaysUntilExpiry,
+		Expired:         response.Expired,
+		NotYetValid:     response.NotYetValid,
+		ExpiryStatus:    response.ExpiryStatus,
+		Valid:           response.Valid,
+		Error:           response.Error,
+	}
+	if response.Certificate != nil {
+		result.NotAfter = response.Certificate.NotAfter
+	}
+
+	return result
+}
@@ -0,0 +1,144 @@
+package ssl
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/deflate"
+)
+
+// cache is a size-bounded LRU cache of SSL responses with a fixed TTL.
+// With compress the PEM encoded certificates, the bulk of a response, are
+// kept deflated.
+type cache struct {
+	ttl      time.Duration
+	maxSize  int
+	compress bool
+	// order holds *cacheEntry values, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	key      string
+	response SSLResponse
+	storedAt time.Time
+	// pem and chainPEMs are the deflated PEM of the certificate and of the
+	// chain, which are then left empty.
+	pem       []byte
+	chainPEMs [][]byte
+}
+
+func newCache(ttl time.Duration, maxSize int, compress bool) *cache {
+	return &cache{
+		ttl:      ttl,
+		maxSize:  maxSize,
+		compress: compress,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// cacheKey identifies a check by its target and the options changing its
+// result. The timeout doesn't, and client certificate checks aren't cached.
+func cacheKey(domain string, port int, opts Options) string {
+	return fmt.Sprintf("%s|%d|%s|%s|%t|%s|%d|%t|%t|%t|%t|%t|%d|%d",
+		strings.ToLower(strings.TrimSpace(domain)), port, opts.StartTLS, opts.SNI,
+		opts.ALPN == nil, strings.Join(opts.ALPN, ","), opts.IPVersion,
+		opts.Browser, opts.Scan, opts.Resumption, opts.Headers, opts.Preload,
+		opts.WarnDays, opts.CritDays)
+}
+
+// get returns the cached response for key and when it was stored.
+func (c *cache) get(key string) (SSLResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return SSLResponse{}, time.Time{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Since(entry.storedAt) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return SSLResponse{}, time.Time{}, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return entry.expand(), entry.storedAt, true
+}
+
+// set stores response under key, evicting the least recently used entry when
+// the cache is full.
+func (c *cache) set(key string, response SSLResponse) {
+	entry := &cacheEntry{key: key, response: response, storedAt: time.Now()}
+	if c.compress {
+		// Deflated outside the lock, it's the costly part
+		entry.shrink()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// shrink moves the certificate PEMs of the response into their deflated
+// form. The certificate and chain are copied, the caller's response may share
+// them.
+func (e *cacheEntry) shrink() {
+	if e.response.Certificate != nil {
+		certificate := *e.response.Certificate
+		e.pem = deflate.Compress(certificate.PEM)
+		certificate.PEM = ""
+		e.response.Certificate = &certificate
+	}
+
+	if len(e.response.Chain) == 0 {
+		return
+	}
+	e.response.Chain = slices.Clone(e.response.Chain)
+	e.chainPEMs = make([][]byte, len(e.response.Chain))
+	for i := range e.response.Chain {
+		e.chainPEMs[i] = deflate.Compress(e.response.Chain[i].PEM)
+		e.response.Chain[i].PEM = ""
+	}
+}
+
+// expand returns the response with its certificate PEMs inflated back.
+func (e *cacheEntry) expand() SSLResponse {
+	response := e.response
+	if e.pem != nil {
+		certificate := *e.response.Certificate
+		certificate.PEM = deflate.Decompress(e.pem)
+		response.Certificate = &certificate
+	}
+	if len(e.chainPEMs) > 0 {
+		response.Chain = slices.Clone(e.response.Chain)
+		for i, pem := range e.chainPEMs {
+			response.Chain[i].PEM = deflate.Decompress(pem)
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,138 @@
+package ssl
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// chainIssues reports how certs depart from a complete chain sent in order:
+// the leaf first, then each certificate followed by its issuer, up to one a
+// trusted root issued. chainErr is the result of verifyChain. Certificates
+// are numbered from 1.
+func chainIssues(certs []*x509.Certificate, chainErr error) []string {
+	var issues []string
+
+	leaf := 0
+	if certs[0].IsCA && len(certs) > 1 {
+		for i, cert := range certs[1:] {
+			if !cert.IsCA {
+				leaf = i + 1
+				issues = append(issues, fmt.Sprintf("leaf certificate is not first, found at %d", leaf+1))
+				break
+			}
+		}
+	}
+
+	if issuedBy(certs[leaf], certs[leaf]) {
+		issues = append(issues, "leaf certificate is self-signed")
+	}
+
+	path := chainPath(certs, leaf)
+	for i := range certs {
+		if j := slices.IndexFunc(certs[:i], certs[i].Equal); j >= 0 {
+			issues = append(issues, fmt.Sprintf("certificate %d is a duplicate of certificate %d", i+1, j+1))
+			continue
+		}
+
+		if !slices.Contains(path, i) {
+			issues = append(issues, fmt.Sprintf("certificate %d is unrelated to the leaf's chain", i+1))
+			continue
+		}
+
+		// Reported once for the unrelated certificate in between
+		if i > 0 && slices.Contains(path, i-1) && !issuedBy(certs[i-1], certs[i]) {
+			issues = append(issues, fmt.Sprintf("certificate %d is not the issuer of certificate %d", i+1, i))
+		}
+	}
+
+	// A chain that ends below a root is missing an intermediate, unless the
+	// system knows it; self-signed ends are reported by ChainError
+	top := path[len(path)-1]
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(chainErr, &unknownAuthority) && !issuedBy(certs[top], certs[top]) {
+		issues = append(issues, fmt.Sprintf("missing intermediate certificate: the issuer of certificate %d, %q, wasn't sent and isn't a trusted root", top+1, certs[top].Issuer.CommonName))
+	}
+
+	return issues
+}
+
+// chainPath returns the indexes of certs on the issuing path of certs[leaf],
+// from the leaf up to a self-signed certificate or one whose issuer wasn't
+// sent.
+func chainPath(certs []*x509.Certificate, leaf int) []int {
+	path := []int{leaf}
+
+	for {
+		cert := certs[path[len(path)-1]]
+		if issuedBy(cert, cert) {
+			return path
+		}
+
+		next := -1
+		for i, parent := range certs {
+			if !slices.Contains(path, i) && issuedBy(cert, parent) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return path
+		}
+
+		path = append(path, next)
+	}
+}
+
+// issuedBy reports whether parent signed cert.
+func issuedBy(cert, parent *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, parent.RawSubject) && cert.CheckSignatureFrom(parent) == nil
+}
+
+// minRSAKeySize is the smallest RSA key not reported as weak.
+const minRSAKeySize = 2048
+
+// chainCertificate describes a certificate of the chain and its own health at
+// now, against the warnDays and critDays expiry thresholds.
+func chainCertificate(cert *x509.Certificate, now time.Time, warnDays, critDays int) ChainCertificate {
+	window := validityAt(cert, now)
+
+	return ChainCertificate{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		NotBefore:         cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:          cert.NotAfter.UTC().Format(time.RFC3339),
+		IsCA:              cert.IsCA,
+		SHA256Fingerprint: sha256Fingerprint(cert.Raw),
+		SHA1Fingerprint:   sha1Fingerprint(cert.Raw),
+		SubjectKeyID:      colonHex(cert.SubjectKeyId),
+		AuthorityKeyID:    colonHex(cert.AuthorityKeyId),
+		TimeValid:         window.valid(),
+		DaysUntilExpiry:   window.daysUntilExpiry,
+		ExpiryStatus:      window.status(warnDays, critDays),
+		WeakKey:           weakKey(cert),
+		WeakSignature:     weakSignature(cert),
+		PEM:               encodeCertToPEM(cert.Raw),
+	}
+}
+
+// weakKey reports whether cert has an RSA key under minRSAKeySize bits.
+func weakKey(cert *x509.Certificate) bool {
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	return ok && key.N.BitLen() < minRSAKeySize
+}
+
+// weakSignature reports whether cert is signed over SHA-1 or MD5, unless it
+// is self-signed.
+func weakSignature(cert *x509.Certificate) bool {
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1, x509.MD5WithRSA, x509.MD2WithRSA:
+		return !bytes.Equal(cert.RawIssuer, cert.RawSubject)
+	default:
+		return false
+	}
+}
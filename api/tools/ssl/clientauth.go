@@ -0,0 +1,187 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/audit"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+// maxRequestSize caps the POST /ssl body, which carries a PEM certificate
+// chain and key.
+const maxRequestSize = 64 << 10
+
+// clientAuthProbeTimeout is how long to wait for a TLS 1.3 server to reject
+// the client certificate after the handshake.
+const clientAuthProbeTimeout = time.Second
+
+// ClientAuth reports on client certificate (mTLS) authentication.
+type ClientAuth struct {
+	// Requested is whether the server asked for a client certificate.
+	Requested bool `json:"requested"`
+	// Presented is whether a client certificate was sent.
+	Presented bool `json:"presented"`
+	// Accepted is whether the server completed the handshake with what was
+	// sent.
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// InspectRequest is the body of POST /ssl. It takes the GET /ssl parameters
+// plus an optional PEM client certificate and key, which can't be passed
+// safely in a query string.
+type InspectRequest struct {
+	Domain   string `json:"domain"`
+	Port     int    `json:"port,omitempty"`
+	StartTLS string `json:"starttls,omitempty"`
+	// SNI overrides the server name sent; "none" sends none.
+	SNI        string `json:"sni,omitempty"`
+	Browser    bool   `json:"browser,omitempty"`
+	Scan       bool   `json:"scan,omitempty"`
+	Headers    bool   `json:"headers,omitempty"`
+	Preload    bool   `json:"preload,omitempty"`
+	Resumption bool   `json:"resumption,omitempty"`
+	WarnDays   int    `json:"warnDays,omitempty"`
+	CritDays   int    `json:"critDays,omitempty"`
+	// ALPN overrides the application protocols offered; [] offers none.
+	ALPN []string `json:"alpn,omitempty"`
+	// IPVersion forces connecting over IPv4 (4) or IPv6 (6).
+	IPVersion int `json:"ipv,omitempty"`
+	// Timeout overrides the connection timeout, e.g. "5s".
+	Timeout string `json:"timeout,omitempty"`
+	// Certificate is the PEM client certificate chain, leaf first.
+	Certificate string `json:"certificate,omitempty"`
+	// Key is the PEM private key of Certificate.
+	Key string `json:"key,omitempty"`
+}
+
+// SSLWithClientCert handles POST /ssl, checking a server that may require a
+// client certificate.
+func (h *Handler) SSLWithClientCert(c *ada.Context) error {
+	c.Request.Body = http.MaxBytesReader(c.Response, c.Request.Body, maxRequestSize)
+
+	var req InspectRequest
+	if err := c.Bind(&req); err != nil {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "invalid request body"})
+	}
+
+	// the certificate and key aren't logged
+	audit.AddTargets(c.Request.Context(), req.Domain)
+
+	if strings.TrimSpace(req.Domain) == "" {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "domain is required"})
+	}
+	if req.Port < 0 {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: errInvalidPort.Error()})
+	}
+	if req.WarnDays < 0 || req.CritDays < 0 {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "invalid warnDays or critDays"})
+	}
+
+	requestTimeout, err := timeout.Parse(req.Timeout)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: err.Error()})
+	}
+
+	opts := Options{
+		Timeout:    requestTimeout,
+		StartTLS:   strings.ToLower(strings.TrimSpace(req.StartTLS)),
+		SNI:        strings.TrimSpace(req.SNI),
+		ALPN:       req.ALPN,
+		IPVersion:  req.IPVersion,
+		Browser:    req.Browser,
+		Scan:       req.Scan,
+		Headers:    req.Headers,
+		Preload:    req.Preload,
+		Resumption: req.Resumption,
+		WarnDays:   req.WarnDays,
+		CritDays:   req.CritDays,
+	}
+
+	if req.Certificate != "" || req.Key != "" {
+		cert, err := tls.X509KeyPair([]byte(req.Certificate), []byte(req.Key))
+		if err != nil {
+			return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "invalid client certificate or key"})
+		}
+		opts.ClientCertificate = &cert
+	}
+
+	response, err := h.Inspect(c.Request.Context(), req.Domain, req.Port, opts)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), SSLResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// clientAuth tracks whether the server requested a client certificate during
+// a handshake and whether one was sent.
+type clientAuth struct {
+	cert      *tls.Certificate
+	requested bool
+	presented bool
+}
+
+// getClientCertificate is the tls.Config.GetClientCertificate callback. Without
+// a certificate it sends none and lets the server decide.
+func (a *clientAuth) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	a.requested = true
+	if a.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+
+	a.presented = true
+	return a.cert, nil
+}
+
+// report returns the result of a handshake that ended with err, or nil when
+// there is nothing to report.
+func (a *clientAuth) report(err error) *ClientAuth {
+	if !a.requested && a.cert == nil {
+		return nil
+	}
+
+	r := &ClientAuth{
+		Requested: a.requested,
+		Presented: a.presented,
+		Accepted:  a.requested && err == nil,
+	}
+	if err != nil {
+		r.Error = simplifyTLSError(err)
+	}
+
+	return r
+}
+
+// confirmClientAuth waits briefly for the server's verdict on the client
+// certificate. In TLS 1.3 the client finishes the handshake before the server
+// checks the certificate, so a rejection only arrives as an alert afterwards.
+func confirmClientAuth(conn *tls.Conn) error {
+	if conn.ConnectionState().Version < tls.VersionTLS13 {
+		return nil
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(clientAuthProbeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Servers don't send application data before the client speaks, so the
+	// read either times out or returns the alert
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+
+	return err
+}
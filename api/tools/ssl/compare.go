@@ -0,0 +1,174 @@
+package ssl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/audit"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
+)
+
+// CompareResponse is the response of GET /ssl/compare: the inspection of
+// both hosts, each with its own error, and their difference once both
+// served a certificate.
+type CompareResponse struct {
+	A     SSLResponse      `json:"a"`
+	B     SSLResponse      `json:"b"`
+	Diff  *CertificateDiff `json:"diff,omitempty"`
+	Error string           `json:"error,omitempty"`
+}
+
+// CertificateDiff compares the leaf certificates of two hosts. Matching and
+// Differing name the compared fields: subject, issuer, sans, publicKey,
+// publicKeyAlgorithm, signatureAlgorithm, serialNumber, notAfter and
+// chainValid.
+type CertificateDiff struct {
+	Matching  []string `json:"matching"`
+	Differing []string `json:"differing"`
+	// SANsEqual is true when both certificates cover the same names, in any
+	// order; SANsOnlyA and SANsOnlyB list the others.
+	SANsEqual bool     `json:"sansEqual"`
+	SANsOnlyA []string `json:"sansOnlyA,omitempty"`
+	SANsOnlyB []string `json:"sansOnlyB,omitempty"`
+	// SameKey is true when both certificates carry the same public key,
+	// SameCertificate when they are the same certificate.
+	SameKey         bool `json:"sameKey"`
+	SameCertificate bool `json:"sameCertificate"`
+}
+
+// Compare handles GET /ssl/compare?a=host[:port]&b=host[:port] - inspects
+// both hosts at once and compares their certificates, e.g. to validate a
+// migration.
+func (h *Handler) Compare(c *ada.Context) error {
+	query := c.Request.URL.Query()
+	a, b := strings.TrimSpace(query.Get("a")), strings.TrimSpace(query.Get("b"))
+	if a == "" || b == "" {
+		return render.Send(c, http.StatusBadRequest, CompareResponse{Error: "a and b parameters are required"})
+	}
+	audit.AddTargets(c.Request.Context(), a, b)
+
+	requestTimeout, err := timeout.FromQuery(query)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, CompareResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, h.CompareHosts(c.Request.Context(), a, b, Options{Timeout: requestTimeout}))
+}
+
+// CompareHosts inspects the host[:port] targets a and b concurrently with
+// opts and compares their certificates. Invalid targets and failures are
+// reported on their side, without hiding the other.
+func (h *Handler) CompareHosts(ctx context.Context, a, b string, opts Options) CompareResponse {
+	var (
+		response CompareResponse
+		wg       sync.WaitGroup
+	)
+	wg.Go(func() { response.A = h.inspectTarget(ctx, a, opts) })
+	wg.Go(func() { response.B = h.inspectTarget(ctx, b, opts) })
+	wg.Wait()
+
+	if response.A.Certificate != nil && response.B.Certificate != nil {
+		response.Diff = diffCertificates(response.A, response.B)
+	}
+
+	return response
+}
+
+// inspectTarget inspects a host[:port] target, reporting invalid input in the
+// response.
+func (h *Handler) inspectTarget(ctx context.Context, target string, opts Options) SSLResponse {
+	domain, port, err := splitTarget(target)
+	if err == nil {
+		var response SSLResponse
+		if response, err = h.Inspect(ctx, domain, port, opts); err == nil {
+			return response
+		}
+	}
+
+	return SSLResponse{Domain: domain, Port: port, Error: err.Error()}
+}
+
+// splitTarget splits host[:port], also given as a URL, into the domain and
+// port; 0 when it has none.
+func splitTarget(target string) (string, int, error) {
+	target = strings.TrimPrefix(strings.TrimPrefix(target, "https://"), "http://")
+	if idx := strings.Index(target, "/"); idx != -1 {
+		target = target[:idx]
+	}
+
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, 0, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return host, 0, errInvalidPort
+	}
+
+	return host, port, nil
+}
+
+// diffCertificates compares the leaf certificates of a and b, which must both
+// have one.
+func diffCertificates(a, b SSLResponse) *CertificateDiff {
+	certA, certB := a.Certificate, b.Certificate
+	diff := &CertificateDiff{Matching: []string{}, Differing: []string{}}
+
+	sansA, sansB := normalizedSANs(certA.SANs), normalizedSANs(certB.SANs)
+	for _, san := range sansA {
+		if !slices.Contains(sansB, san) {
+			diff.SANsOnlyA = append(diff.SANsOnlyA, san)
+		}
+	}
+	for _, san := range sansB {
+		if !slices.Contains(sansA, san) {
+			diff.SANsOnlyB = append(diff.SANsOnlyB, san)
+		}
+	}
+	diff.SANsEqual = len(diff.SANsOnlyA) == 0 && len(diff.SANsOnlyB) == 0
+	diff.SameKey = certA.SPKIPin == certB.SPKIPin
+	diff.SameCertificate = certA.SHA256Fingerprint == certB.SHA256Fingerprint
+
+	for _, field := range []struct {
+		name  string
+		equal bool
+	}{
+		{"subject", certA.Subject == certB.Subject},
+		{"issuer", certA.Issuer == certB.Issuer},
+		{"sans", diff.SANsEqual},
+		{"publicKey", diff.SameKey},
+		{"publicKeyAlgorithm", certA.PublicKeyAlgorithm == certB.PublicKeyAlgorithm},
+		{"signatureAlgorithm", certA.SignatureAlgorithm == certB.SignatureAlgorithm},
+		{"serialNumber", certA.SerialNumber == certB.SerialNumber},
+		{"notAfter", certA.NotAfter == certB.NotAfter},
+		{"chainValid", a.ChainValid == b.ChainValid},
+	} {
+		if field.equal {
+			diff.Matching = append(diff.Matching, field.name)
+		} else {
+			diff.Differing = append(diff.Differing, field.name)
+		}
+	}
+
+	return diff
+}
+
+// normalizedSANs returns the lowercase, sorted and deduplicated sans.
+func normalizedSANs(sans []string) []string {
+	normalized := make([]string, len(sans))
+	for i, san := range sans {
+		normalized[i] = strings.ToLower(san)
+	}
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized)
+}
@@ -0,0 +1,241 @@
+package ssl
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/proxy"
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+const (
+	// ctMaxResponseSize caps the aggregator response; popular domains have
+	// tens of thousands of logged certificates.
+	ctMaxResponseSize = 32 << 20
+	// ctMaxCertificates caps the certificates returned, newest first.
+	ctMaxCertificates = 1000
+)
+
+var ctClient = &http.Client{Transport: proxy.Transport()}
+
+// CTCertificate is a certificate found in the Certificate Transparency logs.
+type CTCertificate struct {
+	// ID is the aggregator's ID of the entry (crt.sh/?id=).
+	ID           int64    `json:"id"`
+	Issuer       string   `json:"issuer"`
+	CommonName   string   `json:"commonName,omitempty"`
+	Names        []string `json:"names,omitempty"`
+	SerialNumber string   `json:"serialNumber"`
+	NotBefore    string   `json:"notBefore"`
+	NotAfter     string   `json:"notAfter"`
+	LoggedAt     string   `json:"loggedAt,omitempty"`
+	Expired      bool     `json:"expired"`
+}
+
+type CTResponse struct {
+	Domain        string          `json:"domain"`
+	UnicodeDomain string          `json:"unicodeDomain,omitempty"`
+	Subdomains    bool            `json:"subdomains,omitempty"`
+	Count         int             `json:"count"`
+	Truncated     bool            `json:"truncated,omitempty"`
+	Certificates  []CTCertificate `json:"certificates,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// crtshEntry is an entry of the crt.sh JSON output.
+type crtshEntry struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"`
+	SerialNumber   string `json:"serial_number"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+	EntryTimestamp string `json:"entry_timestamp"`
+}
+
+// CT handles GET /ssl/ct - lists the certificates logged for a domain.
+func (h *Handler) CT(c *ada.Context) error {
+	domain := strings.TrimSpace(c.Request.URL.Query().Get("domain"))
+	if domain == "" {
+		return render.Send(c, http.StatusBadRequest, CTResponse{Error: "domain parameter is required"})
+	}
+
+	subdomains := c.Request.URL.Query().Get("subdomains") == "true"
+
+	response, err := h.CTLookup(c.Request.Context(), domain, subdomains)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), CTResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// CTLookup lists the certificates logged for domain, and for its subdomains
+// when asked, deduplicated and newest first. The error reports an invalid
+// domain; aggregator failures are part of the response.
+func (h *Handler) CTLookup(ctx context.Context, domain string, subdomains bool) (CTResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return CTResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return CTResponse{}, errInvalidDomain
+	}
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return CTResponse{}, err
+	}
+	defer release()
+
+	response := CTResponse{Domain: domain, UnicodeDomain: idn.ToUnicode(domain), Subdomains: subdomains}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.CTTimeout)
+	defer cancel()
+
+	query := domain
+	if subdomains {
+		query = "%." + domain
+	}
+
+	entries, err := fetchCT(ctx, h.cfg.CTURL, query)
+	if err != nil {
+		metrics.UpstreamFailure("ct")
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			response.Error = "certificate transparency lookup timed out"
+		default:
+			response.Error = "certificate transparency log unavailable: " + err.Error()
+		}
+		return response, nil
+	}
+
+	response.Certificates = dedupeCT(entries, time.Now())
+	response.Count = len(response.Certificates)
+	if len(response.Certificates) > ctMaxCertificates {
+		response.Certificates = response.Certificates[:ctMaxCertificates]
+		response.Truncated = true
+	}
+
+	return response, nil
+}
+
+// fetchCT queries the crt.sh compatible aggregator at baseURL.
+func fetchCT(ctx context.Context, baseURL, query string) ([]crtshEntry, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"q": {query}, "output": {"json"}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ctClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ctMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []crtshEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	return entries, nil
+}
+
+// dedupeCT merges the entries of the same certificate (a precertificate and
+// the final certificate are logged separately) by issuer and serial, and
+// sorts the result newest first.
+func dedupeCT(entries []crtshEntry, now time.Time) []CTCertificate {
+	index := make(map[string]int, len(entries))
+	certs := make([]CTCertificate, 0, len(entries))
+
+	for _, entry := range entries {
+		names := strings.Fields(strings.ToLower(entry.NameValue))
+
+		key := entry.IssuerName + "|" + entry.SerialNumber
+		if i, ok := index[key]; ok {
+			for _, name := range names {
+				if !slices.Contains(certs[i].Names, name) {
+					certs[i].Names = append(certs[i].Names, name)
+				}
+			}
+			// Keep the earliest logging
+			if entry.EntryTimestamp != "" && entry.EntryTimestamp < certs[i].LoggedAt {
+				certs[i].ID = entry.ID
+				certs[i].LoggedAt = entry.EntryTimestamp
+			}
+			continue
+		}
+
+		notAfter := ctTime(entry.NotAfter)
+		index[key] = len(certs)
+		certs = append(certs, CTCertificate{
+			ID:           entry.ID,
+			Issuer:       entry.IssuerName,
+			CommonName:   entry.CommonName,
+			Names:        slices.Compact(names),
+			SerialNumber: entry.SerialNumber,
+			NotBefore:    formatCTTime(entry.NotBefore),
+			NotAfter:     formatCTTime(entry.NotAfter),
+			LoggedAt:     entry.EntryTimestamp,
+			Expired:      !notAfter.IsZero() && now.After(notAfter),
+		})
+	}
+
+	for i := range certs {
+		certs[i].LoggedAt = formatCTTime(certs[i].LoggedAt)
+	}
+
+	slices.SortStableFunc(certs, func(a, b CTCertificate) int {
+		return cmp.Compare(b.NotBefore, a.NotBefore)
+	})
+
+	return certs
+}
+
+// ctTime parses the UTC timestamps of crt.sh, which have no zone suffix.
+func ctTime(value string) time.Time {
+	t, err := time.Parse("2006-01-02T15:04:05.999999999", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func formatCTTime(value string) string {
+	t := ctTime(value)
+	if t.IsZero() {
+		return value
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,135 @@
+package ssl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/render"
+)
+
+var errNoCertificates = errors.New("no certificates found, expected PEM or base64 DER")
+
+// DecodeRequest is the JSON form of the POST /ssl/decode body; a PEM or
+// base64 DER body can be posted as is.
+type DecodeRequest struct {
+	// Certificate is one or more PEM certificates, or a base64 DER one.
+	Certificate string `json:"certificate"`
+}
+
+// DecodeResponse is the response of POST /ssl/decode.
+type DecodeResponse struct {
+	// Certificates are in the order of the input.
+	Certificates []CertificateInfo `json:"certificates,omitempty"`
+	// ChainValid is whether the first certificate verifies against the
+	// system roots, with the others as intermediates.
+	ChainValid bool   `json:"chainValid"`
+	ChainError string `json:"chainError,omitempty"`
+	// ChainIssues flags a bundle that isn't a chain in order, leaf first.
+	ChainIssues []string `json:"chainIssues,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Decode handles POST /ssl/decode, describing certificates given as PEM or
+// base64 DER instead of fetched from a server.
+func (h *Handler) Decode(c *ada.Context) error {
+	c.Request.Body = http.MaxBytesReader(c.Response, c.Request.Body, maxRequestSize)
+
+	var data []byte
+	if mediaType, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req DecodeRequest
+		if err := c.Bind(&req); err != nil {
+			return render.Send(c, http.StatusBadRequest, DecodeResponse{Error: "invalid request body"})
+		}
+		data = []byte(req.Certificate)
+	} else {
+		var err error
+		if data, err = io.ReadAll(c.Request.Body); err != nil {
+			return render.Send(c, http.StatusBadRequest, DecodeResponse{Error: "invalid request body"})
+		}
+	}
+
+	response, err := DecodeCertificates(data)
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, DecodeResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// DecodeCertificates describes the certificates of data, PEM blocks or a
+// base64 DER certificate, and checks them as a chain. The error reports
+// input that holds no valid certificate.
+func DecodeCertificates(data []byte) (DecodeResponse, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return DecodeResponse{}, err
+	}
+
+	response := DecodeResponse{
+		Certificates: make([]CertificateInfo, 0, len(certs)),
+	}
+	for _, cert := range certs {
+		response.Certificates = append(response.Certificates, *certificateInfo(cert))
+	}
+
+	chainErr := verifyChain(certs)
+	if chainErr != nil {
+		response.ChainError = simplifyVerifyError(chainErr)
+	} else {
+		response.ChainValid = true
+	}
+	response.ChainIssues = chainIssues(certs, chainErr)
+
+	return response, nil
+}
+
+// parseCertificates parses PEM CERTIFICATE blocks, or else base64 DER.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	data = bytes.TrimSpace(data)
+
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		der, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(data), nil)))
+		if err != nil || len(der) == 0 {
+			return nil, errNoCertificates
+		}
+
+		certs, err := x509.ParseCertificates(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		return certs, nil
+	}
+
+	var certs []*x509.Certificate
+	for i := 1; ; i++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("PEM block %d is %q, expected CERTIFICATE", i, block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %d: %w", i, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errNoCertificates
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,92 @@
+package ssl
+
+import (
+	"crypto/x509"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expiry statuses, from the least to the most urgent.
+const (
+	ExpiryOK       = "ok"
+	ExpiryWarning  = "warning"
+	ExpiryCritical = "critical"
+	ExpiryExpired  = "expired"
+	// ExpiryNotYetValid is the status of a certificate whose validity hasn't
+	// started, e.g. freshly issued during a staged rollout.
+	ExpiryNotYetValid = "not_yet_valid"
+)
+
+// validity is where a moment falls in the validity window of a certificate.
+type validity struct {
+	daysUntilExpiry int
+	// expired is set past NotAfter, notYetValid before NotBefore; then
+	// daysUntilValid counts the days left, rounded up.
+	expired        bool
+	notYetValid    bool
+	daysUntilValid int
+}
+
+// validityAt returns the validity of cert at now. Both ends of the window
+// are valid, as in x509 verification.
+func validityAt(cert *x509.Certificate, now time.Time) validity {
+	v := validity{
+		daysUntilExpiry: int(cert.NotAfter.Sub(now).Hours() / 24),
+		expired:         now.After(cert.NotAfter),
+		notYetValid:     now.Before(cert.NotBefore),
+	}
+	if v.notYetValid {
+		v.daysUntilValid = int(math.Ceil(cert.NotBefore.Sub(now).Hours() / 24))
+	}
+
+	return v
+}
+
+// valid reports whether now is within the window.
+func (v validity) valid() bool {
+	return !v.expired && !v.notYetValid
+}
+
+// status is the expiry status of the certificate, ExpiryNotYetValid before
+// its window.
+func (v validity) status(warnDays, critDays int) string {
+	if v.notYetValid {
+		return ExpiryNotYetValid
+	}
+
+	return expiryStatus(v.daysUntilExpiry, v.expired, warnDays, critDays)
+}
+
+// expiryStatus normalizes the remaining validity of a certificate against the
+// warning and critical thresholds, in days.
+func expiryStatus(daysUntilExpiry int, expired bool, warnDays, critDays int) string {
+	switch {
+	case expired:
+		return ExpiryExpired
+	case daysUntilExpiry < critDays:
+		return ExpiryCritical
+	case daysUntilExpiry < warnDays:
+		return ExpiryWarning
+	default:
+		return ExpiryOK
+	}
+}
+
+// parseDays parses a positive threshold in days; an empty value is 0, which
+// keeps the configured threshold.
+func parseDays(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return 0, errors.New("invalid number of days")
+	}
+
+	return days, nil
+}
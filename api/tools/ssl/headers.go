@@ -0,0 +1,110 @@
+package ssl
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// headersTimeout bounds the HTTP request made for headers=true.
+const headersTimeout = 5 * time.Second
+
+// SecurityHeaders reports the HTTP security headers of the inspected site
+// (headers=true).
+type SecurityHeaders struct {
+	StatusCode            int       `json:"statusCode,omitempty"`
+	HSTS                  *HSTSInfo `json:"hsts,omitempty"`
+	ContentSecurityPolicy bool      `json:"contentSecurityPolicy"`
+	XContentTypeOptions   bool      `json:"xContentTypeOptions"`
+	Error                 string    `json:"error,omitempty"`
+}
+
+// HSTSInfo is a parsed Strict-Transport-Security header.
+type HSTSInfo struct {
+	Raw               string `json:"raw"`
+	MaxAge            int64  `json:"maxAge"`
+	IncludeSubDomains bool   `json:"includeSubDomains"`
+	Preload           bool   `json:"preload"`
+}
+
+// fetchSecurityHeaders sends a GET / over the established TLS connection and
+// reads the security headers of the response. Errors are reported in the
+// result rather than failing the certificate check.
+func fetchSecurityHeaders(conn *tls.Conn, domain string) *SecurityHeaders {
+	result := &SecurityHeaders{}
+
+	if err := conn.SetDeadline(time.Now().Add(headersTimeout)); err != nil {
+		result.Error = "HTTP request failed"
+		return result
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://"+domain+"/", nil)
+	if err != nil {
+		result.Error = "HTTP request failed"
+		return result
+	}
+	req.Header.Set("User-Agent", "bir")
+	req.Close = true
+
+	resp, err := roundTrip(conn, req)
+	if err != nil {
+		result.Error = fmt.Sprintf("HTTP request failed: %s", simplifyTLSError(err))
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.ContentSecurityPolicy = resp.Header.Get("Content-Security-Policy") != ""
+	result.XContentTypeOptions = strings.EqualFold(strings.TrimSpace(resp.Header.Get("X-Content-Type-Options")), "nosniff")
+
+	if hsts := resp.Header.Get("Strict-Transport-Security"); hsts != "" {
+		result.HSTS = parseHSTS(hsts)
+	}
+
+	return result
+}
+
+// roundTrip sends req over conn, in HTTP/2 when the server picked it with
+// ALPN.
+func roundTrip(conn *tls.Conn, req *http.Request) (*http.Response, error) {
+	if conn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		cc, err := new(http2.Transport).NewClientConn(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return cc.RoundTrip(req)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	return http.ReadResponse(bufio.NewReader(conn), req)
+}
+
+func parseHSTS(value string) *HSTSInfo {
+	info := &HSTSInfo{Raw: value}
+
+	for _, directive := range strings.Split(value, ";") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			if maxAge, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(arg), `"`), 10, 64); err == nil {
+				info.MaxAge = maxAge
+			}
+		case "includesubdomains":
+			info.IncludeSubDomains = true
+		case "preload":
+			info.Preload = true
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,176 @@
+package ssl
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/rytsh/bir/api/tools/proxy"
+)
+
+const (
+	// oneCRLURL serves Firefox's OneCRL blocklist of revoked (mostly
+	// intermediate) CA certificates. Chrome's CRLSet is only distributed
+	// through the browser component updater, so it isn't consulted.
+	oneCRLURL = "https://firefox.settings.services.mozilla.com/v1/buckets/security-state/collections/onecrl/records"
+	// oneCRLRefreshInterval is how long a downloaded copy is used before refreshing.
+	oneCRLRefreshInterval = 6 * time.Hour
+)
+
+type oneCRLRecord struct {
+	IssuerName   string `json:"issuerName"`
+	SerialNumber string `json:"serialNumber"`
+	Subject      string `json:"subject"`
+	PubKeyHash   string `json:"pubKeyHash"`
+}
+
+// oneCRLSet is an in-memory copy of OneCRL, downloaded on first use and
+// refreshed once it is older than oneCRLRefreshInterval.
+type oneCRLSet struct {
+	client    *http.Client
+	fetchedAt time.Time
+	// entries holds issuer+serial and subject+key-hash keys of revoked certs.
+	entries map[string]struct{}
+	mu      sync.Mutex
+	// refresh runs one download at a time, without holding mu.
+	refresh singleflight.Group
+}
+
+var oneCRL = &oneCRLSet{
+	client: &http.Client{Timeout: 15 * time.Second, Transport: proxy.Transport()},
+}
+
+// revoked reports whether any of certs is listed in OneCRL.
+func (s *oneCRLSet) revoked(ctx context.Context, certs []*x509.Certificate) (bool, error) {
+	entries, err := s.load(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, cert := range certs {
+		spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+		if _, ok := entries[issuerSerialKey(cert.RawIssuer, cert.SerialNumber.Bytes())]; ok {
+			return true, nil
+		}
+		if _, ok := entries[subjectKeyKey(cert.RawSubject, spkiHash[:])]; ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// load returns the current entries, refreshing them when stale. A stale copy
+// keeps being used if the refresh fails, until the next interval.
+func (s *oneCRLSet) load(ctx context.Context) (map[string]struct{}, error) {
+	s.mu.Lock()
+	entries, fetchedAt := s.entries, s.fetchedAt
+	s.mu.Unlock()
+
+	if entries != nil && time.Since(fetchedAt) < oneCRLRefreshInterval {
+		return entries, nil
+	}
+
+	result := s.refresh.DoChan("", func() (any, error) {
+		// Shared by every waiting request, so it mustn't end with the first;
+		// the client timeout bounds it
+		entries, err := s.fetch(context.WithoutCancel(ctx))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err != nil {
+			if s.entries != nil {
+				// retry at the next interval, not on every check
+				s.fetchedAt = time.Now()
+				return s.entries, nil
+			}
+			return nil, err
+		}
+
+		s.entries, s.fetchedAt = entries, time.Now()
+
+		return entries, nil
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(map[string]struct{}), nil
+	case <-ctx.Done():
+		if entries != nil {
+			return entries, nil
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *oneCRLSet) fetch(ctx context.Context) (map[string]struct{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oneCRLURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OneCRL returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data []oneCRLRecord `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode OneCRL: %w", err)
+	}
+
+	entries := make(map[string]struct{}, len(body.Data))
+	for _, record := range body.Data {
+		switch {
+		case record.IssuerName != "" && record.SerialNumber != "":
+			issuer, err1 := base64.StdEncoding.DecodeString(record.IssuerName)
+			serial, err2 := base64.StdEncoding.DecodeString(record.SerialNumber)
+			if err1 == nil && err2 == nil {
+				entries[issuerSerialKey(issuer, serial)] = struct{}{}
+			}
+		case record.Subject != "" && record.PubKeyHash != "":
+			subject, err1 := base64.StdEncoding.DecodeString(record.Subject)
+			keyHash, err2 := base64.StdEncoding.DecodeString(record.PubKeyHash)
+			if err1 == nil && err2 == nil {
+				entries[subjectKeyKey(subject, keyHash)] = struct{}{}
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+func issuerSerialKey(rawIssuer, serial []byte) string {
+	// OneCRL serials are DER integer contents, which may carry a leading
+	// zero byte that big.Int.Bytes() doesn't.
+	for len(serial) > 1 && serial[0] == 0 {
+		serial = serial[1:]
+	}
+
+	return "is:" + hex.EncodeToString(rawIssuer) + ":" + hex.EncodeToString(serial)
+}
+
+func subjectKeyKey(rawSubject, keyHash []byte) string {
+	return "sk:" + hex.EncodeToString(rawSubject) + ":" + hex.EncodeToString(keyHash)
+}
@@ -0,0 +1,186 @@
+package ssl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/proxy"
+)
+
+const (
+	// preloadURL serves the Chromium HSTS preload list, base64 encoded.
+	preloadURL = "https://chromium.googlesource.com/chromium/src/+/main/net/http/transport_security_state_static.json?format=TEXT"
+	// preloadRefreshInterval is how long a copy of the list is used before
+	// refreshing; it changes a few times a month.
+	preloadRefreshInterval = 24 * time.Hour
+	// preloadMaxSize caps the downloaded list, about 15MB decoded.
+	preloadMaxSize = 64 << 20
+)
+
+// HSTSPreload reports whether a domain is on the Chromium HSTS preload list
+// (preload=true), directly or through a parent whose entry includes its
+// subdomains.
+type HSTSPreload struct {
+	Preloaded bool          `json:"preloaded"`
+	Entry     *PreloadEntry `json:"entry,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// PreloadEntry is an entry of the preload list.
+type PreloadEntry struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy,omitempty"`
+	Mode              string `json:"mode"`
+	IncludeSubdomains bool   `json:"include_subdomains"`
+}
+
+// preloadList is an in-memory copy of the HSTS preload list: the bundled
+// file when there is one, refreshed from Chromium once older than
+// preloadRefreshInterval.
+type preloadList struct {
+	client *http.Client
+	url    string
+	// file is the copy bundled at build time, if any.
+	file      string
+	fetchedAt time.Time
+	entries   map[string]PreloadEntry
+	mu        sync.Mutex
+}
+
+func newPreloadList(file string) *preloadList {
+	return &preloadList{
+		client: &http.Client{Timeout: 30 * time.Second, Transport: proxy.Transport()},
+		url:    preloadURL,
+		file:   file,
+	}
+}
+
+// check looks domain up, then its parents, stopping at the first entry that
+// covers it, as browsers do.
+func (l *preloadList) check(ctx context.Context, domain string) *HSTSPreload {
+	entries, err := l.load(ctx)
+	if err != nil {
+		return &HSTSPreload{Error: "HSTS preload list unavailable"}
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(domain), ".")
+	for exact := true; name != ""; exact = false {
+		if entry, ok := entries[name]; ok && (exact || entry.IncludeSubdomains) {
+			return &HSTSPreload{Preloaded: true, Entry: &entry}
+		}
+
+		_, name, _ = strings.Cut(name, ".")
+	}
+
+	return &HSTSPreload{}
+}
+
+// load returns the current entries. The bundled file is read first; the list
+// is then refreshed when stale, a stale copy being kept if that fails.
+func (l *preloadList) load(ctx context.Context) (map[string]PreloadEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.entries == nil && l.file != "" {
+		if info, err := os.Stat(l.file); err == nil {
+			if entries, err := readPreloadFile(l.file); err == nil {
+				l.entries, l.fetchedAt = entries, info.ModTime()
+			}
+		}
+	}
+
+	if l.entries != nil && time.Since(l.fetchedAt) < preloadRefreshInterval {
+		return l.entries, nil
+	}
+
+	entries, err := l.fetch(ctx)
+	if err != nil {
+		if l.entries != nil {
+			// retry at the next interval, not on every check
+			l.fetchedAt = time.Now()
+			return l.entries, nil
+		}
+		return nil, err
+	}
+
+	l.entries = entries
+	l.fetchedAt = time.Now()
+
+	return l.entries, nil
+}
+
+func readPreloadFile(file string) (map[string]PreloadEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePreloadList(data)
+}
+
+func (l *preloadList) fetch(ctx context.Context) (map[string]PreloadEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HSTS preload list returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(base64.NewDecoder(base64.StdEncoding, resp.Body), preloadMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("decode HSTS preload list: %w", err)
+	}
+
+	return parsePreloadList(data)
+}
+
+// parsePreloadList parses the Chromium list, JSON with // comment lines,
+// keeping the HSTS entries; the others only pin keys.
+func parsePreloadList(data []byte) (map[string]PreloadEntry, error) {
+	var stripped bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); !bytes.HasPrefix(line, []byte("//")) {
+			stripped.Write(scanner.Bytes())
+			stripped.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Entries []PreloadEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(stripped.Bytes(), &list); err != nil {
+		return nil, fmt.Errorf("parse HSTS preload list: %w", err)
+	}
+
+	entries := make(map[string]PreloadEntry, len(list.Entries))
+	for _, entry := range list.Entries {
+		if entry.Mode == "force-https" {
+			entries[strings.ToLower(entry.Name)] = entry
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,308 @@
+package ssl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"hash"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/cryptobyte"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+// ticketWait is how long to read after the first handshake of a resumption
+// check, for the session tickets a TLS 1.3 server sends after it.
+const ticketWait = time.Second
+
+// Resumption methods.
+const (
+	ResumptionTicket = "ticket"
+	ResumptionPSK    = "psk"
+)
+
+// Resumption reports whether a second handshake resumed the session of the
+// first (resumption=true).
+type Resumption struct {
+	Supported bool `json:"supported"`
+	// Method is "ticket", a TLS 1.2 session ticket, or "psk", a TLS 1.3
+	// pre-shared key. Resumption by session ID isn't attempted.
+	Method string `json:"method,omitempty"`
+	// EarlyData is whether the TLS 1.3 session tickets allow 0-RTT data, up
+	// to MaxEarlyData bytes.
+	EarlyData    bool   `json:"earlyData"`
+	MaxEarlyData uint32 `json:"maxEarlyData,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// checkResumption handshakes with address twice, sharing a session cache, and
+// reports whether the second one resumed the first.
+func checkResumption(ctx context.Context, guard *netguard.Guard, network, address, starttls string, base *tls.Config, timeout time.Duration) *Resumption {
+	var (
+		keyLog   bytes.Buffer
+		recorder *recordConn
+	)
+
+	cfg := base.Clone()
+	cfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	cfg.KeyLogWriter = &keyLog
+
+	conn, err := dialTLSConn(ctx, guard, network, address, starttls, cfg, timeout, func(conn net.Conn) net.Conn {
+		recorder = &recordConn{Conn: conn}
+		return recorder
+	})
+	if err != nil {
+		return &Resumption{Error: "first handshake failed: " + simplifyTLSError(err)}
+	}
+
+	state := conn.ConnectionState()
+	if state.Version == tls.VersionTLS13 {
+		// tickets come after the handshake and are only read with data
+		_ = conn.SetReadDeadline(time.Now().Add(ticketWait))
+		_, _ = conn.Read(make([]byte, 1))
+	}
+	conn.Close()
+
+	resumption := &Resumption{}
+	if state.Version == tls.VersionTLS13 {
+		resumption.MaxEarlyData = maxEarlyData(recorder.bytes(), keyLog.Bytes(), state.CipherSuite)
+		resumption.EarlyData = resumption.MaxEarlyData > 0
+	}
+
+	cfg.KeyLogWriter = nil
+	conn, err = dialTLS(ctx, guard, network, address, starttls, cfg, timeout)
+	if err != nil {
+		resumption.Error = "second handshake failed: " + simplifyTLSError(err)
+		return resumption
+	}
+	defer conn.Close()
+
+	state = conn.ConnectionState()
+	if state.DidResume {
+		resumption.Supported = true
+		resumption.Method = ResumptionTicket
+		if state.Version == tls.VersionTLS13 {
+			resumption.Method = ResumptionPSK
+		}
+	}
+
+	return resumption
+}
+
+// recordConn keeps the bytes read from a connection, the TLS records the
+// server sent.
+type recordConn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	c.buf.Write(b[:n])
+	c.mu.Unlock()
+
+	return n, err
+}
+
+func (c *recordConn) bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.buf.Bytes()
+}
+
+// maxEarlyData returns the largest max_early_data_size of the TLS 1.3 session
+// tickets in records, the bytes the server sent. crypto/tls only reads the
+// early_data extension of tickets for QUIC, so the records are decrypted with
+// the server traffic secret of keyLog.
+func maxEarlyData(records, keyLog []byte, suite uint16) uint32 {
+	secret := serverTrafficSecret(keyLog)
+	if secret == nil {
+		return 0
+	}
+
+	aead, iv := trafficAEAD(suite, secret)
+	if aead == nil {
+		return 0
+	}
+
+	// The handshake records before the application data ones are under
+	// another key and fail to open; sequence numbers start at the first one
+	// that opens.
+	var (
+		handshake []byte
+		seq       uint64
+	)
+	for len(records) >= 5 {
+		header, length := records[:5], int(records[3])<<8|int(records[4])
+		if len(records) < 5+length {
+			break
+		}
+		payload := records[5 : 5+length]
+		records = records[5+length:]
+
+		if header[0] != 23 {
+			continue
+		}
+
+		nonce := bytes.Clone(iv)
+		for i := range 8 {
+			nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+		}
+		plaintext, err := aead.Open(nil, nonce, payload, header)
+		if err != nil {
+			if seq > 0 {
+				break
+			}
+			continue
+		}
+		seq++
+
+		plaintext = bytes.TrimRight(plaintext, "\x00")
+		if len(plaintext) > 0 && plaintext[len(plaintext)-1] == 22 {
+			handshake = append(handshake, plaintext[:len(plaintext)-1]...)
+		}
+	}
+
+	return ticketsMaxEarlyData(handshake)
+}
+
+// ticketsMaxEarlyData parses the NewSessionTicket messages of handshake.
+func ticketsMaxEarlyData(handshake []byte) uint32 {
+	var maxSize uint32
+
+	s := cryptobyte.String(handshake)
+	for !s.Empty() {
+		var (
+			msgType uint8
+			body    cryptobyte.String
+		)
+		if !s.ReadUint8(&msgType) || !s.ReadUint24LengthPrefixed(&body) {
+			break
+		}
+		if msgType != 4 {
+			continue
+		}
+
+		var (
+			lifetime, ageAdd uint32
+			nonce, ticket    cryptobyte.String
+			extensions       cryptobyte.String
+		)
+		if !body.ReadUint32(&lifetime) || !body.ReadUint32(&ageAdd) ||
+			!body.ReadUint8LengthPrefixed(&nonce) || !body.ReadUint16LengthPrefixed(&ticket) ||
+			!body.ReadUint16LengthPrefixed(&extensions) {
+			continue
+		}
+
+		for !extensions.Empty() {
+			var (
+				extType uint16
+				data    cryptobyte.String
+				size    uint32
+			)
+			if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&data) {
+				break
+			}
+			// early_data
+			if extType == 42 && data.ReadUint32(&size) {
+				maxSize = max(maxSize, size)
+			}
+		}
+	}
+
+	return maxSize
+}
+
+// serverTrafficSecret returns the first server application traffic secret
+// of keyLog, in the NSS key log format.
+func serverTrafficSecret(keyLog []byte) []byte {
+	scanner := bufio.NewScanner(bytes.NewReader(keyLog))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "SERVER_TRAFFIC_SECRET_0" {
+			secret, err := hex.DecodeString(fields[2])
+			if err == nil {
+				return secret
+			}
+		}
+	}
+
+	return nil
+}
+
+// trafficAEAD derives the record key and IV of a TLS 1.3 traffic secret
+// (RFC 8446, section 7.3).
+func trafficAEAD(suite uint16, secret []byte) (cipher.AEAD, []byte) {
+	var (
+		newHash func() hash.Hash
+		keyLen  int
+	)
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		newHash, keyLen = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		newHash, keyLen = sha512.New384, 32
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		newHash, keyLen = sha256.New, chacha20poly1305.KeySize
+	default:
+		return nil, nil
+	}
+
+	key, err := hkdf.Expand(newHash, secret, expandLabel("key", keyLen), keyLen)
+	if err != nil {
+		return nil, nil
+	}
+	iv, err := hkdf.Expand(newHash, secret, expandLabel("iv", 12), 12)
+	if err != nil {
+		return nil, nil
+	}
+
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, nil
+		}
+		return aead, iv
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil
+	}
+
+	return aead, iv
+}
+
+// expandLabel is the HkdfLabel info of HKDF-Expand-Label, with an empty
+// context.
+func expandLabel(label string, length int) string {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8LengthPrefixed(func(*cryptobyte.Builder) {})
+
+	return string(b.BytesOrPanic())
+}
@@ -0,0 +1,85 @@
+package ssl
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+)
+
+// Ways the requested hostname is covered by the certificate.
+const (
+	MatchExact    = "exact"
+	MatchWildcard = "wildcard"
+	MatchNone     = "none"
+)
+
+// SubjectAltNames holds the subject alternative names of a certificate by
+// type.
+type SubjectAltNames struct {
+	DNS    []DNSName `json:"dns"`
+	IPs    []string  `json:"ips"`
+	Emails []string  `json:"emails"`
+	URIs   []string  `json:"uris"`
+}
+
+// DNSName is a DNS SAN. Wildcard is set for *.example.com, which covers one
+// label under example.com.
+type DNSName struct {
+	Name     string `json:"name"`
+	Wildcard bool   `json:"wildcard"`
+}
+
+// subjectAltNames breaks the SANs of cert down by type.
+func subjectAltNames(cert *x509.Certificate) SubjectAltNames {
+	sans := SubjectAltNames{
+		DNS:    make([]DNSName, len(cert.DNSNames)),
+		IPs:    make([]string, len(cert.IPAddresses)),
+		Emails: make([]string, len(cert.EmailAddresses)),
+		URIs:   make([]string, len(cert.URIs)),
+	}
+	for i, name := range cert.DNSNames {
+		sans.DNS[i] = DNSName{Name: name, Wildcard: strings.HasPrefix(name, "*.")}
+	}
+	for i, ip := range cert.IPAddresses {
+		sans.IPs[i] = ip.String()
+	}
+	copy(sans.Emails, cert.EmailAddresses)
+	for i, uri := range cert.URIs {
+		sans.URIs[i] = uri.String()
+	}
+
+	return sans
+}
+
+// hostnameMatch returns how cert covers hostname, a name or IP, and the SAN
+// covering it: an exact SAN is preferred over a wildcard one. Wildcards only
+// stand for the leftmost label, as in certificate verification.
+func hostnameMatch(cert *x509.Certificate, hostname string) (string, string) {
+	if ip := net.ParseIP(hostname); ip != nil {
+		for _, candidate := range cert.IPAddresses {
+			if candidate.Equal(ip) {
+				return MatchExact, candidate.String()
+			}
+		}
+		return MatchNone, ""
+	}
+
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, name := range cert.DNSNames {
+		if strings.ToLower(strings.TrimSuffix(name, ".")) == hostname {
+			return MatchExact, name
+		}
+	}
+
+	_, parent, ok := strings.Cut(hostname, ".")
+	if !ok || parent == "" {
+		return MatchNone, ""
+	}
+	for _, name := range cert.DNSNames {
+		if suffix, ok := strings.CutPrefix(strings.ToLower(strings.TrimSuffix(name, ".")), "*."); ok && suffix == parent {
+			return MatchWildcard, name
+		}
+	}
+
+	return MatchNone, ""
+}
@@ -0,0 +1,141 @@
+package ssl
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/netguard"
+)
+
+const (
+	// scanProbeTimeout bounds a single handshake probe of a scan.
+	scanProbeTimeout = 5 * time.Second
+	// scanConcurrency caps the handshake probes running at once per scan.
+	scanConcurrency = 8
+)
+
+// TLSScan lists the protocol versions and TLS 1.2 cipher suites a server
+// accepts (scan=true).
+type TLSScan struct {
+	Protocols    []ProtocolSupport `json:"protocols"`
+	CipherSuites []CipherSupport   `json:"cipherSuites"`
+	Warnings     []string          `json:"warnings,omitempty"`
+}
+
+type ProtocolSupport struct {
+	Version   string `json:"version"`
+	Supported bool   `json:"supported"`
+}
+
+// CipherSupport is a TLS 1.2 cipher suite accepted by the server.
+type CipherSupport struct {
+	Name string `json:"name"`
+	Weak bool   `json:"weak"`
+}
+
+var scanVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// scanTLS probes address with one handshake per protocol version and per
+// TLS 1.2 cipher suite, running the probes concurrently. serverName is sent as
+// SNI, none when empty. Cancelling ctx aborts the remaining probes.
+func scanTLS(ctx context.Context, guard *netguard.Guard, network, address, serverName, starttls string) *TLSScan {
+	scan := &TLSScan{
+		Protocols: make([]ProtocolSupport, len(scanVersions)),
+	}
+
+	var suites []*tls.CipherSuite
+	for _, suite := range slices.Concat(tls.CipherSuites(), tls.InsecureCipherSuites()) {
+		if slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			suites = append(suites, suite)
+		}
+	}
+	accepted := make([]bool, len(suites))
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, scanConcurrency)
+	)
+
+	probe := func(cfg *tls.Config, ok *bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			cfg.InsecureSkipVerify = true
+			cfg.ServerName = serverName
+
+			conn, err := dialTLS(ctx, guard, network, address, starttls, cfg, scanProbeTimeout)
+			if err != nil {
+				return
+			}
+			conn.Close()
+
+			*ok = true
+		}()
+	}
+
+	for i, version := range scanVersions {
+		scan.Protocols[i].Version = tlsVersionString(version)
+		probe(&tls.Config{MinVersion: version, MaxVersion: version}, &scan.Protocols[i].Supported)
+	}
+
+	for i, suite := range suites {
+		probe(&tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{suite.ID},
+		}, &accepted[i])
+	}
+
+	wg.Wait()
+
+	for _, protocol := range scan.Protocols {
+		if protocol.Supported && (protocol.Version == "TLS 1.0" || protocol.Version == "TLS 1.1") {
+			scan.Warnings = append(scan.Warnings, fmt.Sprintf("deprecated protocol %s is accepted", protocol.Version))
+		}
+	}
+
+	scan.CipherSuites = make([]CipherSupport, 0)
+	for i, suite := range suites {
+		if !accepted[i] {
+			continue
+		}
+
+		weak := isWeakCipherSuite(suite)
+		scan.CipherSuites = append(scan.CipherSuites, CipherSupport{Name: suite.Name, Weak: weak})
+		if weak {
+			scan.Warnings = append(scan.Warnings, fmt.Sprintf("weak cipher suite %s is accepted", suite.Name))
+		}
+	}
+
+	return scan
+}
+
+// pinnedAddress returns the IP and port conn is connected to, for the probes
+// of a scan to dial without resolving address again. A TLS connection can't
+// be reused for a handshake with other versions or cipher suites, so each
+// probe still opens its own. address is returned when conn isn't TCP.
+func pinnedAddress(conn net.Conn, address string) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.String()
+	}
+
+	return address
+}
+
+// isWeakCipherSuite reports whether suite uses RC4, 3DES or CBC mode.
+func isWeakCipherSuite(suite *tls.CipherSuite) bool {
+	return suite.Insecure ||
+		strings.Contains(suite.Name, "RC4") ||
+		strings.Contains(suite.Name, "3DES") ||
+		strings.Contains(suite.Name, "CBC")
+}
@@ -1,16 +1,32 @@
 package ssl
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/proxy"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/timeout"
 )
 
 type CertificateInfo struct {
@@ -23,6 +39,11 @@ type CertificateInfo struct {
 	SerialNumber       string   `json:"serialNumber"`
 	SignatureAlgorithm string   `json:"signatureAlgorithm"`
 	PublicKeyAlgorithm string   `json:"publicKeyAlgorithm"`
+	SPKIPin            string   `json:"spkiPin"`
+	SHA256Fingerprint  string   `json:"sha256Fingerprint"`
+	SHA1Fingerprint    string   `json:"sha1Fingerprint"`
+	SubjectKeyID       string   `json:"subjectKeyId,omitempty"`
+	AuthorityKeyID     string   `json:"authorityKeyId,omitempty"`
 	SANs               []string `json:"sans"`
 	DNSNames           []string `json:"dnsNames"`
 	IPAddresses        []string `json:"ipAddresses"`
@@ -30,157 +51,719 @@ type CertificateInfo struct {
 	IsCA               bool     `json:"isCA"`
 	Version            int      `json:"version"`
 	PEM                string   `json:"pem,omitempty"`
+	// SubjectAltNames breaks the SANs down by type; SANs only has the DNS
+	// names and IPs.
+	SubjectAltNames SubjectAltNames `json:"subjectAltNames"`
 }
 
 type ChainCertificate struct {
-	Subject   string `json:"subject"`
-	Issuer    string `json:"issuer"`
-	NotBefore string `json:"notBefore"`
-	NotAfter  string `json:"notAfter"`
-	IsCA      bool   `json:"isCA"`
-	PEM       string `json:"pem,omitempty"`
+	Subject           string `json:"subject"`
+	Issuer            string `json:"issuer"`
+	NotBefore         string `json:"notBefore"`
+	NotAfter          string `json:"notAfter"`
+	IsCA              bool   `json:"isCA"`
+	SHA256Fingerprint string `json:"sha256Fingerprint"`
+	SHA1Fingerprint   string `json:"sha1Fingerprint"`
+	SubjectKeyID      string `json:"subjectKeyId,omitempty"`
+	AuthorityKeyID    string `json:"authorityKeyId,omitempty"`
+	// TimeValid is false while the certificate is expired or not yet valid;
+	// ExpiryStatus and DaysUntilExpiry are its own, as for the leaf.
+	TimeValid       bool   `json:"timeValid"`
+	DaysUntilExpiry int    `json:"daysUntilExpiry"`
+	ExpiryStatus    string `json:"expiryStatus"`
+	// WeakKey flags an RSA key under 2048 bits, WeakSignature a signature
+	// over SHA-1 or MD5. Self-signed certificates aren't checked for the
+	// latter, their signature isn't relied on.
+	WeakKey       bool   `json:"weakKey"`
+	WeakSignature bool   `json:"weakSignature"`
+	PEM           string `json:"pem,omitempty"`
 }
 
 type SSLResponse struct {
-	Domain          string             `json:"domain"`
-	Port            int                `json:"port"`
-	Certificate     *CertificateInfo   `json:"certificate,omitempty"`
-	Chain           []ChainCertificate `json:"chain,omitempty"`
-	Protocol        string             `json:"protocol"`
-	CipherSuite     string             `json:"cipherSuite"`
-	Valid           bool               `json:"valid"`
-	DaysUntilExpiry int                `json:"daysUntilExpiry"`
-	Expired         bool               `json:"expired"`
-	Error           string             `json:"error,omitempty"`
+	Domain        string `json:"domain"`
+	UnicodeDomain string `json:"unicodeDomain,omitempty"`
+	Port          int    `json:"port"`
+	StartTLS      string `json:"starttls,omitempty"`
+	// SNI is the server name sent in the handshake, empty when none was, and
+	// IP the address connected to. ALPN are the application protocols
+	// offered, and NegotiatedProtocol the one the server selected
+	// (ALPNNegotiated) if any.
+	SNI                    string             `json:"sni"`
+	IP                     string             `json:"ip,omitempty"`
+	ALPN                   []string           `json:"alpn,omitempty"`
+	NegotiatedProtocol     string             `json:"negotiatedProtocol,omitempty"`
+	ALPNNegotiated         bool               `json:"alpnNegotiated"`
+	Certificate            *CertificateInfo   `json:"certificate,omitempty"`
+	Chain                  []ChainCertificate `json:"chain,omitempty"`
+	Protocol               string             `json:"protocol"`
+	CipherSuite            string             `json:"cipherSuite"`
+	Valid                  bool               `json:"valid"`
+	HostnameMatch          string             `json:"hostnameMatch,omitempty"`
+	MatchedSAN             string             `json:"matchedSan,omitempty"`
+	ChainValid             bool               `json:"chainValid"`
+	ChainError             string             `json:"chainError,omitempty"`
+	ChainIssues            []string           `json:"chainIssues,omitempty"`
+	DaysUntilExpiry        int                `json:"daysUntilExpiry"`
+	Expired                bool               `json:"expired"`
+	NotYetValid            bool               `json:"notYetValid"`
+	DaysUntilValid         int                `json:"daysUntilValid,omitempty"`
+	ExpiryStatus           string             `json:"expiryStatus,omitempty"`
+	ClientAuth             *ClientAuth        `json:"clientAuth,omitempty"`
+	Scan                   *TLSScan           `json:"scan,omitempty"`
+	Resumption             *Resumption        `json:"resumption,omitempty"`
+	SecurityHeaders        *SecurityHeaders   `json:"securityHeaders,omitempty"`
+	HSTSPreload            *HSTSPreload       `json:"hstsPreload,omitempty"`
+	BrowserRevoked         *bool              `json:"browserRevoked,omitempty"`
+	BrowserRevocationError string             `json:"browserRevocationError,omitempty"`
+	Cached                 bool               `json:"cached,omitempty"`
+	CachedAt               string             `json:"cachedAt,omitempty"`
+	Error                  string             `json:"error,omitempty"`
+}
+
+// Options select the optional checks of Inspect.
+type Options struct {
+	// StartTLS upgrades a plaintext protocol (smtp, imap, pop3 or ftp)
+	// before the handshake.
+	StartTLS string
+	// SNI overrides the server name sent in the handshake, which defaults to
+	// the domain. SNINone sends no server name, to get the default
+	// certificate.
+	SNI string
+	// ALPN overrides the application protocols offered in the handshake,
+	// which default to h2 and http/1.1 (none with STARTTLS). An empty,
+	// non-nil list offers none.
+	ALPN []string
+	// IPVersion forces connecting over IPv4 (4) or IPv6 (6); zero uses
+	// either.
+	IPVersion int
+	// Browser checks the certificates against the browser revocation list.
+	Browser bool
+	// Scan probes the accepted protocol versions and cipher suites.
+	Scan bool
+	// Resumption handshakes a second time to check session resumption.
+	Resumption bool
+	// Headers fetches the HTTP security headers.
+	Headers bool
+	// Preload checks the domain against the HSTS preload list.
+	Preload bool
+	// WarnDays and CritDays override the configured expiry thresholds when
+	// set.
+	WarnDays int
+	CritDays int
+	// ClientCertificate is presented when the server requests a client
+	// certificate (mTLS).
+	ClientCertificate *tls.Certificate
+	// Timeout overrides the configured connection timeout when set.
+	Timeout time.Duration
+}
+
+// Config holds the SSL handler configuration, loaded from env via chu.
+type Config struct {
+	// WarnDays and CritDays are the default expiry thresholds: a certificate
+	// expiring in fewer days is reported as "warning" or "critical".
+	WarnDays int `cfg:"warn_days" default:"30"`
+	CritDays int `cfg:"crit_days" default:"7"`
+	// CTURL is the crt.sh compatible Certificate Transparency log search.
+	CTURL string `cfg:"ct_url" default:"https://crt.sh/"`
+	// CTTimeout bounds a CT log search; crt.sh is slow for large domains.
+	CTTimeout time.Duration `cfg:"ct_timeout" default:"20s"`
+	// Timeout bounds connecting to the server and the handshake, 15s when
+	// unset. Requests may override it with the timeout parameter.
+	Timeout time.Duration `cfg:"timeout"`
+	// BatchTimeout bounds the check of each target of POST /ssl/batch.
+	BatchTimeout time.Duration `cfg:"batch_timeout" default:"10s"`
+	// HSTSPreloadFile is a copy of the Chromium HSTS preload list bundled at
+	// build time, used until a fresher one is downloaded.
+	HSTSPreloadFile string `cfg:"hsts_preload_file"`
+	// CacheTTL is how long a successful check of GET /ssl is served from
+	// memory; 0, the default, checks every time.
+	CacheTTL time.Duration `cfg:"cache_ttl"`
+	// CacheSize caps the number of cached checks (least recently used are
+	// evicted first).
+	CacheSize int `cfg:"cache_size" default:"1000"`
+	// CacheCompress keeps the certificate PEMs of cached checks deflated, for
+	// less memory at some CPU cost on every hit.
+	CacheCompress bool `cfg:"cache_compress"`
+}
+
+// Handler serves the SSL endpoint.
+type Handler struct {
+	cfg     Config
+	guard   *netguard.Guard
+	preload *preloadList
+	// cache is nil when caching is off.
+	cache *cache
+}
+
+// New builds an SSL Handler from the given config. A non-nil guard refuses
+// targets on internal addresses.
+func New(cfg Config, guard *netguard.Guard) *Handler {
+	h := &Handler{cfg: cfg, guard: guard, preload: newPreloadList(cfg.HSTSPreloadFile)}
+	if cfg.CacheTTL > 0 {
+		h.cache = newCache(cfg.CacheTTL, cfg.CacheSize, cfg.CacheCompress)
+	}
+
+	return h
 }
 
 // SSL handles SSL/TLS certificate checking requests
-func SSL(c *ada.Context) error {
+func (h *Handler) SSL(c *ada.Context) error {
 	domain := strings.TrimSpace(c.Request.URL.Query().Get("domain"))
 	portStr := strings.TrimSpace(c.Request.URL.Query().Get("port"))
+	opts := Options{
+		StartTLS:   strings.ToLower(strings.TrimSpace(c.Request.URL.Query().Get("starttls"))),
+		Browser:    c.Request.URL.Query().Get("browser") == "true",
+		Scan:       c.Request.URL.Query().Get("scan") == "true",
+		Headers:    c.Request.URL.Query().Get("headers") == "true",
+		Preload:    c.Request.URL.Query().Get("preload") == "true",
+		Resumption: c.Request.URL.Query().Get("resumption") == "true",
+	}
 
-	if domain == "" {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(SSLResponse{Error: "domain parameter is required"})
+	// An empty sni= also omits it, unlike a missing one
+	if c.Request.URL.Query().Has("sni") {
+		opts.SNI = strings.TrimSpace(c.Request.URL.Query().Get("sni"))
+		if opts.SNI == "" {
+			opts.SNI = SNINone
+		}
 	}
 
-	// Clean domain
-	domain = cleanDomain(domain)
+	// Likewise an empty alpn= offers no protocols
+	if c.Request.URL.Query().Has("alpn") {
+		opts.ALPN = parseALPN(c.Request.URL.Query().Get("alpn"))
+	}
 
-	if !isValidDomain(domain) {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(SSLResponse{Error: "invalid domain format"})
+	if domain == "" {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "domain parameter is required"})
+	}
+
+	var err error
+	if opts.IPVersion, err = parseIPVersion(c.Request.URL.Query().Get("ipv")); err != nil {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: err.Error()})
+	}
+	if opts.Timeout, err = timeout.FromQuery(c.Request.URL.Query()); err != nil {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: err.Error()})
+	}
+	if opts.WarnDays, err = parseDays(c.Request.URL.Query().Get("warnDays")); err != nil {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "invalid warnDays"})
+	}
+	if opts.CritDays, err = parseDays(c.Request.URL.Query().Get("critDays")); err != nil {
+		return render.Send(c, http.StatusBadRequest, SSLResponse{Error: "invalid critDays"})
 	}
 
-	// Parse port
-	port := 443
+	port := 0
 	if portStr != "" {
 		var err error
 		port, err = strconv.Atoi(portStr)
-		if err != nil || port < 1 || port > 65535 {
-			return c.SetStatus(http.StatusBadRequest).SendJSON(SSLResponse{Error: "invalid port number"})
+		if err != nil || port < 1 {
+			return render.Send(c, http.StatusBadRequest, SSLResponse{Error: errInvalidPort.Error()})
+		}
+	}
+
+	noCache := c.Request.URL.Query().Get("nocache") == "true"
+	response, err := h.cachedInspect(c.Request.Context(), domain, port, opts, noCache)
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), SSLResponse{Error: err.Error()})
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// cachedInspect returns the cached response of the check when caching is on,
+// or runs Inspect and caches its response. noCache skips the cached one.
+func (h *Handler) cachedInspect(ctx context.Context, domain string, port int, opts Options, noCache bool) (SSLResponse, error) {
+	if h.cache == nil || opts.ClientCertificate != nil {
+		return h.Inspect(ctx, domain, port, opts)
+	}
+
+	key := cacheKey(domain, port, opts)
+	if !noCache {
+		response, storedAt, ok := h.cache.get(key)
+		metrics.SSLCache(ok)
+		if ok {
+			response.Cached = true
+			response.CachedAt = storedAt.UTC().Format(time.RFC3339)
+			return response, nil
+		}
+	}
+
+	response, err := h.Inspect(ctx, domain, port, opts)
+	// Only cache completed checks; failed handshakes should be retried
+	if err == nil && response.Error == "" {
+		h.cache.set(key, response)
+	}
+
+	return response, err
+}
+
+// Errors returned by Inspect for invalid input
+var (
+	errInvalidDomain   = errors.New("invalid domain format")
+	errInvalidPort     = errors.New("invalid port number")
+	errInvalidStartTLS = errors.New("invalid starttls protocol, expected smtp, imap, pop3 or ftp")
+	errInvalidDays     = errors.New("critDays must not exceed warnDays")
+	errInvalidSNI      = errors.New("invalid sni, expected a domain or none")
+	errInvalidALPN     = errors.New("invalid alpn, expected a comma separated list of protocols")
+	errInvalidIPV      = errors.New("invalid ipv, expected 4 or 6")
+)
+
+// SNINone is the Options.SNI value that omits the server name.
+const SNINone = "none"
+
+// defaultALPN are the application protocols offered to HTTPS servers, as a
+// browser would.
+var defaultALPN = []string{"h2", "http/1.1"}
+
+// maxALPN caps the protocols of an ALPN override.
+const maxALPN = 16
+
+// Inspect connects to domain on port and reports its certificate and chain.
+// A zero port defaults to 443, or to the STARTTLS protocol's port. The error
+// reports invalid input; connection failures are part of the response.
+func (h *Handler) Inspect(ctx context.Context, domain string, port int, opts Options) (SSLResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return SSLResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return SSLResponse{}, errInvalidDomain
+	}
+
+	if opts.WarnDays == 0 {
+		opts.WarnDays = h.cfg.WarnDays
+	}
+	if opts.CritDays == 0 {
+		// A lower warning threshold pulls the default critical one along
+		opts.CritDays = min(h.cfg.CritDays, opts.WarnDays)
+	}
+	if opts.CritDays > opts.WarnDays {
+		return SSLResponse{}, errInvalidDays
+	}
+
+	starttls := opts.StartTLS
+	if _, ok := starttlsPorts[starttls]; starttls != "" && !ok {
+		return SSLResponse{}, errInvalidStartTLS
+	}
+
+	// Default to the STARTTLS protocol's port
+	if port == 0 {
+		port = 443
+		if starttls != "" {
+			port = starttlsPorts[starttls]
 		}
 	}
+	if port < 1 || port > 65535 {
+		return SSLResponse{}, errInvalidPort
+	}
+
+	serverName, err := sniName(domain, opts.SNI)
+	if err != nil {
+		return SSLResponse{}, err
+	}
+
+	alpn, err := alpnProtocols(opts.ALPN, starttls)
+	if err != nil {
+		return SSLResponse{}, err
+	}
+
+	network := "tcp"
+	switch opts.IPVersion {
+	case 0:
+	case 4, 6:
+		network += strconv.Itoa(opts.IPVersion)
+	default:
+		return SSLResponse{}, errInvalidIPV
+	}
+
+	release, err := outbound.Acquire(ctx)
+	if err != nil {
+		return SSLResponse{}, err
+	}
+	defer release()
+
+	// Refuse internal targets before dialing; the dialer checks again on the
+	// address it connects to. Resolution errors are reported by the dial.
+	if err := h.guard.Check(ctx, domain); errors.Is(err, netguard.ErrBlocked) {
+		return SSLResponse{}, err
+	}
+
+	// The handshake also ends with ctx, when the client goes away
+	dialTimeout := cmp.Or(opts.Timeout, h.cfg.Timeout, handshakeTimeout)
 
 	// Connect and get certificate
 	address := fmt.Sprintf("%s:%d", domain, port)
 
-	dialer := &net.Dialer{
-		Timeout: 10 * time.Second,
+	auth := &clientAuth{cert: opts.ClientCertificate}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify:   true, // We want to inspect even invalid certs
+		ServerName:           serverName,
+		NextProtos:           alpn,
+		GetClientCertificate: auth.getClientCertificate,
 	}
-
-	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
-		InsecureSkipVerify: true, // We want to inspect even invalid certs
-		ServerName:         domain,
-	})
+	conn, err := dialTLS(ctx, h.guard, network, address, starttls, tlsConfig, dialTimeout)
 	if err != nil {
-		return c.SetStatus(http.StatusOK).SendJSON(SSLResponse{
-			Domain: domain,
-			Port:   port,
-			Valid:  false,
-			Error:  fmt.Sprintf("connection failed: %s", simplifyTLSError(err)),
-		})
+		metrics.UpstreamFailure("tls")
+
+		message := fmt.Sprintf("connection failed: %s", simplifyTLSError(err))
+		var addrErr *net.AddrError
+		if errors.As(err, &addrErr) && addrErr.Err == "no suitable address found" {
+			message = fmt.Sprintf("%s has no IPv%d address", domain, opts.IPVersion)
+		}
+
+		return SSLResponse{
+			Domain:        domain,
+			UnicodeDomain: idn.ToUnicode(domain),
+			Port:          port,
+			StartTLS:      starttls,
+			SNI:           sentSNI(serverName),
+			ALPN:          alpn,
+			Valid:         false,
+			ClientAuth:    auth.report(err),
+			Error:         message,
+		}, nil
 	}
 	defer conn.Close()
 
+	var clientAuthErr error
+	if auth.requested {
+		clientAuthErr = confirmClientAuth(conn)
+	}
+
 	state := conn.ConnectionState()
 
 	if len(state.PeerCertificates) == 0 {
-		return c.SetStatus(http.StatusOK).SendJSON(SSLResponse{
-			Domain: domain,
-			Port:   port,
-			Valid:  false,
-			Error:  "no certificates received",
-		})
+		return SSLResponse{
+			Domain:        domain,
+			UnicodeDomain: idn.ToUnicode(domain),
+			Port:          port,
+			SNI:           sentSNI(serverName),
+			IP:            remoteIP(conn),
+			ALPN:          alpn,
+			Valid:         false,
+			Error:         "no certificates received",
+		}, nil
 	}
 
 	// Get the leaf certificate
 	leafCert := state.PeerCertificates[0]
 
-	// Calculate days until expiry
+	// Where the validity window stands: expired or not yet started
 	now := time.Now()
-	daysUntilExpiry := int(leafCert.NotAfter.Sub(now).Hours() / 24)
-	expired := now.After(leafCert.NotAfter) || now.Before(leafCert.NotBefore)
+	window := validityAt(leafCert, now)
+
+	// Check if certificate is valid for the requested name: the virtual host
+	// given as SNI, or the domain, and for now
+	hostname := cmp.Or(serverName, domain)
+	valid := leafCert.VerifyHostname(hostname) == nil && window.valid()
+
+	certInfo := certificateInfo(leafCert)
+	match, matchedSAN := hostnameMatch(leafCert, hostname)
 
-	// Check if certificate is valid for this domain
-	valid := leafCert.VerifyHostname(domain) == nil && !expired
+	// Build certificate chain
+	chain := make([]ChainCertificate, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		chain = append(chain, chainCertificate(cert, now, opts.WarnDays, opts.CritDays))
+	}
+
+	// Verify the chain against the system roots separately from the insecure
+	// dial, which accepts any certificate so it can be inspected
+	chainErr := verifyChain(state.PeerCertificates)
+
+	response := SSLResponse{
+		Domain:             domain,
+		UnicodeDomain:      idn.ToUnicode(domain),
+		Port:               port,
+		StartTLS:           starttls,
+		SNI:                sentSNI(serverName),
+		IP:                 remoteIP(conn),
+		ALPN:               alpn,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ALPNNegotiated:     state.NegotiatedProtocol != "",
+		Certificate:        certInfo,
+		Chain:              chain,
+		Protocol:           tlsVersionString(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		Valid:              valid,
+		HostnameMatch:      match,
+		MatchedSAN:         matchedSAN,
+		ChainValid:         chainErr == nil,
+		DaysUntilExpiry:    window.daysUntilExpiry,
+		Expired:            window.expired,
+		NotYetValid:        window.notYetValid,
+		DaysUntilValid:     window.daysUntilValid,
+		ExpiryStatus:       window.status(opts.WarnDays, opts.CritDays),
+		ClientAuth:         auth.report(clientAuthErr),
+	}
+
+	if chainErr != nil {
+		response.ChainError = simplifyVerifyError(chainErr)
+	}
+	response.ChainIssues = chainIssues(state.PeerCertificates, chainErr)
+
+	// HTTP headers are only meaningful on a direct HTTPS connection
+	if opts.Headers && starttls == "" {
+		response.SecurityHeaders = fetchSecurityHeaders(conn, hostname)
+	}
+
+	// The preload list only has names
+	if opts.Preload && net.ParseIP(hostname) == nil {
+		response.HSTSPreload = h.preload.check(ctx, hostname)
+	}
 
-	// Build certificate info
+	// The follow-up handshakes go to the address of this one: no lookup each,
+	// and the same server of a round-robin name
+	probeAddress := pinnedAddress(conn, address)
+
+	if opts.Scan {
+		response.Scan = scanTLS(ctx, h.guard, network, probeAddress, serverName, starttls)
+	}
+
+	if opts.Resumption {
+		// a fresh clientAuth, the probes don't change what was reported
+		probeConfig := tlsConfig.Clone()
+		probeConfig.GetClientCertificate = (&clientAuth{cert: opts.ClientCertificate}).getClientCertificate
+		response.Resumption = checkResumption(ctx, h.guard, network, probeAddress, starttls, probeConfig, dialTimeout)
+	}
+
+	// Browser blocklists catch revocations that OCSP/CRL may miss
+	if opts.Browser {
+		revoked, err := oneCRL.revoked(ctx, state.PeerCertificates)
+		if err != nil {
+			response.BrowserRevocationError = "browser revocation list unavailable"
+		} else {
+			response.BrowserRevoked = &revoked
+		}
+	}
+
+	return response, nil
+}
+
+// certificateInfo returns the details of cert.
+func certificateInfo(cert *x509.Certificate) *CertificateInfo {
 	certInfo := &CertificateInfo{
-		Subject:            leafCert.Subject.String(),
-		CommonName:         leafCert.Subject.CommonName,
-		Issuer:             leafCert.Issuer.String(),
-		IssuerOrg:          getFirstOrEmpty(leafCert.Issuer.Organization),
-		NotBefore:          leafCert.NotBefore.UTC().Format(time.RFC3339),
-		NotAfter:           leafCert.NotAfter.UTC().Format(time.RFC3339),
-		SerialNumber:       leafCert.SerialNumber.String(),
-		SignatureAlgorithm: leafCert.SignatureAlgorithm.String(),
-		PublicKeyAlgorithm: leafCert.PublicKeyAlgorithm.String(),
-		DNSNames:           leafCert.DNSNames,
-		EmailAddresses:     leafCert.EmailAddresses,
-		IsCA:               leafCert.IsCA,
-		Version:            leafCert.Version,
+		Subject:            cert.Subject.String(),
+		CommonName:         cert.Subject.CommonName,
+		Issuer:             cert.Issuer.String(),
+		IssuerOrg:          getFirstOrEmpty(cert.Issuer.Organization),
+		NotBefore:          cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:           cert.NotAfter.UTC().Format(time.RFC3339),
+		SerialNumber:       cert.SerialNumber.String(),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm.String(),
+		SPKIPin:            spkiPin(cert.RawSubjectPublicKeyInfo),
+		SHA256Fingerprint:  sha256Fingerprint(cert.Raw),
+		SHA1Fingerprint:    sha1Fingerprint(cert.Raw),
+		SubjectKeyID:       colonHex(cert.SubjectKeyId),
+		AuthorityKeyID:     colonHex(cert.AuthorityKeyId),
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		IsCA:               cert.IsCA,
+		Version:            cert.Version,
 	}
 
 	// IP addresses as strings
-	certInfo.IPAddresses = make([]string, len(leafCert.IPAddresses))
-	for i, ip := range leafCert.IPAddresses {
+	certInfo.IPAddresses = make([]string, len(cert.IPAddresses))
+	for i, ip := range cert.IPAddresses {
 		certInfo.IPAddresses[i] = ip.String()
 	}
 
 	// Build SANs list (combined DNS names and IPs)
-	certInfo.SANs = append(certInfo.DNSNames, certInfo.IPAddresses...)
+	certInfo.SANs = slices.Concat(certInfo.DNSNames, certInfo.IPAddresses)
+	certInfo.SubjectAltNames = subjectAltNames(cert)
 
-	// Encode leaf certificate as PEM
-	certInfo.PEM = encodeCertToPEM(leafCert.Raw)
+	// Encode certificate as PEM
+	certInfo.PEM = encodeCertToPEM(cert.Raw)
 
-	// Build certificate chain
-	chain := make([]ChainCertificate, 0, len(state.PeerCertificates))
-	for _, cert := range state.PeerCertificates {
-		chain = append(chain, ChainCertificate{
-			Subject:   cert.Subject.String(),
-			Issuer:    cert.Issuer.String(),
-			NotBefore: cert.NotBefore.UTC().Format(time.RFC3339),
-			NotAfter:  cert.NotAfter.UTC().Format(time.RFC3339),
-			IsCA:      cert.IsCA,
-			PEM:       encodeCertToPEM(cert.Raw),
-		})
+	return certInfo
+}
+
+// sniName returns the server name to send for domain: the domain itself, the
+// sni override, or none for SNINone.
+func sniName(domain, sni string) (string, error) {
+	switch strings.ToLower(sni) {
+	case "":
+		return domain, nil
+	case SNINone:
+		return "", nil
 	}
 
-	response := SSLResponse{
-		Domain:          domain,
-		Port:            port,
-		Certificate:     certInfo,
-		Chain:           chain,
-		Protocol:        tlsVersionString(state.Version),
-		CipherSuite:     tls.CipherSuiteName(state.CipherSuite),
-		Valid:           valid,
-		DaysUntilExpiry: daysUntilExpiry,
-		Expired:         expired,
-	}
-
-	return c.SetStatus(http.StatusOK).SendJSON(response)
+	name, err := cleanDomain(sni)
+	if err != nil {
+		return "", err
+	}
+	if !isValidDomain(name) || net.ParseIP(name) != nil {
+		return "", errInvalidSNI
+	}
+
+	return name, nil
+}
+
+// parseIPVersion parses the ipv param: empty, 4 or 6.
+func parseIPVersion(value string) (int, error) {
+	switch strings.TrimSpace(value) {
+	case "":
+		return 0, nil
+	case "4":
+		return 4, nil
+	case "6":
+		return 6, nil
+	default:
+		return 0, errInvalidIPV
+	}
+}
+
+// remoteIP returns the address conn is connected to.
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+
+	return ""
+}
+
+// parseALPN splits a comma separated alpn parameter; an empty one offers no
+// protocols.
+func parseALPN(value string) []string {
+	protocols := []string{}
+	for protocol := range strings.SplitSeq(value, ",") {
+		if protocol = strings.TrimSpace(protocol); protocol != "" {
+			protocols = append(protocols, protocol)
+		}
+	}
+
+	return protocols
+}
+
+// alpnProtocols returns the application protocols to offer: the override, or
+// defaultALPN for a direct TLS connection.
+func alpnProtocols(override []string, starttls string) ([]string, error) {
+	if override == nil {
+		if starttls != "" {
+			return nil, nil
+		}
+		return defaultALPN, nil
+	}
+
+	if len(override) > maxALPN {
+		return nil, errInvalidALPN
+	}
+	for _, protocol := range override {
+		// The handshake encodes each protocol with a one byte length
+		if protocol == "" || len(protocol) > 255 {
+			return nil, errInvalidALPN
+		}
+	}
+
+	return override, nil
+}
+
+// sentSNI returns the server name actually sent for serverName; crypto/tls
+// never sends an IP address.
+func sentSNI(serverName string) string {
+	if net.ParseIP(serverName) != nil {
+		return ""
+	}
+
+	return serverName
 }
 
-func cleanDomain(domain string) string {
+// verifyChain verifies the leaf of certs against the system root pool, using
+// the rest of certs as intermediates. The hostname is checked separately.
+func verifyChain(certs []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+func simplifyVerifyError(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "certificate signed by unknown authority or incomplete chain"
+	case errors.As(err, &invalid):
+		switch invalid.Reason {
+		case x509.Expired:
+			return "certificate in chain has expired or is not yet valid"
+		case x509.NotAuthorizedToSign:
+			return "certificate in chain is not authorized to sign other certificates"
+		}
+	}
+	return err.Error()
+}
+
+// handshakeTimeout bounds connecting plus the full handshake of a lookup when
+// no timeout is configured.
+const handshakeTimeout = 15 * time.Second
+
+// dialTLS connects to address and completes a TLS handshake with cfg, first
+// upgrading the connection with STARTTLS when a protocol is given. timeout
+// covers the whole exchange, including the plaintext negotiation, and
+// cancelling ctx aborts it. network is tcp, or tcp4 or tcp6 to force the
+// address family. A non-nil guard refuses internal addresses.
+func dialTLS(ctx context.Context, guard *netguard.Guard, network, address, starttls string, cfg *tls.Config, timeout time.Duration) (*tls.Conn, error) {
+	return dialTLSConn(ctx, guard, network, address, starttls, cfg, timeout, nil)
+}
+
+// dialTLSConn is dialTLS, running TLS over wrap(conn) when wrap is set.
+func dialTLSConn(ctx context.Context, guard *netguard.Guard, network, address, starttls string, cfg *tls.Config, timeout time.Duration, wrap func(net.Conn) net.Conn) (*tls.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rawConn, err := proxy.DialContext(ctx, &net.Dialer{}, guard, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := rawConn.SetDeadline(deadline); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	// The STARTTLS exchange doesn't take a context; unblock it when ctx is
+	// cancelled
+	stop := context.AfterFunc(ctx, func() {
+		_ = rawConn.SetDeadline(time.Now())
+	})
+
+	if starttls != "" {
+		if err := negotiateStartTLS(rawConn, starttls); err != nil {
+			rawConn.Close()
+			return nil, cmp.Or(ctx.Err(), err)
+		}
+	}
+
+	tlsConn := rawConn
+	if wrap != nil {
+		tlsConn = wrap(rawConn)
+	}
+
+	conn := tls.Client(tlsConn, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, cmp.Or(ctx.Err(), err)
+	}
+
+	// Cancelled right after the handshake; the deadline may already be set
+	if !stop() {
+		rawConn.Close()
+		return nil, ctx.Err()
+	}
+
+	// Clear the handshake deadline for the remaining requests on conn
+	_ = rawConn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func cleanDomain(domain string) (string, error) {
 	// Remove protocol
 	domain = strings.TrimPrefix(domain, "https://")
 	domain = strings.TrimPrefix(domain, "http://")
@@ -192,7 +775,8 @@ func cleanDomain(domain string) string {
 	if idx := strings.Index(domain, ":"); idx != -1 {
 		domain = domain[:idx]
 	}
-	return strings.ToLower(strings.TrimSpace(domain))
+	// Internationalized domains are dialed by their punycode form
+	return idn.ToASCII(strings.ToLower(strings.TrimSpace(domain)))
 }
 
 func isValidDomain(domain string) bool {
@@ -234,6 +818,9 @@ func getFirstOrEmpty(slice []string) string {
 }
 
 func simplifyTLSError(err error) string {
+	if errors.Is(err, netguard.ErrBlocked) {
+		return netguard.ErrBlocked.Error()
+	}
 	errStr := err.Error()
 	if strings.Contains(errStr, "connection refused") {
 		return "connection refused"
@@ -253,6 +840,42 @@ func simplifyTLSError(err error) string {
 	return errStr
 }
 
+// spkiPin returns the base64 SHA-256 hash of a SubjectPublicKeyInfo, the
+// value used by HPKP and key pinning ("pin-sha256").
+func spkiPin(rawSPKI []byte) string {
+	hash := sha256.Sum256(rawSPKI)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// sha256Fingerprint returns the SHA-256 fingerprint of a DER certificate.
+func sha256Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return colonHex(sum[:])
+}
+
+// sha1Fingerprint returns the SHA-1 fingerprint of a DER certificate.
+func sha1Fingerprint(der []byte) string {
+	sum := sha1.Sum(der)
+	return colonHex(sum[:])
+}
+
+// colonHex formats b as uppercase hex bytes separated by colons (AB:CD:...).
+func colonHex(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(b) * 3)
+	for i, v := range b {
+		if i > 0 {
+			sb.WriteByte(':')
+		}
+		fmt.Fprintf(&sb, "%02X", v)
+	}
+	return sb.String()
+}
+
 func encodeCertToPEM(certDER []byte) string {
 	block := &pem.Block{
 		Type:  "CERTIFICATE",
@@ -0,0 +1,132 @@
+package ssl
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// starttlsPorts are the default ports of the supported STARTTLS protocols.
+var starttlsPorts = map[string]int{
+	"smtp": 587,
+	"imap": 143,
+	"pop3": 110,
+	"ftp":  21,
+}
+
+// negotiateStartTLS runs the plaintext part of protocol on conn until the
+// server is ready for the TLS handshake.
+func negotiateStartTLS(conn net.Conn, protocol string) error {
+	switch protocol {
+	case "smtp":
+		return startTLSSMTP(textproto.NewConn(conn))
+	case "ftp":
+		return startTLSFTP(textproto.NewConn(conn))
+	case "imap":
+		return startTLSIMAP(conn)
+	case "pop3":
+		return startTLSPOP3(conn)
+	default:
+		return fmt.Errorf("unsupported STARTTLS protocol %q", protocol)
+	}
+}
+
+func startTLSSMTP(tp *textproto.Conn) error {
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("SMTP greeting: %w", err)
+	}
+
+	if err := tp.PrintfLine("EHLO bir"); err != nil {
+		return err
+	}
+	_, msg, err := tp.ReadResponse(250)
+	if err != nil {
+		return fmt.Errorf("SMTP EHLO: %w", err)
+	}
+	if !strings.Contains(strings.ToUpper(msg), "STARTTLS") {
+		return fmt.Errorf("SMTP server does not offer STARTTLS")
+	}
+
+	if err := tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("SMTP STARTTLS: %w", err)
+	}
+
+	return nil
+}
+
+func startTLSFTP(tp *textproto.Conn) error {
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("FTP greeting: %w", err)
+	}
+
+	if err := tp.PrintfLine("AUTH TLS"); err != nil {
+		return err
+	}
+	if _, _, err := tp.ReadResponse(234); err != nil {
+		return fmt.Errorf("FTP AUTH TLS: %w", err)
+	}
+
+	return nil
+}
+
+func startTLSIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("IMAP greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("IMAP greeting: %s", strings.TrimSpace(greeting))
+	}
+
+	if _, err := fmt.Fprintf(conn, "a001 STARTTLS\r\n"); err != nil {
+		return err
+	}
+
+	// Skip untagged responses until the tagged reply
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("IMAP STARTTLS: %w", err)
+		}
+		if !strings.HasPrefix(line, "a001 ") {
+			continue
+		}
+		if !strings.HasPrefix(strings.ToUpper(line), "A001 OK") {
+			return fmt.Errorf("IMAP STARTTLS: %s", strings.TrimSpace(line))
+		}
+		return nil
+	}
+}
+
+func startTLSPOP3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("POP3 greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("POP3 greeting: %s", strings.TrimSpace(greeting))
+	}
+
+	if _, err := fmt.Fprintf(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("POP3 STLS: %w", err)
+	}
+	if !strings.HasPrefix(reply, "+OK") {
+		return fmt.Errorf("POP3 STLS: %s", strings.TrimSpace(reply))
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package timeout parses the per-request timeout override accepted by the
+// tools, as the timeout query parameter.
+package timeout
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Max caps the timeout a request may ask for; longer values are lowered to it.
+const Max = 60 * time.Second
+
+// ErrInvalid is returned for a timeout that isn't a positive duration.
+var ErrInvalid = errors.New("invalid timeout, expected a duration like 5s or a number of seconds")
+
+// FromQuery returns the timeout parameter of query: a duration ("1500ms",
+// "5s") or a number of seconds ("5"), at most Max. It returns 0 when the
+// parameter is missing, so the configured timeout applies.
+func FromQuery(query url.Values) (time.Duration, error) {
+	return Parse(query.Get("timeout"))
+}
+
+// Parse parses a timeout like FromQuery does.
+func Parse(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil || !(seconds > 0) {
+			return 0, ErrInvalid
+		}
+		// Capped before converting, so huge values can't overflow
+		d = time.Duration(min(seconds, Max.Seconds()) * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, ErrInvalid
+	}
+
+	return min(d, Max), nil
+}
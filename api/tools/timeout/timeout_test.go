@@ -0,0 +1,34 @@
+package timeout
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   error
+	}{
+		{value: "", want: 0},
+		{value: "5s", want: 5 * time.Second},
+		{value: "1500ms", want: 1500 * time.Millisecond},
+		{value: "2", want: 2 * time.Second},
+		{value: "0.5", want: 500 * time.Millisecond},
+		{value: "10m", want: Max},
+		{value: "1e30", want: Max},
+		{value: "NaN", err: ErrInvalid},
+		{value: "0", err: ErrInvalid},
+		{value: "-1s", err: ErrInvalid},
+		{value: "soon", err: ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) = %v, %v, want %v, %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
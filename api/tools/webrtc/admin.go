@@ -0,0 +1,95 @@
+package webrtc
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/apikey"
+)
+
+// RoomInfo describes a room for operators. It leaves out peer IDs and
+// signaling messages, which would let the reader join the conversation.
+type RoomInfo struct {
+	Code       string     `json:"code"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	AgeSeconds int64      `json:"ageSeconds"`
+	Peers      []PeerInfo `json:"peers"`
+}
+
+type PeerInfo struct {
+	// Connected is true while the peer has an events stream open.
+	Connected bool `json:"connected"`
+	// Queued is the number of messages waiting to be delivered to the peer.
+	Queued int `json:"queued"`
+}
+
+// RoomsResponse is the response of GET /webrtc/rooms.
+type RoomsResponse struct {
+	Active int `json:"active"`
+	// Created is the number of rooms created since the server started.
+	Created uint64 `json:"created"`
+	// Streams is the number of events and WebSocket streams open.
+	Streams int `json:"streams"`
+	// Deleted counts the rooms deleted since the server started by reason:
+	// no_connections and max_lifetime for rooms reaped as abandoned,
+	// all_peers_left, deleted or shutdown.
+	Deleted map[string]uint64 `json:"deleted"`
+	Rooms   []RoomInfo        `json:"rooms"`
+}
+
+// Rooms returns the active rooms, oldest first.
+func (m *RoomManager) Rooms() RoomsResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	rooms := make([]RoomInfo, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		room.mu.Lock()
+		info := RoomInfo{
+			Code:       room.Code,
+			CreatedAt:  room.CreatedAt,
+			AgeSeconds: int64(now.Sub(room.CreatedAt).Seconds()),
+			Peers:      make([]PeerInfo, 0, len(room.Peers)),
+		}
+		for _, peer := range room.Peers {
+			info.Peers = append(info.Peers, PeerInfo{Connected: peer.Connected, Queued: len(peer.Chan)})
+		}
+		room.mu.Unlock()
+
+		rooms = append(rooms, info)
+	}
+
+	slices.SortFunc(rooms, func(a, b RoomInfo) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return RoomsResponse{
+		Active:  len(rooms),
+		Created: m.created.Load(),
+		Streams: m.streams.active(),
+		Deleted: maps.Clone(m.deleted),
+		Rooms:   rooms,
+	}
+}
+
+// RoomsHandler handles GET /webrtc/rooms - lists the active rooms. It requires
+// the admin key, as a Bearer token or in X-API-Key, and is unavailable when no
+// key is configured.
+func (m *RoomManager) RoomsHandler(w http.ResponseWriter, r *http.Request) {
+	if m.cfg.AdminKey == "" {
+		writeError(w, http.StatusServiceUnavailable, "Admin API is not configured")
+		return
+	}
+
+	if !apikey.Valid(apikey.FromRequest(r), []byte(m.cfg.AdminKey)) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, m.Rooms())
+}
@@ -1,62 +1,178 @@
 package webrtc
 
 import (
+	"cmp"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
-	"log/slog"
+	"errors"
+	"io"
 	"math/big"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rakunlabs/logi"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/rytsh/bir/api/tools/ip"
+	"github.com/rytsh/bir/api/tools/metrics"
 )
 
 const (
 	// Room code length
 	codeLength = 6
-	// Timeout for rooms with no connections (10 seconds)
-	emptyRoomTimeout = 10 * time.Second
-	// Maximum room lifetime (10 minutes)
-	roomTimeout = 10 * time.Minute
 	// Characters used for room codes (uppercase letters and numbers)
 	codeChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// Peer ID length
+	peerIDLength = 8
+	// Length bounds of a custom room code
+	minCustomCodeLength = 4
+	maxCustomCodeLength = 32
+	// bcrypt ignores password bytes beyond 72
+	maxPasswordLength = 72
+	// Size limit of the room creation body
+	maxCreateBodySize = 4 << 10
+	// How often expired rooms are removed
+	cleanupInterval = 1 * time.Second
+	// How long a message waits for room in a full peer queue when no send
+	// timeout is configured
+	sendTimeout = 2 * time.Second
+	// Size limit of a signaling message when none is configured
+	maxMessageSize = 64 << 10
+)
+
+// Reasons a room is deleted for, logged and counted in the metrics.
+// reasonEmpty and reasonMaxLifetime are rooms reaped by the cleanup loop,
+// usually abandoned by their clients.
+const (
+	reasonEmpty       = "no_connections"
+	reasonMaxLifetime = "max_lifetime"
+	reasonAllLeft     = "all_peers_left"
+	reasonDeleted     = "deleted"
+	reasonShutdown    = "shutdown"
 )
 
-// SignalMessage represents a signaling message
+// Config holds the signaling configuration, loaded from env via chu.
+type Config struct {
+	// RoomTTL is the maximum lifetime of a room.
+	RoomTTL time.Duration `cfg:"room_ttl" default:"10m"`
+	// EmptyTTL is how long a room is kept while no peer is connected.
+	EmptyTTL time.Duration `cfg:"empty_ttl" default:"10s"`
+	// MaxPeers caps the peers in a room (mesh topology, every peer connects
+	// to all others).
+	MaxPeers int `cfg:"max_peers" default:"6"`
+	// QueueSize is the number of messages buffered for each peer.
+	QueueSize int `cfg:"queue_size" default:"10"`
+	// SendTimeout is how long a signal waits while the target's queue is
+	// full before it fails, 2s when unset.
+	SendTimeout time.Duration `cfg:"send_timeout"`
+	// MaxMessageSize caps the size in bytes of a signaling message, 64KiB
+	// when unset.
+	MaxMessageSize int64 `cfg:"max_message_size"`
+	// Heartbeat is the interval of the keep-alive comments sent on idle
+	// events streams, so proxies don't drop them. 0 disables them.
+	Heartbeat time.Duration `cfg:"heartbeat" default:"15s"`
+	// MaxStreams caps the events and WebSocket streams open at once across
+	// all rooms, MaxStreamsPerIP those of one client IP. 0 is no cap.
+	MaxStreams      int `cfg:"max_streams" default:"1000"`
+	MaxStreamsPerIP int `cfg:"max_streams_per_ip" default:"20"`
+	// TURN enables GET /webrtc/turn when a secret and URLs are set.
+	TURN TURNConfig `cfg:"turn"`
+	// AdminKey enables GET /webrtc/rooms for requests carrying it.
+	AdminKey string `cfg:"admin_key"`
+}
+
+// SignalMessage represents a signaling message. From is set by the server to
+// the sender's peer ID; To selects the receiving peer.
 type SignalMessage struct {
 	Type    string          `json:"type"`
+	From    string          `json:"from,omitempty"`
+	To      string          `json:"to,omitempty"`
 	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
+// Peer is a member of a room
+type Peer struct {
+	ID string
+	// Channel for the peer's SSE subscriber
+	Chan chan SignalMessage
+	// Connected is true while the peer has an events stream open
+	Connected bool
+	// gone is closed when the peer is removed, releasing senders waiting for
+	// room in Chan. Senders hold sending so Chan isn't closed under them.
+	gone    chan struct{}
+	sending sync.RWMutex
+}
+
 // Room represents a signaling room
 type Room struct {
 	Code      string
 	CreatedAt time.Time
-	// Channels for SSE subscribers
-	HostChan  chan SignalMessage
-	GuestChan chan SignalMessage
-	HasHost   bool
-	HasGuest  bool
-	mu        sync.Mutex
+	Peers     map[string]*Peer
+	// passwordHash is the bcrypt hash of the room password, nil when the
+	// room has none. It is set on creation and never changes.
+	passwordHash []byte
+	// joinTokenHash is the SHA-256 of the one-time join token, nil when the
+	// room is open to anyone with its code. It is emptied, not nil, once
+	// used, so no one else can join after the guest.
+	joinTokenHash []byte
+	// closed is set once the room is torn down; peer channels are closed
+	// and the room no longer accepts peers or messages
+	closed bool
+	mu     sync.Mutex
 }
 
-// RoomManager manages all active rooms
+// RoomManager manages all active rooms and serves the signaling endpoints
 type RoomManager struct {
+	cfg   Config
 	rooms map[string]*Room
 	mu    sync.RWMutex
+	// created counts the rooms created since start
+	created atomic.Uint64
+	// deleted counts the rooms deleted since start by reason, guarded by mu
+	deleted map[string]uint64
+	// streams caps the open events and WebSocket streams
+	streams *streamLimiter
+	// origins are the origins allowed to open WebSocket streams besides the
+	// server's own, as in the CORS policy of the WebRTC routes
+	origins []string
+}
+
+// New builds a RoomManager. Call Start to begin removing expired rooms.
+func New(cfg Config) *RoomManager {
+	return &RoomManager{
+		cfg:     cfg,
+		rooms:   make(map[string]*Room),
+		deleted: make(map[string]uint64),
+		streams: newStreamLimiter(cfg.MaxStreams, cfg.MaxStreamsPerIP),
+	}
 }
 
-var manager = &RoomManager{
-	rooms: make(map[string]*Room),
+// SetAllowOrigins sets the origins, as in the CORS policy of the WebRTC
+// routes, that may open WebSocket streams. Without them only pages served
+// from the API's own host can. Call it before serving requests.
+func (m *RoomManager) SetAllowOrigins(origins []string) {
+	m.origins = origins
 }
 
-func init() {
-	// Start cleanup goroutine
-	go manager.cleanupLoop()
+// Start runs the cleanup loop that removes expired rooms until ctx is done.
+func (m *RoomManager) Start(ctx context.Context) {
+	go m.cleanupLoop(ctx)
 }
 
 // generateCode creates a random room code
 func generateCode() string {
-	code := make([]byte, codeLength)
+	return randomString(codeLength)
+}
+
+// randomString returns a random string of length characters from codeChars
+func randomString(length int) string {
+	code := make([]byte, length)
 	for i := range code {
 		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(codeChars))))
 		code[i] = codeChars[n.Int64()]
@@ -64,30 +180,67 @@ func generateCode() string {
 	return string(code)
 }
 
-// CreateRoom creates a new room with a unique code
-func (m *RoomManager) CreateRoom() *Room {
+// validCustomCode reports whether code may be chosen for a room: letters,
+// digits and inner hyphens.
+func validCustomCode(code string) bool {
+	if len(code) < minCustomCodeLength || len(code) > maxCustomCodeLength {
+		return false
+	}
+	if strings.HasPrefix(code, "-") || strings.HasSuffix(code, "-") {
+		return false
+	}
+
+	for _, c := range code {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CreateRoom creates a new room with a unique code. ctx carries the request
+// logger.
+func (m *RoomManager) CreateRoom(ctx context.Context) *Room {
+	// A generated code is never taken
+	room, _ := m.createRoom(ctx, "", nil, nil)
+	return room
+}
+
+// createRoom creates a room under code, or a generated code when empty,
+// protected by passwordHash and joinTokenHash when set.
+func (m *RoomManager) createRoom(ctx context.Context, code string, passwordHash, joinTokenHash []byte) (*Room, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if code != "" {
+		if _, exists := m.rooms[code]; exists {
+			return nil, errCodeTaken
+		}
+	}
+
 	// Generate unique code
-	var code string
-	for {
+	for code == "" {
 		code = generateCode()
-		if _, exists := m.rooms[code]; !exists {
-			break
+		if _, exists := m.rooms[code]; exists {
+			code = ""
 		}
 	}
 
 	room := &Room{
-		Code:      code,
-		CreatedAt: time.Now(),
-		HostChan:  make(chan SignalMessage, 10),
-		GuestChan: make(chan SignalMessage, 10),
+		Code:          code,
+		CreatedAt:     time.Now(),
+		Peers:         make(map[string]*Peer),
+		passwordHash:  passwordHash,
+		joinTokenHash: joinTokenHash,
 	}
 	m.rooms[code] = room
+	m.created.Add(1)
+	metrics.SetWebRTCRooms(len(m.rooms))
+	metrics.WebRTCRoomCreated()
 
-	slog.Debug("room created", "code", code, "tools", "webrtc")
-	return room
+	logi.Ctx(ctx).Debug("room created", "code", code, "protected", passwordHash != nil, "token", joinTokenHash != nil, "tools", "webrtc")
+	return room, nil
 }
 
 // GetRoom returns a room by code
@@ -98,48 +251,92 @@ func (m *RoomManager) GetRoom(code string) *Room {
 }
 
 // DeleteRoom removes a room
-func (m *RoomManager) DeleteRoom(code string) {
+func (m *RoomManager) DeleteRoom(ctx context.Context, code string) {
+	m.deleteRoomIf(ctx, code, nil, reasonDeleted)
+}
+
+// deleteRoomIf tears down and removes the room registered under code if cond
+// is nil or reports true. It is the single teardown path for rooms, so peer
+// channels are closed exactly once however many callers race to delete.
+func (m *RoomManager) deleteRoomIf(ctx context.Context, code string, cond func(*Room) bool, reason string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if room, exists := m.rooms[code]; exists {
-		close(room.HostChan)
-		close(room.GuestChan)
-		delete(m.rooms, code)
-		slog.Debug("room deleted", "code", code, "tools", "webrtc")
+	room, exists := m.rooms[code]
+	if !exists {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if cond != nil && !cond(room) {
+		return
+	}
+
+	m.deleteLocked(ctx, room, reason)
+}
+
+// deleteLocked closes room and removes it from the manager. Both the manager
+// and the room locks must be held.
+func (m *RoomManager) deleteLocked(ctx context.Context, room *Room, reason string) {
+	room.close()
+	if m.rooms[room.Code] == room {
+		delete(m.rooms, room.Code)
+		m.deleted[reason]++
+		metrics.SetWebRTCRooms(len(m.rooms))
+		metrics.WebRTCRoomDeleted(reason)
 	}
+	logi.Ctx(ctx).Debug("room deleted", "code", room.Code, "reason", reason, "tools", "webrtc")
 }
 
-// cleanupLoop removes expired rooms
-func (m *RoomManager) cleanupLoop() {
-	ticker := time.NewTicker(1 * time.Second)
+// Close tears down every room, ending their event streams and WebSocket
+// connections. It is called on shutdown, before waiting for in-flight
+// requests, as streams would otherwise keep the server open.
+func (m *RoomManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		room.mu.Lock()
+		m.deleteLocked(context.Background(), room, reasonShutdown)
+		room.mu.Unlock()
+	}
+}
+
+// cleanupLoop removes expired rooms until ctx is done
+func (m *RoomManager) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		m.mu.Lock()
 		now := time.Now()
-		for code, room := range m.rooms {
+		for _, room := range m.rooms {
 			room.mu.Lock()
 			shouldDelete := false
 			reason := ""
 
-			// Delete room if no one is connected and 10 seconds have passed
-			if !room.HasHost && !room.HasGuest && now.Sub(room.CreatedAt) > emptyRoomTimeout {
+			// Delete room if no one is connected after the empty TTL
+			if room.connectedCount() == 0 && now.Sub(room.CreatedAt) > m.cfg.EmptyTTL {
 				shouldDelete = true
-				reason = "no connections"
+				reason = reasonEmpty
 			}
 
-			// Delete room if it has existed for more than 10 minutes (safety net)
-			if now.Sub(room.CreatedAt) > roomTimeout {
+			// Delete room if it has existed longer than the room TTL (safety net)
+			if now.Sub(room.CreatedAt) > m.cfg.RoomTTL {
 				shouldDelete = true
-				reason = "max lifetime exceeded"
+				reason = reasonMaxLifetime
 			}
 
 			if shouldDelete {
-				close(room.HostChan)
-				close(room.GuestChan)
-				delete(m.rooms, code)
-				slog.Debug("room expired", "code", code, "reason", reason, "tools", "webrtc")
+				m.deleteLocked(ctx, room, reason)
 			}
 			room.mu.Unlock()
 		}
@@ -147,6 +344,295 @@ func (m *RoomManager) cleanupLoop() {
 	}
 }
 
+// addPeer adds a new peer with a unique ID and a message buffer of queueSize
+// to the room. The room lock must be held.
+func (r *Room) addPeer(queueSize int) (*Peer, error) {
+	if r.closed {
+		return nil, errRoomClosed
+	}
+
+	var id string
+	for {
+		id = randomString(peerIDLength)
+		if _, exists := r.Peers[id]; !exists {
+			break
+		}
+	}
+
+	peer := &Peer{
+		ID:   id,
+		Chan: make(chan SignalMessage, queueSize),
+		gone: make(chan struct{}),
+	}
+	r.Peers[id] = peer
+
+	return peer, nil
+}
+
+// removePeer removes a peer and closes its channel. The room lock must be held.
+func (r *Room) removePeer(id string) {
+	if peer, exists := r.Peers[id]; exists {
+		close(peer.gone)
+
+		// Wait for the senders still holding the channel
+		peer.sending.Lock()
+		close(peer.Chan)
+		peer.sending.Unlock()
+
+		delete(r.Peers, id)
+	}
+}
+
+// close marks the room closed and closes every peer channel. Calling it
+// again is a no-op. The room lock must be held.
+func (r *Room) close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+
+	for id := range r.Peers {
+		r.removePeer(id)
+	}
+}
+
+// peerIDs returns the IDs of all peers except exclude. The room lock must be held.
+func (r *Room) peerIDs(exclude string) []string {
+	ids := make([]string, 0, len(r.Peers))
+	for id := range r.Peers {
+		if id != exclude {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// connectedCount returns the number of peers with an open events stream.
+// The room lock must be held.
+func (r *Room) connectedCount() int {
+	n := 0
+	for _, peer := range r.Peers {
+		if peer.Connected {
+			n++
+		}
+	}
+	return n
+}
+
+// broadcast sends msg to every peer except msg.From without blocking. The
+// room lock must be held.
+func (r *Room) broadcast(msg SignalMessage) {
+	for id, peer := range r.Peers {
+		if id == msg.From {
+			continue
+		}
+		select {
+		case peer.Chan <- msg:
+		default:
+		}
+	}
+}
+
+// Errors returned by Room.route and Room.connect
+var (
+	errRoomClosed       = errors.New("Room closed")
+	errUnknownPeer      = errors.New("Unknown peer")
+	errAlreadyConnected = errors.New("Peer already connected")
+	errTargetRequired   = errors.New("Target peer (to) is required")
+	errTargetNotFound   = errors.New("Target peer not found")
+	errPeerQueueFull    = errors.New("Peer message queue full")
+	errCodeTaken        = errors.New("Room code already taken")
+	errWrongPassword    = errors.New("Wrong room password")
+	errWrongJoinToken   = errors.New("Invalid or already used join token")
+)
+
+// route delivers msg from msg.From to msg.To. With a single other peer in the
+// room, msg.To may be empty. While the target's queue is full it waits until
+// ctx is done, so bursts of ICE candidates aren't dropped; a target leaving
+// meanwhile fails as not found.
+func (r *Room) route(ctx context.Context, msg SignalMessage) error {
+	target, err := r.target(&msg)
+	if err != nil {
+		return err
+	}
+
+	target.sending.RLock()
+	defer target.sending.RUnlock()
+
+	// Chan is only closed after gone, so it is open if gone isn't
+	select {
+	case <-target.gone:
+		return errTargetNotFound
+	default:
+	}
+
+	select {
+	case target.Chan <- msg:
+		return nil
+	case <-target.gone:
+		return errTargetNotFound
+	case <-ctx.Done():
+		return errPeerQueueFull
+	}
+}
+
+// target returns the peer msg is for, filling in msg.To when the sender is
+// alone with one other peer.
+func (r *Room) target(msg *SignalMessage) (*Peer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, errRoomClosed
+	}
+
+	if _, ok := r.Peers[msg.From]; !ok {
+		return nil, errUnknownPeer
+	}
+
+	if msg.To == "" {
+		others := r.peerIDs(msg.From)
+		if len(others) != 1 {
+			return nil, errTargetRequired
+		}
+		msg.To = others[0]
+	}
+
+	target, ok := r.Peers[msg.To]
+	if !ok || msg.To == msg.From {
+		return nil, errTargetNotFound
+	}
+
+	return target, nil
+}
+
+// maxMessageSize returns the size limit of a signaling message.
+func (m *RoomManager) maxMessageSize() int64 {
+	return cmp.Or(m.cfg.MaxMessageSize, maxMessageSize)
+}
+
+// route delivers msg within room, waiting up to the send timeout for room in
+// a full queue.
+func (m *RoomManager) route(ctx context.Context, room *Room, msg SignalMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(m.cfg.SendTimeout, sendTimeout))
+	defer cancel()
+
+	return room.route(ctx, msg)
+}
+
+// errorStatus maps a room error to an HTTP status code
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errRoomClosed):
+		return http.StatusGone
+	case errors.Is(err, errUnknownPeer):
+		return http.StatusForbidden
+	case errors.Is(err, errAlreadyConnected), errors.Is(err, errCodeTaken):
+		return http.StatusConflict
+	case errors.Is(err, errWrongPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, errWrongJoinToken):
+		return http.StatusForbidden
+	case errors.Is(err, errTargetRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, errTargetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errTooManyStreamsPerIP):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// checkPassword returns errWrongPassword unless r carries the room password,
+// in the X-Room-Password header or the password query param (EventSource
+// can't set headers). Rooms without a password accept any request.
+func (r *Room) checkPassword(req *http.Request) error {
+	if r.passwordHash == nil {
+		return nil
+	}
+
+	password := req.Header.Get("X-Room-Password")
+	if password == "" {
+		password = req.URL.Query().Get("password")
+	}
+
+	if bcrypt.CompareHashAndPassword(r.passwordHash, []byte(password)) != nil {
+		return errWrongPassword
+	}
+
+	return nil
+}
+
+// useJoinToken returns errWrongJoinToken unless req carries the room's join
+// token, in the X-Join-Token header or the token query param, and then
+// clears it so it can't be used twice. Rooms without a token accept any
+// request. The room lock must be held.
+func (r *Room) useJoinToken(req *http.Request) error {
+	if r.joinTokenHash == nil {
+		return nil
+	}
+
+	token := req.Header.Get("X-Join-Token")
+	if token == "" {
+		token = req.URL.Query().Get("token")
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	if token == "" || subtle.ConstantTimeCompare(r.joinTokenHash, hash[:]) != 1 {
+		return errWrongJoinToken
+	}
+
+	// Spent: no hash matches an empty one
+	r.joinTokenHash = []byte{}
+
+	return nil
+}
+
+// connect marks peerID as connected and returns its message channel
+func (r *Room) connect(peerID string) (chan SignalMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, errRoomClosed
+	}
+
+	peer, ok := r.Peers[peerID]
+	if !ok {
+		return nil, errUnknownPeer
+	}
+	if peer.Connected {
+		return nil, errAlreadyConnected
+	}
+	peer.Connected = true
+
+	return peer.Chan, nil
+}
+
+// leave removes peerID from room, notifies the remaining peers and deletes
+// the room when it becomes empty
+func (m *RoomManager) leave(ctx context.Context, room *Room, peerID string) {
+	room.mu.Lock()
+	if _, ok := room.Peers[peerID]; !ok {
+		// Already removed by a room teardown
+		room.mu.Unlock()
+		return
+	}
+	room.removePeer(peerID)
+	room.broadcast(SignalMessage{Type: "peer_left", From: peerID})
+	empty := len(room.Peers) == 0
+	room.mu.Unlock()
+
+	// Delete room if everyone is gone. Re-checked under the manager lock as
+	// a peer may have joined in between.
+	if empty {
+		m.deleteRoomIf(ctx, room.Code, func(r *Room) bool {
+			return r == room && len(r.Peers) == 0
+		}, reasonAllLeft)
+	}
+}
+
 // writeJSON writes a JSON response
 func writeJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -159,118 +645,219 @@ func writeError(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, map[string]string{"error": message})
 }
 
-// CreateRoomHandler handles POST /webrtc/room - creates a new room
-func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
-	room := manager.CreateRoom()
+// writeSignalError writes the 400 response of a refused signaling message
+func writeSignalError(w http.ResponseWriter, err error) {
+	var signalErr *signalError
+	if !errors.As(err, &signalErr) {
+		signalErr = errInvalidMessage
+	}
+	writeJSON(w, http.StatusBadRequest, signalErrorResponse{Error: signalErr.Message, Code: signalErr.Code})
+}
 
-	writeJSON(w, http.StatusOK, map[string]string{
-		"room": room.Code,
-	})
+// CreateRoomRequest is the optional body of POST /webrtc/room.
+type CreateRoomRequest struct {
+	// Code is the room code instead of a generated one.
+	Code string `json:"code,omitempty"`
+	// Password is then required to join the room and open its streams.
+	Password string `json:"password,omitempty"`
+	// JoinToken issues a one-time token, returned once, that the host shares
+	// with the guest; joining then requires it, so a guessed code isn't
+	// enough to take the guest's place.
+	JoinToken bool `json:"joinToken,omitempty"`
 }
 
-// JoinRoomHandler handles POST /webrtc/room/{code}/join - joins an existing room
-func JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
+// CreateRoomHandler handles POST /webrtc/room - creates a new room, with the
+// code, password and join token of the optional body. The creator becomes
+// the room's first peer.
+func (m *RoomManager) CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateRoomRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCreateBodySize)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Code != "" && !validCustomCode(req.Code) {
+		writeError(w, http.StatusBadRequest, "Invalid room code, use 4 to 32 letters, digits or hyphens")
+		return
+	}
+	if len(req.Password) > maxPasswordLength {
+		writeError(w, http.StatusBadRequest, "Password is too long, at most 72 bytes")
+		return
+	}
+
+	var passwordHash []byte
+	if req.Password != "" {
+		var err error
+		passwordHash, err = bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+	}
+
+	var joinToken string
+	var joinTokenHash []byte
+	if req.JoinToken {
+		joinToken = rand.Text()
+		hash := sha256.Sum256([]byte(joinToken))
+		joinTokenHash = hash[:]
+	}
+
+	room, err := m.createRoom(r.Context(), req.Code, passwordHash, joinTokenHash)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	room.mu.Lock()
+	peer, err := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	response := map[string]string{
+		"room":   room.Code,
+		"peerId": peer.ID,
+	}
+	if joinToken != "" {
+		response["joinToken"] = joinToken
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// JoinRoomHandler handles POST /webrtc/room/{code}/join - joins an existing room.
+// It returns the new peer ID and the IDs of the peers already in the room, so
+// the client can create an offer to each of them.
+func (m *RoomManager) JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.PathValue("code")
 	if code == "" {
 		writeError(w, http.StatusBadRequest, "Invalid room code")
 		return
 	}
 
-	room := manager.GetRoom(code)
+	room := m.GetRoom(code)
 	if room == nil {
 		writeError(w, http.StatusNotFound, "Room not found")
 		return
 	}
 
+	if err := room.checkPassword(r); err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
 	room.mu.Lock()
-	if room.HasGuest {
+	if len(room.Peers) >= m.cfg.MaxPeers {
 		room.mu.Unlock()
 		writeError(w, http.StatusConflict, "Room is full")
 		return
 	}
-	room.HasGuest = true
-	room.mu.Unlock()
-
-	// Notify host that a peer joined
-	select {
-	case room.HostChan <- SignalMessage{Type: "peer_joined"}:
-	default:
+	if err := room.useJoinToken(r); err != nil {
+		room.mu.Unlock()
+		writeError(w, errorStatus(err), err.Error())
+		return
 	}
+	peer, err := room.addPeer(m.cfg.QueueSize)
+	if err != nil {
+		room.mu.Unlock()
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	peers := room.peerIDs(peer.ID)
+
+	// Notify everyone else that a peer joined
+	room.broadcast(SignalMessage{Type: "peer_joined", From: peer.ID})
+	room.mu.Unlock()
 
-	writeJSON(w, http.StatusOK, map[string]string{
+	writeJSON(w, http.StatusOK, map[string]any{
 		"status": "joined",
+		"peerId": peer.ID,
+		"peers":  peers,
 	})
 }
 
-// SignalHandler handles POST /webrtc/room/{code}/signal - sends a signaling message
-func SignalHandler(w http.ResponseWriter, r *http.Request) {
+// SignalHandler handles POST /webrtc/room/{code}/signal?peer={id} - sends a
+// signaling message to the peer named by the message's "to" field or the
+// "to" query param. With a single other peer in the room, "to" may be omitted.
+func (m *RoomManager) SignalHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.PathValue("code")
 	if code == "" {
 		writeError(w, http.StatusBadRequest, "Invalid room code")
 		return
 	}
 
-	room := manager.GetRoom(code)
+	room := m.GetRoom(code)
 	if room == nil {
 		writeError(w, http.StatusNotFound, "Room not found")
 		return
 	}
 
 	var msg SignalMessage
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid message format")
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, m.maxMessageSize())).Decode(&msg); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeSignalError(w, errTooLarge)
+		} else {
+			writeSignalError(w, errInvalidMessage)
+		}
 		return
 	}
 
-	// Determine sender from query param
-	sender := r.URL.Query().Get("sender")
-
-	room.mu.Lock()
-	defer room.mu.Unlock()
+	if err := validateSignal(msg); err != nil {
+		writeSignalError(w, err)
+		return
+	}
 
-	// Route message to the other peer
-	var targetChan chan SignalMessage
-	if sender == "host" {
-		targetChan = room.GuestChan
-	} else {
-		targetChan = room.HostChan
+	// Sender is identified by its peer ID
+	msg.From = r.URL.Query().Get("peer")
+	if msg.To == "" {
+		msg.To = r.URL.Query().Get("to")
 	}
 
-	select {
-	case targetChan <- msg:
-		writeJSON(w, http.StatusOK, map[string]string{"status": "sent"})
-	default:
-		writeError(w, http.StatusServiceUnavailable, "Peer not connected")
+	if err := m.route(r.Context(), room, msg); err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
 	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "sent"})
 }
 
-// EventsHandler handles GET /webrtc/room/{code}/events - SSE endpoint
-func EventsHandler(w http.ResponseWriter, r *http.Request) {
+// EventsHandler handles GET /webrtc/room/{code}/events?peer={id} - SSE endpoint
+func (m *RoomManager) EventsHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.PathValue("code")
 	if code == "" {
 		writeError(w, http.StatusBadRequest, "Invalid room code")
 		return
 	}
 
-	room := manager.GetRoom(code)
+	room := m.GetRoom(code)
 	if room == nil {
 		writeError(w, http.StatusNotFound, "Room not found")
 		return
 	}
 
-	// Determine if this is host or guest from query param
-	role := r.URL.Query().Get("role")
-	var msgChan chan SignalMessage
+	if err := room.checkPassword(r); err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
 
-	room.mu.Lock()
-	if role == "host" {
-		msgChan = room.HostChan
-		room.HasHost = true
-	} else {
-		msgChan = room.GuestChan
-		room.HasGuest = true
+	release, err := m.streams.acquire(ip.TrustedClientIP(r))
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	defer release()
+
+	peerID := r.URL.Query().Get("peer")
+
+	msgChan, err := room.connect(peerID)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
 	}
-	room.mu.Unlock()
 
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -284,46 +871,48 @@ func EventsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// send writes a whole event at once and flushes it. Messages and
+	// heartbeats are all written from this goroutine, so they never interleave.
+	send := func(event []byte) error {
+		if _, err := w.Write(event); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	ctx := r.Context()
+
 	// Send initial connection event
-	w.Write([]byte("event: connected\ndata: {}\n\n"))
-	flusher.Flush()
+	if err := send([]byte("event: connected\ndata: {}\n\n")); err != nil {
+		m.leave(ctx, room, peerID)
+		return
+	}
+
+	var heartbeat <-chan time.Time
+	if m.cfg.Heartbeat > 0 {
+		ticker := time.NewTicker(m.cfg.Heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
 
 	// Stream messages
-	ctx := r.Context()
 	for {
+		var event []byte
+
 		select {
 		case <-ctx.Done():
-			// Client disconnected
-			room.mu.Lock()
-			if role == "host" {
-				room.HasHost = false
-				// Notify guest
-				select {
-				case room.GuestChan <- SignalMessage{Type: "peer_left"}:
-				default:
-				}
-			} else {
-				room.HasGuest = false
-				// Notify host
-				select {
-				case room.HostChan <- SignalMessage{Type: "peer_left"}:
-				default:
-				}
-			}
-			room.mu.Unlock()
-
-			// Delete room if both peers are gone
-			room.mu.Lock()
-			bothGone := !room.HasHost && !room.HasGuest
-			room.mu.Unlock()
-			if bothGone {
-				manager.DeleteRoom(code)
-			}
+			// Client disconnected, leave the room and notify the others
+			m.leave(ctx, room, peerID)
 			return
 
+		case <-heartbeat:
+			event = []byte(": ping\n\n")
+
 		case msg, ok := <-msgChan:
 			if !ok {
-				// Channel closed, room deleted
+				// Channel closed, room deleted or server shutting down
+				_ = send([]byte("event: disconnect\ndata: {}\n\n"))
 				return
 			}
 
@@ -332,10 +921,15 @@ func EventsHandler(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			w.Write([]byte("event: message\ndata: "))
-			w.Write(data)
-			w.Write([]byte("\n\n"))
-			flusher.Flush()
+			event = append([]byte("event: message\ndata: "), data...)
+			event = append(event, "\n\n"...)
+		}
+
+		if err := send(event); err != nil {
+			// The connection is gone even if the request context hasn't
+			// noticed yet
+			m.leave(ctx, room, peerID)
+			return
 		}
 	}
 }
@@ -0,0 +1,445 @@
+package webrtc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestRoomTeardownIsIdempotent(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	host, _ := room.addPeer(m.cfg.QueueSize)
+	guest, _ := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+
+	// Peers leaving, explicit deletes and sends all racing on the same room
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(4)
+		go func() { defer wg.Done(); m.leave(context.Background(), room, host.ID) }()
+		go func() { defer wg.Done(); m.leave(context.Background(), room, guest.ID) }()
+		go func() { defer wg.Done(); m.DeleteRoom(context.Background(), room.Code) }()
+		go func() {
+			defer wg.Done()
+			_ = room.route(context.Background(), SignalMessage{Type: "offer", From: host.ID, To: guest.ID})
+		}()
+	}
+	wg.Wait()
+
+	if m.GetRoom(room.Code) != nil {
+		t.Fatal("room still registered after teardown")
+	}
+
+	// Channels are closed exactly once; drain anything routed before teardown
+	for range guest.Chan {
+	}
+
+	room.mu.Lock()
+	_, err := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+	if !errors.Is(err, errRoomClosed) {
+		t.Errorf("addPeer on closed room: err = %v, want %v", err, errRoomClosed)
+	}
+
+	if err := room.route(context.Background(), SignalMessage{From: host.ID, To: guest.ID}); !errors.Is(err, errRoomClosed) {
+		t.Errorf("route on closed room: err = %v, want %v", err, errRoomClosed)
+	}
+}
+
+func TestRouteWaitsForFullQueue(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 1, SendTimeout: 50 * time.Millisecond})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	host, _ := room.addPeer(m.cfg.QueueSize)
+	guest, _ := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+
+	msg := SignalMessage{Type: "candidate", From: host.ID, To: guest.ID}
+	if err := m.route(context.Background(), room, msg); err != nil {
+		t.Fatalf("route() error = %v", err)
+	}
+
+	// The queue is full until the guest reads
+	if err := m.route(context.Background(), room, msg); !errors.Is(err, errPeerQueueFull) {
+		t.Fatalf("route() to a full queue: err = %v, want %v", err, errPeerQueueFull)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- room.route(context.Background(), msg) }()
+	time.Sleep(10 * time.Millisecond)
+	<-guest.Chan
+	if err := <-errCh; err != nil {
+		t.Fatalf("route() once the queue drained: err = %v", err)
+	}
+
+	// A waiting message fails as soon as the target leaves
+	go func() { errCh <- room.route(context.Background(), msg) }()
+	time.Sleep(10 * time.Millisecond)
+	m.leave(context.Background(), room, guest.ID)
+	if err := <-errCh; !errors.Is(err, errTargetNotFound) {
+		t.Fatalf("route() to a peer that left: err = %v, want %v", err, errTargetNotFound)
+	}
+}
+
+func TestRoomsHandler(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10, AdminKey: "secret"})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	host, _ := room.addPeer(m.cfg.QueueSize)
+	host.Connected = true
+	_, _ = room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+	m.DeleteRoom(context.Background(), m.CreateRoom(context.Background()).Code)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{name: "no key", status: http.StatusUnauthorized},
+		{name: "wrong key", header: "Authorization", value: "Bearer nope", status: http.StatusUnauthorized},
+		{name: "bearer", header: "Authorization", value: "Bearer secret", status: http.StatusOK},
+		{name: "header", header: "X-API-Key", value: "secret", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/webrtc/rooms", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			m.RoomsHandler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if strings.Contains(rec.Body.String(), host.ID) {
+				t.Fatal("response exposes a peer ID")
+			}
+
+			var response RoomsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Active != 1 || response.Created != 2 || response.Deleted[reasonDeleted] != 1 || response.Rooms[0].Code != room.Code {
+				t.Fatalf("response = %+v", response)
+			}
+			if peers := response.Rooms[0].Peers; len(peers) != 2 || peers[0].Connected == peers[1].Connected {
+				t.Fatalf("peers = %+v", peers)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	New(Config{}).RoomsHandler(rec, httptest.NewRequest(http.MethodGet, "/webrtc/rooms", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status without admin key = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCleanupLoopCountsReaped(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10, EmptyTTL: time.Millisecond, RoomTTL: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	room := m.CreateRoom(ctx)
+	m.Start(ctx)
+
+	deadline := time.Now().Add(5 * cleanupInterval)
+	for m.GetRoom(room.Code) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("empty room not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if deleted := m.Rooms().Deleted; deleted[reasonEmpty] != 1 || len(deleted) != 1 {
+		t.Fatalf("deleted = %v, want %s: 1", deleted, reasonEmpty)
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10, Heartbeat: 10 * time.Millisecond})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	peer, _ := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms/{code}/events", m.EventsHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/rooms/" + room.Code + "/events?peer=" + peer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	var pings int
+	for pings < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read events: %v", err)
+		}
+		if line == ": ping\n" {
+			pings++
+		}
+	}
+}
+
+func TestCustomRoom(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10})
+
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.CreateRoomHandler(rec, httptest.NewRequest(http.MethodPost, "/webrtc/room", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := create(`{"code":"team-standup","password":"hunter2"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := create(`{"code":"team-standup"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("create taken code status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := create(`{"code":"no spaces"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("create invalid code status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := create(""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"room"`) {
+		t.Fatalf("create without body = %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, tt := range []struct {
+		password string
+		want     int
+	}{
+		{password: "", want: http.StatusUnauthorized},
+		{password: "wrong", want: http.StatusUnauthorized},
+		{password: "hunter2", want: http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/webrtc/room/team-standup/join", nil)
+		req.SetPathValue("code", "team-standup")
+		req.Header.Set("X-Room-Password", tt.password)
+		rec := httptest.NewRecorder()
+		m.JoinRoomHandler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("join with %q: status = %d, want %d", tt.password, rec.Code, tt.want)
+		}
+	}
+
+	// EventSource can only pass the password in the query
+	req := httptest.NewRequest(http.MethodGet, "/webrtc/room/team-standup/events?peer=unknown", nil)
+	req.SetPathValue("code", "team-standup")
+	rec := httptest.NewRecorder()
+	m.EventsHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("events without password: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSignalValidation(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10, MaxMessageSize: 256})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	host, _ := room.addPeer(m.cfg.QueueSize)
+	guest, _ := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+
+	for _, tt := range []struct {
+		body string
+		code string
+	}{
+		{body: `{"type":"offer","payload":{"sdp":"v=0"}}`},
+		{body: `{"type":"candidate","payload":{"candidate":"","sdpMid":null,"sdpMLineIndex":0}}`},
+		{body: `{"type":"bye"}`},
+		{body: `{"type":"offer"`, code: CodeInvalidMessage},
+		{body: `{"type":"peer_joined"}`, code: CodeInvalidType},
+		{body: `{"type":"answer","payload":{"sdp":""}}`, code: CodeInvalidPayload},
+		{body: `{"type":"candidate","payload":"candidate:1"}`, code: CodeInvalidPayload},
+		{body: `{"type":"candidate","payload":{"candidate":"","sdpMLineIndex":"0"}}`, code: CodeInvalidPayload},
+		{body: `{"type":"offer","payload":{"sdp":"` + strings.Repeat("a", 256) + `"}}`, code: CodeMessageTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/webrtc/room/"+room.Code+"/signal?peer="+host.ID, strings.NewReader(tt.body))
+		req.SetPathValue("code", room.Code)
+		rec := httptest.NewRecorder()
+		m.SignalHandler(rec, req)
+
+		if tt.code == "" {
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: status = %d: %s", tt.body, rec.Code, rec.Body)
+			}
+			continue
+		}
+
+		var response signalErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusBadRequest || response.Code != tt.code {
+			t.Errorf("%.40s: status = %d, code = %q, want %d, %q", tt.body, rec.Code, response.Code, http.StatusBadRequest, tt.code)
+		}
+	}
+
+	if len(guest.Chan) != 3 {
+		t.Fatalf("forwarded %d messages, want 3", len(guest.Chan))
+	}
+}
+
+func TestJoinToken(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10})
+
+	rec := httptest.NewRecorder()
+	m.CreateRoomHandler(rec, httptest.NewRequest(http.MethodPost, "/webrtc/room", strings.NewReader(`{"joinToken":true}`)))
+
+	var created map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusOK || created["joinToken"] == "" {
+		t.Fatalf("create with join token = %d: %s", rec.Code, rec.Body)
+	}
+
+	join := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webrtc/room/"+created["room"]+"/join", nil)
+		req.SetPathValue("code", created["room"])
+		req.Header.Set("X-Join-Token", token)
+		rec := httptest.NewRecorder()
+		m.JoinRoomHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := join(""); code != http.StatusForbidden {
+		t.Errorf("join without token: status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := join("guessed"); code != http.StatusForbidden {
+		t.Errorf("join with wrong token: status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := join(created["joinToken"]); code != http.StatusOK {
+		t.Errorf("join with token: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := join(created["joinToken"]); code != http.StatusForbidden {
+		t.Errorf("join with used token: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Rooms created without a token stay open
+	open := m.CreateRoom(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/webrtc/room/"+open.Code+"/join", nil)
+	req.SetPathValue("code", open.Code)
+	rec = httptest.NewRecorder()
+	m.JoinRoomHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("join open room: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestStreamLimits(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10, MaxStreamsPerIP: 1})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	host, _ := room.addPeer(m.cfg.QueueSize)
+	guest, _ := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms/{code}/events", m.EventsHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	first, err := http.Get(server.URL + "/rooms/" + room.Code + "/events?peer=" + host.ID)
+	if err != nil || first.StatusCode != http.StatusOK {
+		t.Fatalf("first stream = %v, %v", first, err)
+	}
+
+	second, err := http.Get(server.URL + "/rooms/" + room.Code + "/events?peer=" + guest.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second stream from the same IP: status %d, want %d", second.StatusCode, http.StatusTooManyRequests)
+	}
+
+	// Closing the first stream frees its slot
+	first.Body.Close()
+	for deadline := time.Now().Add(2 * time.Second); m.streams.active() != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d streams still counted after the client left", m.streams.active())
+		}
+	}
+
+	l := newStreamLimiter(1, 0)
+	func() {
+		defer func() { _ = recover() }()
+
+		release, err := l.acquire("192.0.2.1")
+		if err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+		defer release()
+
+		if _, err := l.acquire("192.0.2.2"); !errors.Is(err, errTooManyStreams) {
+			t.Fatalf("acquire() over the total cap error = %v, want %v", err, errTooManyStreams)
+		}
+		panic("stream handler failed")
+	}()
+	if l.active() != 0 {
+		t.Fatalf("a panicking stream kept its slot: %d active", l.active())
+	}
+}
+
+func TestWebSocketOrigins(t *testing.T) {
+	m := New(Config{MaxPeers: 6, QueueSize: 10})
+	m.SetAllowOrigins([]string{"https://app.example.com"})
+
+	room := m.CreateRoom(context.Background())
+	room.mu.Lock()
+	peer, _ := room.addPeer(m.cfg.QueueSize)
+	room.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms/{code}/ws", m.WebSocketHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/rooms/" + room.Code + "/ws?peer=" + peer.ID
+	dial := func(origin string) (*websocket.Conn, *http.Response, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		return websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: http.Header{"Origin": {origin}}})
+	}
+
+	_, resp, err := dial("https://evil.example.net")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial from another origin = %v, %v, want 403", resp, err)
+	}
+
+	conn, _, err := dial("https://app.example.com")
+	if err != nil {
+		t.Fatalf("dial from the allowed origin: %v", err)
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
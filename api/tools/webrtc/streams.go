@@ -0,0 +1,70 @@
+package webrtc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+)
+
+var (
+	errTooManyStreams      = errors.New("Too many open streams, try again later")
+	errTooManyStreamsPerIP = errors.New("Too many open streams from this address")
+)
+
+// streamLimiter caps the open events and WebSocket streams, each holding a
+// goroutine and a room slot for as long as the client keeps it, overall and
+// per client IP. A zero cap is no cap.
+type streamLimiter struct {
+	maxTotal int
+	maxPerIP int
+	total    int
+	perIP    map[string]int
+	mu       sync.Mutex
+}
+
+func newStreamLimiter(maxTotal, maxPerIP int) *streamLimiter {
+	return &streamLimiter{maxTotal: maxTotal, maxPerIP: maxPerIP, perIP: make(map[string]int)}
+}
+
+// acquire takes a stream slot for ip. release gives it back and must be
+// called once the stream ends, deferred so a panic doesn't leak the slot.
+func (l *streamLimiter) acquire(ip string) (release func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		metrics.WebRTCStreamRejected("total")
+		return nil, errTooManyStreams
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		metrics.WebRTCStreamRejected("per_ip")
+		return nil, errTooManyStreamsPerIP
+	}
+
+	l.total++
+	l.perIP[ip]++
+	metrics.WebRTCStreamOpened()
+
+	var once sync.Once
+	return func() { once.Do(func() { l.release(ip) }) }, nil
+}
+
+func (l *streamLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+	metrics.WebRTCStreamClosed()
+}
+
+// active returns the number of open streams.
+func (l *streamLimiter) active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.total
+}
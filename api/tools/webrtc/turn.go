@@ -0,0 +1,80 @@
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TURNConfig holds the TURN server settings used to issue credentials with the
+// TURN REST API scheme (shared secret, e.g. coturn's use-auth-secret).
+type TURNConfig struct {
+	// Secret is the shared secret configured on the TURN server.
+	Secret string `cfg:"secret"`
+	// Realm is the TURN server realm, returned to clients as-is.
+	Realm string `cfg:"realm"`
+	// TTL is how long issued credentials stay valid.
+	TTL time.Duration `cfg:"ttl" default:"1h"`
+	// URLs are the TURN/STUN server URLs, e.g. turn:turn.example.com:3478.
+	URLs []string `cfg:"urls"`
+}
+
+// ICEServer is an RTCIceServer entry, usable as-is in RTCPeerConnection's
+// iceServers option.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// TURNCredentials is the response of GET /webrtc/turn.
+type TURNCredentials struct {
+	Username   string      `json:"username"`
+	Credential string      `json:"credential"`
+	Realm      string      `json:"realm,omitempty"`
+	TTL        int64       `json:"ttl"`
+	ExpiresAt  time.Time   `json:"expiresAt"`
+	ICEServers []ICEServer `json:"iceServers"`
+}
+
+// TURNHandler handles GET /webrtc/turn - issues short-lived TURN credentials.
+func (m *RoomManager) TURNHandler(w http.ResponseWriter, r *http.Request) {
+	turn := m.cfg.TURN
+	if turn.Secret == "" || len(turn.URLs) == 0 {
+		writeError(w, http.StatusServiceUnavailable, "TURN is not configured")
+		return
+	}
+
+	username, credential, expiresAt := turnCredentials(turn.Secret, turn.TTL, time.Now())
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, TURNCredentials{
+		Username:   username,
+		Credential: credential,
+		Realm:      turn.Realm,
+		TTL:        int64(turn.TTL.Seconds()),
+		ExpiresAt:  expiresAt,
+		ICEServers: []ICEServer{{
+			URLs:       turn.URLs,
+			Username:   username,
+			Credential: credential,
+		}},
+	})
+}
+
+// turnCredentials returns a "expiry:random" username and its password, the
+// base64 HMAC-SHA1 of the username keyed with secret. The TURN server accepts
+// them until the expiry timestamp.
+func turnCredentials(secret string, ttl time.Duration, now time.Time) (username, credential string, expiresAt time.Time) {
+	expiresAt = now.Add(ttl).Truncate(time.Second).UTC()
+	username = strconv.FormatInt(expiresAt.Unix(), 10) + ":" + randomString(peerIDLength)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return username, credential, expiresAt
+}
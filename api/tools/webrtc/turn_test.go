@@ -0,0 +1,33 @@
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTURNCredentials(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	username, credential, expiresAt := turnCredentials("secret", time.Hour, now)
+
+	if !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expiresAt = %v, want %v", expiresAt, now.Add(time.Hour))
+	}
+
+	timestamp, _, ok := strings.Cut(username, ":")
+	if !ok || timestamp != strconv.FormatInt(expiresAt.Unix(), 10) {
+		t.Fatalf("username = %q, want %d:<random>", username, expiresAt.Unix())
+	}
+
+	// The TURN server derives the same password from the username
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(username))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); credential != want {
+		t.Errorf("credential = %q, want %q", credential, want)
+	}
+}
@@ -0,0 +1,117 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Codes of the signaling messages refused before routing.
+const (
+	CodeInvalidMessage  = "INVALID_MESSAGE"
+	CodeInvalidType     = "INVALID_TYPE"
+	CodeInvalidPayload  = "INVALID_PAYLOAD"
+	CodeMessageTooLarge = "MESSAGE_TOO_LARGE"
+)
+
+// signalTypes are the message types peers may send each other. The others,
+// such as peer_joined, are only sent by the server.
+var signalTypes = map[string]func(payload json.RawMessage) error{
+	"offer":     checkDescription,
+	"answer":    checkDescription,
+	"candidate": checkCandidate,
+	"bye":       checkObject,
+}
+
+// signalError is a signaling message refused before routing.
+type signalError struct {
+	Code    string
+	Message string
+}
+
+func (e *signalError) Error() string {
+	return e.Message
+}
+
+// signalErrorResponse is the body of a refused message on HTTP.
+type signalErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+var (
+	errInvalidMessage = &signalError{Code: CodeInvalidMessage, Message: "Invalid message format"}
+	errTooLarge       = &signalError{Code: CodeMessageTooLarge, Message: "Message too large"}
+)
+
+// validateSignal checks that msg has a type peers may send and a payload
+// well-formed for it.
+func validateSignal(msg SignalMessage) error {
+	check, ok := signalTypes[msg.Type]
+	if !ok {
+		return &signalError{Code: CodeInvalidType, Message: fmt.Sprintf("Invalid message type %q, expected offer, answer, candidate or bye", msg.Type)}
+	}
+
+	if err := check(msg.Payload); err != nil {
+		return &signalError{Code: CodeInvalidPayload, Message: fmt.Sprintf("Invalid %s payload: %v", msg.Type, err)}
+	}
+
+	return nil
+}
+
+// checkDescription checks the payload of an offer or answer, an object with
+// the SDP.
+func checkDescription(payload json.RawMessage) error {
+	var description struct {
+		SDP *string `json:"sdp"`
+	}
+	if err := decodeObject(payload, &description); err != nil {
+		return err
+	}
+	if description.SDP == nil || *description.SDP == "" {
+		return errors.New("sdp is required")
+	}
+
+	return nil
+}
+
+// checkCandidate checks the payload of a candidate, an RTCIceCandidate in its
+// JSON form. An empty candidate string marks the end of the candidates.
+func checkCandidate(payload json.RawMessage) error {
+	var candidate struct {
+		Candidate     *string `json:"candidate"`
+		SDPMid        *string `json:"sdpMid"`
+		SDPMLineIndex *uint16 `json:"sdpMLineIndex"`
+	}
+	if err := decodeObject(payload, &candidate); err != nil {
+		return err
+	}
+	if candidate.Candidate == nil {
+		return errors.New("candidate is required")
+	}
+
+	return nil
+}
+
+// checkObject checks that an optional payload is an object.
+func checkObject(payload json.RawMessage) error {
+	if len(payload) == 0 || string(payload) == "null" {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	return decodeObject(payload, &fields)
+}
+
+// decodeObject decodes payload, which must be a JSON object, into v.
+func decodeObject(payload json.RawMessage, v any) error {
+	if !bytes.HasPrefix(payload, []byte("{")) {
+		return errors.New("expected an object")
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errors.New("invalid field types")
+	}
+
+	return nil
+}
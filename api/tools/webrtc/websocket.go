@@ -0,0 +1,192 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/rakunlabs/logi"
+
+	"github.com/rytsh/bir/api/tools/ip"
+)
+
+// WebSocketHandler handles GET /webrtc/room/{code}/ws?peer={id} - carries
+// signaling in both directions over one WebSocket. Inbound frames are routed
+// like SignalHandler messages; outbound frames are what EventsHandler would
+// stream, so a client uses either this or the HTTP/SSE pair.
+func (m *RoomManager) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	if code == "" {
+		writeError(w, http.StatusBadRequest, "Invalid room code")
+		return
+	}
+
+	room := m.GetRoom(code)
+	if room == nil {
+		writeError(w, http.StatusNotFound, "Room not found")
+		return
+	}
+
+	if err := room.checkPassword(r); err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	// Checked before the peer connects, or a page of another origin that
+	// learned a peer ID could take its place and drop it when refused
+	if !m.allowedOrigin(r) {
+		writeError(w, http.StatusForbidden, "Origin not allowed")
+		return
+	}
+
+	release, err := m.streams.acquire(ip.TrustedClientIP(r))
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	defer release()
+
+	peerID := r.URL.Query().Get("peer")
+
+	msgChan, err := room.connect(peerID)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	defer m.leave(r.Context(), room, peerID)
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		// Browsers don't apply CORS to WebSockets, so the origins it allows
+		// are checked here
+		OriginPatterns: m.origins,
+	})
+	if err != nil {
+		logi.Ctx(r.Context()).Debug("websocket accept failed", "code", code, "error", err, "tools", "webrtc")
+		return
+	}
+	defer conn.CloseNow()
+
+	// Oversized frames close the connection with 1009 (message too big)
+	conn.SetReadLimit(m.maxMessageSize())
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Replies to inbound frames go through the write loop below, so only one
+	// goroutine writes to the connection
+	replies := make(chan SignalMessage, 10)
+
+	go func() {
+		defer cancel()
+
+		reply := func(err error) bool {
+			select {
+			case replies <- errorMessage(err):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			var msg SignalMessage
+			if err := wsjson.Read(ctx, conn, &msg); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if (errors.As(err, &syntaxErr) || errors.As(err, &typeErr)) && reply(errInvalidMessage) {
+					continue
+				}
+				return
+			}
+
+			if err := validateSignal(msg); err != nil {
+				if !reply(err) {
+					return
+				}
+				continue
+			}
+
+			msg.From = peerID
+			if err := m.route(ctx, room, msg); err != nil && !reply(err) {
+				return
+			}
+		}
+	}()
+
+	if err := wsjson.Write(ctx, conn, SignalMessage{Type: "connected"}); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+
+		case msg := <-replies:
+			if err := wsjson.Write(ctx, conn, msg); err != nil {
+				return
+			}
+
+		case msg, ok := <-msgChan:
+			if !ok {
+				// Channel closed, room deleted or server shutting down
+				conn.Close(websocket.StatusGoingAway, "room closed")
+				return
+			}
+
+			if err := wsjson.Write(ctx, conn, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// errorMessage builds an error frame for the WebSocket transport. Refused
+// messages carry the same code as on HTTP.
+func errorMessage(err error) SignalMessage {
+	fields := map[string]string{"error": err.Error()}
+	var signalErr *signalError
+	if errors.As(err, &signalErr) {
+		fields["code"] = signalErr.Code
+	}
+
+	payload, _ := json.Marshal(fields)
+	return SignalMessage{Type: "error", Payload: payload}
+}
+
+// allowedOrigin reports whether the Origin of r may open a WebSocket stream:
+// none (not a browser), the API's own host, or one of m.origins, matched as
+// websocket.Accept does.
+func (m *RoomManager) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, pattern := range m.origins {
+		target := u.Host
+		if strings.Contains(pattern, "://") {
+			target = u.Scheme + "://" + u.Host
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(target)); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package whois
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rytsh/bir/api/tools/deflate"
+)
+
+// cache is a size-bounded LRU cache of WHOIS responses with a fixed TTL.
+// With compress the raw answers, the bulk of a response, are kept deflated.
+type cache struct {
+	ttl      time.Duration
+	maxSize  int
+	compress bool
+	// order holds *cacheEntry values, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	key      string
+	response WhoisResponse
+	storedAt time.Time
+	// raw and referralRaws are the deflated Raw of the response and of its
+	// referrals, which are then left empty.
+	raw          []byte
+	referralRaws [][]byte
+}
+
+func newCache(ttl time.Duration, maxSize int, compress bool) *cache {
+	return &cache{
+		ttl:      ttl,
+		maxSize:  maxSize,
+		compress: compress,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached response for key and when it was stored.
+func (c *cache) get(key string) (WhoisResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return WhoisResponse{}, time.Time{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Since(entry.storedAt) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return WhoisResponse{}, time.Time{}, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return entry.expand(), entry.storedAt, true
+}
+
+// set stores response under key, evicting the least recently used entry when
+// the cache is full.
+func (c *cache) set(key string, response WhoisResponse) {
+	entry := &cacheEntry{key: key, response: response, storedAt: time.Now()}
+	if c.compress {
+		// Deflated outside the lock, it's the costly part
+		entry.shrink()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// shrink moves the raw answers of the response into their deflated form.
+// The referrals are copied, the caller's response may share them.
+func (e *cacheEntry) shrink() {
+	e.raw = deflate.Compress(e.response.Raw)
+	e.response.Raw = ""
+
+	if len(e.response.Referrals) == 0 {
+		return
+	}
+	e.response.Referrals = slices.Clone(e.response.Referrals)
+	e.referralRaws = make([][]byte, len(e.response.Referrals))
+	for i := range e.response.Referrals {
+		e.referralRaws[i] = deflate.Compress(e.response.Referrals[i].Raw)
+		e.response.Referrals[i].Raw = ""
+	}
+}
+
+// expand returns the response with its raw answers inflated back.
+func (e *cacheEntry) expand() WhoisResponse {
+	if e.raw == nil {
+		return e.response
+	}
+
+	response := e.response
+	response.Raw = deflate.Decompress(e.raw)
+	if len(e.referralRaws) > 0 {
+		response.Referrals = slices.Clone(e.response.Referrals)
+		for i, raw := range e.referralRaws {
+			response.Referrals[i].Raw = deflate.Decompress(raw)
+		}
+	}
+
+	return response
+}
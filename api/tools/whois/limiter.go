@@ -0,0 +1,144 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// errQueueFull is returned by a query to a WHOIS server that already has the
+// maximum number of queries waiting.
+var errQueueFull = errors.New("too many queries waiting for the WHOIS server")
+
+// maxLimitedServers caps the servers tracked by a serverLimiter; idle ones are
+// dropped beyond it.
+const maxLimitedServers = 1024
+
+// serverLimiter paces the queries to each WHOIS server, so a burst of lookups
+// for one TLD doesn't get our address banned by its registry. Queries to a
+// server start one at a time at most rate per second; when maxQueue are
+// already waiting, further ones fail with errQueueFull.
+type serverLimiter struct {
+	rate     rate.Limit
+	maxQueue int
+
+	mu      sync.Mutex
+	servers map[string]*serverQueue
+}
+
+type serverQueue struct {
+	limiter *rate.Limiter
+	waiting int
+}
+
+// newServerLimiter returns a limiter of perSecond queries per server, or nil
+// (no limit) when perSecond isn't positive.
+func newServerLimiter(perSecond float64, maxQueue int) *serverLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &serverLimiter{
+		rate:     rate.Limit(perSecond),
+		maxQueue: maxQueue,
+		servers:  make(map[string]*serverQueue),
+	}
+}
+
+// wait blocks until a query to server may start, at most maxWait.
+func (l *serverLimiter) wait(server string, maxWait time.Duration) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	queue, ok := l.servers[server]
+	if !ok {
+		if len(l.servers) >= maxLimitedServers {
+			l.dropIdle()
+		}
+		queue = &serverQueue{limiter: rate.NewLimiter(l.rate, 1)}
+		l.servers[server] = queue
+	}
+	if queue.waiting >= l.maxQueue {
+		l.mu.Unlock()
+		return errQueueFull
+	}
+	queue.waiting++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		queue.waiting--
+		l.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxWait)
+	defer cancel()
+
+	// Fails right away when the wait would outlast maxWait
+	if err := queue.limiter.Wait(ctx); err != nil {
+		return errQueueFull
+	}
+
+	return nil
+}
+
+// dropIdle forgets the servers without waiting queries. l.mu must be held.
+func (l *serverLimiter) dropIdle() {
+	for server, queue := range l.servers {
+		if queue.waiting == 0 {
+			delete(l.servers, server)
+		}
+	}
+}
+
+// queueFull returns response rejected by the limiter.
+func queueFull(response WhoisResponse) WhoisResponse {
+	response.Code = CodeQueueFull
+	response.Error = "too many queries waiting for the WHOIS server, try again later"
+	return response
+}
+
+// retryAfter is a hint of when a rejected query may succeed.
+func (l *serverLimiter) retryAfter() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	return time.Duration(float64(l.maxQueue) / float64(l.rate) * float64(time.Second))
+}
+
+// limitedDialer waits for the server's turn before connecting. It wraps the
+// dialer of the WHOIS client, so referrals and the IANA fallback are paced as
+// well.
+type limitedDialer struct {
+	dialer  *net.Dialer
+	limiter *serverLimiter
+	// maxWait bounds the wait for a turn.
+	maxWait time.Duration
+	// maxSize caps the bytes read from each connection, none when 0.
+	maxSize int64
+}
+
+func (d *limitedDialer) Dial(network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.limiter.wait(host, d.maxWait); err != nil {
+		return nil, err
+	}
+
+	conn, err := d.dialer.Dial(network, address)
+	if err != nil || d.maxSize <= 0 {
+		return conn, err
+	}
+
+	return &cappedConn{Conn: conn, remaining: d.maxSize}, nil
+}
@@ -0,0 +1,45 @@
+package whois
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServerLimiter(t *testing.T) {
+	l := newServerLimiter(4, 1)
+
+	if err := l.wait("whois.example", time.Second); err != nil {
+		t.Fatalf("first query: %v", err)
+	}
+
+	// The next query waits for the server's turn, filling the queue
+	done := make(chan error, 1)
+	go func() { done <- l.wait("whois.example", time.Second) }()
+	for {
+		l.mu.Lock()
+		waiting := l.servers["whois.example"].waiting
+		l.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := l.wait("whois.example", time.Second); !errors.Is(err, errQueueFull) {
+		t.Fatalf("query beyond the queue: err = %v, want %v", err, errQueueFull)
+	}
+	// Other servers have their own turn
+	if err := l.wait("whois.other", time.Second); err != nil {
+		t.Fatalf("query to another server: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("queued query: %v", err)
+	}
+
+	// A turn further away than the wait allowed is rejected right away
+	if err := l.wait("whois.example", time.Millisecond); !errors.Is(err, errQueueFull) {
+		t.Fatalf("query with a short wait: err = %v, want %v", err, errQueueFull)
+	}
+}
@@ -0,0 +1,138 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/likexian/whois"
+
+	"github.com/rytsh/bir/api/tools/metrics"
+)
+
+// abuseContactPattern matches the RIPE/AFRINIC style abuse comment:
+// % Abuse contact for '193.0.0.0 - 193.0.7.255' is 'abuse@ripe.net'
+var abuseContactPattern = regexp.MustCompile(`(?i)abuse contact for .* is '([^']+)'`)
+
+// Field patterns of the RIR (ARIN, RIPE, APNIC, LACNIC, AFRINIC) formats.
+var (
+	netRangePatterns = []string{"NetRange:", "inetnum:", "inet6num:"}
+	cidrPatterns     = []string{"CIDR:", "route:", "route6:"}
+	netNamePatterns  = []string{"NetName:", "netname:"}
+	orgPatterns      = []string{"OrgName:", "org-name:", "Organization:", "owner:", "descr:"}
+	countryPatterns  = []string{"Country:", "country:"}
+	abusePatterns    = []string{"OrgAbuseEmail:", "abuse-mailbox:"}
+	asNamePatterns   = []string{"ASName:", "as-name:"}
+)
+
+// parseASN parses an AS number with or without the "AS" prefix.
+func parseASN(value string) (string, bool) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "AS")
+	if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+		return "", false
+	}
+	return "AS" + value, true
+}
+
+// lookupNetwork queries the RIR responsible for an IP address or ASN. The
+// RIR is found through the whois.iana.org referral.
+func lookupNetwork(ctx context.Context, client *whois.Client, query string, isASN bool) WhoisResponse {
+	response := WhoisResponse{Source: SourceWhois}
+	if isASN {
+		response.ASN = query
+	} else {
+		response.IP = query
+	}
+
+	raw, retries, err := whoisQuery(ctx, client, query)
+	response.Retries = retries
+	if errors.Is(err, errQueueFull) {
+		return queueFull(response)
+	}
+	if err != nil {
+		metrics.UpstreamFailure("whois")
+		response.Error = simplifyError(err)
+		return response
+	}
+
+	raw, response.Truncated = cutTruncated(raw)
+
+	if code, message, ok := checkResponse(raw); !ok {
+		response.Code = code
+		response.RetryAfter = int(retryAfter(raw).Seconds())
+		response.Error = message
+		return response
+	}
+
+	parseNetworkResponse(&response, raw)
+	response.Raw = raw
+
+	return response
+}
+
+// parseNetworkResponse fills the network fields of response from raw. A
+// response may include a referred RIR's data after the first one, so later
+// values override earlier ones as they are more specific.
+func parseNetworkResponse(response *WhoisResponse, raw string) {
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if m := abuseContactPattern.FindStringSubmatch(line); m != nil {
+			response.AbuseEmail = m[1]
+			continue
+		}
+
+		// Skip other comments
+		if strings.HasPrefix(line, "%") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if v, ok := matchField(line, netRangePatterns); ok {
+			response.NetRange = v
+		}
+		if v, ok := matchField(line, cidrPatterns); ok {
+			response.CIDR = v
+		}
+		if v, ok := matchField(line, netNamePatterns); ok {
+			response.NetName = v
+		}
+		if v, ok := matchField(line, orgPatterns); ok && (response.Organization == "" || !strings.HasPrefix(line, "descr:")) {
+			response.Organization = v
+		}
+		if v, ok := matchField(line, countryPatterns); ok {
+			response.Country = strings.ToUpper(v)
+		}
+		if v, ok := matchField(line, abusePatterns); ok && response.AbuseEmail == "" {
+			response.AbuseEmail = v
+		}
+		if response.ASN != "" {
+			if v, ok := matchField(line, asNamePatterns); ok {
+				response.ASName = v
+			}
+		}
+	}
+
+	// APNIC/LACNIC ranges may be given in CIDR form only
+	if response.CIDR == "" {
+		if _, network, err := net.ParseCIDR(response.NetRange); err == nil {
+			response.CIDR = network.String()
+		}
+	}
+}
+
+// matchField returns the value of line when it starts with one of patterns.
+func matchField(line string, patterns []string) (string, bool) {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(line, pattern) {
+			value := strings.TrimSpace(strings.TrimPrefix(line, pattern))
+			return value, value != ""
+		}
+	}
+	return "", false
+}
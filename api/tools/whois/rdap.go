@@ -0,0 +1,319 @@
+package whois
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/rytsh/bir/api/tools/proxy"
+)
+
+const (
+	// rdapBootstrapURL is the IANA registry of RDAP servers per TLD (RFC 9224).
+	rdapBootstrapURL = "https://data.iana.org/rdap/dns.json"
+	// rdapBootstrapRefreshInterval is how long the bootstrap registry is used
+	// before refreshing.
+	rdapBootstrapRefreshInterval = 24 * time.Hour
+	// rdapMaxResponseSize caps an RDAP response body.
+	rdapMaxResponseSize = 1 << 20
+)
+
+// Response sources reported in WhoisResponse.Source.
+const (
+	SourceRDAP  = "rdap"
+	SourceWhois = "whois"
+)
+
+// errNoRDAP is returned when the TLD has no RDAP server.
+var errNoRDAP = errors.New("no RDAP server for TLD")
+
+// errRDAPNotFound is returned for a 404, which RDAP servers send for domains
+// that aren't registered.
+var errRDAPNotFound = errors.New("RDAP object not found")
+
+type rdapBootstrap struct {
+	Services [][][]string `json:"services"`
+}
+
+type rdapDomain struct {
+	LDHName     string           `json:"ldhName"`
+	Status      []string         `json:"status"`
+	Events      []rdapEvent      `json:"events"`
+	Nameservers []rdapNameserver `json:"nameservers"`
+	Entities    []rdapEntity     `json:"entities"`
+}
+
+type rdapEvent struct {
+	Action string `json:"eventAction"`
+	Date   string `json:"eventDate"`
+}
+
+type rdapNameserver struct {
+	LDHName string `json:"ldhName"`
+}
+
+type rdapEntity struct {
+	Roles      []string        `json:"roles"`
+	VCardArray json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity    `json:"entities"`
+}
+
+// rdapClient queries RDAP servers found through the IANA bootstrap registry,
+// which is downloaded on first use and refreshed daily.
+type rdapClient struct {
+	client    *http.Client
+	fetchedAt time.Time
+	// servers maps a TLD to its RDAP base URLs.
+	servers map[string][]string
+	mu      sync.Mutex
+	// refresh runs one bootstrap download at a time, without holding mu.
+	refresh singleflight.Group
+}
+
+var rdap = &rdapClient{
+	client: &http.Client{Timeout: 15 * time.Second, Transport: proxy.Transport()},
+}
+
+// lookup queries the RDAP server of domain's TLD, returning errNoRDAP when the
+// TLD has none.
+func (r *rdapClient) lookup(ctx context.Context, domain string) (WhoisResponse, error) {
+	servers, err := r.load(ctx)
+	if err != nil {
+		return WhoisResponse{}, err
+	}
+
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+	bases := servers[tld]
+	if len(bases) == 0 {
+		return WhoisResponse{}, errNoRDAP
+	}
+
+	// Prefer HTTPS base URLs
+	base := bases[0]
+	for _, b := range bases {
+		if strings.HasPrefix(b, "https://") {
+			base = b
+			break
+		}
+	}
+
+	body, err := r.get(ctx, strings.TrimSuffix(base, "/")+"/domain/"+domain)
+	if errors.Is(err, errRDAPNotFound) {
+		return WhoisResponse{Domain: domain, Source: SourceRDAP, Server: base, Available: true}, nil
+	}
+	if err != nil {
+		return WhoisResponse{}, err
+	}
+
+	var result rdapDomain
+	if err := json.Unmarshal(body, &result); err != nil {
+		return WhoisResponse{}, fmt.Errorf("decode RDAP response: %w", err)
+	}
+
+	response := mapRDAP(domain, result)
+	response.Raw = string(body)
+	response.Server = base
+
+	return response, nil
+}
+
+// load returns the TLD to RDAP server map, refreshing it when stale. A stale
+// copy keeps being used if the refresh fails, until the next interval.
+func (r *rdapClient) load(ctx context.Context) (map[string][]string, error) {
+	r.mu.Lock()
+	servers, fetchedAt := r.servers, r.fetchedAt
+	r.mu.Unlock()
+
+	if servers != nil && time.Since(fetchedAt) < rdapBootstrapRefreshInterval {
+		return servers, nil
+	}
+
+	result := r.refresh.DoChan("", func() (any, error) {
+		// Shared by every waiting lookup, so it mustn't end with the first;
+		// the client timeout bounds it
+		servers, err := r.fetchBootstrap(context.WithoutCancel(ctx))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if err != nil {
+			if r.servers != nil {
+				// retry at the next interval, not on every lookup
+				r.fetchedAt = time.Now()
+				return r.servers, nil
+			}
+			return nil, err
+		}
+
+		r.servers, r.fetchedAt = servers, time.Now()
+
+		return servers, nil
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(map[string][]string), nil
+	case <-ctx.Done():
+		if servers != nil {
+			return servers, nil
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (r *rdapClient) fetchBootstrap(ctx context.Context) (map[string][]string, error) {
+	body, err := r.get(ctx, rdapBootstrapURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var bootstrap rdapBootstrap
+	if err := json.Unmarshal(body, &bootstrap); err != nil {
+		return nil, fmt.Errorf("decode RDAP bootstrap: %w", err)
+	}
+
+	// Each service is [[tlds...], [base URLs...]]
+	servers := make(map[string][]string)
+	for _, service := range bootstrap.Services {
+		if len(service) != 2 {
+			continue
+		}
+		for _, tld := range service[0] {
+			servers[strings.ToLower(tld)] = service[1]
+		}
+	}
+
+	return servers, nil
+}
+
+func (r *rdapClient) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errRDAPNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RDAP server returned status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, rdapMaxResponseSize))
+}
+
+// mapRDAP maps an RDAP domain object onto the WHOIS response fields.
+func mapRDAP(domain string, result rdapDomain) WhoisResponse {
+	response := WhoisResponse{
+		Domain: domain,
+		Source: SourceRDAP,
+	}
+
+	for _, event := range result.Events {
+		switch event.Action {
+		case "registration":
+			response.CreatedDate = normalizeDate(event.Date)
+		case "last changed":
+			response.UpdatedDate = normalizeDate(event.Date)
+		case "expiration":
+			response.ExpiryDate = normalizeDate(event.Date)
+		}
+	}
+
+	for _, ns := range result.Nameservers {
+		name := strings.ToLower(strings.TrimSuffix(ns.LDHName, "."))
+		if name != "" && !containsString(response.Nameservers, name) {
+			response.Nameservers = append(response.Nameservers, name)
+		}
+	}
+
+	for _, status := range result.Status {
+		// RDAP uses spaced names ("client transfer prohibited"); match the
+		// EPP style WHOIS returns (clientTransferProhibited)
+		response.Status = append(response.Status, eppStatus(status))
+	}
+
+	for _, entity := range result.Entities {
+		switch {
+		case containsString(entity.Roles, "registrar"):
+			response.Registrar = vcardField(entity.VCardArray, "fn")
+
+			// The abuse contact is nested under the registrar
+			for _, nested := range entity.Entities {
+				if containsString(nested.Roles, "abuse") {
+					response.AbuseEmail = vcardField(nested.VCardArray, "email")
+				}
+			}
+		case containsString(entity.Roles, "registrant"):
+			response.RegistrantOrg = vcardField(entity.VCardArray, "org")
+			if response.RegistrantOrg == "" {
+				response.RegistrantOrg = vcardField(entity.VCardArray, "fn")
+			}
+			response.PrivacyProtected = isPrivacyMasked(response.RegistrantOrg)
+		}
+	}
+
+	if response.CreatedDate != "" {
+		response.DomainAge = calculateDomainAge(response.CreatedDate)
+	}
+
+	return response
+}
+
+// eppStatus converts an RDAP status ("client transfer prohibited") to its
+// EPP form ("clientTransferProhibited").
+func eppStatus(status string) string {
+	words := strings.Fields(status)
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+	}
+	return strings.Join(words, "")
+}
+
+// vcardField returns the first text value of name in a jCard (RFC 7095)
+// vcardArray: ["vcard", [[name, params, type, value], ...]].
+func vcardField(raw json.RawMessage, name string) string {
+	var vcard []json.RawMessage
+	if err := json.Unmarshal(raw, &vcard); err != nil || len(vcard) != 2 {
+		return ""
+	}
+
+	var props [][]json.RawMessage
+	if err := json.Unmarshal(vcard[1], &props); err != nil {
+		return ""
+	}
+
+	for _, prop := range props {
+		if len(prop) < 4 {
+			continue
+		}
+
+		var propName, value string
+		if json.Unmarshal(prop[0], &propName) != nil || propName != name {
+			continue
+		}
+		if json.Unmarshal(prop[3], &value) == nil {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,164 @@
+package whois
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/likexian/whois"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/metrics"
+)
+
+// maxReferralHops caps the referrals followed by a deep lookup, in case
+// servers refer to each other.
+const maxReferralHops = 3
+
+// referralPrefixes start the line naming the server with more detailed data,
+// usually the registrar's, in a thin registry response.
+var referralPrefixes = []string{
+	"registrar whois server:",
+	"whois server:",
+}
+
+// Referral is a registrar WHOIS server queried by a deep lookup.
+type Referral struct {
+	Server string `json:"server"`
+	Raw    string `json:"raw,omitempty"`
+	// Truncated is whether Raw was cut at the maximum response size.
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// lookupDeep queries the registry WHOIS server of domain and follows its
+// referrals, merging the registrar's registrant and contact details into the
+// registry's answer. client must have referrals disabled so each server's
+// answer is parsed on its own.
+func (h *Handler) lookupDeep(ctx context.Context, client *whois.Client, domain string) (WhoisResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return WhoisResponse{}, err
+	}
+	if !isValidDomain(domain) {
+		return WhoisResponse{}, errInvalidDomain
+	}
+
+	response, err := h.cached(ctx, "deep:"+domain, func() WhoisResponse {
+		response := lookupWhois(ctx, client, domain, h.whoisServer(domain))
+		if response.Error == "" {
+			followReferrals(ctx, client, &response)
+		}
+		return response
+	})
+	if err != nil {
+		return WhoisResponse{}, err
+	}
+	response.UnicodeDomain = idn.ToUnicode(domain)
+
+	return response, nil
+}
+
+// followReferrals queries the server referred to by the registry response,
+// and by each answer in turn, until one refers nowhere new.
+func followReferrals(ctx context.Context, client *whois.Client, response *WhoisResponse) {
+	visited := make(map[string]bool)
+	raw := response.Raw
+	for range maxReferralHops {
+		server := referralServer(raw)
+		if server == "" || visited[server] {
+			break
+		}
+		visited[server] = true
+
+		referral := Referral{Server: server}
+
+		var (
+			retries int
+			err     error
+		)
+		raw, retries, err = whoisQuery(ctx, client, response.Domain, server)
+		response.Retries += retries
+		switch {
+		case errors.Is(err, errQueueFull):
+			referral.Error = queueFull(WhoisResponse{}).Error
+		case err != nil:
+			metrics.UpstreamFailure("whois")
+			referral.Error = simplifyError(err)
+		default:
+			raw, referral.Truncated = cutTruncated(raw)
+			if _, message, ok := checkResponse(raw); !ok {
+				referral.Error = message
+			} else {
+				referral.Raw = raw
+				mergeReferral(response, parseWhoisResponse(response.Domain, raw))
+			}
+		}
+
+		response.Referrals = append(response.Referrals, referral)
+		if referral.Error != "" {
+			break
+		}
+	}
+}
+
+// referralServer returns the WHOIS server raw refers to, empty when none.
+func referralServer(raw string) string {
+	for line := range strings.Lines(raw) {
+		line = strings.TrimSpace(line)
+		lower := strings.ToLower(line)
+
+		for _, prefix := range referralPrefixes {
+			if !strings.HasPrefix(lower, prefix) {
+				continue
+			}
+
+			server := strings.ToLower(strings.TrimSpace(line[len(prefix):]))
+			for _, scheme := range []string{"whois://", "rwhois://", "https://", "http://"} {
+				server = strings.TrimPrefix(server, scheme)
+			}
+			server = strings.TrimRight(server, "/")
+
+			if server != "" && !strings.ContainsAny(server, " \t/") {
+				return server
+			}
+		}
+	}
+
+	return ""
+}
+
+// mergeReferral adds the registrar's answer to the registry's response: its
+// registrant and contact details win, other fields only fill in blanks.
+func mergeReferral(response *WhoisResponse, referral WhoisResponse) {
+	response.RegistrantOrg = cmp.Or(referral.RegistrantOrg, response.RegistrantOrg)
+	response.AbuseEmail = cmp.Or(referral.AbuseEmail, response.AbuseEmail)
+	response.PrivacyProtected = response.PrivacyProtected || referral.PrivacyProtected
+
+	response.Registrar = cmp.Or(response.Registrar, referral.Registrar)
+	response.CreatedDate = cmp.Or(response.CreatedDate, referral.CreatedDate)
+	response.UpdatedDate = cmp.Or(response.UpdatedDate, referral.UpdatedDate)
+	response.ExpiryDate = cmp.Or(response.ExpiryDate, referral.ExpiryDate)
+	response.DomainAge = cmp.Or(response.DomainAge, referral.DomainAge)
+	if len(response.Nameservers) == 0 {
+		response.Nameservers = referral.Nameservers
+	}
+	if len(response.Status) == 0 {
+		response.Status = referral.Status
+	}
+}
+
+// withoutRaw returns response without the raw server responses. The
+// referrals are copied, as response may be shared with the cache.
+func withoutRaw(response WhoisResponse) WhoisResponse {
+	response.Raw = ""
+
+	response.Referrals = slices.Clone(response.Referrals)
+	for i := range response.Referrals {
+		response.Referrals[i].Raw = ""
+	}
+
+	return response
+}
@@ -0,0 +1,150 @@
+package whois
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response codes set on WhoisResponse when the server didn't return WHOIS data.
+const (
+	// CodeThrottled means the server is rate limiting or blocking our queries.
+	CodeThrottled = "THROTTLED"
+	// CodeRateLimited means the server said our query quota is used up.
+	CodeRateLimited = "RATE_LIMITED"
+	// CodeInvalidResponse means the server returned something that isn't
+	// WHOIS data, such as an HTML or CAPTCHA page.
+	CodeInvalidResponse = "INVALID_RESPONSE"
+	// CodeQueueFull means too many of our queries were already waiting for
+	// the server; the request wasn't sent.
+	CodeQueueFull = "QUEUE_FULL"
+)
+
+// throttleMarkers are phrases servers send instead of data when throttling.
+var throttleMarkers = []string{
+	"too many requests",
+	"please try again",
+	"try again later",
+	"connection throttled",
+	"you have been banned",
+	"access denied",
+	"exceeded the maximum allowable number",
+	"exceeded maximum connection limit",
+}
+
+// rateLimitMarkers are the notices of registries whose query quota ran out.
+var rateLimitMarkers = []string{
+	"rate limit exceeded",
+	"whois limit exceeded",
+	"query limit exceeded",
+	"queries limit exceeded",
+	"lookup limit exceeded",
+	"request limit exceeded",
+	"exceeded the query limit",
+	"quota exceeded",
+}
+
+// retryPattern finds the wait a rate limit notice asks for, e.g. "try again
+// in 60 seconds" or "retry after 5 minutes".
+var retryPattern = regexp.MustCompile(`(?i)(?:try again|retry|wait)[^0-9\n]{0,20}(\d+)\s*(seconds?|secs?|s|minutes?|mins?|m|hours?|hrs?|h)\b`)
+
+// htmlMarkers indicate an HTML page (usually a CAPTCHA wall) instead of WHOIS.
+var htmlMarkers = []string{
+	"<html",
+	"<!doctype html",
+	"captcha",
+}
+
+// availableMarkers start the line registries answer with when a domain isn't
+// registered.
+var availableMarkers = []string{
+	"no match for",
+	"not found",
+	"domain not found",
+	"no data found",
+	"no entries found",
+	"status: free",
+	"status: available",
+}
+
+// isAvailable reports whether raw says the domain isn't registered. raw must
+// already have passed checkResponse, so throttle and error pages never count;
+// a response with registration data isn't available whatever its disclaimer
+// says.
+func isAvailable(raw string, parsed WhoisResponse) bool {
+	if parsed.Registrar != "" || parsed.CreatedDate != "" || len(parsed.Nameservers) > 0 {
+		return false
+	}
+
+	for line := range strings.Lines(strings.ToLower(raw)) {
+		line = strings.TrimLeft(line, "%#> \t")
+		for _, marker := range availableMarkers {
+			if strings.HasPrefix(line, marker) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkResponse reports whether raw doesn't look like WHOIS data, returning
+// the response code and a message describing why.
+func checkResponse(raw string) (code, message string, ok bool) {
+	lower := strings.ToLower(raw)
+
+	for _, marker := range htmlMarkers {
+		if strings.Contains(lower, marker) {
+			return CodeInvalidResponse, "WHOIS server returned a web page instead of WHOIS data, likely a CAPTCHA or block page", false
+		}
+	}
+
+	// Throttle notices are short; only check the start of the response so
+	// registry disclaimers further down don't trigger false positives.
+	head := lower
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	for _, marker := range rateLimitMarkers {
+		if strings.Contains(head, marker) {
+			message = "WHOIS server rate limit exceeded, try again later"
+			if wait := retryAfter(raw); wait > 0 {
+				message = fmt.Sprintf("WHOIS server rate limit exceeded, try again in %s", wait)
+			}
+			return CodeRateLimited, message, false
+		}
+	}
+	for _, marker := range throttleMarkers {
+		if strings.Contains(head, marker) {
+			return CodeThrottled, "WHOIS server is throttling requests, try again later", false
+		}
+	}
+
+	return "", "", true
+}
+
+// retryAfter returns the wait a throttle or rate limit notice asks for, zero
+// when it names none.
+func retryAfter(raw string) time.Duration {
+	match := retryPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+
+	unit := time.Second
+	switch strings.ToLower(match[2])[0] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	}
+
+	return time.Duration(n) * unit
+}
@@ -0,0 +1,58 @@
+package whois
+
+import (
+	"io"
+	"net"
+	"strings"
+)
+
+// truncatedNotice ends the data of a connection cut at the maximum response
+// size. The WHOIS client only returns the text it read, so the notice is how
+// the lookups learn of the cut; cutTruncated takes it out again.
+const truncatedNotice = "\n% bir: response truncated"
+
+// cappedConn reads at most remaining bytes from a WHOIS server, then the
+// truncated notice when the server had more to send.
+type cappedConn struct {
+	net.Conn
+
+	remaining int64
+	checked   bool
+	pending   []byte
+}
+
+func (c *cappedConn) Read(b []byte) (int, error) {
+	if c.remaining > 0 {
+		n, err := c.Conn.Read(b[:min(int64(len(b)), c.remaining)])
+		c.remaining -= int64(n)
+		return n, err
+	}
+
+	if !c.checked {
+		c.checked = true
+
+		// one more byte tells a cut answer from one of exactly the cap
+		if n, _ := c.Conn.Read(make([]byte, 1)); n > 0 {
+			c.pending = []byte(truncatedNotice)
+		}
+	}
+
+	if len(c.pending) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// cutTruncated removes the truncated notices of raw and reports whether it
+// had any.
+func cutTruncated(raw string) (string, bool) {
+	if !strings.Contains(raw, truncatedNotice) {
+		return raw, false
+	}
+
+	return strings.ReplaceAll(raw, truncatedNotice, ""), true
+}
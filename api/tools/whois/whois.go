@@ -1,61 +1,405 @@
 package whois
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/likexian/whois"
 	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+	"github.com/rytsh/bir/api/tools/metrics"
+	"github.com/rytsh/bir/api/tools/netguard"
+	"github.com/rytsh/bir/api/tools/outbound"
+	"github.com/rytsh/bir/api/tools/render"
+	"github.com/rytsh/bir/api/tools/retry"
+	"github.com/rytsh/bir/api/tools/timeout"
 )
 
 type WhoisResponse struct {
-	Domain      string   `json:"domain"`
-	Registrar   string   `json:"registrar,omitempty"`
-	CreatedDate string   `json:"createdDate,omitempty"`
-	UpdatedDate string   `json:"updatedDate,omitempty"`
-	ExpiryDate  string   `json:"expiryDate,omitempty"`
-	Nameservers []string `json:"nameservers,omitempty"`
-	Status      []string `json:"status,omitempty"`
-	DomainAge   string   `json:"domainAge,omitempty"`
-	Raw         string   `json:"raw,omitempty"`
-	Error       string   `json:"error,omitempty"`
+	Domain           string   `json:"domain,omitempty"`
+	UnicodeDomain    string   `json:"unicodeDomain,omitempty"`
+	IP               string   `json:"ip,omitempty"`
+	ASN              string   `json:"asn,omitempty"`
+	Registrar        string   `json:"registrar,omitempty"`
+	CreatedDate      string   `json:"createdDate,omitempty"`
+	UpdatedDate      string   `json:"updatedDate,omitempty"`
+	ExpiryDate       string   `json:"expiryDate,omitempty"`
+	Nameservers      []string `json:"nameservers,omitempty"`
+	Status           []string `json:"status,omitempty"`
+	DomainAge        string   `json:"domainAge,omitempty"`
+	NetRange         string   `json:"netRange,omitempty"`
+	CIDR             string   `json:"cidr,omitempty"`
+	NetName          string   `json:"netName,omitempty"`
+	Organization     string   `json:"organization,omitempty"`
+	ASName           string   `json:"asName,omitempty"`
+	Country          string   `json:"country,omitempty"`
+	AbuseEmail       string   `json:"abuseEmail,omitempty"`
+	RegistrantOrg    string   `json:"registrantOrg,omitempty"`
+	PrivacyProtected bool     `json:"privacyProtected"`
+	Available        bool     `json:"available"`
+	Source           string   `json:"source,omitempty"`
+	Server           string   `json:"server,omitempty"`
+	Cached           bool     `json:"cached"`
+	CachedAt         string   `json:"cachedAt,omitempty"`
+	Raw              string   `json:"raw,omitempty"`
+	Truncated        bool     `json:"truncated,omitempty"`
+	Code             string   `json:"code,omitempty"`
+	RetryAfter       int      `json:"retryAfter,omitempty"`
+	Retries          int      `json:"retries,omitempty"`
+	Error            string   `json:"error,omitempty"`
+	// Referrals are the registrar servers followed with deep=true.
+	Referrals []Referral `json:"referrals,omitempty"`
+}
+
+// Config holds the whois handler configuration, loaded from env via chu.
+type Config struct {
+	// CacheTTL is how long a successful lookup is served from memory.
+	CacheTTL time.Duration `cfg:"cache_ttl" default:"1h"`
+	// CacheSize caps the number of cached domains (least recently used are
+	// evicted first).
+	CacheSize int `cfg:"cache_size" default:"1000"`
+	// CacheCompress keeps the raw answers of cached lookups deflated, for
+	// less memory at some CPU cost on every hit.
+	CacheCompress bool `cfg:"cache_compress"`
+	// Timeout bounds a classic WHOIS query, 30s when unset. Requests may
+	// override it with the timeout parameter, which also bounds RDAP.
+	Timeout time.Duration `cfg:"timeout"`
+	// ServerRate caps the queries per second sent to each WHOIS server,
+	// whois.iana.org included; 0 disables the limit. ServerQueue queries may
+	// wait for their turn, the requests beyond it get 429.
+	ServerRate  float64 `cfg:"server_rate" default:"2"`
+	ServerQueue int     `cfg:"server_queue" default:"10"`
+	// Servers maps a TLD, or a longer suffix such as co.uk, to the WHOIS
+	// server (host or host:port) queried for its domains instead of the one
+	// found through whois.iana.org.
+	Servers map[string]string `cfg:"servers"`
+	// MaxResponseSize caps the bytes read from a WHOIS server, 256KB when
+	// unset; longer answers are cut and flagged as truncated.
+	MaxResponseSize int64 `cfg:"max_response_size"`
+}
+
+// whoisTimeout bounds a classic WHOIS query when no timeout is configured.
+const whoisTimeout = 30 * time.Second
+
+// maxResponseSize caps the answer of a WHOIS server when no cap is
+// configured.
+const maxResponseSize = 256 << 10
+
+// Handler serves the whois endpoint.
+type Handler struct {
+	cache   *cache
+	client  *whois.Client
+	timeout time.Duration
+	guard   *netguard.Guard
+	limiter *serverLimiter
+	maxSize int64
+	// servers are the WHOIS server overrides by lowercase suffix.
+	servers map[string]string
 }
 
-// Whois handles WHOIS lookup requests
-func Whois(c *ada.Context) error {
+// New builds a whois Handler from the given config. A non-nil guard keeps
+// WHOIS referrals from reaching internal addresses.
+func New(cfg Config, guard *netguard.Guard) *Handler {
+	h := &Handler{
+		cache:   newCache(cfg.CacheTTL, cfg.CacheSize, cfg.CacheCompress),
+		timeout: cmp.Or(cfg.Timeout, whoisTimeout),
+		guard:   guard,
+		limiter: newServerLimiter(cfg.ServerRate, cfg.ServerQueue),
+		maxSize: cmp.Or(cfg.MaxResponseSize, maxResponseSize),
+		servers: make(map[string]string, len(cfg.Servers)),
+	}
+	for suffix, server := range cfg.Servers {
+		suffix = strings.Trim(strings.ToLower(strings.TrimSpace(suffix)), ".")
+		if server = strings.ToLower(strings.TrimSpace(server)); suffix != "" && server != "" {
+			h.servers[suffix] = server
+		}
+	}
+	h.client = h.newClient(h.timeout)
+
+	return h
+}
+
+// newClient returns a WHOIS client whose queries, connecting and waiting for
+// the server's turn included, last at most timeout.
+func (h *Handler) newClient(timeout time.Duration) *whois.Client {
+	return whois.NewClient().
+		SetDialer(&limitedDialer{
+			dialer:  h.guard.Dialer(&net.Dialer{Timeout: timeout}),
+			limiter: h.limiter,
+			maxWait: timeout,
+			maxSize: h.maxSize,
+		}).
+		SetTimeout(timeout)
+}
+
+// Whois handles WHOIS lookup requests for a domain, an IP address or an ASN.
+// The raw server responses are only included with raw=true.
+func (h *Handler) Whois(c *ada.Context) error {
 	domain := strings.TrimSpace(c.Request.URL.Query().Get("domain"))
+	ip := strings.TrimSpace(c.Request.URL.Query().Get("ip"))
+	asn := strings.TrimSpace(c.Request.URL.Query().Get("asn"))
+	deep := c.Request.URL.Query().Get("deep") == "true"
+
+	if deep && domain == "" {
+		return render.Send(c, http.StatusBadRequest, WhoisResponse{Error: "deep only applies to domain lookups"})
+	}
+
+	requestTimeout, err := timeout.FromQuery(c.Request.URL.Query())
+	if err != nil {
+		return render.Send(c, http.StatusBadRequest, WhoisResponse{Error: err.Error()})
+	}
+
+	// The client bounds each query; the deadline bounds their retries
+	ctx, cancel := context.WithTimeout(c.Request.Context(), cmp.Or(requestTimeout, h.timeout))
+	defer cancel()
+
+	client := h.client
+	if requestTimeout > 0 {
+		client = h.newClient(requestTimeout)
+	}
 
-	if domain == "" {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(WhoisResponse{Error: "domain parameter is required"})
+	var response WhoisResponse
+	switch {
+	case deep:
+		response, err = h.lookupDeep(ctx, h.newClient(cmp.Or(requestTimeout, h.timeout)).SetDisableReferral(true), domain)
+	case domain != "":
+		response, err = h.lookupDomain(ctx, client, domain)
+	case ip != "":
+		response, err = h.lookupIP(ctx, client, ip)
+	case asn != "":
+		response, err = h.lookupASN(ctx, client, asn)
+	default:
+		return render.Send(c, http.StatusBadRequest, WhoisResponse{Error: "domain, ip or asn parameter is required"})
+	}
+	if err != nil {
+		return render.Send(c, outbound.Status(c.Response, err), WhoisResponse{Error: err.Error()})
 	}
 
-	// Clean domain
-	domain = cleanDomain(domain)
+	if response.Code == CodeQueueFull {
+		c.Response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.limiter.retryAfter().Seconds()))))
+		return render.Send(c, http.StatusTooManyRequests, response)
+	}
 
+	if response.RetryAfter > 0 {
+		c.Response.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+	}
+
+	if c.Request.URL.Query().Get("raw") != "true" {
+		response = withoutRaw(response)
+	}
+
+	return render.Send(c, http.StatusOK, response)
+}
+
+// Errors returned by the lookups for malformed queries.
+var (
+	errInvalidDomain = errors.New("invalid domain format")
+	errInvalidIP     = errors.New("invalid IP address")
+	errInvalidASN    = errors.New("invalid ASN")
+)
+
+// Lookup returns the registration data of domain, from the cache when
+// available. The error reports an invalid domain; lookup failures are part of
+// the response.
+func (h *Handler) Lookup(ctx context.Context, domain string) (WhoisResponse, error) {
+	return h.lookupDomain(ctx, h.client, domain)
+}
+
+func (h *Handler) lookupDomain(ctx context.Context, client *whois.Client, domain string) (WhoisResponse, error) {
+	domain, err := cleanDomain(domain)
+	if err != nil {
+		return WhoisResponse{}, err
+	}
 	if !isValidDomain(domain) {
-		return c.SetStatus(http.StatusBadRequest).SendJSON(WhoisResponse{Error: "invalid domain format"})
+		return WhoisResponse{}, errInvalidDomain
 	}
 
-	// Perform WHOIS lookup
-	raw, err := whois.Whois(domain)
-	if err != nil && strings.Contains(err.Error(), "no whois server") {
-		raw, err = whois.Whois(domain, "whois.iana.org")
+	response, err := h.cached(ctx, domain, func() WhoisResponse {
+		return lookup(ctx, client, domain, h.whoisServer(domain))
+	})
+	if err != nil {
+		return WhoisResponse{}, err
 	}
+	response.UnicodeDomain = idn.ToUnicode(domain)
+
+	return response, nil
+}
+
+// LookupIP returns the network registration of an IP address from its RIR,
+// sharing the domain cache.
+func (h *Handler) LookupIP(ip string) (WhoisResponse, error) {
+	return h.lookupIP(context.Background(), h.client, ip)
+}
+
+func (h *Handler) lookupIP(ctx context.Context, client *whois.Client, ip string) (WhoisResponse, error) {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return WhoisResponse{}, errInvalidIP
+	}
+
+	query := parsed.String()
+	return h.cached(ctx, "net:"+query, func() WhoisResponse {
+		return lookupNetwork(ctx, client, query, false)
+	})
+}
+
+// LookupASN returns the registration of an AS number, with or without the
+// "AS" prefix, from its RIR.
+func (h *Handler) LookupASN(asn string) (WhoisResponse, error) {
+	return h.lookupASN(context.Background(), h.client, asn)
+}
+
+func (h *Handler) lookupASN(ctx context.Context, client *whois.Client, asn string) (WhoisResponse, error) {
+	query, ok := parseASN(asn)
+	if !ok {
+		return WhoisResponse{}, errInvalidASN
+	}
+
+	return h.cached(ctx, "net:"+query, func() WhoisResponse {
+		return lookupNetwork(ctx, client, query, true)
+	})
+}
+
+// cached returns the cached response for key, or runs fetch and caches its
+// response. The error is outbound.ErrBusy when no lookup slot is free.
+func (h *Handler) cached(ctx context.Context, key string, fetch func() WhoisResponse) (WhoisResponse, error) {
+	response, storedAt, ok := h.cache.get(key)
+	metrics.WhoisCache(ok)
+	if ok {
+		response.Cached = true
+		response.CachedAt = storedAt.UTC().Format(time.RFC3339)
+		return response, nil
+	}
+
+	release, err := outbound.Acquire(ctx)
 	if err != nil {
-		return c.SetStatus(http.StatusOK).SendJSON(WhoisResponse{
-			Domain: domain,
-			Error:  simplifyError(err),
-		})
+		return WhoisResponse{}, err
+	}
+	defer release()
+
+	response = fetch()
+
+	// Only cache real answers; errors and throttling should be retried
+	if response.Error == "" && !slices.ContainsFunc(response.Referrals, func(r Referral) bool { return r.Error != "" }) {
+		h.cache.set(key, response)
+	}
+
+	return response, nil
+}
+
+// whoisServer returns the configured WHOIS server for the longest suffix of
+// domain, empty when none is.
+func (h *Handler) whoisServer(domain string) string {
+	for suffix := domain; ; {
+		_, after, found := strings.Cut(suffix, ".")
+		if !found {
+			return ""
+		}
+		if server, ok := h.servers[after]; ok {
+			return server
+		}
+		suffix = after
+	}
+}
+
+// lookup queries RDAP, falling back to classic WHOIS when the TLD has no RDAP
+// server or the query fails. A non-empty server, configured for the TLD, is
+// queried directly instead.
+func lookup(ctx context.Context, client *whois.Client, domain, server string) WhoisResponse {
+	if server != "" {
+		return lookupWhois(ctx, client, domain, server)
+	}
+
+	rdapCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	response, err := rdap.lookup(rdapCtx, domain)
+	cancel()
+	if err == nil {
+		return response
+	}
+	if !errors.Is(err, errNoRDAP) {
+		metrics.UpstreamFailure("rdap")
+	}
+
+	return lookupWhois(ctx, client, domain, server)
+}
+
+// whoisQuery sends query as client.Whois does, retrying transient failures,
+// and returns the answer and the number of retries.
+func whoisQuery(ctx context.Context, client *whois.Client, query string, servers ...string) (string, int, error) {
+	var raw string
+	attempts, err := retry.Do(ctx, "whois", func() (err error) {
+		raw, err = client.Whois(query, servers...)
+		return err
+	})
+
+	return raw, attempts - 1, err
+}
+
+// lookupWhois queries server, or when empty the classic WHOIS server of
+// domain's TLD.
+func lookupWhois(ctx context.Context, client *whois.Client, domain, server string) WhoisResponse {
+	var (
+		raw     string
+		retries int
+		err     error
+	)
+	if server != "" {
+		raw, retries, err = whoisQuery(ctx, client, domain, server)
+	} else {
+		raw, retries, err = whoisQuery(ctx, client, domain)
+		if err != nil && strings.Contains(err.Error(), "no whois server") {
+			server = "whois.iana.org"
+			raw, retries, err = whoisQuery(ctx, client, domain, server)
+		}
+	}
+	if errors.Is(err, errQueueFull) {
+		return queueFull(WhoisResponse{Domain: domain, Server: server})
+	}
+	if err != nil {
+		metrics.UpstreamFailure("whois")
+		return WhoisResponse{
+			Domain:  domain,
+			Server:  server,
+			Retries: retries,
+			Error:   simplifyError(err),
+		}
+	}
+
+	raw, truncated := cutTruncated(raw)
+
+	// Don't parse throttle notices or CAPTCHA pages into a blank result
+	if code, message, ok := checkResponse(raw); !ok {
+		return WhoisResponse{
+			Domain:     domain,
+			Server:     server,
+			Code:       code,
+			RetryAfter: int(retryAfter(raw).Seconds()),
+			Retries:    retries,
+			Error:      message,
+		}
 	}
 
 	// Parse the raw WHOIS response
 	response := parseWhoisResponse(domain, raw)
-	return c.SetStatus(http.StatusOK).SendJSON(response)
+	response.Source = SourceWhois
+	response.Server = server
+	response.Retries = retries
+	response.Truncated = truncated
+	response.Available = isAvailable(raw, response)
+	return response
 }
 
-func cleanDomain(domain string) string {
+func cleanDomain(domain string) (string, error) {
 	// Remove protocol
 	domain = strings.TrimPrefix(domain, "https://")
 	domain = strings.TrimPrefix(domain, "http://")
@@ -69,7 +413,8 @@ func cleanDomain(domain string) string {
 	}
 	// Remove www prefix for cleaner lookup
 	domain = strings.TrimPrefix(domain, "www.")
-	return strings.ToLower(strings.TrimSpace(domain))
+	// Internationalized domains are registered by their punycode form
+	return idn.ToASCII(strings.ToLower(strings.TrimSpace(domain)))
 }
 
 func isValidDomain(domain string) bool {
@@ -147,6 +492,28 @@ func parseWhoisResponse(domain, raw string) WhoisResponse {
 		"status:",
 	}
 
+	abuseEmailPatterns := []string{
+		"Registrar Abuse Contact Email:",
+		"Abuse Contact Email:",
+		"abuse-mailbox:",
+		"Abuse Email:",
+	}
+
+	registrantOrgPatterns := []string{
+		"Registrant Organization:",
+		"Registrant Organisation:",
+		"Registrant:",
+	}
+
+	// registrantPrefixes are the lines checked for privacy-service markers
+	registrantPrefixes := []string{
+		"Registrant Name:",
+		"Registrant Organization:",
+		"Registrant Organisation:",
+		"Registrant Email:",
+		"Registrant:",
+	}
+
 	nameservers := make([]string, 0)
 	statuses := make([]string, 0)
 
@@ -211,6 +578,36 @@ func parseWhoisResponse(domain, raw string) WhoisResponse {
 			}
 		}
 
+		// Check for abuse contact email
+		if response.AbuseEmail == "" {
+			for _, pattern := range abuseEmailPatterns {
+				if strings.HasPrefix(line, pattern) {
+					response.AbuseEmail = strings.TrimSpace(strings.TrimPrefix(line, pattern))
+					break
+				}
+			}
+		}
+
+		// Check for registrant organization
+		if response.RegistrantOrg == "" {
+			for _, pattern := range registrantOrgPatterns {
+				if strings.HasPrefix(line, pattern) {
+					response.RegistrantOrg = strings.TrimSpace(strings.TrimPrefix(line, pattern))
+					break
+				}
+			}
+		}
+
+		// Check registrant fields for privacy services
+		if !response.PrivacyProtected {
+			for _, prefix := range registrantPrefixes {
+				if strings.HasPrefix(line, prefix) {
+					response.PrivacyProtected = isPrivacyMasked(strings.TrimPrefix(line, prefix))
+					break
+				}
+			}
+		}
+
 		// Check for status (multiple)
 		for _, pattern := range statusPatterns {
 			if strings.HasPrefix(line, pattern) {
@@ -243,6 +640,36 @@ func parseWhoisResponse(domain, raw string) WhoisResponse {
 	return response
 }
 
+// privacyMarkers are phrases used by privacy/proxy services and GDPR
+// redaction in place of the registrant's details.
+var privacyMarkers = []string{
+	"redacted for privacy",
+	"redacted",
+	"domains by proxy",
+	"whoisguard",
+	"withheld for privacy",
+	"privacy protect",
+	"contact privacy",
+	"privacyguardian",
+	"whois privacy",
+	"identity protection service",
+	"data protected",
+	"not disclosed",
+	"gdpr masked",
+}
+
+// isPrivacyMasked reports whether a registrant value is a privacy-service
+// placeholder rather than the real registrant.
+func isPrivacyMasked(value string) bool {
+	value = strings.ToLower(value)
+	for _, marker := range privacyMarkers {
+		if strings.Contains(value, marker) {
+			return true
+		}
+	}
+	return false
+}
+
 func normalizeDate(dateStr string) string {
 	// Try to parse various date formats and return ISO format
 	formats := []string{
@@ -328,6 +755,9 @@ func containsString(slice []string, str string) bool {
 }
 
 func simplifyError(err error) string {
+	if errors.Is(err, netguard.ErrBlocked) {
+		return "WHOIS server is on an internal address"
+	}
 	errStr := err.Error()
 	if strings.Contains(errStr, "timeout") {
 		return "WHOIS server timed out"
@@ -0,0 +1,550 @@
+package whois
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rakunlabs/ada"
+
+	"github.com/rytsh/bir/api/tools/idn"
+)
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		code string
+	}{
+		{
+			name: "whois data",
+			raw:  "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar, Inc.\nCreation Date: 1995-08-14T04:00:00Z\n",
+		},
+		{
+			name: "disclaimer mentioning access",
+			raw:  "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar, Inc.\n" + longDisclaimer + "\nAccess denied to automated queries.\n",
+		},
+		{
+			name: "throttle message",
+			raw:  "Your connection limit exceeded. Please try again later.\n",
+			code: CodeThrottled,
+		},
+		{
+			name: "too many requests",
+			raw:  "% Too many requests from 203.0.113.9\n",
+			code: CodeThrottled,
+		},
+		{
+			name: "query rate limit",
+			raw:  "Query rate limit exceeded. Please try again in 60 seconds.\n",
+			code: CodeRateLimited,
+		},
+		{
+			name: "whois limit",
+			raw:  "WHOIS LIMIT EXCEEDED - SEE WWW.PIR.ORG/WHOIS FOR DETAILS\n",
+			code: CodeRateLimited,
+		},
+		{
+			name: "captcha page",
+			raw:  "<!DOCTYPE html>\n<html><head><title>Verify</title></head><body><div class=\"g-recaptcha\"></div></body></html>",
+			code: CodeInvalidResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, ok := checkResponse(tt.raw)
+			if ok != (tt.code == "") || code != tt.code {
+				t.Fatalf("checkResponse = %q, %v; want %q", code, ok, tt.code)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := map[string]time.Duration{
+		"Query rate limit exceeded. Please try again in 60 seconds.":     time.Minute,
+		"Rate limit exceeded, retry after 5 minutes":                     5 * time.Minute,
+		"Quota exceeded. Wait 1h before querying again.":                 time.Hour,
+		"WHOIS LIMIT EXCEEDED - SEE WWW.PIR.ORG/WHOIS FOR DETAILS":       0,
+		"Please try again later, the 2 servers are busy for 10 minutes.": 0,
+	}
+
+	for raw, want := range tests {
+		if got := retryAfter(raw); got != want {
+			t.Errorf("retryAfter(%q) = %s, want %s", raw, got, want)
+		}
+	}
+}
+
+const longDisclaimer = `TERMS OF USE: You are not authorized to access or query our Whois
+database through the use of electronic processes that are high-volume and
+automated except as reasonably necessary to register domain names or
+modify existing registrations; the Data in VeriSign Global Registry
+Services' ("VeriSign") Whois database is provided by VeriSign for
+information purposes only, and to assist persons in obtaining information
+about or related to a domain name registration record. VeriSign does not
+guarantee its accuracy. By submitting a Whois query, you agree to abide
+by the following terms of use.`
+
+func TestMapRDAP(t *testing.T) {
+	raw := `{
+		"ldhName": "EXAMPLE.COM",
+		"status": ["client delete prohibited", "active"],
+		"events": [
+			{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+			{"eventAction": "expiration", "eventDate": "2030-08-13T04:00:00Z"}
+		],
+		"nameservers": [{"ldhName": "A.IANA-SERVERS.NET"}, {"ldhName": "B.IANA-SERVERS.NET"}],
+		"entities": [{
+			"roles": ["registrar"],
+			"vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "RESERVED-Internet Assigned Numbers Authority"]]]
+		}]
+	}`
+
+	var result rdapDomain
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	response := mapRDAP("example.com", result)
+	if response.Registrar != "RESERVED-Internet Assigned Numbers Authority" {
+		t.Errorf("registrar = %q", response.Registrar)
+	}
+	if response.CreatedDate != "1995-08-14T04:00:00Z" || response.ExpiryDate != "2030-08-13T04:00:00Z" {
+		t.Errorf("dates = %q, %q", response.CreatedDate, response.ExpiryDate)
+	}
+	if len(response.Nameservers) != 2 || response.Nameservers[0] != "a.iana-servers.net" {
+		t.Errorf("nameservers = %v", response.Nameservers)
+	}
+	if len(response.Status) != 2 || response.Status[0] != "clientDeleteProhibited" {
+		t.Errorf("status = %v", response.Status)
+	}
+	if response.Source != SourceRDAP {
+		t.Errorf("source = %q", response.Source)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(time.Hour, 2, false)
+
+	c.set("a.com", WhoisResponse{Domain: "a.com"})
+	c.set("b.com", WhoisResponse{Domain: "b.com"})
+
+	// Touch a.com so b.com becomes the least recently used
+	if _, _, ok := c.get("a.com"); !ok {
+		t.Fatal("a.com missing")
+	}
+	c.set("c.com", WhoisResponse{Domain: "c.com"})
+
+	if _, _, ok := c.get("b.com"); ok {
+		t.Fatal("b.com should have been evicted")
+	}
+	for _, key := range []string{"a.com", "c.com"} {
+		if response, _, ok := c.get(key); !ok || response.Domain != key {
+			t.Fatalf("get(%q) = %+v, %v", key, response, ok)
+		}
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := newCache(time.Nanosecond, 10, false)
+	c.set("a.com", WhoisResponse{Domain: "a.com"})
+	time.Sleep(time.Millisecond)
+
+	if _, _, ok := c.get("a.com"); ok {
+		t.Fatal("expired entry was returned")
+	}
+}
+
+func TestCacheCompress(t *testing.T) {
+	c := newCache(time.Hour, 10, true)
+
+	raw := strings.Repeat("Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar\n", 50)
+	referrals := []Referral{{Server: "whois.example-registrar.com", Raw: raw + "Registrant Organization: Example\n"}, {Server: "whois.down.test", Error: "timeout"}}
+	c.set("example.com", WhoisResponse{Domain: "example.com", Raw: raw, Referrals: referrals})
+
+	// The caller's referrals are left alone
+	if referrals[0].Raw == "" {
+		t.Fatal("set() cleared the caller's referral raw")
+	}
+
+	elem := c.entries["example.com"].Value.(*cacheEntry)
+	if elem.response.Raw != "" || len(elem.raw) >= len(raw) {
+		t.Fatalf("stored raw = %d bytes uncompressed, %d compressed, want it compressed", len(elem.response.Raw), len(elem.raw))
+	}
+
+	response, _, ok := c.get("example.com")
+	if !ok || response.Raw != raw || !slices.Equal(response.Referrals, referrals) {
+		t.Fatalf("get() = %+v, %v, want the stored response back", response, ok)
+	}
+}
+
+func TestParseNetworkResponse(t *testing.T) {
+	arin := `NetRange:       8.8.8.0 - 8.8.8.255
+CIDR:           8.8.8.0/24
+NetName:        GOGL
+Organization:   Google LLC (GOGL)
+OrgName:        Google LLC
+Country:        US
+OrgAbuseEmail:  network-abuse@google.com
+`
+	var response WhoisResponse
+	parseNetworkResponse(&response, arin)
+	if response.NetRange != "8.8.8.0 - 8.8.8.255" || response.CIDR != "8.8.8.0/24" || response.NetName != "GOGL" {
+		t.Errorf("network = %+v", response)
+	}
+	if response.Organization != "Google LLC" || response.Country != "US" || response.AbuseEmail != "network-abuse@google.com" {
+		t.Errorf("contact = %+v", response)
+	}
+
+	ripe := `% Abuse contact for '193.0.0.0 - 193.0.7.255' is 'abuse@ripe.net'
+
+inetnum:        193.0.0.0 - 193.0.7.255
+netname:        RIPE-NCC
+descr:          RIPE Network Coordination Centre
+country:        nl
+route:          193.0.0.0/21
+`
+	response = WhoisResponse{}
+	parseNetworkResponse(&response, ripe)
+	if response.NetRange != "193.0.0.0 - 193.0.7.255" || response.CIDR != "193.0.0.0/21" || response.Country != "NL" {
+		t.Errorf("network = %+v", response)
+	}
+	if response.Organization != "RIPE Network Coordination Centre" || response.AbuseEmail != "abuse@ripe.net" {
+		t.Errorf("contact = %+v", response)
+	}
+
+	asn := "aut-num:        AS3333\nas-name:        RIPE-NCC-AS\n"
+	response = WhoisResponse{ASN: "AS3333"}
+	parseNetworkResponse(&response, asn)
+	if response.ASName != "RIPE-NCC-AS" {
+		t.Errorf("asName = %q", response.ASName)
+	}
+}
+
+func TestParseWhoisContacts(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		abuseEmail    string
+		registrantOrg string
+		privacy       bool
+	}{
+		{
+			name: "gTLD registrar with privacy service",
+			raw: `Domain Name: example.com
+Registrar: GoDaddy.com, LLC
+Registrar Abuse Contact Email: abuse@godaddy.com
+Registrant Organization: Domains By Proxy, LLC
+Registrant Email: Select Contact Domain Holder link at https://www.godaddy.com/whois
+`,
+			abuseEmail:    "abuse@godaddy.com",
+			registrantOrg: "Domains By Proxy, LLC",
+			privacy:       true,
+		},
+		{
+			name: "gTLD registrar with GDPR redaction",
+			raw: `Domain Name: example.org
+Registrar: Example Registrar
+Registrar Abuse Contact Email: abuse@registrar.example
+Registrant Name: REDACTED FOR PRIVACY
+Registrant Organization: Example Org Inc.
+`,
+			abuseEmail:    "abuse@registrar.example",
+			registrantOrg: "Example Org Inc.",
+			privacy:       true,
+		},
+		{
+			name: "ccTLD with public registrant",
+			raw: `domain:         example.nl
+Registrant:     Stichting Example
+Abuse Contact Email: abuse@example.nl
+`,
+			abuseEmail:    "abuse@example.nl",
+			registrantOrg: "Stichting Example",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := parseWhoisResponse("example.com", tt.raw)
+			if response.AbuseEmail != tt.abuseEmail {
+				t.Errorf("abuseEmail = %q, want %q", response.AbuseEmail, tt.abuseEmail)
+			}
+			if response.RegistrantOrg != tt.registrantOrg {
+				t.Errorf("registrantOrg = %q, want %q", response.RegistrantOrg, tt.registrantOrg)
+			}
+			if response.PrivacyProtected != tt.privacy {
+				t.Errorf("privacyProtected = %v, want %v", response.PrivacyProtected, tt.privacy)
+			}
+		})
+	}
+}
+
+func TestLookupServesCache(t *testing.T) {
+	h := New(Config{CacheTTL: time.Hour, CacheSize: 10}, nil)
+	h.cache.set("example.com", WhoisResponse{Domain: "example.com", Registrar: "Example Registrar"})
+	h.cache.set("net:AS13335", WhoisResponse{ASN: "AS13335", ASName: "CLOUDFLARENET"})
+	h.cache.set("net:2001:db8::1", WhoisResponse{IP: "2001:db8::1", NetName: "EXAMPLE"})
+
+	response, err := h.Lookup(context.Background(), "https://Example.com/")
+	if err != nil || !response.Cached || response.Registrar != "Example Registrar" {
+		t.Fatalf("Lookup() = %+v, %v", response, err)
+	}
+
+	response, err = h.LookupASN("13335")
+	if err != nil || !response.Cached || response.ASName != "CLOUDFLARENET" {
+		t.Fatalf("LookupASN() = %+v, %v", response, err)
+	}
+
+	// IPs are normalized before the cache lookup
+	response, err = h.LookupIP("2001:0db8:0::1")
+	if err != nil || !response.Cached || response.NetName != "EXAMPLE" {
+		t.Fatalf("LookupIP() = %+v, %v", response, err)
+	}
+}
+
+func TestLookupRejectsInvalidInput(t *testing.T) {
+	h := New(Config{CacheTTL: time.Hour, CacheSize: 10}, nil)
+
+	if _, err := h.Lookup(context.Background(), "localhost"); !errors.Is(err, errInvalidDomain) {
+		t.Errorf("Lookup() error = %v, want %v", err, errInvalidDomain)
+	}
+	if _, err := h.Lookup(context.Background(), "a\u200db.de"); !errors.Is(err, idn.ErrInvalid) {
+		t.Errorf("Lookup() error = %v, want %v", err, idn.ErrInvalid)
+	}
+	if _, err := h.LookupIP("1.2.3"); !errors.Is(err, errInvalidIP) {
+		t.Errorf("LookupIP() error = %v, want %v", err, errInvalidIP)
+	}
+	if _, err := h.LookupASN("ASX"); !errors.Is(err, errInvalidASN) {
+		t.Errorf("LookupASN() error = %v, want %v", err, errInvalidASN)
+	}
+}
+
+func TestIsAvailable(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		available bool
+	}{
+		{
+			name:      "verisign no match",
+			raw:       "No match for \"EXAMPLE-UNREGISTERED.COM\".\n>>> Last update of whois database: 2024-01-01T00:00:00Z <<<\n" + longDisclaimer,
+			available: true,
+		},
+		{
+			name:      "not found",
+			raw:       "NOT FOUND\n>>> Last update of WHOIS database: 2024-01-01T00:00:00Z <<<\n",
+			available: true,
+		},
+		{
+			name:      "commented marker",
+			raw:       "%% No entries found for the selected source(s).\n",
+			available: true,
+		},
+		{
+			name:      "denic free",
+			raw:       "Domain: example-unregistered.de\nStatus: free\n",
+			available: true,
+		},
+		{
+			name: "registered with disclaimer",
+			raw:  "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar, Inc.\nCreation Date: 1995-08-14T04:00:00Z\nNOT FOUND entries are not shown\n",
+		},
+		{
+			name: "marker inside text",
+			raw:  "Domain Name: EXAMPLE.NET\nThe requested page was not found on this server\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, ok := checkResponse(tt.raw); !ok {
+				t.Fatal("checkResponse rejected the response")
+			}
+			parsed := parseWhoisResponse("example.com", tt.raw)
+			if got := isAvailable(tt.raw, parsed); got != tt.available {
+				t.Fatalf("isAvailable() = %v, want %v", got, tt.available)
+			}
+		})
+	}
+}
+
+func TestWhoisRawOnRequest(t *testing.T) {
+	h := New(Config{CacheTTL: time.Hour, CacheSize: 10}, nil)
+	h.cache.set("example.com", WhoisResponse{Domain: "example.com", Raw: "Domain Name: EXAMPLE.COM\n"})
+
+	for _, tt := range []struct {
+		query string
+		raw   bool
+	}{
+		{query: "?domain=example.com"},
+		{query: "?domain=example.com&raw=true", raw: true},
+		// The default response mustn't have dropped it from the cache
+		{query: "?domain=example.com&raw=false"},
+		{query: "?domain=example.com&raw=true", raw: true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/whois"+tt.query, nil)
+		rec := httptest.NewRecorder()
+		if err := h.Whois(ada.NewContext(rec, req)); err != nil {
+			t.Fatal(err)
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["raw"]; ok != tt.raw {
+			t.Errorf("%s: raw present = %v, want %v", tt.query, ok, tt.raw)
+		}
+	}
+}
+
+// whoisServer serves answer to every query until the test ends, returning its
+// address.
+func whoisServer(t *testing.T, answer func(addr string) string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Read(make([]byte, 512))
+			_, _ = conn.Write([]byte(answer(ln.Addr().String())))
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestFollowReferrals(t *testing.T) {
+	// The registrar refers to itself, which must not loop
+	registrar := whoisServer(t, func(addr string) string {
+		return "Domain Name: example.com\nRegistrar WHOIS Server: " + addr + "\nRegistrant Organization: Example Org\nRegistrar Abuse Contact Email: abuse@registrar.test\n"
+	})
+
+	response := parseWhoisResponse("example.com", "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar, Inc.\nRegistrar WHOIS Server: whois://"+registrar+"/\nCreation Date: 1995-08-14T04:00:00Z\n")
+	followReferrals(context.Background(), New(Config{}, nil).newClient(5*time.Second).SetDisableReferral(true), &response)
+
+	if len(response.Referrals) != 1 || response.Referrals[0].Server != registrar || response.Referrals[0].Error != "" {
+		t.Fatalf("Referrals = %+v", response.Referrals)
+	}
+	if response.RegistrantOrg != "Example Org" || response.AbuseEmail != "abuse@registrar.test" {
+		t.Fatalf("registrar details not merged: %+v", response)
+	}
+	if response.Registrar != "Example Registrar, Inc." || response.Raw == "" || response.Referrals[0].Raw == "" {
+		t.Fatalf("registry answer lost: %+v", response)
+	}
+
+	if stripped := withoutRaw(response); stripped.Referrals[0].Raw != "" || response.Referrals[0].Raw == "" {
+		t.Fatal("withoutRaw() didn't copy the referrals")
+	}
+}
+
+func TestWhoisServerOverride(t *testing.T) {
+	registry := whoisServer(t, func(string) string {
+		return "Domain Name: EXAMPLE.CO.TEST\nRegistrar: Example Registrar, Inc.\n"
+	})
+
+	h := New(Config{Servers: map[string]string{".CO.TEST": registry, "test": "whois.nic.test"}}, nil)
+	for domain, want := range map[string]string{
+		"example.co.test": registry,
+		"example.test":    "whois.nic.test",
+		"example.com":     "",
+	} {
+		if got := h.whoisServer(domain); got != want {
+			t.Errorf("whoisServer(%q) = %q, want %q", domain, got, want)
+		}
+	}
+
+	// The override wins over the RDAP server of the TLD
+	rdapServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(rdapDomain{LDHName: "example.co.test"})
+	}))
+	defer rdapServer.Close()
+
+	rdap.mu.Lock()
+	servers, fetchedAt := rdap.servers, rdap.fetchedAt
+	rdap.servers, rdap.fetchedAt = map[string][]string{"test": {rdapServer.URL + "/"}}, time.Now()
+	rdap.mu.Unlock()
+	t.Cleanup(func() {
+		rdap.mu.Lock()
+		rdap.servers, rdap.fetchedAt = servers, fetchedAt
+		rdap.mu.Unlock()
+	})
+
+	response, err := h.lookupDomain(context.Background(), h.client, "example.co.test")
+	if err != nil || response.Error != "" || response.Source != SourceWhois || response.Server != registry || response.Registrar != "Example Registrar, Inc." {
+		t.Fatalf("lookupDomain() = %+v, %v", response, err)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	header := "Domain Name: EXAMPLE.TEST\nRegistrar: Example Registrar, Inc.\n"
+	long := whoisServer(t, func(string) string {
+		return header + strings.Repeat("Remarks: padding\n", 100)
+	})
+	exact := whoisServer(t, func(string) string {
+		return header
+	})
+
+	h := New(Config{MaxResponseSize: int64(len(header))}, nil)
+
+	response := lookupWhois(context.Background(), h.client, "example.test", long)
+	if response.Error != "" || !response.Truncated || response.Registrar != "Example Registrar, Inc." {
+		t.Fatalf("lookupWhois() = %+v, want a truncated answer", response)
+	}
+	if strings.Contains(response.Raw, "padding") || strings.Contains(response.Raw, truncatedNotice) {
+		t.Errorf("Raw = %q, want the first %d bytes", response.Raw, len(header))
+	}
+
+	if response := lookupWhois(context.Background(), h.client, "example.test", exact); response.Truncated {
+		t.Errorf("answer of exactly the maximum size reported as truncated: %+v", response)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRDAPBootstrapRefreshFailure(t *testing.T) {
+	var downloads atomic.Int32
+	r := &rdapClient{
+		client: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			downloads.Add(1)
+			return nil, errors.New("IANA is down")
+		})},
+		servers:   map[string][]string{"test": {"https://rdap.example/"}},
+		fetchedAt: time.Now().Add(-2 * rdapBootstrapRefreshInterval),
+	}
+
+	for range 3 {
+		if servers, err := r.load(context.Background()); err != nil || len(servers["test"]) != 1 {
+			t.Fatalf("load() = %v, %v, want the stale copy", servers, err)
+		}
+	}
+	// The failure waits for the next interval rather than the next lookup
+	if n := downloads.Load(); n != 1 {
+		t.Fatalf("downloads = %d, want 1", n)
+	}
+}